@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"video-processor/internal/codec"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/contact"
+	"video-processor/internal/filters"
+	"video-processor/internal/graph"
+	"video-processor/internal/y4m"
+)
+
+// runContact implements the contact subcommand: it lays out every image in
+// a directory, or every Nth frame of a Y4M stream, on a grid of thumbnails
+// with optional captions
+func runContact(args []string) {
+	flags := flag.NewFlagSet("contact", flag.ExitOnError)
+	input := flags.String("input", "", "Directory of images or Y4M video (required)")
+	outputFile := flags.String("output", "contact.png", "Path to contact sheet; numbered _NNN when there is more than one sheet")
+	columns := flags.Int("columns", 5, "Cells per row")
+	rows := flags.Int("rows", 0, "Rows per sheet; 0 puts everything on one sheet")
+	width := flags.Int("width", 200, "Cell width in pixels")
+	height := flags.Int("height", 150, "Cell height in pixels")
+	spacing := flags.Int("spacing", 8, "Gap between cells in pixels")
+	background := flags.String("background", "white", "Background and padding colour, e.g. black or #202020")
+	captions := flags.Bool("captions", true, "Caption each cell with its file name or timestamp")
+	captionColor := flags.String("caption-color", "black", "Caption text colour")
+	fontScale := flags.Int("font-scale", 1, "Caption font pixel size")
+	step := flags.Int("step", 1, "Use every Nth frame of a video")
+	autoOrient := flags.Bool("auto-orient", true, "Turn JPEG images upright according to their EXIF orientation")
+	filterName := flags.String("filter", "lanczos", "Resampling filter: "+strings.Join(filters.Names(), ", "))
+	inMatrix := flags.String("in-matrix", "bt601", "Colour matrix of Y4M input: "+strings.Join(colorspace.MatrixNames(), ", "))
+	verbose := flags.Bool("verbose", false, "Enable verbose output")
+	flags.Parse(args)
+
+	if *input == "" {
+		fmt.Println("Error: Input is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *step < 1 {
+		fmt.Println("Error: step must be at least 1")
+		os.Exit(1)
+	}
+
+	enc, err := outputEncoder(*outputFile, "", "png")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	filter, err := filters.ByName(*filterName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	matrix, err := colorspace.ParseMatrix(*inMatrix)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts := contact.Options{
+		Columns:    *columns,
+		Rows:       *rows,
+		CellWidth:  *width,
+		CellHeight: *height,
+		Spacing:    *spacing,
+		Captions:   *captions,
+		FontScale:  *fontScale,
+		Filter:     filter,
+	}
+	if opts.Background, err = graph.ParseColor(*background); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.CaptionColor, err = graph.ParseColor(*captionColor); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	builder, err := contact.NewBuilder(opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outputFile), 0o755); err != nil {
+		fmt.Printf("Error: failed to create directory: %v\n", err)
+		os.Exit(1)
+	}
+	ext := filepath.Ext(*outputFile)
+	save := func(sheet *image.NRGBA, i int, numbered bool) error {
+		path := *outputFile
+		if numbered {
+			path = fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(*outputFile, ext), i, ext)
+		}
+		if *verbose {
+			fmt.Printf("Sheet %d: %s (%dx%d)\n", i, path, sheet.Rect.Dx(), sheet.Rect.Dy())
+		}
+		return saveImage(path, sheet, enc, codec.Options{}, nil, nil)
+	}
+
+	// Sheets are written as they fill, except that the first is held until
+	// a second one shows whether it keeps the plain name
+	var first *image.NRGBA
+	written := 0
+	emit := func(sheet *image.NRGBA) error {
+		if written == 0 && first == nil {
+			first = sheet
+			return nil
+		}
+		if first != nil {
+			if err := save(first, 0, true); err != nil {
+				return err
+			}
+			first, written = nil, 1
+		}
+		if err := save(sheet, written, true); err != nil {
+			return err
+		}
+		written++
+		return nil
+	}
+	add := func(img image.Image, caption string) error {
+		sheet, err := builder.Add(img, caption)
+		if err != nil {
+			return err
+		}
+		if sheet != nil {
+			return emit(sheet)
+		}
+		return nil
+	}
+
+	if strings.ToLower(filepath.Ext(*input)) == ".y4m" {
+		err = contactVideo(*input, *step, matrix, add, *verbose)
+	} else {
+		err = contactDirectory(*input, add, *autoOrient, *verbose)
+	}
+	if err == nil {
+		if sheet := builder.Flush(); sheet != nil {
+			err = emit(sheet)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error building contact sheet: %v\n", err)
+		os.Exit(1)
+	}
+	if first != nil {
+		err = save(first, 0, false)
+	} else if written == 0 {
+		fmt.Println("Error: no images found")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error saving image: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// contactDirectory adds the images in dir in name order, captioned with
+// their file names. Files that are not images are skipped; autoOrient turns
+// JPEGs upright
+func contactDirectory(dir string, add func(image.Image, string) error, autoOrient, verbose bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		img, _, err := loadImage(filepath.Join(dir, name), autoOrient)
+		if errors.Is(err, image.ErrFormat) {
+			continue
+		}
+		if err != nil {
+			// One unreadable file should not cost the whole sheet
+			fmt.Printf("Warning: skipping %s: %v\n", name, err)
+			continue
+		}
+		if verbose {
+			fmt.Printf("Adding %s (%dx%d)\n", name, img.Bounds().Dx(), img.Bounds().Dy())
+		}
+		if err := add(img, name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// contactVideo adds every step-th frame of a Y4M stream, decoded with the
+// stream's siting and range and the given matrix, captioned with its
+// timestamp
+func contactVideo(path string, step int, matrix colorspace.Matrix, add func(image.Image, string) error, verbose bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := y4m.NewReader(file)
+	if err != nil {
+		return err
+	}
+	rate := reader.Header.FrameRate
+	source, err := newStreamColour(reader.Header, matrix, nil)
+	if err != nil {
+		return err
+	}
+
+	for index := 0; ; index++ {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+		if index%step != 0 {
+			continue
+		}
+
+		caption := fmt.Sprintf("#%d", index)
+		if rate.Num > 0 && rate.Den > 0 {
+			at := time.Duration(int64(index) * rate.Den * int64(time.Second) / rate.Num)
+			caption = frameTimestamp(at)
+		}
+		if verbose {
+			fmt.Printf("Adding frame %d (%s)\n", index, caption)
+		}
+		rgb, err := source.ToRGB(frame)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+		if err := add(rgb, caption); err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+	}
+}
+
+// frameTimestamp formats d as h:mm:ss.mmm, leaving out the hours of short
+// videos to keep captions narrow
+func frameTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms >= 3600000 {
+		return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+	}
+	return fmt.Sprintf("%02d:%02d.%03d", ms/60000, ms/1000%60, ms%1000)
+}
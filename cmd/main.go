@@ -1,24 +1,94 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"image/png"
+	"image/color"
+	"image/gif"
+	// Register the JPEG and PNG decoders with image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"video-processor/internal/resize"
+	"video-processor/internal/affine"
+	"video-processor/internal/chroma"
+	// Also registers the decoders of the formats it writes
+	"video-processor/internal/codec"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/deinterlace"
+	"video-processor/internal/exif"
+	"video-processor/internal/filters"
+	"video-processor/internal/framerate"
+	"video-processor/internal/gifanim"
+	"video-processor/internal/graph"
+	"video-processor/internal/icc"
+	"video-processor/internal/metadata"
+	"video-processor/internal/orient"
+	"video-processor/internal/quantize"
+	// Registers the lossless WebP decoder with image.Decode
+	_ "video-processor/internal/webp"
+	"video-processor/internal/y4m"
 )
 
 func main() {
+	// Subcommands come before any flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "scenes":
+			runScenes(os.Args[2:])
+			return
+		case "sprites":
+			runSprites(os.Args[2:])
+			return
+		case "contact":
+			runContact(os.Args[2:])
+			return
+		}
+	}
+
 	// Define command-line flags
 	inputFile := flag.String("input", "", "Path to input image file (required)")
-	outputFile := flag.String("output", "", "Path to output image file (default: input file with _resized suffix)")
+	outputFile := flag.String("output", "", "Path to output image file (default: input file with _resized suffix, as PNG for read-only formats)")
 	width := flag.Int("width", 0, "Target width in pixels (required)")
 	height := flag.Int("height", 0, "Target height in pixels (required)")
+	filterName := flag.String("filter", "lanczos", "Resampling filter: "+strings.Join(filters.Names(), ", "))
+	filterGraph := flag.String("vf", "", "Filter graph, e.g. crop=1920:800:0:140,scale=1280:-2:lanczos,pad=1280:720:-1:-1:black")
+	angle := flag.Float64("angle", 0, "Rotate clockwise by this many degrees before resizing, e.g. -1.5 to straighten a scan")
+	fillSpec := flag.String("fill", "transparent", "Colour of the corners -angle uncovers")
+	orientName := flag.String("orient", "", "Turn or mirror the image losslessly before resizing: "+strings.Join(orient.OpNames(), ", "))
+	orientAfterName := flag.String("orient-after", "", "Turn or mirror the image losslessly after resizing, with the same names as -orient")
+	expand := flag.Bool("expand", false, "Grow the output of -angle to hold the whole rotated image instead of keeping the input size")
+	colors := flag.Int("colors", 0, "Reduce output to an indexed palette of this many colours (2-256), e.g. for GIF or PNG-8")
+	quantizerName := flag.String("quantizer", "mediancut", "Palette quantizer: "+strings.Join(quantize.QuantizerNames(), ", "))
+	ditherName := flag.String("dither", "floyd-steinberg", "Dithering for indexed output: "+strings.Join(quantize.DithererNames(), ", "))
+	frameRate := flag.String("fps", "", "Output frame rate for Y4M video, e.g. 30 or 30000/1001 (default: input rate)")
+	frameRateMode := flag.String("fps-mode", "dup", "Frame rate conversion: dup (drop/duplicate) or blend")
+	frameRateReport := flag.String("fps-report", "", "Write the frame rate conversion mapping as JSON to this file")
+	deinterlacerName := flag.String("deinterlace", "yadif", "Deinterlacer for interlaced Y4M input: none (keep fields), "+strings.Join(deinterlace.Names(), ", "))
+	primaries := flag.String("primaries", "", "Convert between RGB colour spaces before filtering, as in:out, e.g. displayp3:srgb ("+strings.Join(colorspace.RGBSpaceNames(), ", ")+")")
+	gamutName := flag.String("gamut", "clip", "Gamut mapping for -primaries and ICC conversion: clip or compress")
+	inMatrix := flag.String("in-matrix", "bt601", "Colour matrix of Y4M input: "+strings.Join(colorspace.MatrixNames(), ", "))
+	inRange := flag.String("in-range", "", "Range of Y4M input: limited or full (default: from the header, else limited)")
+	outMatrix := flag.String("out-matrix", "", "Colour matrix of Y4M output (default: same as input)")
+	outRange := flag.String("out-range", "", "Range of Y4M output (default: same as input)")
+	formatName := flag.String("format", "", "Output format: "+strings.Join(codec.Names(), ", ")+" (default: from the output extension, else the input format)")
+	quality := flag.Int("quality", 0, "JPEG quality, 1-100 (default 95)")
+	compressionName := flag.String("compression", "default", "PNG compression level: "+strings.Join(codec.CompressionLevelNames(), ", "))
+	progressive := flag.Bool("progressive", false, "Write progressive JPEG")
+	interlace := flag.Bool("interlace", false, "Write interlaced (Adam7) PNG")
+	autoOrient := flag.Bool("auto-orient", true, "Turn JPEG input upright according to its EXIF orientation; -auto-orient=false keeps the stored pixels")
+	convertICC := flag.Bool("convert-icc", true, "Convert JPEG and PNG input with an embedded RGB ICC profile to sRGB, filtering in linear light; -convert-icc=false keeps the pixels and profile as they are")
+	metadataModeName := flag.String("metadata", "keep", "EXIF, ICC and XMP metadata of JPEG and PNG input to write to JPEG and PNG output: "+strings.Join(metadata.ModeNames(), ", "))
+	metadataTags := flag.String("metadata-tags", "", "Comma-separated EXIF tag names, gps, interop, icc and xmp to keep with -metadata filter (default: descriptive EXIF tags and the ICC profile)")
+	backgroundSpec := flag.String("background", "white", "Background for transparent pixels when the output format has no alpha: a colour, or checker[:size] for a checkerboard")
 	verbose := flag.Bool("verbose", false, "Enable verbose output")
 
 	// Parse command-line flags
@@ -37,17 +107,143 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Validate dimensions
-	if *width <= 0 || *height <= 0 {
+	// Y4M input is processed as a video stream
+	videoMode := strings.ToLower(filepath.Ext(*inputFile)) == ".y4m"
+
+	// Validate dimensions; they are optional when a filter graph does the
+	// scaling, the image is rotated or mirrored, the colours are converted,
+	// or a video only has its frame rate converted
+	resizeRequested := *width != 0 || *height != 0
+	oriented := *orientName != "" || *orientAfterName != ""
+	if (*filterGraph == "" && *primaries == "" && *angle == 0 && !oriented && !videoMode || resizeRequested) && (*width <= 0 || *height <= 0) {
 		fmt.Println("Error: Both width and height must be greater than 0")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	filter, err := filters.ByName(*filterName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	quantizer, err := quantize.QuantizerByName(*quantizerName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	ditherer, err := quantize.DithererByName(*ditherName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *colors != 0 && (*colors < 2 || *colors > 256) {
+		fmt.Println("Error: colors must be between 2 and 256")
+		os.Exit(1)
+	}
+
+	encodeOpts := codec.Options{Quality: *quality, Progressive: *progressive, Interlace: *interlace}
+	if encodeOpts.Compression, err = codec.ParseCompressionLevel(*compressionName); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	background, err := parseBackground(*backgroundSpec)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	gamut, err := colorspace.ParseGamutMapping(*gamutName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	metadataMode, err := metadata.ParseMode(*metadataModeName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	whitelistNames := metadata.DefaultWhitelist
+	if *metadataTags != "" {
+		whitelistNames = strings.Split(*metadataTags, ",")
+	}
+	whitelist, err := metadata.ParseWhitelist(whitelistNames)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if videoMode && (*formatName != "" || encodeOpts != codec.Options{}) {
+		fmt.Println("Error: -format and encoder options apply to image output only")
+		os.Exit(1)
+	}
+
+	orientBefore, err := parseOrient(*orientName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	orientAfter, err := parseOrient(*orientAfterName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Build the processing pipeline: the -vf graph followed by the lossless
+	// turn, the rotation, the plain resize and the final lossless turn
+	pipeline := graph.New()
+	if *filterGraph != "" {
+		pipeline, err = graph.Parse(*filterGraph)
+		if err != nil {
+			fmt.Printf("Error parsing filter graph: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if orientBefore != nil {
+		pipeline.Append(orientBefore)
+	}
+	if *angle != 0 {
+		fill, err := graph.ParseColor(*fillSpec)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		rotate := &graph.Rotate{Angle: *angle, Fill: fill, Filter: filter, FilterName: *filterName}
+		if *expand {
+			rotate.Bounds = affine.Expand
+		}
+		pipeline.Append(rotate)
+	}
+	if resizeRequested {
+		pipeline.Append(&graph.Scale{Width: *width, Height: *height, Filter: filter, FilterName: *filterName})
+	}
+	if orientAfter != nil {
+		pipeline.Append(orientAfter)
+	}
+
+	// Colour space conversion comes first so every stage sees output colours
+	if *primaries != "" {
+		stage, err := parsePrimaries(*primaries, gamut)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		pipeline.Stages = append([]graph.Stage{stage}, pipeline.Stages...)
+	}
+
 	// Generate default output file name if not specified
 	if *outputFile == "" {
 		ext := filepath.Ext(*inputFile)
 		baseName := strings.TrimSuffix(*inputFile, ext)
+		if *formatName != "" {
+			enc, err := codec.Lookup(*formatName)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			ext = enc.Extensions[0]
+		} else if _, err := codec.ForExtension(ext); err != nil && ext != "" && !videoMode {
+			// Formats that can only be read, such as WebP, are written as PNG
+			ext = ".png"
+		}
 		*outputFile = fmt.Sprintf("%s_resized%s", baseName, ext)
 	}
 
@@ -55,25 +251,147 @@ func main() {
 		fmt.Println("Starting image resizing...")
 		fmt.Printf("Input: %s\n", *inputFile)
 		fmt.Printf("Output: %s\n", *outputFile)
-		fmt.Printf("Dimensions: %d x %d\n", *width, *height)
+		fmt.Printf("Filters: %s\n", pipeline)
+	}
+
+	if videoMode {
+		opts := videoOptions{ReportPath: *frameRateReport}
+		if opts.In.Matrix, err = colorspace.ParseMatrix(*inMatrix); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Out.Matrix = opts.In.Matrix
+		if *outMatrix != "" {
+			if opts.Out.Matrix, err = colorspace.ParseMatrix(*outMatrix); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *inRange != "" {
+			r, err := colorspace.ParseRange(*inRange)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.InRange = &r
+		}
+		if *outRange != "" {
+			r, err := colorspace.ParseRange(*outRange)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.OutRange = &r
+		}
+		if *frameRate != "" {
+			if opts.FrameRate, err = y4m.ParseRational(*frameRate); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if opts.Mode, err = framerate.ParseMode(*frameRateMode); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *deinterlacerName != "none" {
+			if opts.Deinterlacer, err = deinterlace.New(*deinterlacerName, filter); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.DeinterlacerName = *deinterlacerName
+		}
+		if err := processVideo(*inputFile, *outputFile, pipeline, opts, *verbose); err != nil {
+			fmt.Printf("Error processing video: %v\n", err)
+			os.Exit(1)
+		}
+		if *verbose {
+			fmt.Println("Video processing completed successfully")
+		}
+		return
 	}
 
 	// Load the input image
-	inputImg, format, err := loadImage(*inputFile)
+	inputImg, format, err := loadImage(*inputFile, *autoOrient)
 	if err != nil {
 		fmt.Printf("Error loading image: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Resize the image
-	resizedImg, err := resize.Resize(inputImg, *width, *height)
+	// Pick the encoder before any processing so a bad output fails fast
+	enc, err := outputEncoder(*outputFile, *formatName, format)
+	if err == nil {
+		err = enc.Check(encodeOpts)
+	}
 	if err != nil {
-		fmt.Printf("Error resizing image: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Animated GIFs keep every frame when written back out as GIF
+	if format == "gif" && enc.Name == "gif" {
+		opts := gifanim.Options{Ditherer: ditherer}
+		if *colors > 0 {
+			opts.Quantizer, opts.Colors = quantizer, *colors
+		}
+		if err := processAnimation(*inputFile, *outputFile, pipeline, opts, *verbose); err != nil {
+			fmt.Printf("Error processing animation: %v\n", err)
+			os.Exit(1)
+		}
+		if *verbose {
+			fmt.Println("Image resizing completed successfully")
+		}
+		return
+	}
+
+	// Read the metadata: the ICC profile says what the pixel values mean,
+	// and the rest is carried over to the output
+	var md *metadata.Metadata
+	if metadataMode != metadata.Strip || *convertICC {
+		md = readMetadata(*inputFile, format)
+	}
+
+	// Colours described by an ICC profile are converted to sRGB first and
+	// filtered in linear light, then encoded again at the end
+	converted := false
+	if *convertICC && md != nil && md.ICC != nil {
+		if stage := iccConversion(md.ICC, gamut); stage != nil {
+			if *verbose {
+				fmt.Printf("Converting from ICC profile: %s\n", stage)
+			}
+			pipeline.Stages = append([]graph.Stage{stage}, pipeline.Stages...)
+			pipeline.Append(&graph.Format{Name: sRGBFormat(inputImg)})
+			converted = true
+		}
+	}
+
+	// Run the image through the pipeline
+	resizedImg, err := pipeline.Apply(inputImg)
+	if err != nil {
+		fmt.Printf("Error processing image: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Reduce to an indexed palette if requested
+	if *colors > 0 {
+		resizedImg, err = quantize.ToPaletted(resizedImg, *colors, quantizer, ditherer)
+		if err != nil {
+			fmt.Printf("Error quantizing image: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Carry the input's metadata over to the output
+	var outMetadata *metadata.Metadata
+	if metadataMode != metadata.Strip && md != nil {
+		size := resizedImg.Bounds().Size()
+		outMetadata = outputMetadata(md, metadataMode, whitelist, size.X, size.Y, *autoOrient && format == "jpeg", converted)
+		if *verbose && !outMetadata.Empty() && enc.Embed == nil {
+			fmt.Printf("%s output cannot carry metadata; it is dropped\n", enc.Name)
+		}
+	}
+
 	// Save the resized image
-	err = saveImage(*outputFile, resizedImg, format)
+	err = saveImage(*outputFile, resizedImg, enc, encodeOpts, background, outMetadata)
 	if err != nil {
 		fmt.Printf("Error saving image: %v\n", err)
 		os.Exit(1)
@@ -84,8 +402,136 @@ func main() {
 	}
 }
 
-// loadImage loads an image from the given file path
-func loadImage(filePath string) (image.Image, string, error) {
+// parsePrimaries builds the colour space conversion for the -primaries
+// flag
+func parsePrimaries(value string, gamut colorspace.GamutMapping) (*graph.Primaries, error) {
+	in, out, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, fmt.Errorf("primaries must be given as in:out, got %q", value)
+	}
+	stage := &graph.Primaries{Mapping: gamut}
+	var err error
+	if stage.From, err = colorspace.RGBSpaceByName(in); err != nil {
+		return nil, err
+	}
+	if stage.To, err = colorspace.RGBSpaceByName(out); err != nil {
+		return nil, err
+	}
+	return stage, nil
+}
+
+// parseBackground builds the flattening for the -background flag: a colour,
+// or checker with an optional square size for a grey and white
+// checkerboard
+func parseBackground(value string) (*graph.Flatten, error) {
+	if spec, ok := strings.CutPrefix(value, "checker"); ok {
+		background := &graph.Flatten{Color: color.Gray{Y: 0xcc}, Alt: color.White, Checker: 8}
+		if spec != "" {
+			size, err := strconv.Atoi(strings.TrimPrefix(spec, ":"))
+			if err != nil || size <= 0 || spec[0] != ':' {
+				return nil, fmt.Errorf("invalid checkerboard %q, want checker or checker:size", value)
+			}
+			background.Checker = size
+		}
+		return background, nil
+	}
+	c, err := graph.ParseColor(value)
+	if err != nil {
+		return nil, err
+	}
+	if c.A != 0xff {
+		return nil, fmt.Errorf("background %q must be opaque", value)
+	}
+	return &graph.Flatten{Color: c}, nil
+}
+
+// readMetadata reads the metadata of the input. Metadata that cannot be
+// read is ignored with a warning, as the image itself was fine
+func readMetadata(path, format string) *metadata.Metadata {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Warning: ignoring metadata: %v\n", err)
+		return nil
+	}
+	defer file.Close()
+
+	md, err := metadata.Read(file, format)
+	if err != nil {
+		fmt.Printf("Warning: ignoring metadata: %v\n", err)
+		return nil
+	}
+	return md
+}
+
+// iccConversion returns the stage converting an image with the given ICC
+// profile to sRGB, or nil when the profile is sRGB already or cannot be
+// used, in which case the pixels are taken as sRGB
+func iccConversion(profile []byte, gamut colorspace.GamutMapping) *graph.ICC {
+	p, err := icc.Parse(profile)
+	if err != nil {
+		fmt.Printf("Warning: ignoring ICC profile: %v\n", err)
+		return nil
+	}
+	srgb, err := colorspace.RGBSpaceByName("srgb")
+	if err != nil || p.Matches(srgb) {
+		return nil
+	}
+	return &graph.ICC{Profile: p, Mapping: gamut}
+}
+
+// sRGBFormat returns the pixel format that encodes converted colours back
+// to sRGB at the precision of the input
+func sRGBFormat(img image.Image) string {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		return "rgba64"
+	}
+	return "rgba"
+}
+
+// outputMetadata prepares the metadata of the input for an output of the
+// given size: filtered by whitelist in filter mode, with the EXIF and XMP
+// orientation reset when the pixels were turned upright, and marked as
+// sRGB when the colours were converted
+func outputMetadata(md *metadata.Metadata, mode metadata.Mode, whitelist *metadata.Whitelist, width, height int, upright, srgb bool) *metadata.Metadata {
+	var err error
+	if mode == metadata.Filter {
+		err = md.Filter(whitelist)
+	}
+	if err == nil {
+		err = md.Update(width, height, upright)
+	}
+	if err != nil {
+		// A block that cannot be parsed cannot be filtered or corrected
+		fmt.Printf("Warning: dropping EXIF data: %v\n", err)
+		md.EXIF = nil
+	}
+	if srgb {
+		// The original profile no longer describes the pixels either way
+		if err := md.MarkSRGB(); err != nil {
+			fmt.Printf("Warning: dropping ICC profile: %v\n", err)
+			md.ICC = nil
+		}
+	}
+	return md
+}
+
+// parseOrient returns the stage for a -orient value, or nil when there is
+// nothing to do
+func parseOrient(name string) (*graph.Orient, error) {
+	if name == "" {
+		return nil, nil
+	}
+	op, err := orient.ParseOp(name)
+	if err != nil || op == orient.None {
+		return nil, err
+	}
+	return &graph.Orient{Op: op}, nil
+}
+
+// loadImage loads an image from the given file path. With autoOrient, a
+// JPEG is turned upright according to its EXIF orientation
+func loadImage(filePath string, autoOrient bool) (image.Image, string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to open file: %w", err)
@@ -97,32 +543,424 @@ func loadImage(filePath string) (image.Image, string, error) {
 		return nil, "", fmt.Errorf("failed to decode image: %w", err)
 	}
 
+	if autoOrient && format == "jpeg" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, "", fmt.Errorf("failed to read EXIF data: %w", err)
+		}
+		// Damaged EXIF data leaves an otherwise readable image as stored
+		if md, err := metadata.ReadJPEG(file); err == nil && md.EXIF != nil {
+			if orientation, err := exif.ReadOrientation(md.EXIF); err == nil {
+				img = orientation.Apply(img)
+			}
+		}
+	}
+
 	return img, format, nil
 }
 
-// saveImage saves an image to the given file path
-func saveImage(filePath string, img *image.NRGBA, format string) error {
-	file, err := os.Create(filePath)
+// processAnimation runs every frame of an animated GIF through the pipeline
+// and writes the result with the original timing and loop count
+func processAnimation(inputPath, outputPath string, pipeline *graph.Graph, opts gifanim.Options, verbose bool) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	anim, err := gif.DecodeAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to decode gif: %w", err)
+	}
+
+	if verbose {
+		fmt.Printf("Frames: %d, loop count: %d\n", len(anim.Image), anim.LoopCount)
+	}
+
+	result, err := gifanim.MapWithOptions(anim, pipeline.Apply, opts)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
+	defer out.Close()
+
+	if err := gif.EncodeAll(out, result); err != nil {
+		return fmt.Errorf("failed to encode gif: %w", err)
+	}
+
+	return nil
+}
+
+// videoOptions controls how a Y4M stream is converted
+type videoOptions struct {
+	// FrameRate is the output rate; zero keeps the input rate
+	FrameRate  y4m.Rational
+	Mode       framerate.Mode
+	ReportPath string
+	// Deinterlacer makes interlaced input progressive before the pipeline
+	// runs; nil keeps it interlaced and scales each field separately
+	Deinterlacer     deinterlace.Deinterlacer
+	DeinterlacerName string
+	// In and Out are the colour encodings of the streams. Their ranges are
+	// only used when InRange or OutRange is nil; the input range then
+	// comes from the header and the output range follows the input
+	In, Out           colorspace.Space
+	InRange, OutRange *colorspace.Range
+}
+
+// streamColour is how the frames of a Y4M stream are encoded
+type streamColour struct {
+	Siting     chroma.Siting
+	Space      colorspace.Space
+	Interlaced bool
+}
+
+// newStreamColour reads the chroma siting, range and field order from a Y4M
+// header. Headers do not record the matrix, so it is given, and inRange
+// overrides the header's range when set
+func newStreamColour(header y4m.Header, matrix colorspace.Matrix, inRange *colorspace.Range) (streamColour, error) {
+	siting, err := chroma.ParseSiting(header.ChromaSiting())
+	if err != nil {
+		return streamColour{}, err
+	}
+	c := streamColour{Siting: siting, Space: colorspace.Space{Matrix: matrix, Range: colorspace.Limited}, Interlaced: header.Interlaced()}
+	if inRange != nil {
+		c.Space.Range = *inRange
+	} else if header.ColorRange() == "FULL" {
+		c.Space.Range = colorspace.Full
+	}
+	return c, nil
+}
+
+// ToRGB decodes a frame of the stream, interpolating interlaced chroma
+// within each field so the fields are not mixed
+func (c streamColour) ToRGB(frame *image.YCbCr) (*image.NRGBA, error) {
+	if c.Interlaced {
+		return chroma.ToRGBFields(frame, c.Siting, c.Space, nil)
+	}
+	return chroma.ToRGB(frame, c.Siting, c.Space, nil)
+}
+
+// processVideo runs every frame of a Y4M stream through the pipeline,
+// converts it to the requested frame rate and writes a Y4M stream with the
+// input's chroma layout
+func processVideo(inputPath, outputPath string, pipeline *graph.Graph, opts videoOptions, verbose bool) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
 	defer file.Close()
 
-	ext := strings.ToLower(filepath.Ext(filePath))
-	
-	switch {
-	case format == "jpeg" || ext == ".jpg" || ext == ".jpeg":
-		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 95})
-	case format == "png" || ext == ".png":
-		err = png.Encode(file, img)
-	default:
-		// Default to JPEG if format is unknown
-		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 95})
+	reader, err := y4m.NewReader(file)
+	if err != nil {
+		return err
+	}
+	header := reader.Header
+	if header.FrameRate.Num <= 0 || header.FrameRate.Den <= 0 {
+		return errors.New("input stream has no frame rate")
+	}
+	ratio, _ := header.SubsampleRatio()
+	source, err := newStreamColour(header, opts.In.Matrix, opts.InRange)
+	if err != nil {
+		return err
+	}
+	siting, in, out := source.Siting, source.Space, opts.Out
+	out.Range = in.Range
+	if opts.OutRange != nil {
+		out.Range = *opts.OutRange
+	}
+
+	to := opts.FrameRate
+	if to.Num == 0 {
+		to = header.FrameRate
+	}
+	converter, err := framerate.NewConverter(header.FrameRate, to, opts.Mode)
+	if err != nil {
+		return err
+	}
+
+	// Interlaced input is either deinterlaced before scaling or kept
+	// interlaced, in which case scaling must not mix the fields
+	var fields *deinterlace.Stream
+	outInterlace := header.Interlace
+	if header.Interlaced() {
+		order := deinterlace.TopFieldFirst
+		if header.Interlace == y4m.BottomFieldFirst {
+			order = deinterlace.BottomFieldFirst
+		}
+		if opts.Deinterlacer != nil {
+			fields = deinterlace.NewStream(opts.Deinterlacer, order)
+			outInterlace = y4m.Progressive
+		} else {
+			for _, stage := range pipeline.Stages {
+				switch s := stage.(type) {
+				case *graph.Scale:
+					s.Interlaced = true
+				case *graph.Format:
+					s.Interlaced = true
+				}
+				// The output stays interlaced, so a stage mixing the
+				// fields would corrupt every frame
+				if !graph.FieldSafe(stage) {
+					return fmt.Errorf("filter %s mixes the fields of interlaced input; choose a deinterlacer instead of -deinterlace none", graph.New(stage))
+				}
+			}
+		}
+		if verbose {
+			name := opts.DeinterlacerName
+			if fields == nil {
+				name = "none, field-aware scaling"
+			}
+			fmt.Printf("Interlaced input (%c), deinterlacer: %s\n", header.Interlace, name)
+		}
+	}
+
+	// A pipeline that only scales keeps progressive frames in YCbCr and
+	// converts the colour encoding while resizing. Anything else works in
+	// RGB, so its output is converted back to the stream's chroma layout
+	planar := fields == nil && !header.Interlaced()
+	for _, stage := range pipeline.Stages {
+		if _, ok := stage.(*graph.Scale); !ok {
+			planar = false
+		}
+	}
+	if planar {
+		if len(pipeline.Stages) == 0 && in != out {
+			pipeline = graph.New(&graph.Scale{Width: -1, Height: -1})
+		}
+		for i, stage := range pipeline.Stages {
+			scale := stage.(*graph.Scale)
+			scale.Planar, scale.Siting, scale.From, scale.To = true, siting, out, out
+			if i == 0 {
+				scale.From = in
+			}
+		}
+	} else if len(pipeline.Stages) > 0 || fields != nil || in != out {
+		format := &graph.Format{Name: y4m.PixelFormat(ratio), Siting: siting, Space: out, Interlaced: fields == nil && header.Interlaced()}
+		pipeline = graph.New(append(pipeline.Stages, format)...)
+	}
+	if verbose && in != out {
+		fmt.Printf("Colour space: %s -> %s\n", in, out)
+	}
+
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer outFile.Close()
+
+	// The header is written once the first frame's size is known
+	var writer *y4m.Writer
+	write := func(frames []*image.YCbCr) error {
+		for _, frame := range frames {
+			if writer == nil {
+				outHeader := header
+				outHeader.Width, outHeader.Height = frame.Bounds().Dx(), frame.Bounds().Dy()
+				outHeader.FrameRate = to
+				outHeader.Interlace = outInterlace
+				if out.Range != in.Range || header.ColorRange() != "" {
+					outHeader.SetColorRange(strings.ToUpper(out.Range.String()))
+				}
+				if writer, err = y4m.NewWriter(outFile, outHeader); err != nil {
+					return err
+				}
+			}
+			if err := writer.WriteFrame(frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// process runs one progressive (or field-preserving) frame through the
+	// pipeline and the frame rate converter
+	processed := 0
+	process := func(frame image.Image) error {
+		if len(pipeline.Stages) > 0 {
+			var err error
+			// Decode with the stream's real range and matrix, interpolating
+			// chroma from where it is sited
+			if ycc, ok := frame.(*image.YCbCr); ok && !planar {
+				if frame, err = source.ToRGB(ycc); err != nil {
+					return fmt.Errorf("frame %d: %w", processed, err)
+				}
+			}
+			if frame, err = pipeline.Apply(frame); err != nil {
+				return fmt.Errorf("frame %d: %w", processed, err)
+			}
+		}
+		processed++
+
+		frames, err := converter.Push(frame.(*image.YCbCr))
+		if err != nil {
+			return err
+		}
+		return write(frames)
+	}
+
+	for index := 0; ; index++ {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+
+		if fields == nil {
+			if err := process(frame); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rgb, err := source.ToRGB(frame)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+		progressive, err := fields.Push(rgb)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+		if progressive != nil {
+			if err := process(progressive); err != nil {
+				return err
+			}
+		}
+	}
+
+	if fields != nil {
+		progressive, err := fields.Flush()
+		if err != nil {
+			return err
+		}
+		if progressive != nil {
+			if err := process(progressive); err != nil {
+				return err
+			}
+		}
 	}
 
+	frames, err := converter.Flush()
 	if err != nil {
+		return err
+	}
+	if err := write(frames); err != nil {
+		return err
+	}
+	if writer == nil {
+		return errors.New("input stream has no frames")
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	report := converter.Report()
+	if verbose {
+		fmt.Printf("Frame rate: %s -> %s (%s), %d frames in, %d out, %d dropped, %d duplicated, %d blended\n",
+			report.From, report.To, report.Mode, report.Inputs, report.Outputs, report.Dropped, report.Duplicated, report.Blended)
+	}
+	if opts.ReportPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(opts.ReportPath, data, 0o644); err != nil {
+			return fmt.Errorf("failed to write frame rate report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// outputEncoder picks the encoder for path. An explicit format must agree
+// with the extension when the extension is a known one; otherwise the
+// extension decides, and a path without one uses fallback, normally the
+// input format
+func outputEncoder(path, format, fallback string) (*codec.Encoder, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if format != "" {
+		enc, err := codec.Lookup(format)
+		if err != nil {
+			return nil, err
+		}
+		if byExt, err := codec.ForExtension(ext); err == nil && byExt != enc {
+			return nil, fmt.Errorf("output extension %q does not match format %s", ext, enc.Name)
+		}
+		return enc, nil
+	}
+	if ext != "" {
+		return codec.ForExtension(ext)
+	}
+	enc, err := codec.Lookup(fallback)
+	if err != nil {
+		// Read-only input formats such as WebP need an explicit format
+		return nil, fmt.Errorf("cannot write %s output; give the output file an extension or use -format (available: %s)", fallback, strings.Join(codec.Names(), ", "))
+	}
+	return enc, nil
+}
+
+// saveImage encodes img to filePath with enc, adding md where the format
+// can carry it. Nothing is written when opts asks for something enc cannot
+// do
+func saveImage(filePath string, img image.Image, enc *codec.Encoder, opts codec.Options, background *graph.Flatten, md *metadata.Metadata) error {
+	if err := enc.Check(opts); err != nil {
+		return err
+	}
+
+	// Formats without alpha would show whatever colour transparent pixels
+	// hide, so composite them over the background first; nil means white
+	if !enc.KeepsAlpha(img) && !opaque(img) {
+		if background == nil {
+			background = &graph.Flatten{}
+		}
+		flat, err := background.Apply(img)
+		if err != nil {
+			return err
+		}
+		img = flat
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	if md.Empty() || enc.Embed == nil {
+		if err := enc.Encode(file, img, opts); err != nil {
+			return fmt.Errorf("failed to encode image: %w", err)
+		}
+		return nil
+	}
+
+	// Metadata goes into the encoded file's headers
+	var buf bytes.Buffer
+	if err := enc.Encode(&buf, img, opts); err != nil {
 		return fmt.Errorf("failed to encode image: %w", err)
 	}
+	if err := enc.Embed(file, buf.Bytes(), md); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// opaque reports whether every pixel of img is fully opaque
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"video-processor/internal/codec"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/graph"
+	"video-processor/internal/scene"
+	"video-processor/internal/y4m"
+)
+
+// runScenes implements the scenes subcommand: it detects scene cuts in a
+// Y4M stream, writes them as JSON and optionally saves a resized still of
+// each scene's representative frame
+func runScenes(args []string) {
+	flags := flag.NewFlagSet("scenes", flag.ExitOnError)
+	inputFile := flags.String("input", "", "Path to input Y4M video (required)")
+	outputFile := flags.String("output", "", "Path to JSON cut list (default: input file with _scenes.json suffix)")
+	metricName := flags.String("metric", "combined", "Frame difference metric: combined, histogram, sad")
+	sensitivity := flags.Float64("sensitivity", 3, "Standard deviations above the recent average a score must reach to count as a cut")
+	threshold := flags.Float64("threshold", 0.15, "Minimum score (0-1) for a cut")
+	minScene := flags.Int("min-scene", 12, "Minimum scene length in frames")
+	stillsDir := flags.String("stills", "", "Directory to write one still per scene to")
+	stillFormat := flags.String("still-format", "jpg", "Still image format: jpg, png or gif")
+	width := flags.Int("width", 320, "Still width in pixels; -1 keeps the aspect ratio")
+	height := flags.Int("height", -1, "Still height in pixels; -1 keeps the aspect ratio")
+	filterName := flags.String("filter", "lanczos", "Resampling filter for stills: "+strings.Join(filters.Names(), ", "))
+	inMatrix := flags.String("in-matrix", "bt601", "Colour matrix of the input for stills: "+strings.Join(colorspace.MatrixNames(), ", "))
+	verbose := flags.Bool("verbose", false, "Enable verbose output")
+	flags.Parse(args)
+
+	if *inputFile == "" {
+		fmt.Println("Error: Input file is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	metric, err := scene.ParseMetric(*metricName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	filter, err := filters.ByName(*filterName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	matrix, err := colorspace.ParseMatrix(*inMatrix)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *outputFile == "" {
+		*outputFile = strings.TrimSuffix(*inputFile, filepath.Ext(*inputFile)) + "_scenes.json"
+	}
+
+	opts := scene.Options{
+		Metric:         metric,
+		Sensitivity:    *sensitivity,
+		MinThreshold:   *threshold,
+		MinSceneLength: *minScene,
+	}
+	result, err := detectScenes(*inputFile, opts)
+	if err != nil {
+		fmt.Printf("Error detecting scenes: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *verbose {
+		fmt.Printf("Frames: %d, scenes: %d\n", result.Frames, len(result.Scenes))
+		for _, cut := range result.Cuts {
+			fmt.Printf("Cut at frame %d (%.3fs), score %.3f > %.3f\n", cut.Frame, cut.Time, cut.Score, cut.Threshold)
+		}
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Printf("Error encoding scenes: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*outputFile, data, 0o644); err != nil {
+		fmt.Printf("Error writing scenes: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *stillsDir != "" {
+		scale := &graph.Scale{Width: *width, Height: *height, Filter: filter, FilterName: *filterName}
+		if err := writeStills(*inputFile, *stillsDir, *stillFormat, result, scale, matrix, *verbose); err != nil {
+			fmt.Printf("Error writing stills: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// detectScenes runs every frame of a Y4M stream through a scene detector
+func detectScenes(inputPath string, opts scene.Options) (scene.Result, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return scene.Result{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := y4m.NewReader(file)
+	if err != nil {
+		return scene.Result{}, err
+	}
+	opts.FrameRate = reader.Header.FrameRate
+	detector := scene.NewDetector(opts)
+
+	for index := 0; ; index++ {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return scene.Result{}, fmt.Errorf("frame %d: %w", index, err)
+		}
+		if _, err := detector.Push(frame); err != nil {
+			return scene.Result{}, err
+		}
+	}
+
+	return detector.Result(), nil
+}
+
+// writeStills reads the stream a second time and saves the representative
+// frame of every scene, decoded with the stream's siting and range and the
+// given matrix and resized by scale, as scene_NNN.<format>
+func writeStills(inputPath, dir, format string, result scene.Result, scale *graph.Scale, matrix colorspace.Matrix, verbose bool) error {
+	enc, err := codec.Lookup(format)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	wanted := make(map[int]int, len(result.Scenes))
+	for i, s := range result.Scenes {
+		wanted[s.Representative] = i
+	}
+
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := y4m.NewReader(file)
+	if err != nil {
+		return err
+	}
+	source, err := newStreamColour(reader.Header, matrix, nil)
+	if err != nil {
+		return err
+	}
+
+	for index := 0; len(wanted) > 0; index++ {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+
+		sceneIndex, ok := wanted[index]
+		if !ok {
+			continue
+		}
+		delete(wanted, index)
+
+		rgb, err := source.ToRGB(frame)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+		still, err := scale.Apply(rgb)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("scene_%03d.%s", sceneIndex, format))
+		if err := saveImage(path, still, enc, codec.Options{}, nil, nil); err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Printf("Scene %d still: %s (frame %d)\n", sceneIndex, path, index)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"video-processor/internal/codec"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/sprite"
+	"video-processor/internal/y4m"
+)
+
+// runSprites implements the sprites subcommand: it samples a Y4M stream at a
+// fixed interval, tiles the thumbnails into sprite sheets and writes a
+// WebVTT track pointing at each tile
+func runSprites(args []string) {
+	flags := flag.NewFlagSet("sprites", flag.ExitOnError)
+	inputFile := flags.String("input", "", "Path to input Y4M video (required)")
+	outputFile := flags.String("output", "", "Path to WebVTT file; sheets are written next to it (default: input file with _sprites.vtt suffix)")
+	interval := flags.Duration("interval", 10*time.Second, "Time between thumbnails, e.g. 2s or 500ms")
+	columns := flags.Int("columns", 5, "Thumbnails per sheet row")
+	rows := flags.Int("rows", 5, "Thumbnail rows per sheet")
+	width := flags.Int("width", 160, "Thumbnail width in pixels; -1 keeps the aspect ratio")
+	height := flags.Int("height", -1, "Thumbnail height in pixels; -1 keeps the aspect ratio")
+	format := flags.String("format", "jpg", "Sheet image format: jpg or png")
+	urlPrefix := flags.String("url-prefix", "", "Prefix for sheet URLs in the WebVTT file, e.g. https://cdn.example.com/thumbs/")
+	filterName := flags.String("filter", "lanczos", "Resampling filter: "+strings.Join(filters.Names(), ", "))
+	inMatrix := flags.String("in-matrix", "bt601", "Colour matrix of the input: "+strings.Join(colorspace.MatrixNames(), ", "))
+	verbose := flags.Bool("verbose", false, "Enable verbose output")
+	flags.Parse(args)
+
+	if *inputFile == "" {
+		fmt.Println("Error: Input file is required")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *format != "jpg" && *format != "png" {
+		fmt.Printf("Error: unsupported sheet format %q\n", *format)
+		os.Exit(1)
+	}
+	filter, err := filters.ByName(*filterName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	matrix, err := colorspace.ParseMatrix(*inMatrix)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *outputFile == "" {
+		*outputFile = strings.TrimSuffix(*inputFile, filepath.Ext(*inputFile)) + "_sprites.vtt"
+	}
+
+	opts := sprite.Options{
+		Interval:   *interval,
+		Columns:    *columns,
+		Rows:       *rows,
+		TileWidth:  *width,
+		TileHeight: *height,
+		Filter:     filter,
+		Space:      colorspace.Space{Matrix: matrix},
+	}
+	if err := processSprites(*inputFile, *outputFile, *format, *urlPrefix, opts, *verbose); err != nil {
+		fmt.Printf("Error generating sprites: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// processSprites builds the sheets for a Y4M stream, saving each one as
+// <vtt name>_NNN.<format> as soon as it is full, then writes the WebVTT file
+func processSprites(inputPath, outputPath, format, urlPrefix string, opts sprite.Options, verbose bool) error {
+	enc, err := codec.Lookup(format)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := y4m.NewReader(file)
+	if err != nil {
+		return err
+	}
+	// Frames are decoded with the stream's siting and range
+	source, err := newStreamColour(reader.Header, opts.Space.Matrix, nil)
+	if err != nil {
+		return err
+	}
+	opts.Siting, opts.Space, opts.Interlaced = source.Siting, source.Space, source.Interlaced
+	builder, err := sprite.NewBuilder(opts, reader.Header.FrameRate)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	sheetName := func(index int) string {
+		return fmt.Sprintf("%s_%03d.%s", base, index, format)
+	}
+	save := func(sheet *sprite.Sheet) error {
+		path := sheetName(sheet.Index)
+		if verbose {
+			fmt.Printf("Sheet %d: %s (%dx%d)\n", sheet.Index, path, sheet.Image.Rect.Dx(), sheet.Image.Rect.Dy())
+		}
+		return saveImage(path, sheet.Image, enc, codec.Options{}, nil, nil)
+	}
+
+	for index := 0; ; index++ {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", index, err)
+		}
+
+		sheets, err := builder.Push(frame)
+		if err != nil {
+			return err
+		}
+		for _, sheet := range sheets {
+			if err := save(sheet); err != nil {
+				return err
+			}
+		}
+	}
+	if sheet := builder.Flush(); sheet != nil {
+		if err := save(sheet); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+
+	// Sheets live next to the WebVTT file, so refer to them by name only
+	url := func(sheet int) string {
+		return urlPrefix + filepath.Base(sheetName(sheet))
+	}
+	if err := sprite.WriteWebVTT(out, builder.Cues(), url); err != nil {
+		return fmt.Errorf("failed to write WebVTT: %w", err)
+	}
+
+	if verbose {
+		fmt.Printf("Thumbnails: %d, WebVTT: %s\n", len(builder.Cues()), outputPath)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package affine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Matrix is an affine transform of the image plane, mapping x, y to
+// A*x + B*y + C, D*x + E*y + F. Coordinates are in pixels from the centre
+// of the image, with y pointing down.
+type Matrix struct {
+	A, B, C float64
+	D, E, F float64
+}
+
+// Identity leaves every point where it is.
+func Identity() Matrix {
+	return Matrix{A: 1, E: 1}
+}
+
+// Rotation turns the plane clockwise, as seen on screen, by degrees.
+// Multiples of 90 degrees are exact, so they move pixel centres onto pixel
+// centres.
+func Rotation(degrees float64) Matrix {
+	var sin, cos float64
+	switch math.Mod(math.Mod(degrees, 360)+360, 360) {
+	case 0:
+		cos = 1
+	case 90:
+		sin = 1
+	case 180:
+		cos = -1
+	case 270:
+		sin = -1
+	default:
+		sin, cos = math.Sincos(degrees * math.Pi / 180)
+	}
+	return Matrix{A: cos, B: -sin, D: sin, E: cos}
+}
+
+// Shearing slants the plane: x moves by x times y and y by y times x.
+func Shearing(x, y float64) Matrix {
+	return Matrix{A: 1, B: x, D: y, E: 1}
+}
+
+// Scaling stretches the plane by x horizontally and y vertically.
+func Scaling(x, y float64) Matrix {
+	return Matrix{A: x, E: y}
+}
+
+// Translation moves the plane by x, y pixels.
+func Translation(x, y float64) Matrix {
+	return Matrix{A: 1, C: x, E: 1, F: y}
+}
+
+// Then returns the transform applying m and then n.
+func (m Matrix) Then(n Matrix) Matrix {
+	return Matrix{
+		A: n.A*m.A + n.B*m.D, B: n.A*m.B + n.B*m.E, C: n.A*m.C + n.B*m.F + n.C,
+		D: n.D*m.A + n.E*m.D, E: n.D*m.B + n.E*m.E, F: n.D*m.C + n.E*m.F + n.F,
+	}
+}
+
+// Apply maps the point x, y.
+func (m Matrix) Apply(x, y float64) (float64, float64) {
+	return m.A*x + m.B*y + m.C, m.D*x + m.E*y + m.F
+}
+
+// Invert returns the transform undoing m. It fails when m collapses the
+// plane onto a line or point.
+func (m Matrix) Invert() (Matrix, error) {
+	det := m.A*m.E - m.B*m.D
+	if math.Abs(det) < 1e-12 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return Matrix{}, errors.New("transform is not invertible")
+	}
+	inv := Matrix{A: m.E / det, B: -m.B / det, D: -m.D / det, E: m.A / det}
+	inv.C = -(inv.A*m.C + inv.B*m.F)
+	inv.F = -(inv.D*m.C + inv.E*m.F)
+	return inv, nil
+}
+
+// Bounds selects the size of the transformed image.
+type Bounds int
+
+const (
+	// Crop keeps the size and centre of the input, cutting off whatever
+	// the transform moves outside it.
+	Crop Bounds = iota
+	// Expand grows or shrinks the output to just hold the whole
+	// transformed input.
+	Expand
+)
+
+var boundsNames = []string{"crop", "expand"}
+
+// ParseBounds converts a name such as "expand" to Bounds.
+func ParseBounds(name string) (Bounds, error) {
+	for i, n := range boundsNames {
+		if strings.EqualFold(name, n) {
+			return Bounds(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown bounds %q (available: %s)", name, strings.Join(BoundsNames(), ", "))
+}
+
+func (b Bounds) String() string {
+	if b < 0 || int(b) >= len(boundsNames) {
+		return fmt.Sprintf("Bounds(%d)", int(b))
+	}
+	return boundsNames[b]
+}
+
+// BoundsNames lists the accepted bounds names.
+func BoundsNames() []string {
+	return append([]string(nil), boundsNames...)
+}
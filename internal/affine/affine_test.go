@@ -0,0 +1,201 @@
+package affine
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"video-processor/internal/filters"
+	"video-processor/internal/hdr"
+)
+
+// pattern is an opaque image with a distinct colour at every pixel.
+func pattern(width, height int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(x * 40), uint8(y * 40), uint8(x*7 + y*13), 255})
+		}
+	}
+	return m
+}
+
+func TestMatrix(t *testing.T) {
+	tests := []struct {
+		name   string
+		m      Matrix
+		x, y   float64
+		wx, wy float64
+	}{
+		// Right turns to down, which is clockwise on screen.
+		{"rotate 90", Rotation(90), 1, 0, 0, 1},
+		{"rotate -90", Rotation(-90), 1, 0, 0, -1},
+		{"rotate 180", Rotation(540), 2, 1, -2, -1},
+		{"rotate 45", Rotation(45), 1, 0, math.Sqrt2 / 2, math.Sqrt2 / 2},
+		{"shear", Shearing(0.5, 0), 2, 4, 4, 4},
+		{"scale then move", Scaling(2, 3).Then(Translation(1, -1)), 1, 1, 3, 2},
+		{"move then scale", Translation(1, -1).Then(Scaling(2, 3)), 1, 1, 4, 0},
+	}
+	for _, tt := range tests {
+		x, y := tt.m.Apply(tt.x, tt.y)
+		if math.Abs(x-tt.wx) > 1e-12 || math.Abs(y-tt.wy) > 1e-12 {
+			t.Errorf("%s: Apply(%g, %g) = %g, %g; want %g, %g", tt.name, tt.x, tt.y, x, y, tt.wx, tt.wy)
+		}
+	}
+
+	m := Rotation(30).Then(Shearing(0.2, -0.1)).Then(Translation(5, 7))
+	inv, err := m.Invert()
+	if err != nil {
+		t.Fatalf("Invert() unexpected error: %v", err)
+	}
+	if x, y := m.Then(inv).Apply(3, -4); math.Abs(x-3) > 1e-9 || math.Abs(y+4) > 1e-9 {
+		t.Errorf("m then its inverse maps 3, -4 to %g, %g", x, y)
+	}
+	if _, err := Scaling(0, 1).Invert(); err == nil {
+		t.Error("Invert() of a flattening transform expected error")
+	}
+}
+
+func TestRightAnglesAreExact(t *testing.T) {
+	src := pattern(5, 3)
+	tests := []struct {
+		degrees float64
+		width   int
+		// at returns the source pixel that lands on x, y.
+		at func(x, y int) (int, int)
+	}{
+		{90, 3, func(x, y int) (int, int) { return y, 2 - x }},
+		{180, 5, func(x, y int) (int, int) { return 4 - x, 2 - y }},
+		{270, 3, func(x, y int) (int, int) { return 4 - y, x }},
+	}
+	for _, tt := range tests {
+		got, err := Transform(src, Rotation(tt.degrees), &Options{Bounds: Expand})
+		if err != nil {
+			t.Fatalf("%g: Transform() unexpected error: %v", tt.degrees, err)
+		}
+		if got.Rect.Dx() != tt.width || got.Rect.Dy() != 8-tt.width {
+			t.Fatalf("%g: size = %v, want %dx%d", tt.degrees, got.Rect.Size(), tt.width, 8-tt.width)
+		}
+		for y := 0; y < got.Rect.Dy(); y++ {
+			for x := 0; x < got.Rect.Dx(); x++ {
+				sx, sy := tt.at(x, y)
+				if got.NRGBAAt(x, y) != src.NRGBAAt(sx, sy) {
+					t.Errorf("%g: pixel %d,%d = %v, want %v", tt.degrees, x, y, got.NRGBAAt(x, y), src.NRGBAAt(sx, sy))
+				}
+			}
+		}
+	}
+}
+
+func TestRotateBounds(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 200
+	}
+	red := color.NRGBA{255, 0, 0, 255}
+
+	cropped, err := Transform(src, Rotation(45), &Options{Background: red})
+	if err != nil {
+		t.Fatalf("Transform() unexpected error: %v", err)
+	}
+	if cropped.Rect.Dx() != 20 || cropped.Rect.Dy() != 20 {
+		t.Errorf("cropped size = %v, want 20x20", cropped.Rect.Size())
+	}
+	if c := cropped.NRGBAAt(0, 0); c != red {
+		t.Errorf("corner = %v, want the background", c)
+	}
+	if c := cropped.NRGBAAt(10, 10); c != (color.NRGBA{200, 200, 200, 200}) {
+		t.Errorf("centre = %v, want the input", c)
+	}
+
+	expanded, err := Transform(src, Rotation(45), &Options{Bounds: Expand})
+	if err != nil {
+		t.Fatalf("Transform() unexpected error: %v", err)
+	}
+	if expanded.Rect.Dx() != 29 || expanded.Rect.Dy() != 29 {
+		t.Errorf("expanded size = %v, want 29x29", expanded.Rect.Size())
+	}
+	// The default background is transparent, and the diagonal edges fade
+	// into it.
+	if c := expanded.NRGBAAt(0, 0); c.A != 0 {
+		t.Errorf("corner = %v, want transparent", c)
+	}
+	if c := expanded.NRGBAAt(14, 1); c.A == 0 || c.A == 200 {
+		t.Errorf("edge = %v, want partly transparent", c)
+	}
+
+	sheared, err := Transform(src, Shearing(0.5, 0), &Options{Bounds: Expand, Filter: filters.NewTriangle()})
+	if err != nil {
+		t.Fatalf("Transform() unexpected error: %v", err)
+	}
+	if sheared.Rect.Dx() != 30 || sheared.Rect.Dy() != 20 {
+		t.Errorf("sheared size = %v, want 30x20", sheared.Rect.Size())
+	}
+}
+
+func TestShrinkingAverages(t *testing.T) {
+	// A one-pixel checkerboard halved must turn grey rather than alias
+	// into stripes or a solid colour.
+	src := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if (x+y)%2 == 0 {
+				src.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	got, err := Transform(src, Rotation(30).Then(Scaling(0.5, 0.5)), &Options{Bounds: Expand})
+	if err != nil {
+		t.Fatalf("Transform() unexpected error: %v", err)
+	}
+	c := got.NRGBAAt(got.Rect.Dx()/2, got.Rect.Dy()/2)
+	if c.A != 255 || c.R < 112 || c.R > 143 {
+		t.Errorf("centre = %v, want grey", c)
+	}
+}
+
+func TestTransformHDR(t *testing.T) {
+	src := hdr.NewImage(image.Rect(0, 0, 8, 4), 1000)
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = 1000, 500, 10, 1
+	}
+	got, err := TransformHDR(src, Rotation(90), &Options{Bounds: Expand})
+	if err != nil {
+		t.Fatalf("TransformHDR() unexpected error: %v", err)
+	}
+	if got.Rect.Dx() != 4 || got.Rect.Dy() != 8 || got.Peak != 1000 {
+		t.Fatalf("result is %v with peak %g, want 4x8 with peak 1000", got.Rect.Size(), got.Peak)
+	}
+	p := got.Pix[got.PixOffset(1, 3):]
+	if math.Abs(float64(p[0])-1000) > 1e-3 || math.Abs(float64(p[1])-500) > 1e-3 || p[3] != 1 {
+		t.Errorf("pixel = %v, want 1000 500 10 1", p[:4])
+	}
+
+	// Moved out of frame, only the background is left.
+	white, err := TransformHDR(src, Translation(100, 0), &Options{Background: color.White})
+	if err != nil {
+		t.Fatalf("TransformHDR() unexpected error: %v", err)
+	}
+	if p := white.Pix[:4]; math.Abs(float64(p[0])-hdr.SDRWhite) > 1e-3 || p[3] != 1 {
+		t.Errorf("background = %v, want SDR white", p)
+	}
+}
+
+func TestParseBounds(t *testing.T) {
+	for _, name := range BoundsNames() {
+		b, err := ParseBounds(name)
+		if err != nil || b.String() != name {
+			t.Errorf("ParseBounds(%q) = %v, %v", name, b, err)
+		}
+	}
+	if b, err := ParseBounds("Expand"); err != nil || b != Expand {
+		t.Errorf(`ParseBounds("Expand") = %v, %v`, b, err)
+	}
+	if _, err := ParseBounds("fit"); err == nil {
+		t.Error(`ParseBounds("fit") expected error`)
+	}
+	if _, err := Transform(image.NewGray(image.Rect(0, 0, 4, 4)), Identity(), &Options{Bounds: Bounds(7)}); err == nil {
+		t.Error("Transform() with invalid bounds expected error")
+	}
+}
@@ -0,0 +1,237 @@
+package affine
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/hdr"
+)
+
+// maxPixels bounds the output of an expanding transform, which a steep
+// shear could otherwise make arbitrarily large.
+const maxPixels = 1 << 28
+
+// Options control how a transform resamples the input.
+type Options struct {
+	// Filter reconstructs the input between pixel centres; nil means
+	// Lanczos-3, as for resizing.
+	Filter filters.Resampler
+	// Background fills the output where the input does not reach and
+	// blends into its edges; nil is transparent.
+	Background color.Color
+	Bounds     Bounds
+}
+
+// frame is an image as tightly packed, premultiplied RGBA floats.
+type frame struct {
+	pix           []float32
+	width, height int
+}
+
+// Transform resamples src through m. Colour is premultiplied by alpha
+// while filtering, as in resizing, and the input's edges are blended with
+// the background rather than repeated.
+func Transform(src image.Image, m Matrix, opts *Options) (*image.NRGBA, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	bounds := src.Bounds()
+	rgba := image.NewRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, src, bounds.Min, draw.Src)
+	in := frame{pix: make([]float32, len(rgba.Pix)/2), width: bounds.Dx(), height: bounds.Dy()}
+	for i := range in.pix {
+		in.pix[i] = float32(int(rgba.Pix[2*i])<<8|int(rgba.Pix[2*i+1])) / 65535
+	}
+
+	var background [4]float32
+	if opts != nil && opts.Background != nil {
+		r, g, b, a := opts.Background.RGBA()
+		background = [4]float32{float32(r) / 65535, float32(g) / 65535, float32(b) / 65535, float32(a) / 65535}
+	}
+
+	out, err := transform(in, m, background, opts)
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, out.width, out.height))
+	for i := 0; i < len(out.pix); i += 4 {
+		a := min(max(out.pix[i+3], 0), 1)
+		if a == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			dst.Pix[i+c] = uint8(min(max(out.pix[i+c], 0), a)/a*255 + 0.5)
+		}
+		dst.Pix[i+3] = uint8(a*255 + 0.5)
+	}
+	return dst, nil
+}
+
+// TransformHDR is Transform for linear-light images, keeping values above
+// SDR white. The background colour is taken as sRGB.
+func TransformHDR(src *hdr.Image, m Matrix, opts *Options) (*hdr.Image, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	in := frame{pix: make([]float32, 4*src.Rect.Dx()*src.Rect.Dy()), width: src.Rect.Dx(), height: src.Rect.Dy()}
+	for y := 0; y < in.height; y++ {
+		row := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+		out := in.pix[4*y*in.width:]
+		for x := 0; x < 4*in.width; x += 4 {
+			alpha := row[x+3]
+			out[x], out[x+1], out[x+2], out[x+3] = row[x]*alpha, row[x+1]*alpha, row[x+2]*alpha, alpha
+		}
+	}
+
+	var background [4]float32
+	if opts != nil && opts.Background != nil {
+		c := color.NRGBA64Model.Convert(opts.Background).(color.NRGBA64)
+		a := float64(c.A) / 65535
+		linear := func(v uint16) float32 {
+			return float32(colorspace.TransferSRGB.ToLinear(float64(v)/65535) * hdr.SDRWhite * a)
+		}
+		background = [4]float32{linear(c.R), linear(c.G), linear(c.B), float32(a)}
+	}
+
+	out, err := transform(in, m, background, opts)
+	if err != nil {
+		return nil, err
+	}
+	dst := hdr.NewImage(image.Rect(0, 0, out.width, out.height), src.Peak)
+	for i := 0; i < len(out.pix); i += 4 {
+		a := min(max(out.pix[i+3], 0), 1)
+		if a == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			dst.Pix[i+c] = max(out.pix[i+c], 0) / a
+		}
+		dst.Pix[i+3] = a
+	}
+	return dst, nil
+}
+
+// transform maps every output pixel centre back through m into src and
+// filters the input around it. Where m shrinks the image the filter is
+// widened by as much, as when downscaling, so fine detail averages out
+// instead of aliasing. Input pixels outside src count as background.
+func transform(src frame, m Matrix, background [4]float32, opts *Options) (frame, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	filter := o.Filter
+	if filter == nil {
+		filter = filters.NewLanczos(3)
+	}
+	if src.width <= 0 || src.height <= 0 {
+		return frame{}, errors.New("source image is empty")
+	}
+	inv, err := m.Invert()
+	if err != nil {
+		return frame{}, err
+	}
+
+	width, height := src.width, src.height
+	var centreX, centreY float64
+	switch o.Bounds {
+	case Crop:
+	case Expand:
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		halfW, halfH := float64(src.width)/2, float64(src.height)/2
+		for _, corner := range [][2]float64{{-halfW, -halfH}, {halfW, -halfH}, {-halfW, halfH}, {halfW, halfH}} {
+			x, y := m.Apply(corner[0], corner[1])
+			minX, maxX = min(minX, x), max(maxX, x)
+			minY, maxY = min(minY, y), max(maxY, y)
+		}
+		// Rounding error must not add a row of background
+		w, h := math.Ceil(maxX-minX-1e-6), math.Ceil(maxY-minY-1e-6)
+		if w*h > maxPixels {
+			return frame{}, fmt.Errorf("transformed image %.0fx%.0f too large", w, h)
+		}
+		width, height = max(int(w), 1), max(int(h), 1)
+		centreX, centreY = (minX+maxX)/2, (minY+maxY)/2
+	default:
+		return frame{}, fmt.Errorf("invalid bounds %v", o.Bounds)
+	}
+
+	scaleX := max(1, math.Hypot(inv.A, inv.B))
+	scaleY := max(1, math.Hypot(inv.D, inv.E))
+	radiusX, radiusY := filter.Support()*scaleX, filter.Support()*scaleY
+	weightsX := make([]float64, 0, int(2*radiusX)+2)
+	weightsY := make([]float64, 0, int(2*radiusY)+2)
+
+	dst := frame{pix: make([]float32, 4*width*height), width: width, height: height}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			out := dst.pix[4*(y*width+x):][:4]
+			sx, sy := inv.Apply(float64(x)+0.5-float64(width)/2+centreX, float64(y)+0.5-float64(height)/2+centreY)
+			sx, sy = sx+float64(src.width)/2, sy+float64(src.height)/2
+
+			// The taps are the input pixels whose centres lie within the
+			// filter's reach
+			x0, x1 := int(math.Ceil(sx-0.5-radiusX)), int(math.Floor(sx-0.5+radiusX))
+			y0, y1 := int(math.Ceil(sy-0.5-radiusY)), int(math.Floor(sy-0.5+radiusY))
+			if x1 < 0 || y1 < 0 || x0 >= src.width || y0 >= src.height {
+				copy(out, background[:])
+				continue
+			}
+
+			weightsX, weightsY = weightsX[:0], weightsY[:0]
+			var sumX, sumY float64
+			for i := x0; i <= x1; i++ {
+				w := filter.Kernel((float64(i) + 0.5 - sx) / scaleX)
+				weightsX = append(weightsX, w)
+				sumX += w
+			}
+			for j := y0; j <= y1; j++ {
+				w := filter.Kernel((float64(j) + 0.5 - sy) / scaleY)
+				weightsY = append(weightsY, w)
+				sumY += w
+			}
+			total := sumX * sumY
+			if total == 0 {
+				copy(out, background[:])
+				continue
+			}
+
+			var acc [4]float64
+			var outside float64
+			for j, wy := range weightsY {
+				row := y0 + j
+				if wy == 0 {
+					continue
+				}
+				if row < 0 || row >= src.height {
+					outside += wy * sumX
+					continue
+				}
+				line := src.pix[4*row*src.width:]
+				for i, wx := range weightsX {
+					col := x0 + i
+					if col < 0 || col >= src.width {
+						outside += wx * wy
+						continue
+					}
+					w := wx * wy
+					p := line[4*col : 4*col+4]
+					acc[0] += w * float64(p[0])
+					acc[1] += w * float64(p[1])
+					acc[2] += w * float64(p[2])
+					acc[3] += w * float64(p[3])
+				}
+			}
+			for c := range acc {
+				out[c] = float32((acc[c] + outside*float64(background[c])) / total)
+			}
+		}
+	}
+	return dst, nil
+}
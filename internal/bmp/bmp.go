@@ -0,0 +1,406 @@
+package bmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+
+	"video-processor/internal/imagesize"
+)
+
+const fileHeaderSize = 14
+
+// Compression methods of the info header.
+const (
+	biRGB            = 0
+	biRLE8           = 1
+	biRLE4           = 2
+	biBitfields      = 3
+	biAlphaBitfields = 6
+)
+
+// Sizes of the info header versions.
+const (
+	coreHeaderSize = 12
+	infoHeaderSize = 40
+	v4HeaderSize   = 108
+)
+
+func init() {
+	image.RegisterFormat("bmp", "BM", Decode, DecodeConfig)
+}
+
+// header is the file header and whichever version of the info header
+// follows it, reduced to what decoding needs.
+type header struct {
+	width, height int
+	// topDown is set for a negative height, which stores the top row first.
+	topDown     bool
+	bitCount    int
+	compression uint32
+	// masks are the red, green, blue and alpha bit masks of 16- and 32-bit
+	// pixels. An alpha mask of zero means the image is opaque.
+	masks   [4]uint32
+	palette color.Palette
+	// dataOffset is how far past the end of the palette the pixels start.
+	dataOffset int
+}
+
+func readHeader(r *bufio.Reader) (header, error) {
+	var h header
+	var fileHeader [fileHeaderSize + 4]byte
+	if _, err := io.ReadFull(r, fileHeader[:]); err != nil {
+		return h, fmt.Errorf("failed to read header: %w", unexpectedEOF(err))
+	}
+	if string(fileHeader[:2]) != "BM" {
+		return h, errors.New("not a BMP file")
+	}
+	offset := int(binary.LittleEndian.Uint32(fileHeader[10:]))
+	size := int(binary.LittleEndian.Uint32(fileHeader[14:]))
+	if size < coreHeaderSize || size > 4096 {
+		return h, fmt.Errorf("unsupported BMP header size %d", size)
+	}
+	info := make([]byte, size)
+	if _, err := io.ReadFull(r, info[4:]); err != nil {
+		return h, fmt.Errorf("failed to read header: %w", unexpectedEOF(err))
+	}
+
+	paletteEntry := 4
+	if size == coreHeaderSize {
+		// OS/2 core headers have 16-bit dimensions and RGB triples.
+		h.width = int(binary.LittleEndian.Uint16(info[4:]))
+		h.height = int(binary.LittleEndian.Uint16(info[6:]))
+		h.bitCount = int(binary.LittleEndian.Uint16(info[10:]))
+		paletteEntry = 3
+	} else {
+		if size < infoHeaderSize {
+			return h, fmt.Errorf("unsupported BMP header size %d", size)
+		}
+		h.width = int(int32(binary.LittleEndian.Uint32(info[4:])))
+		height := int32(binary.LittleEndian.Uint32(info[8:]))
+		h.topDown = height < 0
+		h.height = int(height)
+		if h.topDown {
+			h.height = -h.height
+		}
+		h.bitCount = int(binary.LittleEndian.Uint16(info[14:]))
+		h.compression = binary.LittleEndian.Uint32(info[16:])
+	}
+	if !imagesize.Valid(h.width, h.height) {
+		return h, fmt.Errorf("invalid BMP dimensions %dx%d", h.width, h.height)
+	}
+
+	switch h.compression {
+	case biRGB:
+		switch h.bitCount {
+		case 1, 4, 8, 24:
+		case 16:
+			h.masks = [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+		case 32:
+			h.masks = [4]uint32{0xff0000, 0xff00, 0xff, 0}
+		default:
+			return h, fmt.Errorf("unsupported BMP bit depth %d", h.bitCount)
+		}
+	case biRLE8, biRLE4:
+		if h.compression == biRLE8 && h.bitCount != 8 || h.compression == biRLE4 && h.bitCount != 4 {
+			return h, fmt.Errorf("invalid bit depth %d for run-length encoding", h.bitCount)
+		}
+		if h.topDown {
+			return h, errors.New("run-length encoded BMP cannot be top-down")
+		}
+	case biBitfields, biAlphaBitfields:
+		if h.bitCount != 16 && h.bitCount != 32 {
+			return h, fmt.Errorf("invalid bit depth %d for bit fields", h.bitCount)
+		}
+		// An info header is followed by the masks; later versions
+		// include them.
+		n := 3
+		if h.compression == biAlphaBitfields {
+			n = 4
+		}
+		if size == infoHeaderSize {
+			extra := make([]byte, 4*n)
+			if _, err := io.ReadFull(r, extra); err != nil {
+				return h, fmt.Errorf("failed to read bit fields: %w", unexpectedEOF(err))
+			}
+			info = append(info, extra...)
+		} else if size > infoHeaderSize {
+			n = min(4, (size-infoHeaderSize)/4)
+		}
+		for i := 0; i < n; i++ {
+			h.masks[i] = binary.LittleEndian.Uint32(info[infoHeaderSize+4*i:])
+		}
+		if h.masks[0] == 0 && h.masks[1] == 0 && h.masks[2] == 0 {
+			return h, errors.New("empty BMP bit fields")
+		}
+	default:
+		return h, fmt.Errorf("unsupported BMP compression %d", h.compression)
+	}
+
+	read := fileHeaderSize + len(info)
+	if h.bitCount <= 8 {
+		colors := 0
+		if size > coreHeaderSize {
+			colors = int(binary.LittleEndian.Uint32(info[32:]))
+		}
+		if colors == 0 || colors > 1<<h.bitCount {
+			colors = 1 << h.bitCount
+		}
+		// Some writers declare more colours than fit before the pixels.
+		if offset > read {
+			colors = min(colors, (offset-read)/paletteEntry)
+		}
+		raw := make([]byte, colors*paletteEntry)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return h, fmt.Errorf("failed to read palette: %w", unexpectedEOF(err))
+		}
+		read += len(raw)
+		h.palette = make(color.Palette, colors)
+		for i := range h.palette {
+			p := raw[i*paletteEntry:]
+			h.palette[i] = color.RGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+		}
+	}
+	h.dataOffset = max(0, offset-read)
+	return h, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (h header) model() color.Model {
+	if h.palette != nil {
+		return h.palette
+	}
+	return color.NRGBAModel
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.model(), Width: h.width, Height: h.height}, nil
+}
+
+// Decode reads a BMP image. Palette images, including run-length encoded
+// ones, decode to *image.Paletted and all others to *image.NRGBA. Alpha
+// comes from the alpha bit field when there is one; 32-bit images without
+// bit fields use the fourth byte as alpha unless it is zero throughout, as
+// many writers leave it unset.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := br.Discard(h.dataOffset); err != nil {
+		return nil, fmt.Errorf("failed to read pixels: %w", unexpectedEOF(err))
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	// row maps a stored row to an image row.
+	row := func(i int) int {
+		if h.topDown {
+			return i
+		}
+		return h.height - 1 - i
+	}
+
+	if h.compression == biRLE8 || h.compression == biRLE4 {
+		img := image.NewPaletted(rect, h.palette)
+		if err := decodeRLE(br, img, h.compression == biRLE4); err != nil {
+			return nil, err
+		}
+		return img, checkIndices(img)
+	}
+
+	line := make([]byte, (h.width*h.bitCount+31)/32*4)
+	if h.palette != nil {
+		img := image.NewPaletted(rect, h.palette)
+		perByte := 8 / h.bitCount
+		for i := 0; i < h.height; i++ {
+			if _, err := io.ReadFull(br, line); err != nil {
+				return nil, fmt.Errorf("failed to read row %d: %w", i, unexpectedEOF(err))
+			}
+			dst := img.Pix[row(i)*img.Stride:]
+			for x := 0; x < h.width; x++ {
+				shift := 8 - h.bitCount*(x%perByte+1)
+				dst[x] = line[x/perByte] >> shift & (1<<h.bitCount - 1)
+			}
+		}
+		return img, checkIndices(img)
+	}
+
+	img := image.NewNRGBA(rect)
+	var fields [4]field
+	for i, m := range h.masks {
+		fields[i] = newField(m)
+	}
+	// guessAlpha is set for 32-bit pixels whose fourth byte may or may not
+	// be alpha.
+	guessAlpha := h.bitCount == 32 && h.compression == biRGB
+	if guessAlpha {
+		fields[3] = newField(0xff000000)
+	}
+	anyAlpha := false
+	for i := 0; i < h.height; i++ {
+		if _, err := io.ReadFull(br, line); err != nil {
+			return nil, fmt.Errorf("failed to read row %d: %w", i, unexpectedEOF(err))
+		}
+		dst := img.Pix[row(i)*img.Stride:]
+		for x := 0; x < h.width; x++ {
+			p := dst[4*x : 4*x+4]
+			if h.bitCount == 24 {
+				p[0], p[1], p[2], p[3] = line[3*x+2], line[3*x+1], line[3*x], 0xff
+				continue
+			}
+			var v uint32
+			if h.bitCount == 16 {
+				v = uint32(binary.LittleEndian.Uint16(line[2*x:]))
+			} else {
+				v = binary.LittleEndian.Uint32(line[4*x:])
+			}
+			for c, f := range fields {
+				p[c] = f.extract(v)
+			}
+			anyAlpha = anyAlpha || p[3] != 0
+		}
+	}
+	if guessAlpha && !anyAlpha {
+		for o := 3; o < len(img.Pix); o += 4 {
+			img.Pix[o] = 0xff
+		}
+	}
+	return img, nil
+}
+
+// field extracts one channel from a pixel through its bit mask.
+type field struct {
+	mask  uint32
+	shift int
+	max   uint32
+}
+
+func newField(mask uint32) field {
+	if mask == 0 {
+		return field{}
+	}
+	shift := bits.TrailingZeros32(mask)
+	return field{mask: mask, shift: shift, max: mask >> shift}
+}
+
+// extract returns the channel scaled to 8 bits, or 0xff when there is no
+// mask: only alpha is missing in practice, and then the pixel is opaque.
+func (f field) extract(v uint32) uint8 {
+	if f.mask == 0 {
+		return 0xff
+	}
+	return uint8((uint64(v&f.mask>>f.shift)*255 + uint64(f.max)/2) / uint64(f.max))
+}
+
+// decodeRLE expands BI_RLE8 or BI_RLE4 data. Pixels skipped by a delta or
+// left by an early end of bitmap keep index 0.
+func decodeRLE(r *bufio.Reader, img *image.Paletted, nibbles bool) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	x, y := 0, height-1
+	put := func(index byte) {
+		if x < width && y >= 0 {
+			img.Pix[y*img.Stride+x] = index
+		}
+		x++
+	}
+	next := func() (byte, error) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read pixels: %w", unexpectedEOF(err))
+		}
+		return b, nil
+	}
+
+	for {
+		count, err := next()
+		if err != nil {
+			return err
+		}
+		value, err := next()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			// An encoded run; RLE4 alternates the two nibbles.
+			for i := 0; i < int(count); i++ {
+				switch {
+				case !nibbles:
+					put(value)
+				case i%2 == 0:
+					put(value >> 4)
+				default:
+					put(value & 0x0f)
+				}
+			}
+			continue
+		}
+
+		switch value {
+		case 0:
+			x, y = 0, y-1
+		case 1:
+			return nil
+		case 2:
+			dx, err := next()
+			if err != nil {
+				return err
+			}
+			dy, err := next()
+			if err != nil {
+				return err
+			}
+			x, y = x+int(dx), y-int(dy)
+		default:
+			// An absolute run of literal pixels, padded to a 16-bit
+			// boundary.
+			n := int(value)
+			size := n
+			if nibbles {
+				size = (n + 1) / 2
+			}
+			literal := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, literal); err != nil {
+				return fmt.Errorf("failed to read pixels: %w", unexpectedEOF(err))
+			}
+			for i := 0; i < n; i++ {
+				switch {
+				case !nibbles:
+					put(literal[i])
+				case i%2 == 0:
+					put(literal[i/2] >> 4)
+				default:
+					put(literal[i/2] & 0x0f)
+				}
+			}
+		}
+		if y < 0 {
+			return nil
+		}
+	}
+}
+
+// checkIndices rejects pixels that refer past the end of the palette.
+func checkIndices(img *image.Paletted) error {
+	for _, v := range img.Pix {
+		if int(v) >= len(img.Palette) {
+			return fmt.Errorf("palette index %d out of range", v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package bmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// file builds a BMP from an info header of the given size, the bytes that
+// follow the fixed part of the header (masks and palette) and the pixels.
+func file(headerSize, width, height, bitCount int, compression uint32, extra, pixels []byte) []byte {
+	le := binary.LittleEndian
+	info := make([]byte, headerSize)
+	le.PutUint32(info[0:], uint32(headerSize))
+	le.PutUint32(info[4:], uint32(int32(width)))
+	le.PutUint32(info[8:], uint32(int32(height)))
+	le.PutUint16(info[12:], 1)
+	le.PutUint16(info[14:], uint16(bitCount))
+	le.PutUint32(info[16:], compression)
+	if headerSize > infoHeaderSize {
+		copy(info[infoHeaderSize:], extra)
+		extra = nil
+	}
+
+	out := make([]byte, fileHeaderSize)
+	copy(out, "BM")
+	offset := fileHeaderSize + len(info) + len(extra)
+	le.PutUint32(out[2:], uint32(offset+len(pixels)))
+	le.PutUint32(out[10:], uint32(offset))
+	out = append(out, info...)
+	out = append(out, extra...)
+	return append(out, pixels...)
+}
+
+func words(values ...uint32) []byte {
+	out := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+func TestDecode(t *testing.T) {
+	// Two-colour palette: black, then red.
+	palette := []byte{0, 0, 0, 0, 0, 0, 255, 0}
+	tests := []struct {
+		name string
+		data []byte
+		// want lists the top row of a 2x2 image, then the bottom row.
+		want [4]color.Color
+	}{
+		{
+			"1-bit",
+			file(infoHeaderSize, 2, 2, 1, biRGB, palette, []byte{0x80, 0, 0, 0, 0x40, 0, 0, 0}),
+			[4]color.Color{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 0, 255}},
+		},
+		{
+			"24-bit top-down",
+			file(infoHeaderSize, 2, -2, 24, biRGB, nil, []byte{
+				0, 0, 255, 0, 255, 0, 0, 0,
+				255, 0, 0, 255, 255, 255, 0, 0,
+			}),
+			[4]color.Color{color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{255, 255, 255, 255}},
+		},
+		{
+			"16-bit 565 bit fields",
+			file(infoHeaderSize, 2, 2, 16, biBitfields, words(0xf800, 0x07e0, 0x001f), []byte{
+				0x1f, 0x00, 0xe0, 0x07,
+				0x00, 0xf8, 0xff, 0xff,
+			}),
+			[4]color.Color{color.NRGBA{255, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 255, 0, 255}},
+		},
+		{
+			"16-bit default 555",
+			file(infoHeaderSize, 2, 2, 16, biRGB, nil, []byte{
+				0x00, 0x7c, 0x00, 0x00,
+				0x00, 0x00, 0x1f, 0x00,
+			}),
+			[4]color.Color{color.NRGBA{0, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 0, 255}},
+		},
+		{
+			"32-bit V5 alpha bit fields",
+			file(124, 2, 2, 32, biBitfields, words(0xff0000, 0xff00, 0xff, 0xff000000), words(
+				0x80ff0000, 0x00000000,
+				0xff00ff00, 0x400000ff,
+			)),
+			[4]color.Color{color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 0, 255, 64}, color.NRGBA{255, 0, 0, 128}, color.NRGBA{0, 0, 0, 0}},
+		},
+		{
+			"32-bit without alpha",
+			file(infoHeaderSize, 2, 2, 32, biRGB, nil, words(0x00ff0000, 0x0000ff00, 0x000000ff, 0x00ffffff)),
+			[4]color.Color{color.NRGBA{0, 0, 255, 255}, color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}},
+		},
+		{
+			"RLE8",
+			// A run of red on the bottom row, then a delta past the
+			// top-left pixel and a single red.
+			file(infoHeaderSize, 2, 2, 8, biRLE8, palette, []byte{2, 1, 0, 0, 0, 2, 1, 0, 1, 1, 0, 1}),
+			[4]color.Color{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{255, 0, 0, 255}},
+		},
+		{
+			"RLE4",
+			// Runs alternate their two nibbles.
+			file(infoHeaderSize, 2, 2, 4, biRLE4, palette, []byte{2, 0x10, 0, 0, 2, 0x01, 0, 1}),
+			[4]color.Color{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 0, 255}},
+		},
+	}
+
+	for _, tt := range tests {
+		img, format, err := image.Decode(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: image.Decode() unexpected error: %v", tt.name, err)
+			continue
+		}
+		if format != "bmp" {
+			t.Errorf("%s: format = %q, want bmp", tt.name, format)
+		}
+		for i, want := range tt.want {
+			if got := img.At(i%2, i/2); got != want {
+				t.Errorf("%s: pixel (%d, %d) = %#v, want %#v", tt.name, i%2, i/2, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated":     file(infoHeaderSize, 2, 2, 24, biRGB, nil, []byte{1, 2, 3})[:60],
+		"zero width":    file(infoHeaderSize, 0, 2, 24, biRGB, nil, nil),
+		"bad depth":     file(infoHeaderSize, 1, 1, 7, biRGB, nil, make([]byte, 4)),
+		"bad index":     file(infoHeaderSize, 1, 1, 8, biRGB, []byte{0, 0, 0, 0}, []byte{5, 0, 0, 0}),
+		"compression":   file(infoHeaderSize, 1, 1, 24, 4, nil, make([]byte, 4)),
+		"empty masks":   file(infoHeaderSize, 1, 1, 32, biBitfields, words(0, 0, 0), make([]byte, 4)),
+		"RLE top-down":  file(infoHeaderSize, 1, -1, 8, biRLE8, nil, []byte{0, 1}),
+		"RLE truncated": file(infoHeaderSize, 4, 1, 8, biRLE8, nil, []byte{0, 4, 1}),
+	}
+	for name, data := range tests {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode() expected error, got nil", name)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	opaque := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	translucent := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	grey := image.NewGray(image.Rect(0, 0, 3, 2))
+	paletted := image.NewPaletted(image.Rect(0, 0, 7, 2), color.Palette{color.Black, color.White, color.RGBA{10, 20, 30, 255}})
+	for i := range opaque.Pix {
+		opaque.Pix[i] = uint8(i * 7)
+		translucent.Pix[i] = uint8(i * 5)
+		if i%4 == 3 {
+			opaque.Pix[i] = 255
+		}
+	}
+	for i := range grey.Pix {
+		grey.Pix[i] = uint8(i * 40)
+	}
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % 3)
+	}
+
+	tests := []struct {
+		name     string
+		src      image.Image
+		bitCount int
+	}{
+		{"opaque", opaque, 24},
+		{"translucent", translucent, 32},
+		{"grey", grey, 8},
+		{"paletted", paletted, 8},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.src); err != nil {
+			t.Fatalf("%s: Encode() unexpected error: %v", tt.name, err)
+		}
+		if got := int(binary.LittleEndian.Uint16(buf.Bytes()[28:])); got != tt.bitCount {
+			t.Errorf("%s: bit count = %d, want %d", tt.name, got, tt.bitCount)
+		}
+		img, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: Decode() unexpected error: %v", tt.name, err)
+		}
+		b := tt.src.Bounds()
+		if img.Bounds() != b {
+			t.Fatalf("%s: bounds = %v, want %v", tt.name, img.Bounds(), b)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				want := color.NRGBAModel.Convert(tt.src.At(x, y))
+				if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+					t.Fatalf("%s: pixel (%d, %d) = %v, want %v", tt.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package bmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"video-processor/internal/imagesize"
+)
+
+// pixelsPerMetre is 72 DPI, written as the resolution of every image.
+const pixelsPerMetre = 2835
+
+// lcsSRGB is the colour space tag of a V4 header.
+const lcsSRGB = 0x73524742
+
+// Encode writes m as a bottom-up BMP. Paletted and greyscale images are
+// written with an 8-bit palette, opaque images as 24-bit BGR and others as
+// 32-bit BGRA with a V4 header whose bit fields declare the alpha channel.
+func Encode(w io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if !imagesize.Valid(width, height) {
+		return fmt.Errorf("cannot encode %dx%d image as BMP", width, height)
+	}
+
+	var palette color.Palette
+	switch src := m.(type) {
+	case *image.Paletted:
+		if len(src.Palette) <= 256 && paletteOpaque(src.Palette) {
+			palette = src.Palette
+		}
+	case *image.Gray:
+		palette = make(color.Palette, 256)
+		for i := range palette {
+			palette[i] = color.Gray{Y: uint8(i)}
+		}
+	}
+
+	var src *image.NRGBA
+	bitCount, headerSize, compression := 24, infoHeaderSize, uint32(biRGB)
+	if palette == nil {
+		src = toNRGBA(m)
+		if !src.Opaque() {
+			bitCount, headerSize, compression = 32, v4HeaderSize, biBitfields
+		}
+	} else {
+		bitCount = 8
+	}
+
+	stride := (width*bitCount + 31) / 32 * 4
+	offset := fileHeaderSize + headerSize + 4*len(palette)
+	fileSize := offset + stride*height
+
+	bw := bufio.NewWriter(w)
+	header := make([]byte, offset)
+	copy(header, "BM")
+	le := binary.LittleEndian
+	le.PutUint32(header[2:], uint32(fileSize))
+	le.PutUint32(header[10:], uint32(offset))
+	info := header[fileHeaderSize:]
+	le.PutUint32(info[0:], uint32(headerSize))
+	le.PutUint32(info[4:], uint32(width))
+	le.PutUint32(info[8:], uint32(height))
+	le.PutUint16(info[12:], 1)
+	le.PutUint16(info[14:], uint16(bitCount))
+	le.PutUint32(info[16:], compression)
+	le.PutUint32(info[20:], uint32(stride*height))
+	le.PutUint32(info[24:], pixelsPerMetre)
+	le.PutUint32(info[28:], pixelsPerMetre)
+	le.PutUint32(info[32:], uint32(len(palette)))
+	if headerSize == v4HeaderSize {
+		for i, mask := range []uint32{0xff0000, 0xff00, 0xff, 0xff000000} {
+			le.PutUint32(info[infoHeaderSize+4*i:], mask)
+		}
+		le.PutUint32(info[56:], lcsSRGB)
+	}
+	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
+		copy(header[fileHeaderSize+headerSize+4*i:], []byte{uint8(b >> 8), uint8(g >> 8), uint8(r >> 8), 0})
+	}
+	bw.Write(header)
+
+	line := make([]byte, stride)
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		switch {
+		case palette != nil:
+			// The indices of a paletted image, or the grey levels, which
+			// index the grey ramp.
+			switch src := m.(type) {
+			case *image.Paletted:
+				copy(line, src.Pix[src.PixOffset(bounds.Min.X, y):][:width])
+			case *image.Gray:
+				copy(line, src.Pix[src.PixOffset(bounds.Min.X, y):][:width])
+			}
+		default:
+			row := src.Pix[src.PixOffset(bounds.Min.X, y):]
+			n := bitCount / 8
+			for x := 0; x < width; x++ {
+				p := line[n*x:]
+				p[0], p[1], p[2] = row[4*x+2], row[4*x+1], row[4*x]
+				if n == 4 {
+					p[3] = row[4*x+3]
+				}
+			}
+		}
+		bw.Write(line)
+	}
+	return bw.Flush()
+}
+
+// paletteOpaque reports whether a palette can be written as is; BMP
+// palettes have no alpha.
+func paletteOpaque(p color.Palette) bool {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+func toNRGBA(m image.Image) *image.NRGBA {
+	if img, ok := m.(*image.NRGBA); ok {
+		return img
+	}
+	bounds := m.Bounds()
+	img := image.NewNRGBA(bounds)
+	draw.Draw(img, bounds, m, bounds.Min, draw.Src)
+	return img
+}
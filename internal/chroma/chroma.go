@@ -0,0 +1,251 @@
+package chroma
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/resize"
+	"video-processor/internal/subsample"
+)
+
+// Siting is the position of subsampled chroma samples relative to the luma
+// samples they cover. Names follow ffmpeg's chroma_loc.
+type Siting int
+
+const (
+	// Center places chroma midway between luma samples in both directions,
+	// as in JPEG and MPEG-1.
+	Center Siting = iota
+	// Left co-sites chroma with the left luma column and centres it
+	// vertically, as in MPEG-2, H.264 and HEVC by default.
+	Left
+	// TopLeft co-sites chroma with the top-left luma sample.
+	TopLeft
+	Top
+	BottomLeft
+	Bottom
+)
+
+var sitingNames = []string{"center", "left", "topleft", "top", "bottomleft", "bottom"}
+
+func ParseSiting(name string) (Siting, error) {
+	for i, n := range sitingNames {
+		if n == name {
+			return Siting(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown chroma siting %q (available: %s)", name, strings.Join(sitingNames, ", "))
+}
+
+func (s Siting) String() string {
+	if s < 0 || int(s) >= len(sitingNames) {
+		return fmt.Sprintf("Siting(%d)", int(s))
+	}
+	return sitingNames[s]
+}
+
+// SitingNames lists the names understood by ParseSiting.
+func SitingNames() []string {
+	return append([]string(nil), sitingNames...)
+}
+
+// position returns the luma coordinates of the first chroma sample for
+// subsampling factors fx and fy. Later samples follow every fx columns and
+// fy rows.
+func (s Siting) position(fx, fy int) (float64, float64) {
+	x := float64(fx-1) / 2
+	if s == Left || s == TopLeft || s == BottomLeft {
+		x = 0
+	}
+	y := float64(fy-1) / 2
+	switch s {
+	case TopLeft, Top:
+		y = 0
+	case BottomLeft, Bottom:
+		y = float64(fy - 1)
+	}
+	return x, y
+}
+
+// Position returns the luma coordinates of the first chroma sample of the
+// given layout, as resize.YCbCrOptions expects them.
+func (s Siting) Position(ratio image.YCbCrSubsampleRatio) (float64, float64) {
+	return s.position(subsample.Factors(ratio))
+}
+
+// grid describes where the chroma samples of one layout sit on the luma
+// grid.
+type grid struct {
+	fx, fy int
+	x, y   float64
+}
+
+func newGrid(ratio image.YCbCrSubsampleRatio, siting Siting) grid {
+	fx, fy := subsample.Factors(ratio)
+	x, y := siting.position(fx, fy)
+	return grid{fx: fx, fy: fy, x: x, y: y}
+}
+
+// size returns the chroma plane size for a width x height frame, matching
+// image.NewYCbCr.
+func (g grid) size(width, height int) (int, int) {
+	return (width + g.fx - 1) / g.fx, (height + g.fy - 1) / g.fy
+}
+
+// axis maps chroma samples spaced to luma pixels apart, the first at luma
+// position toAt, onto samples spaced from apart with the first at fromAt.
+func axis(from int, fromAt float64, to int, toAt float64) resize.Axis {
+	scale := float64(to) / float64(from)
+	return resize.Axis{Scale: scale, Offset: (toAt-fromAt)/float64(from) + (1-scale)/2}
+}
+
+// resample moves a chroma plane from one grid to another. Both grids cover
+// the same width x height frame.
+func resample(p resize.Plane, from, to grid, width, height int, filter filters.Resampler) (resize.Plane, error) {
+	w, h := to.size(width, height)
+	return resize.ResamplePlane(p, w, h, filter, axis(from.fx, from.x, to.fx, to.x), axis(from.fy, from.y, to.fy, to.y))
+}
+
+func planes(img *image.YCbCr) (resize.Plane, resize.Plane, resize.Plane) {
+	bounds := img.Rect
+	g := newGrid(img.SubsampleRatio, Center)
+	cw, ch := g.size(bounds.Dx(), bounds.Dy())
+	yOffset, cOffset := img.YOffset(bounds.Min.X, bounds.Min.Y), img.COffset(bounds.Min.X, bounds.Min.Y)
+	return resize.Plane{Pix: img.Y[yOffset:], Stride: img.YStride, Width: bounds.Dx(), Height: bounds.Dy()},
+		resize.Plane{Pix: img.Cb[cOffset:], Stride: img.CStride, Width: cw, Height: ch},
+		resize.Plane{Pix: img.Cr[cOffset:], Stride: img.CStride, Width: cw, Height: ch}
+}
+
+// assemble builds a YCbCr image from planes of the matching sizes.
+func assemble(y, cb, cr resize.Plane, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	dst := image.NewYCbCr(image.Rect(0, 0, y.Width, y.Height), ratio)
+	for row := 0; row < y.Height; row++ {
+		copy(dst.Y[row*dst.YStride:row*dst.YStride+y.Width], y.Pix[row*y.Stride:])
+	}
+	for row := 0; row < cb.Height; row++ {
+		copy(dst.Cb[row*dst.CStride:row*dst.CStride+cb.Width], cb.Pix[row*cb.Stride:])
+		copy(dst.Cr[row*dst.CStride:row*dst.CStride+cr.Width], cr.Pix[row*cr.Stride:])
+	}
+	return dst
+}
+
+// FromRGB encodes src as YCbCr in space with the given subsampling,
+// filtering the full-resolution chroma down to samples at siting. A nil
+// filter selects Triangle, which gives the usual [1 3 3 1] taps for centred
+// and [1 2 1] taps for co-sited 2:1 chroma.
+func FromRGB(src image.Image, ratio image.YCbCrSubsampleRatio, siting Siting, space colorspace.Space, filter filters.Resampler) (*image.YCbCr, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if filter == nil {
+		filter = filters.NewTriangle()
+	}
+	return fromRGB(src, ratio, newGrid(ratio, siting), space, filter)
+}
+
+// fromRGB encodes src with its chroma filtered down to samples on grid to.
+func fromRGB(src image.Image, ratio image.YCbCrSubsampleRatio, to grid, space colorspace.Space, filter filters.Resampler) (*image.YCbCr, error) {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("source image is empty")
+	}
+
+	rgb, ok := src.(*image.NRGBA)
+	if !ok || rgb.Rect.Min != (image.Point{}) {
+		rgb = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(rgb, rgb.Rect, src, bounds.Min, draw.Src)
+	}
+
+	y, cb, cr := resize.NewPlane(width, height), resize.NewPlane(width, height), resize.NewPlane(width, height)
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			c := rgb.NRGBAAt(col, row)
+			i := row*width + col
+			yy, u, v := space.FromRGB(float64(c.R), float64(c.G), float64(c.B))
+			y.Pix[i], cb.Pix[i], cr.Pix[i] = colorspace.Quantize(yy), colorspace.Quantize(u), colorspace.Quantize(v)
+		}
+	}
+
+	full := newGrid(image.YCbCrSubsampleRatio444, Center)
+	var err error
+	if cb, err = resample(cb, full, to, width, height, filter); err != nil {
+		return nil, err
+	}
+	if cr, err = resample(cr, full, to, width, height, filter); err != nil {
+		return nil, err
+	}
+	return assemble(y, cb, cr, ratio), nil
+}
+
+// ToRGB decodes src, encoded in space, to RGB, interpolating its chroma,
+// which sits at siting, up to every luma sample. A nil filter selects
+// Triangle; Box repeats the nearest chroma sample instead.
+func ToRGB(src *image.YCbCr, siting Siting, space colorspace.Space, filter filters.Resampler) (*image.NRGBA, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if filter == nil {
+		filter = filters.NewTriangle()
+	}
+	return toRGB(src, newGrid(src.SubsampleRatio, siting), space, filter)
+}
+
+// toRGB decodes src with its chroma interpolated from samples on grid from.
+func toRGB(src *image.YCbCr, from grid, space colorspace.Space, filter filters.Resampler) (*image.NRGBA, error) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("source image is empty")
+	}
+
+	y, cb, cr := planes(src)
+	full := newGrid(image.YCbCrSubsampleRatio444, Center)
+	var err error
+	if cb, err = resample(cb, from, full, width, height, filter); err != nil {
+		return nil, err
+	}
+	if cr, err = resample(cr, from, full, width, height, filter); err != nil {
+		return nil, err
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			r, g, b := space.ToRGB(float64(y.At(col, row)), float64(cb.At(col, row)), float64(cr.At(col, row)))
+			dst.SetNRGBA(col, row, color.NRGBA{R: colorspace.Quantize(r), G: colorspace.Quantize(g), B: colorspace.Quantize(b), A: 255})
+		}
+	}
+	return dst, nil
+}
+
+// Convert changes the subsampling and siting of src's chroma without going
+// through RGB, so luma is copied untouched. A nil filter selects Triangle.
+func Convert(src *image.YCbCr, from Siting, ratio image.YCbCrSubsampleRatio, to Siting, filter filters.Resampler) (*image.YCbCr, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if filter == nil {
+		filter = filters.NewTriangle()
+	}
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("source image is empty")
+	}
+
+	y, cb, cr := planes(src)
+	srcGrid, dstGrid := newGrid(src.SubsampleRatio, from), newGrid(ratio, to)
+	var err error
+	if cb, err = resample(cb, srcGrid, dstGrid, width, height, filter); err != nil {
+		return nil, err
+	}
+	if cr, err = resample(cr, srcGrid, dstGrid, width, height, filter); err != nil {
+		return nil, err
+	}
+	return assemble(y, cb, cr, ratio), nil
+}
@@ -0,0 +1,206 @@
+package chroma
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"video-processor/internal/colorspace"
+	"video-processor/internal/resize"
+)
+
+// ramp returns a 4:4:4 frame whose Cb rises by step per column and whose
+// Cr rises by step per row.
+func ramp(width, height, step int) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio444)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Y[img.YOffset(x, y)] = 128
+			img.Cb[img.COffset(x, y)] = uint8(64 + step*x)
+			img.Cr[img.COffset(x, y)] = uint8(64 + step*y)
+		}
+	}
+	return img
+}
+
+func TestSitingPhase(t *testing.T) {
+	src := ramp(16, 16, 4)
+	tests := []struct {
+		siting Siting
+		// Luma position of the first chroma sample.
+		x, y float64
+	}{
+		{Center, 0.5, 0.5},
+		{Left, 0, 0.5},
+		{TopLeft, 0, 0},
+		{Bottom, 0.5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.siting.String(), func(t *testing.T) {
+			dst, err := Convert(src, Center, image.YCbCrSubsampleRatio420, tt.siting, nil)
+			if err != nil {
+				t.Fatalf("Convert() unexpected error: %v", err)
+			}
+			if dst.SubsampleRatio != image.YCbCrSubsampleRatio420 || len(dst.Cb) != 64 {
+				t.Fatalf("got %v with %d chroma samples, want 4:2:0 with 64", dst.SubsampleRatio, len(dst.Cb))
+			}
+			// Away from the edges a linear filter reproduces the ramp at the
+			// sample's position.
+			for j := 1; j < 7; j++ {
+				offset := dst.COffset(2*j, 2*j)
+				if want := uint8(64 + 4*(2*float64(j)+tt.x)); dst.Cb[offset] != want {
+					t.Errorf("Cb[%d] = %d, want %d", j, dst.Cb[offset], want)
+				}
+				if want := uint8(64 + 4*(2*float64(j)+tt.y)); dst.Cr[offset] != want {
+					t.Errorf("Cr[%d] = %d, want %d", j, dst.Cr[offset], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripNeedsMatchingSiting(t *testing.T) {
+	src := ramp(16, 4, 8)
+	sub, err := Convert(src, Center, image.YCbCrSubsampleRatio422, Left, nil)
+	if err != nil {
+		t.Fatalf("Convert() unexpected error: %v", err)
+	}
+
+	maxError := func(siting Siting) int {
+		back, err := Convert(sub, siting, image.YCbCrSubsampleRatio444, Center, nil)
+		if err != nil {
+			t.Fatalf("Convert() unexpected error: %v", err)
+		}
+		worst := 0
+		for x := 2; x < 14; x++ {
+			diff := int(back.Cb[back.COffset(x, 1)]) - int(src.Cb[src.COffset(x, 1)])
+			worst = max(worst, diff, -diff)
+		}
+		return worst
+	}
+
+	if got := maxError(Left); got > 1 {
+		t.Errorf("round trip with matching siting is off by %d", got)
+	}
+	// Reading left-sited chroma as centred shifts it by half a pixel.
+	if got := maxError(Center); got < 3 {
+		t.Errorf("round trip with mismatched siting is off by only %d", got)
+	}
+}
+
+func TestRGBRoundTrip(t *testing.T) {
+	// Odd sizes leave a partial chroma block on the right and bottom.
+	src := image.NewNRGBA(image.Rect(0, 0, 7, 5))
+	want := color.NRGBA{R: 200, G: 60, B: 30, A: 255}
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 7; x++ {
+			src.SetNRGBA(x, y, want)
+		}
+	}
+
+	for _, siting := range []Siting{Center, Left, TopLeft} {
+		ycc, err := FromRGB(src, image.YCbCrSubsampleRatio420, siting, colorspace.Space{Matrix: colorspace.BT709, Range: colorspace.Limited}, nil)
+		if err != nil {
+			t.Fatalf("FromRGB() unexpected error: %v", err)
+		}
+		if ycc.Rect != src.Rect {
+			t.Fatalf("FromRGB() bounds = %v, want %v", ycc.Rect, src.Rect)
+		}
+		rgb, err := ToRGB(ycc, siting, colorspace.Space{Matrix: colorspace.BT709, Range: colorspace.Limited}, nil)
+		if err != nil {
+			t.Fatalf("ToRGB() unexpected error: %v", err)
+		}
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 7; x++ {
+				got := rgb.NRGBAAt(x, y)
+				if abs(int(got.R)-int(want.R)) > 2 || abs(int(got.G)-int(want.G)) > 2 || abs(int(got.B)-int(want.B)) > 2 {
+					t.Fatalf("%v: pixel (%d,%d) = %v, want %v", siting, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestFieldsKeepTheirOwnChroma(t *testing.T) {
+	// An interlaced 4:2:0 frame whose top field is reddish and bottom field
+	// bluish: chroma rows alternate between the fields like luma rows.
+	space := colorspace.Space{Matrix: colorspace.BT709, Range: colorspace.Limited}
+	src := image.NewYCbCr(image.Rect(0, 0, 8, 8), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = 128
+	}
+	cb, cr := [2]uint8{90, 170}, [2]uint8{170, 90}
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 4; col++ {
+			src.Cb[row*src.CStride+col], src.Cr[row*src.CStride+col] = cb[row%2], cr[row%2]
+		}
+	}
+
+	for _, siting := range []Siting{Center, Left, TopLeft} {
+		rgb, err := ToRGBFields(src, siting, space, nil)
+		if err != nil {
+			t.Fatalf("ToRGBFields() unexpected error: %v", err)
+		}
+		for y := 0; y < 8; y++ {
+			r, g, b := space.ToRGB(128, float64(cb[y%2]), float64(cr[y%2]))
+			want := color.NRGBA{R: colorspace.Quantize(r), G: colorspace.Quantize(g), B: colorspace.Quantize(b), A: 255}
+			for x := 0; x < 8; x++ {
+				if got := rgb.NRGBAAt(x, y); abs(int(got.R)-int(want.R)) > 1 || abs(int(got.B)-int(want.B)) > 1 {
+					t.Fatalf("%v: pixel (%d,%d) = %v, want %v", siting, x, y, got, want)
+				}
+			}
+		}
+
+		ycc, err := FromRGBFields(rgb, image.YCbCrSubsampleRatio420, siting, space, nil)
+		if err != nil {
+			t.Fatalf("FromRGBFields() unexpected error: %v", err)
+		}
+		for row := 0; row < 4; row++ {
+			for col := 0; col < 4; col++ {
+				i := row*ycc.CStride + col
+				if abs(int(ycc.Cb[i])-int(cb[row%2])) > 1 || abs(int(ycc.Cr[i])-int(cr[row%2])) > 1 {
+					t.Fatalf("%v: chroma (%d,%d) = %d,%d; want %d,%d", siting, col, row, ycc.Cb[i], ycc.Cr[i], cb[row%2], cr[row%2])
+				}
+			}
+		}
+	}
+
+	// Decoding the frame as progressive blends the fields.
+	rgb, err := ToRGB(src, Center, space, nil)
+	if err != nil {
+		t.Fatalf("ToRGB() unexpected error: %v", err)
+	}
+	if top, bottom := rgb.NRGBAAt(3, 3), rgb.NRGBAAt(3, 4); top == bottom {
+		t.Errorf("ToRGB() kept the fields apart: rows 3 and 4 both %v", top)
+	}
+}
+
+func TestFieldGridSpacing(t *testing.T) {
+	// In frame rows, interlaced 4:2:0 chroma sits at 0.5 for the top field
+	// and 2.5 for the bottom field, one sample every four rows in each.
+	for p, want := range []float64{0.5, 2.5} {
+		g := fieldGrid(image.YCbCrSubsampleRatio420, Left, resize.Parity(p))
+		if got := 2*g.y + float64(p); got != want {
+			t.Errorf("parity %d: first chroma sample on frame row %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestParseSiting(t *testing.T) {
+	for _, name := range SitingNames() {
+		s, err := ParseSiting(name)
+		if err != nil || s.String() != name {
+			t.Errorf("ParseSiting(%q) = %v, %v", name, s, err)
+		}
+	}
+	if _, err := ParseSiting("middle"); err == nil {
+		t.Error("ParseSiting(\"middle\") expected error")
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,121 @@
+package chroma
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/resize"
+)
+
+// fieldGrid returns where the chroma of field p sits on that field's rows.
+// Vertically centred chroma follows MPEG-2's interlaced siting: each sample
+// sits a quarter of the way down the pair of field rows it covers in the
+// top field and three quarters of the way down in the bottom field, which
+// keeps the samples of both fields evenly spaced down the frame.
+func fieldGrid(ratio image.YCbCrSubsampleRatio, siting Siting, p resize.Parity) grid {
+	g := newGrid(ratio, siting)
+	if siting == Center || siting == Left {
+		g.y += float64(g.fy-1) * (float64(p)/2 - 0.25)
+	}
+	return g
+}
+
+// splitField returns the rows of field p of src as an image of their own.
+// A field left short of chroma rows by an odd frame height repeats its
+// last one.
+func splitField(src *image.YCbCr, p resize.Parity) *image.YCbCr {
+	b := src.Rect
+	dst := image.NewYCbCr(image.Rect(0, 0, b.Dx(), (b.Dy()+1-int(p))/2), src.SubsampleRatio)
+	for row := 0; row < dst.Rect.Dy(); row++ {
+		copy(dst.Y[row*dst.YStride:(row+1)*dst.YStride], src.Y[src.YOffset(b.Min.X, b.Min.Y+2*row+int(p)):])
+	}
+
+	_, cb, cr := planes(src)
+	for row := 0; row < len(dst.Cb)/dst.CStride; row++ {
+		from := 2*row + int(p)
+		for from >= cb.Height {
+			from -= 2
+		}
+		if from < 0 {
+			from = 0
+		}
+		copy(dst.Cb[row*dst.CStride:(row+1)*dst.CStride], cb.Pix[from*cb.Stride:])
+		copy(dst.Cr[row*dst.CStride:(row+1)*dst.CStride], cr.Pix[from*cr.Stride:])
+	}
+	return dst
+}
+
+// ToRGBFields decodes an interlaced frame like ToRGB. The fields take
+// alternate rows of every plane, chroma included, so each field's chroma is
+// interpolated from that field's samples alone rather than blended with the
+// other field's.
+func ToRGBFields(src *image.YCbCr, siting Siting, space colorspace.Space, filter filters.Resampler) (*image.NRGBA, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if filter == nil {
+		filter = filters.NewTriangle()
+	}
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("source image is empty")
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for _, p := range []resize.Parity{resize.TopField, resize.BottomField} {
+		field := splitField(src, p)
+		if field.Rect.Empty() {
+			continue
+		}
+		rgb, err := toRGB(field, fieldGrid(src.SubsampleRatio, siting, p), space, filter)
+		if err != nil {
+			return nil, err
+		}
+		for row := 0; row < rgb.Rect.Dy(); row++ {
+			line := image.Rect(0, 2*row+int(p), width, 2*row+int(p)+1)
+			draw.Draw(dst, line, rgb, image.Pt(0, row), draw.Src)
+		}
+	}
+	return dst, nil
+}
+
+// FromRGBFields encodes an interlaced frame like FromRGB, filtering each
+// field's chroma from that field's rows alone so that no chroma sample mixes
+// the two fields.
+func FromRGBFields(src image.Image, ratio image.YCbCrSubsampleRatio, siting Siting, space colorspace.Space, filter filters.Resampler) (*image.YCbCr, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if filter == nil {
+		filter = filters.NewTriangle()
+	}
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("source image is empty")
+	}
+
+	dst := image.NewYCbCr(image.Rect(0, 0, width, height), ratio)
+	chromaRows := len(dst.Cb) / dst.CStride
+	for _, p := range []resize.Parity{resize.TopField, resize.BottomField} {
+		rows := resize.ExtractField(src, p)
+		if rows.Rect.Empty() {
+			continue
+		}
+		field, err := fromRGB(rows, ratio, fieldGrid(ratio, siting, p), space, filter)
+		if err != nil {
+			return nil, err
+		}
+		for row := 0; row < field.Rect.Dy(); row++ {
+			copy(dst.Y[(2*row+int(p))*dst.YStride:], field.Y[row*field.YStride:(row+1)*field.YStride])
+		}
+		for row := 0; row < len(field.Cb)/field.CStride && 2*row+int(p) < chromaRows; row++ {
+			at := (2*row + int(p)) * dst.CStride
+			copy(dst.Cb[at:at+dst.CStride], field.Cb[row*field.CStride:])
+			copy(dst.Cr[at:at+dst.CStride], field.Cr[row*field.CStride:])
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,203 @@
+package codec
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strings"
+
+	"video-processor/internal/metadata"
+)
+
+// Feature is an encoder option that only some formats support.
+type Feature int
+
+const (
+	// Quality is the quality of lossy compression.
+	Quality Feature = 1 << iota
+	// Compression is the effort spent on lossless compression.
+	Compression
+	// Progressive orders the data coarse to fine.
+	Progressive
+	// Interlace stores rows in passes, coarse to fine.
+	Interlace
+)
+
+// CompressionLevel trades encoding speed for size in lossless formats.
+type CompressionLevel int
+
+const (
+	DefaultCompression CompressionLevel = iota
+	NoCompression
+	BestSpeed
+	BestCompression
+)
+
+var compressionLevelNames = []string{"default", "none", "fast", "best"}
+
+// ParseCompressionLevel converts a name such as "best" to a
+// CompressionLevel.
+func ParseCompressionLevel(name string) (CompressionLevel, error) {
+	for i, n := range compressionLevelNames {
+		if strings.EqualFold(name, n) {
+			return CompressionLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown compression level %q (available: %s)", name, strings.Join(CompressionLevelNames(), ", "))
+}
+
+func (l CompressionLevel) String() string {
+	if l < 0 || int(l) >= len(compressionLevelNames) {
+		return fmt.Sprintf("CompressionLevel(%d)", int(l))
+	}
+	return compressionLevelNames[l]
+}
+
+// CompressionLevelNames lists the accepted compression level names.
+func CompressionLevelNames() []string {
+	return append([]string(nil), compressionLevelNames...)
+}
+
+// Alpha says how much transparency a format keeps.
+type Alpha int
+
+const (
+	// NoAlpha formats store opaque colour only.
+	NoAlpha Alpha = iota
+	// PalettedAlpha formats keep transparent palette entries but drop the
+	// alpha of other images.
+	PalettedAlpha
+	// FullAlpha formats keep any alpha channel.
+	FullAlpha
+)
+
+// Options are the settings an encoder may honour. The zero value asks
+// every encoder for its defaults.
+type Options struct {
+	// Quality ranges from 1 to 100; zero means the encoder's default.
+	Quality     int
+	Compression CompressionLevel
+	Progressive bool
+	Interlace   bool
+}
+
+// Encoder writes one image format.
+type Encoder struct {
+	// Name is the format's name, the one image.Decode reports where the
+	// format can also be read.
+	Name string
+	// Aliases are other names Lookup accepts.
+	Aliases []string
+	// Extensions are lower case and include the dot; the first is the one
+	// to give new files.
+	Extensions []string
+	// Features are the options Encode honours.
+	Features Feature
+	Alpha    Alpha
+	Encode   func(w io.Writer, m image.Image, o Options) error
+	// Embed writes data, as written by Encode, with the metadata added;
+	// it is nil for formats that cannot carry metadata.
+	Embed func(w io.Writer, data []byte, m *metadata.Metadata) error
+}
+
+// KeepsAlpha reports whether e stores the transparency of m.
+func (e *Encoder) KeepsAlpha(m image.Image) bool {
+	switch e.Alpha {
+	case FullAlpha:
+		return true
+	case PalettedAlpha:
+		_, ok := m.(*image.Paletted)
+		return ok
+	}
+	return false
+}
+
+// Check reports an error if o sets options e does not support or sets
+// them out of range.
+func (e *Encoder) Check(o Options) error {
+	set := []struct {
+		feature Feature
+		set     bool
+		name    string
+	}{
+		{Quality, o.Quality != 0, "quality"},
+		{Compression, o.Compression != DefaultCompression, "compression level"},
+		{Progressive, o.Progressive, "progressive output"},
+		{Interlace, o.Interlace, "interlacing"},
+	}
+	for _, s := range set {
+		if s.set && e.Features&s.feature == 0 {
+			return fmt.Errorf("%s output does not support %s", e.Name, s.name)
+		}
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality %d out of range (1-100)", o.Quality)
+	}
+	if o.Compression < DefaultCompression || o.Compression > BestCompression {
+		return fmt.Errorf("invalid compression level %d", o.Compression)
+	}
+	return nil
+}
+
+var (
+	encoders    []*Encoder
+	byName      = map[string]*Encoder{}
+	byExtension = map[string]*Encoder{}
+)
+
+// Register adds an encoder. It panics if a name, alias or extension is
+// already taken, as that is a programming error.
+func Register(e Encoder) {
+	enc := &e
+	for _, name := range append([]string{e.Name}, e.Aliases...) {
+		name = strings.ToLower(name)
+		if _, ok := byName[name]; ok {
+			panic("codec: format " + name + " registered twice")
+		}
+		byName[name] = enc
+	}
+	for _, ext := range e.Extensions {
+		if _, ok := byExtension[ext]; ok {
+			panic("codec: extension " + ext + " registered twice")
+		}
+		byExtension[ext] = enc
+	}
+	encoders = append(encoders, enc)
+}
+
+// Lookup returns the encoder for a format name or alias, in any case.
+func Lookup(name string) (*Encoder, error) {
+	if e, ok := byName[strings.ToLower(name)]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (available: %s)", name, strings.Join(Names(), ", "))
+}
+
+// ForExtension returns the encoder for a file extension such as ".png".
+func ForExtension(ext string) (*Encoder, error) {
+	if e, ok := byExtension[strings.ToLower(ext)]; ok {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unsupported output extension %q (supported: %s)", ext, strings.Join(Extensions(), ", "))
+}
+
+// Names lists the registered format names, sorted.
+func Names() []string {
+	names := make([]string, 0, len(encoders))
+	for _, e := range encoders {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extensions lists the registered extensions, sorted.
+func Extensions() []string {
+	exts := make([]string, 0, len(byExtension))
+	for ext := range byExtension {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
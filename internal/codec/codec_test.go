@@ -0,0 +1,195 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{"png", "png", ""},
+		{"JPEG", "jpeg", ""},
+		{"jpg", "jpeg", ""},
+		{"Tif", "tiff", ""},
+		{"pnm", "ppm", ""},
+		{"netpbm", "pam", ""},
+		{"webp", "", `unknown output format "webp" (available: bmp, gif, hdr, jpeg, pam, pbm, pfm, pgm, png, ppm, qoi, tga, tiff)`},
+	}
+	for _, tt := range tests {
+		e, err := Lookup(tt.name)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Lookup(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Lookup(%q) unexpected error: %v", tt.name, err)
+			continue
+		}
+		if e.Name != tt.want {
+			t.Errorf("Lookup(%q) = %s, want %s", tt.name, e.Name, tt.want)
+		}
+	}
+}
+
+func TestForExtension(t *testing.T) {
+	tests := []struct {
+		ext     string
+		want    string
+		wantErr bool
+	}{
+		{".png", "png", false},
+		{".JPG", "jpeg", false},
+		{".jpeg", "jpeg", false},
+		{".pnm", "ppm", false},
+		{".tif", "tiff", false},
+		{".webp", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		e, err := ForExtension(tt.ext)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "unsupported output extension") {
+				t.Errorf("ForExtension(%q) error = %v, want unsupported output extension", tt.ext, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ForExtension(%q) unexpected error: %v", tt.ext, err)
+			continue
+		}
+		if e.Name != tt.want {
+			t.Errorf("ForExtension(%q) = %s, want %s", tt.ext, e.Name, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		format  string
+		opts    Options
+		wantErr string
+	}{
+		{"png", Options{}, ""},
+		{"png", Options{Compression: BestCompression, Interlace: true}, ""},
+		{"jpeg", Options{Quality: 80, Progressive: true}, ""},
+		{"jpeg", Options{Quality: 101}, "quality 101 out of range (1-100)"},
+		{"jpeg", Options{Quality: -5}, "quality -5 out of range (1-100)"},
+		{"png", Options{Quality: 80}, "png output does not support quality"},
+		{"jpeg", Options{Compression: BestSpeed}, "jpeg output does not support compression level"},
+		{"gif", Options{Progressive: true}, "gif output does not support progressive output"},
+		{"jpeg", Options{Interlace: true}, "jpeg output does not support interlacing"},
+		{"png", Options{Compression: 9}, "invalid compression level 9"},
+	}
+	for _, tt := range tests {
+		e, err := Lookup(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = e.Check(tt.opts)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s Check(%+v) unexpected error: %v", tt.format, tt.opts, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("%s Check(%+v) error = %v, want %q", tt.format, tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestKeepsAlpha(t *testing.T) {
+	rgba := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	paletted := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Transparent})
+	tests := []struct {
+		format string
+		m      image.Image
+		want   bool
+	}{
+		{"png", rgba, true},
+		{"tiff", rgba, true},
+		{"pam", rgba, true},
+		{"jpeg", rgba, false},
+		{"ppm", rgba, false},
+		{"hdr", rgba, false},
+		{"gif", rgba, false},
+		{"gif", paletted, true},
+		{"jpeg", paletted, false},
+	}
+	for _, tt := range tests {
+		e, err := Lookup(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.KeepsAlpha(tt.m); got != tt.want {
+			t.Errorf("%s KeepsAlpha(%T) = %v, want %v", tt.format, tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestParseCompressionLevel(t *testing.T) {
+	for _, name := range CompressionLevelNames() {
+		l, err := ParseCompressionLevel(strings.ToUpper(name))
+		if err != nil {
+			t.Errorf("ParseCompressionLevel(%q) unexpected error: %v", name, err)
+			continue
+		}
+		if l.String() != name {
+			t.Errorf("ParseCompressionLevel(%q) = %v", name, l)
+		}
+	}
+	if _, err := ParseCompressionLevel("max"); err == nil {
+		t.Error(`ParseCompressionLevel("max") succeeded`)
+	}
+}
+
+// TestEncoders writes an image with every registered encoder and reads it
+// back with image.Decode, which the packages behind the encoders register
+// with.
+func TestEncoders(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 9, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 9; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 28), uint8(y * 36), 128, 255})
+		}
+	}
+	for _, name := range Names() {
+		e, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := Options{}
+		if e.Features&Interlace != 0 {
+			opts.Interlace = true
+		}
+		if e.Features&Progressive != 0 {
+			opts.Progressive = true
+		}
+		var buf bytes.Buffer
+		if err := e.Encode(&buf, m, opts); err != nil {
+			t.Errorf("%s: Encode() unexpected error: %v", name, err)
+			continue
+		}
+		got, format, err := image.Decode(&buf)
+		if err != nil {
+			t.Errorf("%s: Decode() unexpected error: %v", name, err)
+			continue
+		}
+		if got.Bounds().Size() != m.Bounds().Size() {
+			t.Errorf("%s: size = %v, want %v", name, got.Bounds().Size(), m.Bounds().Size())
+		}
+		// Netpbm variants all decode as "netpbm".
+		if format != name && format != "netpbm" {
+			t.Errorf("%s: decoded as %s", name, format)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"image"
+	"image/gif"
+	stdpng "image/png"
+	"io"
+
+	"video-processor/internal/bmp"
+	"video-processor/internal/jpeg"
+	"video-processor/internal/metadata"
+	"video-processor/internal/netpbm"
+	"video-processor/internal/pfm"
+	"video-processor/internal/png"
+	"video-processor/internal/qoi"
+	"video-processor/internal/rgbe"
+	"video-processor/internal/tga"
+	"video-processor/internal/tiff"
+)
+
+// pngLevels maps compression levels to image/png's.
+var pngLevels = map[CompressionLevel]stdpng.CompressionLevel{
+	DefaultCompression: stdpng.DefaultCompression,
+	NoCompression:      stdpng.NoCompression,
+	BestSpeed:          stdpng.BestSpeed,
+	BestCompression:    stdpng.BestCompression,
+}
+
+func init() {
+	Register(Encoder{
+		Name:       "png",
+		Extensions: []string{".png"},
+		Features:   Compression | Interlace,
+		Alpha:      FullAlpha,
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return png.Encode(w, m, &png.Options{CompressionLevel: pngLevels[o.Compression], Interlace: o.Interlace})
+		},
+		Embed: metadata.EmbedPNG,
+	})
+	Register(Encoder{
+		Name:       "jpeg",
+		Aliases:    []string{"jpg"},
+		Extensions: []string{".jpg", ".jpeg"},
+		Features:   Quality | Progressive,
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return jpeg.Encode(w, m, &jpeg.Options{Quality: o.Quality, Progressive: o.Progressive})
+		},
+		Embed: metadata.EmbedJPEG,
+	})
+	Register(Encoder{
+		Name:       "gif",
+		Extensions: []string{".gif"},
+		Alpha:      PalettedAlpha,
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return gif.Encode(w, m, nil)
+		},
+	})
+	Register(Encoder{
+		Name:       "bmp",
+		Extensions: []string{".bmp"},
+		Alpha:      FullAlpha,
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return bmp.Encode(w, m)
+		},
+	})
+	Register(Encoder{
+		Name:       "tga",
+		Extensions: []string{".tga"},
+		Alpha:      FullAlpha,
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return tga.Encode(w, m, nil)
+		},
+	})
+	Register(Encoder{
+		Name:       "tiff",
+		Aliases:    []string{"tif"},
+		Extensions: []string{".tif", ".tiff"},
+		Alpha:      FullAlpha,
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return tiff.Encode(w, m, nil)
+		},
+	})
+	Register(Encoder{
+		Name:       "qoi",
+		Extensions: []string{".qoi"},
+		Alpha:      FullAlpha,
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return qoi.Encode(w, m, nil)
+		},
+	})
+	Register(Encoder{
+		Name:       "hdr",
+		Extensions: []string{".hdr"},
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return rgbe.Encode(w, m)
+		},
+	})
+	Register(Encoder{
+		Name:       "pfm",
+		Extensions: []string{".pfm"},
+		Encode: func(w io.Writer, m image.Image, o Options) error {
+			return pfm.Encode(w, m)
+		},
+	})
+
+	// Netpbm images all decode as "netpbm", which writes a PAM as the
+	// variant that keeps the most.
+	for _, f := range []struct {
+		name       string
+		aliases    []string
+		extensions []string
+		format     netpbm.Format
+		alpha      Alpha
+	}{
+		{"pam", []string{"netpbm"}, []string{".pam"}, netpbm.PAM, FullAlpha},
+		{"pbm", nil, []string{".pbm"}, netpbm.PBM, NoAlpha},
+		{"pgm", nil, []string{".pgm"}, netpbm.PGM, NoAlpha},
+		{"ppm", []string{"pnm"}, []string{".ppm", ".pnm"}, netpbm.PPM, NoAlpha},
+	} {
+		format := f.format
+		Register(Encoder{
+			Name:       f.name,
+			Aliases:    f.aliases,
+			Extensions: f.extensions,
+			Alpha:      f.alpha,
+			Encode: func(w io.Writer, m image.Image, o Options) error {
+				return netpbm.Encode(w, m, &netpbm.Options{Format: format})
+			},
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package colorspace
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Matrix is the set of luma coefficients that relates Y'CbCr to R'G'B'.
+type Matrix int
+
+const (
+	// BT601 is used by SD video and JPEG.
+	BT601 Matrix = iota
+	// BT709 is used by HD video.
+	BT709
+	// BT2020 is the non-constant luminance matrix of UHD video.
+	BT2020
+	// SMPTE240M is the interim 1035i HD matrix, still found in old masters.
+	SMPTE240M
+)
+
+var matrixNames = map[string]Matrix{
+	"bt601":     BT601,
+	"bt470bg":   BT601,
+	"smpte170m": BT601,
+	"bt709":     BT709,
+	"bt2020":    BT2020,
+	"bt2020nc":  BT2020,
+	"bt2020ncl": BT2020,
+	"smpte240m": SMPTE240M,
+}
+
+func ParseMatrix(name string) (Matrix, error) {
+	if m, ok := matrixNames[strings.ToLower(name)]; ok {
+		return m, nil
+	}
+	return 0, fmt.Errorf("unknown color matrix %q (available: %s)", name, strings.Join(MatrixNames(), ", "))
+}
+
+// MatrixNames lists the canonical matrix names understood by ParseMatrix.
+func MatrixNames() []string {
+	return []string{"bt601", "bt709", "bt2020", "smpte240m"}
+}
+
+func (m Matrix) String() string {
+	switch m {
+	case BT709:
+		return "bt709"
+	case BT2020:
+		return "bt2020"
+	case SMPTE240M:
+		return "smpte240m"
+	}
+	return "bt601"
+}
+
+// coefficients returns Kr and Kb; Kg is 1 - Kr - Kb.
+func (m Matrix) coefficients() (float64, float64) {
+	switch m {
+	case BT709:
+		return 0.2126, 0.0722
+	case BT2020:
+		return 0.2627, 0.0593
+	case SMPTE240M:
+		return 0.212, 0.087
+	}
+	return 0.299, 0.114
+}
+
+// Range is the span of 8-bit code values a signal uses.
+type Range int
+
+const (
+	// Full uses 0-255 for every component, as JPEG does.
+	Full Range = iota
+	// Limited uses 16-235 for luma and 16-240 for chroma, leaving head and
+	// foot room as broadcast video does.
+	Limited
+)
+
+func ParseRange(name string) (Range, error) {
+	switch strings.ToLower(name) {
+	case "full", "pc", "jpeg":
+		return Full, nil
+	case "limited", "tv", "mpeg":
+		return Limited, nil
+	}
+	return 0, fmt.Errorf("unknown color range %q (available: full, limited)", name)
+}
+
+func (r Range) String() string {
+	if r == Limited {
+		return "limited"
+	}
+	return "full"
+}
+
+// scale returns the code value of black, the luma excursion and the
+// chroma excursion.
+func (r Range) scale() (float64, float64, float64) {
+	if r == Limited {
+		return 16, 219, 224
+	}
+	return 0, 255, 255
+}
+
+// Space is a Y'CbCr encoding. The zero value is full-range BT.601, the
+// encoding image/color uses.
+type Space struct {
+	Matrix Matrix
+	Range  Range
+}
+
+func (s Space) String() string {
+	return s.Matrix.String() + ":" + s.Range.String()
+}
+
+// ToRGB decodes 8-bit code values to R'G'B' on a 0-255 scale. The result
+// is not clamped, so out-of-gamut values survive further processing.
+func (s Space) ToRGB(y, cb, cr float64) (float64, float64, float64) {
+	kr, kb := s.Matrix.coefficients()
+	black, luma, chroma := s.Range.scale()
+	ey := (y - black) / luma
+	pb := (cb - 128) / chroma
+	pr := (cr - 128) / chroma
+
+	r := ey + 2*(1-kr)*pr
+	b := ey + 2*(1-kb)*pb
+	g := (ey - kr*r - kb*b) / (1 - kr - kb)
+	return r * 255, g * 255, b * 255
+}
+
+// FromRGB encodes R'G'B' on a 0-255 scale to unrounded code values.
+func (s Space) FromRGB(r, g, b float64) (float64, float64, float64) {
+	kr, kb := s.Matrix.coefficients()
+	black, luma, chroma := s.Range.scale()
+	r, g, b = r/255, g/255, b/255
+
+	ey := kr*r + (1-kr-kb)*g + kb*b
+	pb := (b - ey) / (2 * (1 - kb))
+	pr := (r - ey) / (2 * (1 - kr))
+	return black + luma*ey, 128 + chroma*pb, 128 + chroma*pr
+}
+
+// Quantize rounds a code value to the nearest 8-bit value, clamping it to
+// the representable range.
+func Quantize(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Floor(v+0.5))))
+}
+
+// Conversion re-encodes Y'CbCr from one Space to another. Both steps are
+// linear, so they are folded into a single 3x3 matrix and offset.
+type Conversion struct {
+	m      [3][3]float64
+	offset [3]float64
+	// identity is set when from and to are the same space.
+	identity bool
+}
+
+func NewConversion(from, to Space) Conversion {
+	if from == to {
+		return Conversion{identity: true}
+	}
+
+	var c Conversion
+	apply := func(y, cb, cr float64) [3]float64 {
+		y, cb, cr = to.FromRGB(from.ToRGB(y, cb, cr))
+		return [3]float64{y, cb, cr}
+	}
+	// The offset is the image of the origin; each column is the image of a
+	// unit step along one input.
+	c.offset = apply(0, 0, 0)
+	for col := 0; col < 3; col++ {
+		var in [3]float64
+		in[col] = 1
+		out := apply(in[0], in[1], in[2])
+		for row := 0; row < 3; row++ {
+			c.m[row][col] = out[row] - c.offset[row]
+		}
+	}
+	return c
+}
+
+// Identity reports whether the conversion leaves values unchanged.
+func (c Conversion) Identity() bool {
+	return c.identity
+}
+
+// Apply converts one sample. With subsampled chroma, convert each luma
+// sample with the chroma covering it and each chroma sample with the mean
+// luma of its block.
+func (c Conversion) Apply(y, cb, cr float64) (float64, float64, float64) {
+	if c.identity {
+		return y, cb, cr
+	}
+	return c.m[0][0]*y + c.m[0][1]*cb + c.m[0][2]*cr + c.offset[0],
+		c.m[1][0]*y + c.m[1][1]*cb + c.m[1][2]*cr + c.offset[1],
+		c.m[2][0]*y + c.m[2][1]*cb + c.m[2][2]*cr + c.offset[2]
+}
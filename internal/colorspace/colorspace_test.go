@@ -0,0 +1,111 @@
+package colorspace
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestFromRGB(t *testing.T) {
+	tests := []struct {
+		name      string
+		space     Space
+		r, g, b   float64
+		y, cb, cr uint8
+	}{
+		{"709 limited white", Space{BT709, Limited}, 255, 255, 255, 235, 128, 128},
+		{"709 limited black", Space{BT709, Limited}, 0, 0, 0, 16, 128, 128},
+		{"709 limited red", Space{BT709, Limited}, 255, 0, 0, 63, 102, 240},
+		{"601 limited red", Space{BT601, Limited}, 255, 0, 0, 81, 90, 240},
+		{"2020 limited green", Space{BT2020, Limited}, 0, 255, 0, 164, 47, 25},
+		{"601 full blue", Space{BT601, Full}, 0, 0, 255, 29, 255, 107},
+	}
+	for _, tt := range tests {
+		y, cb, cr := tt.space.FromRGB(tt.r, tt.g, tt.b)
+		if got := [3]uint8{Quantize(y), Quantize(cb), Quantize(cr)}; got != [3]uint8{tt.y, tt.cb, tt.cr} {
+			t.Errorf("%s: FromRGB() = %v, want [%d %d %d]", tt.name, got, tt.y, tt.cb, tt.cr)
+		}
+	}
+}
+
+func TestZeroSpaceMatchesImageColor(t *testing.T) {
+	var space Space
+	for _, c := range [][3]uint8{{0, 0, 0}, {255, 255, 255}, {200, 60, 30}, {12, 140, 250}} {
+		wy, wcb, wcr := color.RGBToYCbCr(c[0], c[1], c[2])
+		y, cb, cr := space.FromRGB(float64(c[0]), float64(c[1]), float64(c[2]))
+		if d := maxDiff([3]float64{y, cb, cr}, [3]float64{float64(wy), float64(wcb), float64(wcr)}); d > 1 {
+			t.Errorf("FromRGB(%v) = %.1f %.1f %.1f, image/color gives %d %d %d", c, y, cb, cr, wy, wcb, wcr)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, m := range []Matrix{BT601, BT709, BT2020, SMPTE240M} {
+		for _, r := range []Range{Full, Limited} {
+			space := Space{m, r}
+			y, cb, cr := space.FromRGB(200, 60, 30)
+			got := [3]float64{}
+			got[0], got[1], got[2] = space.ToRGB(y, cb, cr)
+			if d := maxDiff(got, [3]float64{200, 60, 30}); d > 1e-9 {
+				t.Errorf("%v: round trip off by %g", space, d)
+			}
+		}
+	}
+}
+
+func TestRangeConversion(t *testing.T) {
+	c := NewConversion(Space{BT709, Full}, Space{BT709, Limited})
+	tests := []struct{ in, want [3]uint8 }{
+		{[3]uint8{0, 128, 128}, [3]uint8{16, 128, 128}},
+		{[3]uint8{255, 128, 128}, [3]uint8{235, 128, 128}},
+		{[3]uint8{128, 0, 255}, [3]uint8{126, 16, 240}},
+	}
+	for _, tt := range tests {
+		y, cb, cr := c.Apply(float64(tt.in[0]), float64(tt.in[1]), float64(tt.in[2]))
+		if got := [3]uint8{Quantize(y), Quantize(cb), Quantize(cr)}; got != tt.want {
+			t.Errorf("Apply(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatrixConversionMatchesRGB(t *testing.T) {
+	from, to := Space{BT601, Limited}, Space{BT709, Limited}
+	c := NewConversion(from, to)
+	if c.Identity() {
+		t.Fatal("conversion between different spaces reported as identity")
+	}
+	for _, in := range [][3]float64{{16, 128, 128}, {81, 90, 240}, {150, 44, 21}, {235, 200, 100}} {
+		y, cb, cr := c.Apply(in[0], in[1], in[2])
+		wy, wcb, wcr := to.FromRGB(from.ToRGB(in[0], in[1], in[2]))
+		if d := maxDiff([3]float64{y, cb, cr}, [3]float64{wy, wcb, wcr}); d > 1e-9 {
+			t.Errorf("Apply(%v) differs from decoding and re-encoding by %g", in, d)
+		}
+	}
+	// Grey has no chroma in any matrix, so only the range could move it.
+	if y, cb, cr := c.Apply(100, 128, 128); math.Abs(y-100) > 1e-9 || math.Abs(cb-128) > 1e-9 || math.Abs(cr-128) > 1e-9 {
+		t.Errorf("grey changed to %.3f %.3f %.3f", y, cb, cr)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if m, err := ParseMatrix("BT2020nc"); err != nil || m != BT2020 {
+		t.Errorf("ParseMatrix(BT2020nc) = %v, %v", m, err)
+	}
+	if r, err := ParseRange("tv"); err != nil || r != Limited {
+		t.Errorf("ParseRange(tv) = %v, %v", r, err)
+	}
+	if _, err := ParseMatrix("bt999"); err == nil {
+		t.Error("ParseMatrix(bt999) expected error")
+	}
+	if _, err := ParseRange("medium"); err == nil {
+		t.Error("ParseRange(medium) expected error")
+	}
+}
+
+func maxDiff(a, b [3]float64) float64 {
+	d := 0.0
+	for i := range a {
+		d = math.Max(d, math.Abs(a[i]-b[i]))
+	}
+	return d
+}
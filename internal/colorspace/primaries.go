@@ -0,0 +1,285 @@
+package colorspace
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Chromaticity is a CIE 1931 xy coordinate.
+type Chromaticity struct {
+	X, Y float64
+}
+
+var (
+	D65 = Chromaticity{0.3127, 0.3290}
+	D50 = Chromaticity{0.3457, 0.3585}
+	// DCI is the greenish white of digital cinema projection.
+	DCI = Chromaticity{0.314, 0.351}
+)
+
+// RGBSpace is an RGB colour space: the chromaticities of its primaries and
+// white point, and the curve its values are encoded with.
+type RGBSpace struct {
+	Name                    string
+	Red, Green, Blue, White Chromaticity
+	Transfer                Transfer
+}
+
+var rgbSpaces = map[string]RGBSpace{
+	"srgb":      {"srgb", Chromaticity{0.64, 0.33}, Chromaticity{0.30, 0.60}, Chromaticity{0.15, 0.06}, D65, TransferSRGB},
+	"rec709":    {"rec709", Chromaticity{0.64, 0.33}, Chromaticity{0.30, 0.60}, Chromaticity{0.15, 0.06}, D65, TransferBT709},
+	"displayp3": {"displayp3", Chromaticity{0.680, 0.320}, Chromaticity{0.265, 0.690}, Chromaticity{0.150, 0.060}, D65, TransferSRGB},
+	"dcip3":     {"dcip3", Chromaticity{0.680, 0.320}, Chromaticity{0.265, 0.690}, Chromaticity{0.150, 0.060}, DCI, TransferGamma26},
+	"rec2020":   {"rec2020", Chromaticity{0.708, 0.292}, Chromaticity{0.170, 0.797}, Chromaticity{0.131, 0.046}, D65, TransferBT709},
+	"adobergb":  {"adobergb", Chromaticity{0.64, 0.33}, Chromaticity{0.21, 0.71}, Chromaticity{0.15, 0.06}, D65, TransferGamma22},
+	"prophoto":  {"prophoto", Chromaticity{0.7347, 0.2653}, Chromaticity{0.1596, 0.8404}, Chromaticity{0.0366, 0.0001}, D50, TransferROMM},
+}
+
+// RGBSpaceByName returns the RGB space registered under name.
+func RGBSpaceByName(name string) (RGBSpace, error) {
+	if s, ok := rgbSpaces[strings.ToLower(name)]; ok {
+		return s, nil
+	}
+	return RGBSpace{}, fmt.Errorf("unknown RGB colour space %q (available: %s)", name, strings.Join(RGBSpaceNames(), ", "))
+}
+
+// RGBSpaceNames lists the names understood by RGBSpaceByName.
+func RGBSpaceNames() []string {
+	names := make([]string, 0, len(rgbSpaces))
+	for name := range rgbSpaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mat3 is a row-major 3x3 matrix.
+type mat3 [3][3]float64
+
+func (m mat3) mul(n mat3) mat3 {
+	var r mat3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+func (m mat3) apply(v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+func (m mat3) inverse() mat3 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	return mat3{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det},
+	}
+}
+
+// xyz returns the XYZ tristimulus of c scaled to Y = 1.
+func (c Chromaticity) xyz() [3]float64 {
+	return [3]float64{c.X / c.Y, 1, (1 - c.X - c.Y) / c.Y}
+}
+
+// toXYZ returns the matrix from linear RGB in s to XYZ relative to s's own
+// white point: each primary is scaled so that RGB 1,1,1 lands on white.
+func (s RGBSpace) toXYZ() mat3 {
+	r, g, b := s.Red.xyz(), s.Green.xyz(), s.Blue.xyz()
+	primaries := mat3{{r[0], g[0], b[0]}, {r[1], g[1], b[1]}, {r[2], g[2], b[2]}}
+	scale := primaries.inverse().apply(s.White.xyz())
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			primaries[i][j] *= scale[j]
+		}
+	}
+	return primaries
+}
+
+// ToXYZ returns the matrix from linear RGB in s to XYZ relative to white,
+// adapting s's own white point with the Bradford transform.
+func (s RGBSpace) ToXYZ(white Chromaticity) [3][3]float64 {
+	return bradford(s.White, white).mul(s.toXYZ())
+}
+
+// ChromaticAdaptation returns the Bradford transform of XYZ colours from
+// white point from to white point to.
+func ChromaticAdaptation(from, to Chromaticity) [3][3]float64 {
+	return bradford(from, to)
+}
+
+// bradfordCone converts XYZ to the sharpened cone responses of the
+// Bradford transform.
+var bradfordCone = mat3{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// bradford returns the chromatic adaptation from white point from to to,
+// scaling the cone responses so that from's white becomes to's white.
+func bradford(from, to Chromaticity) mat3 {
+	if from == to {
+		return mat3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	}
+	src, dst := bradfordCone.apply(from.xyz()), bradfordCone.apply(to.xyz())
+	gain := mat3{{dst[0] / src[0], 0, 0}, {0, dst[1] / src[1], 0}, {0, 0, dst[2] / src[2]}}
+	return bradfordCone.inverse().mul(gain).mul(bradfordCone)
+}
+
+// GamutMapping selects how colours outside the destination gamut are
+// brought inside it.
+type GamutMapping int
+
+const (
+	// GamutClip clamps every channel to 0-1, which is exact for in-gamut
+	// colours but flattens saturated gradients and can shift hue.
+	GamutClip GamutMapping = iota
+	// GamutCompress smoothly reduces the saturation of colours near and
+	// beyond the destination gamut boundary, keeping gradients and hue, at
+	// the cost of slightly desaturating some colours that were in gamut.
+	GamutCompress
+)
+
+func ParseGamutMapping(name string) (GamutMapping, error) {
+	switch strings.ToLower(name) {
+	case "clip":
+		return GamutClip, nil
+	case "compress":
+		return GamutCompress, nil
+	}
+	return 0, fmt.Errorf("unknown gamut mapping %q (available: clip, compress)", name)
+}
+
+func (g GamutMapping) String() string {
+	if g == GamutCompress {
+		return "compress"
+	}
+	return "clip"
+}
+
+// Compression starts at compressThreshold of the distance to the gamut
+// boundary and follows a power curve with exponent compressPower, as in the
+// ACES reference gamut compression.
+const (
+	compressThreshold = 0.8
+	compressPower     = 1.2
+)
+
+// PrimariesConversion converts colours between RGB spaces through XYZ.
+type PrimariesConversion struct {
+	from, to RGBSpace
+	m        mat3
+	mapping  GamutMapping
+	// limits is the largest distance from the achromatic axis, per
+	// destination channel, that a colour inside the source gamut reaches.
+	limits [3]float64
+}
+
+func NewPrimariesConversion(from, to RGBSpace, mapping GamutMapping) *PrimariesConversion {
+	m := to.toXYZ().inverse().mul(bradford(from.White, to.White)).mul(from.toXYZ())
+	return newConversion(from, to, m, mapping)
+}
+
+// NewXYZConversion converts from linear RGB given by its matrix to XYZ
+// relative to white, such as an ICC profile's D50 connection space. The
+// source has no transfer curve of its own: Convert treats it as linear.
+func NewXYZConversion(toXYZ [3][3]float64, white Chromaticity, to RGBSpace, mapping GamutMapping) *PrimariesConversion {
+	from := RGBSpace{Name: "xyz", White: white, Transfer: TransferLinear}
+	m := to.toXYZ().inverse().mul(bradford(white, to.White)).mul(toXYZ)
+	return newConversion(from, to, m, mapping)
+}
+
+func newConversion(from, to RGBSpace, m mat3, mapping GamutMapping) *PrimariesConversion {
+	c := &PrimariesConversion{from: from, to: to, m: m, mapping: mapping}
+
+	// The source gamut's most saturated colours are its primaries and
+	// secondaries, so they bound how far outside the destination it goes.
+	for _, corner := range [][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 0}, {0, 1, 1}, {1, 0, 1}} {
+		d := distances(m.apply(corner))
+		for i := range c.limits {
+			c.limits[i] = math.Max(c.limits[i], d[i])
+		}
+	}
+	return c
+}
+
+// Matrix returns the linear-light RGB to RGB matrix, row-major.
+func (c *PrimariesConversion) Matrix() [3][3]float64 {
+	return c.m
+}
+
+// ConvertLinear converts linear-light RGB and maps the result into the
+// destination gamut, returning values in 0-1.
+func (c *PrimariesConversion) ConvertLinear(r, g, b float64) (float64, float64, float64) {
+	v := c.m.apply([3]float64{r, g, b})
+	if c.mapping == GamutCompress {
+		v = c.compress(v)
+	}
+	return clamp01(v[0]), clamp01(v[1]), clamp01(v[2])
+}
+
+// Convert converts encoded RGB values in 0-1, decoding and re-encoding them
+// with the spaces' transfer curves.
+func (c *PrimariesConversion) Convert(r, g, b float64) (float64, float64, float64) {
+	tf := c.from.Transfer
+	r, g, b = c.ConvertLinear(tf.ToLinear(r), tf.ToLinear(g), tf.ToLinear(b))
+	tf = c.to.Transfer
+	return tf.FromLinear(r), tf.FromLinear(g), tf.FromLinear(b)
+}
+
+// compress pulls each channel towards the achromatic axis, given by the
+// largest channel, so that the source gamut boundary lands on the
+// destination's.
+func (c *PrimariesConversion) compress(v [3]float64) [3]float64 {
+	achromatic := math.Max(v[0], math.Max(v[1], v[2]))
+	if achromatic <= 0 {
+		return v
+	}
+	d := distances(v)
+	for i := range v {
+		if c.limits[i] <= 1 {
+			continue
+		}
+		v[i] = achromatic - compressDistance(d[i], c.limits[i])*achromatic
+	}
+	return v
+}
+
+// distances returns how far each channel is from the largest one, relative
+// to it. A distance above 1 means the channel is negative: out of gamut.
+func distances(v [3]float64) [3]float64 {
+	achromatic := math.Max(v[0], math.Max(v[1], v[2]))
+	if achromatic <= 0 {
+		return [3]float64{}
+	}
+	return [3]float64{(achromatic - v[0]) / achromatic, (achromatic - v[1]) / achromatic, (achromatic - v[2]) / achromatic}
+}
+
+// compressDistance maps distances in [threshold, limit] smoothly onto
+// [threshold, 1], leaving smaller distances alone.
+func compressDistance(d, limit float64) float64 {
+	t, p := compressThreshold, compressPower
+	if d < t {
+		return d
+	}
+	scale := (limit - t) / math.Pow(math.Pow((1-t)/(limit-t), -p)-1, 1/p)
+	x := (d - t) / scale
+	return t + scale*x/math.Pow(1+math.Pow(x, p), 1/p)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
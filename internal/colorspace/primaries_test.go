@@ -0,0 +1,140 @@
+package colorspace
+
+import (
+	"math"
+	"testing"
+)
+
+func space(t *testing.T, name string) RGBSpace {
+	t.Helper()
+	s, err := RGBSpaceByName(name)
+	if err != nil {
+		t.Fatalf("RGBSpaceByName(%q) unexpected error: %v", name, err)
+	}
+	return s
+}
+
+func assertMatrix(t *testing.T, name string, got, want [3][3]float64, tolerance float64) {
+	t.Helper()
+	for i := range got {
+		for j := range got[i] {
+			if math.Abs(got[i][j]-want[i][j]) > tolerance {
+				t.Errorf("%s[%d][%d] = %.5f, want %.5f", name, i, j, got[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestSRGBToXYZ(t *testing.T) {
+	// IEC 61966-2-1.
+	want := [3][3]float64{
+		{0.4124, 0.3576, 0.1805},
+		{0.2126, 0.7152, 0.0722},
+		{0.0193, 0.1192, 0.9505},
+	}
+	assertMatrix(t, "sRGB to XYZ", space(t, "srgb").toXYZ(), want, 5e-4)
+}
+
+func TestPrimariesMatrices(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     [3][3]float64
+	}{
+		// BT.2087 gives the BT.2020 to BT.709 matrix to four places.
+		{"rec2020", "rec709", [3][3]float64{
+			{1.6605, -0.5876, -0.0728},
+			{-0.1246, 1.1329, -0.0083},
+			{-0.0182, -0.1006, 1.1187},
+		}},
+		{"displayp3", "srgb", [3][3]float64{
+			{1.2249, -0.2247, 0},
+			{-0.0420, 1.0419, 0},
+			{-0.0197, -0.0786, 1.0979},
+		}},
+	}
+	for _, tt := range tests {
+		c := NewPrimariesConversion(space(t, tt.from), space(t, tt.to), GamutClip)
+		assertMatrix(t, tt.from+" to "+tt.to, c.Matrix(), tt.want, 5e-4)
+	}
+}
+
+func TestBradford(t *testing.T) {
+	// Lindbloom's D65 to D50 Bradford matrix.
+	want := [3][3]float64{
+		{1.0478, 0.0229, -0.0501},
+		{0.0295, 0.9905, -0.0171},
+		{-0.0092, 0.0151, 0.7519},
+	}
+	assertMatrix(t, "D65 to D50", bradford(D65, D50), want, 5e-4)
+}
+
+func TestWhiteIsPreserved(t *testing.T) {
+	// Adaptation maps white to white even between different white points.
+	for _, pair := range [][2]string{{"prophoto", "srgb"}, {"dcip3", "rec2020"}, {"srgb", "adobergb"}} {
+		c := NewPrimariesConversion(space(t, pair[0]), space(t, pair[1]), GamutClip)
+		r, g, b := c.ConvertLinear(1, 1, 1)
+		if math.Abs(r-1) > 1e-6 || math.Abs(g-1) > 1e-6 || math.Abs(b-1) > 1e-6 {
+			t.Errorf("%s to %s: white = %.6f %.6f %.6f", pair[0], pair[1], r, g, b)
+		}
+	}
+}
+
+func TestXYZConversion(t *testing.T) {
+	// Through a D50 connection space, as ICC profiles describe colours, a
+	// space comes back to itself and P3 converts as it does directly.
+	srgb, p3 := space(t, "srgb"), space(t, "displayp3")
+	identity := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	assertMatrix(t, "sRGB via D50", NewXYZConversion(srgb.ToXYZ(D50), D50, srgb, GamutClip).Matrix(), identity, 1e-9)
+	assertMatrix(t, "P3 via D50", NewXYZConversion(p3.ToXYZ(D50), D50, srgb, GamutClip).Matrix(),
+		NewPrimariesConversion(p3, srgb, GamutClip).Matrix(), 1e-9)
+}
+
+func TestGamutCompression(t *testing.T) {
+	from, to := space(t, "rec2020"), space(t, "srgb")
+	clip := NewPrimariesConversion(from, to, GamutClip)
+	compress := NewPrimariesConversion(from, to, GamutCompress)
+
+	// Pure BT.2020 green is far outside sRGB. Clipping it drops the
+	// negative channels to zero; compression lands it on the boundary
+	// with the same ordering of channels.
+	r, g, b := compress.ConvertLinear(0, 1, 0)
+	if g != 1 || r < 0 || b < 0 || r > 0.2 || b > 0.2 {
+		t.Errorf("compressed green = %.4f %.4f %.4f", r, g, b)
+	}
+	if r, g, b := clip.ConvertLinear(0, 1, 0); r != 0 || b != 0 || g != 1 {
+		t.Errorf("clipped green = %.4f %.4f %.4f", r, g, b)
+	}
+
+	// Neutral and weakly saturated colours are left alone.
+	for _, v := range [][3]float64{{0.5, 0.5, 0.5}, {0.4, 0.45, 0.5}} {
+		r1, g1, b1 := clip.ConvertLinear(v[0], v[1], v[2])
+		r2, g2, b2 := compress.ConvertLinear(v[0], v[1], v[2])
+		if math.Abs(r1-r2) > 1e-9 || math.Abs(g1-g2) > 1e-9 || math.Abs(b1-b2) > 1e-9 {
+			t.Errorf("compression changed in-gamut colour %v", v)
+		}
+	}
+
+	// The curve is continuous and monotonic.
+	prev := 0.0
+	for d := 0.0; d <= 1.6; d += 0.01 {
+		got := compressDistance(d, 1.6)
+		if got < prev || got > 1+1e-9 {
+			t.Fatalf("compressDistance(%.2f) = %.4f after %.4f", d, got, prev)
+		}
+		prev = got
+	}
+	if got := compressDistance(1.6, 1.6); math.Abs(got-1) > 1e-9 {
+		t.Errorf("compressDistance(limit) = %.6f, want 1", got)
+	}
+}
+
+func TestParseGamutMapping(t *testing.T) {
+	for name, want := range map[string]GamutMapping{"clip": GamutClip, "Compress": GamutCompress, "CLIP": GamutClip} {
+		if g, err := ParseGamutMapping(name); err != nil || g != want {
+			t.Errorf("ParseGamutMapping(%q) = %v, %v; want %v", name, g, err, want)
+		}
+	}
+	if _, err := ParseGamutMapping("squash"); err == nil {
+		t.Error(`ParseGamutMapping("squash") expected error`)
+	}
+}
@@ -0,0 +1,182 @@
+package colorspace
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Transfer is the curve between linear light and encoded R'G'B' values.
+type Transfer int
+
+const (
+	// TransferSRGB is the piecewise sRGB curve, also used by Display P3.
+	TransferSRGB Transfer = iota
+	// TransferBT709 is the BT.709 camera curve, shared by BT.2020.
+	TransferBT709
+	TransferGamma22
+	TransferGamma26
+	// TransferROMM is the ProPhoto curve: gamma 1.8 with a linear toe.
+	TransferROMM
+	TransferLinear
+	// TransferBT1886 is the display curve of SDR video, gamma 2.4 on a
+	// display with zero black level.
+	TransferBT1886
+	// TransferPQ is the SMPTE ST 2084 perceptual quantizer. Linear values
+	// are absolute: 1 is 10000 cd/m².
+	TransferPQ
+	// TransferHLG is the BT.2100 hybrid log-gamma camera curve. Linear
+	// values are relative scene light; the display adds a system gamma.
+	TransferHLG
+)
+
+var transferNames = map[string]Transfer{
+	"srgb":         TransferSRGB,
+	"iec61966-2-1": TransferSRGB,
+	"bt709":        TransferBT709,
+	"bt2020":       TransferBT709,
+	"gamma22":      TransferGamma22,
+	"gamma26":      TransferGamma26,
+	"romm":         TransferROMM,
+	"linear":       TransferLinear,
+	"bt1886":       TransferBT1886,
+	"pq":           TransferPQ,
+	"smpte2084":    TransferPQ,
+	"hlg":          TransferHLG,
+	"arib-std-b67": TransferHLG,
+}
+
+func ParseTransfer(name string) (Transfer, error) {
+	if t, ok := transferNames[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+	return 0, fmt.Errorf("unknown transfer function %q (available: %s)", name, strings.Join(TransferNames(), ", "))
+}
+
+// TransferNames lists the canonical transfer names understood by
+// ParseTransfer.
+func TransferNames() []string {
+	return []string{"srgb", "bt709", "bt1886", "gamma22", "gamma26", "romm", "linear", "pq", "hlg"}
+}
+
+func (t Transfer) String() string {
+	switch t {
+	case TransferBT709:
+		return "bt709"
+	case TransferGamma22:
+		return "gamma22"
+	case TransferGamma26:
+		return "gamma26"
+	case TransferROMM:
+		return "romm"
+	case TransferLinear:
+		return "linear"
+	case TransferBT1886:
+		return "bt1886"
+	case TransferPQ:
+		return "pq"
+	case TransferHLG:
+		return "hlg"
+	}
+	return "srgb"
+}
+
+// HDR reports whether the curve encodes light beyond SDR reference white.
+func (t Transfer) HDR() bool {
+	return t == TransferPQ || t == TransferHLG
+}
+
+// ST 2084 constants.
+const (
+	pqM1 = 2610.0 / 16384
+	pqM2 = 2523.0 / 4096 * 128
+	pqC1 = 3424.0 / 4096
+	pqC2 = 2413.0 / 4096 * 32
+	pqC3 = 2392.0 / 4096 * 32
+)
+
+// BT.2100 HLG constants.
+const (
+	hlgA = 0.17883277
+	hlgB = 1 - 4*hlgA
+	hlgC = 0.55991073
+)
+
+// ToLinear decodes an encoded value in 0-1 to linear light. Values
+// outside 0-1 are extended symmetrically around zero.
+func (t Transfer) ToLinear(v float64) float64 {
+	if v < 0 {
+		return -t.ToLinear(-v)
+	}
+	switch t {
+	case TransferSRGB:
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	case TransferBT709:
+		if v < 0.081 {
+			return v / 4.5
+		}
+		return math.Pow((v+0.099)/1.099, 1/0.45)
+	case TransferGamma22:
+		return math.Pow(v, 563.0/256)
+	case TransferGamma26:
+		return math.Pow(v, 2.6)
+	case TransferROMM:
+		if v < 16.0/512 {
+			return v / 16
+		}
+		return math.Pow(v, 1.8)
+	case TransferBT1886:
+		return math.Pow(v, 2.4)
+	case TransferPQ:
+		p := math.Pow(v, 1/pqM2)
+		return math.Pow(math.Max(p-pqC1, 0)/(pqC2-pqC3*p), 1/pqM1)
+	case TransferHLG:
+		if v <= 0.5 {
+			return v * v / 3
+		}
+		return (math.Exp((v-hlgC)/hlgA) + hlgB) / 12
+	}
+	return v
+}
+
+// FromLinear encodes linear light as a value in 0-1; it inverts ToLinear.
+func (t Transfer) FromLinear(l float64) float64 {
+	if l < 0 {
+		return -t.FromLinear(-l)
+	}
+	switch t {
+	case TransferSRGB:
+		if l <= 0.0031308 {
+			return l * 12.92
+		}
+		return 1.055*math.Pow(l, 1/2.4) - 0.055
+	case TransferBT709:
+		if l < 0.018 {
+			return l * 4.5
+		}
+		return 1.099*math.Pow(l, 0.45) - 0.099
+	case TransferGamma22:
+		return math.Pow(l, 256.0/563)
+	case TransferGamma26:
+		return math.Pow(l, 1/2.6)
+	case TransferROMM:
+		if l < 1.0/512 {
+			return l * 16
+		}
+		return math.Pow(l, 1/1.8)
+	case TransferBT1886:
+		return math.Pow(l, 1/2.4)
+	case TransferPQ:
+		p := math.Pow(l, pqM1)
+		return math.Pow((pqC1+pqC2*p)/(1+pqC3*p), pqM2)
+	case TransferHLG:
+		if l <= 1.0/12 {
+			return math.Sqrt(3 * l)
+		}
+		return hlgA*math.Log(12*l-hlgB) + hlgC
+	}
+	return l
+}
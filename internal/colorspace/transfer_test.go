@@ -0,0 +1,49 @@
+package colorspace
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTransferRoundTrip(t *testing.T) {
+	for _, name := range TransferNames() {
+		tf, err := ParseTransfer(name)
+		if err != nil {
+			t.Fatalf("ParseTransfer(%q) unexpected error: %v", name, err)
+		}
+		if tf.String() != name {
+			t.Errorf("ParseTransfer(%q).String() = %q", name, tf)
+		}
+		// PQ encodes zero light as 7.3e-7 rather than 0.
+		for _, v := range []float64{0, 0.001, 0.02, 0.05, 0.5, 0.75, 1} {
+			if got := tf.FromLinear(tf.ToLinear(v)); math.Abs(got-v) > 1e-6 {
+				t.Errorf("%s: round trip of %g = %g", tf, v, got)
+			}
+		}
+	}
+	if got := TransferSRGB.ToLinear(0.5); math.Abs(got-0.214041) > 1e-6 {
+		t.Errorf("sRGB 0.5 decodes to %.6f, want 0.214041", got)
+	}
+}
+
+func TestHDRTransfers(t *testing.T) {
+	// Reference points from ST 2084 and BT.2100.
+	tests := []struct {
+		tf          Transfer
+		linear, enc float64
+	}{
+		{TransferPQ, 100.0 / 10000, 0.50808},
+		{TransferPQ, 1000.0 / 10000, 0.75183},
+		{TransferPQ, 1, 1},
+		{TransferHLG, 1.0 / 12, 0.5},
+		{TransferHLG, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.tf.FromLinear(tt.linear); math.Abs(got-tt.enc) > 5e-5 {
+			t.Errorf("%s encodes %g as %.5f, want %.5f", tt.tf, tt.linear, got, tt.enc)
+		}
+	}
+	if _, err := ParseTransfer("log-c"); err == nil {
+		t.Error("ParseTransfer(\"log-c\") expected error")
+	}
+}
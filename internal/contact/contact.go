@@ -0,0 +1,163 @@
+package contact
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"video-processor/internal/filters"
+	"video-processor/internal/font"
+	"video-processor/internal/resize"
+)
+
+// Options configures the layout of contact sheets.
+type Options struct {
+	// Columns is the number of cells per row. Rows limits the rows per
+	// sheet; zero puts every image on a single sheet.
+	Columns, Rows int
+	// CellWidth and CellHeight bound each thumbnail. Images are scaled to
+	// fit inside the cell with their aspect ratio preserved and padded
+	// with Background.
+	CellWidth, CellHeight int
+	// Spacing is the gap between cells and around the sheet's edge.
+	Spacing    int
+	Background color.NRGBA
+	// Captions adds a line of text below every cell in CaptionColor, with
+	// font pixels enlarged by FontScale (default 1).
+	Captions     bool
+	CaptionColor color.NRGBA
+	FontScale    int
+	// Filter resamples the images; nil selects Lanczos-3.
+	Filter filters.Resampler
+}
+
+func (o Options) validate() error {
+	if o.Columns <= 0 || o.Rows < 0 {
+		return fmt.Errorf("invalid contact sheet grid %dx%d", o.Columns, o.Rows)
+	}
+	if o.CellWidth <= 0 || o.CellHeight <= 0 {
+		return fmt.Errorf("invalid cell size %dx%d", o.CellWidth, o.CellHeight)
+	}
+	if o.Spacing < 0 {
+		return fmt.Errorf("invalid spacing %d", o.Spacing)
+	}
+	return nil
+}
+
+// captionHeight returns the height of the caption strip under each cell.
+func (o Options) captionHeight() int {
+	if !o.Captions {
+		return 0
+	}
+	return font.LineHeight*o.FontScale + o.FontScale
+}
+
+// Cell returns the thumbnail area of cell i on its sheet. Captions sit
+// directly below it.
+func (o Options) Cell(i int) image.Rectangle {
+	col, row := i%o.Columns, i/o.Columns
+	x := o.Spacing + col*(o.CellWidth+o.Spacing)
+	y := o.Spacing + row*(o.CellHeight+o.captionHeight()+o.Spacing)
+	return image.Rect(x, y, x+o.CellWidth, y+o.CellHeight)
+}
+
+// SheetSize returns the size of a sheet holding n cells.
+func (o Options) SheetSize(n int) (int, int) {
+	rows := (n + o.Columns - 1) / o.Columns
+	columns := o.Columns
+	if n < columns {
+		columns = n
+	}
+	return o.Spacing + columns*(o.CellWidth+o.Spacing), o.Spacing + rows*(o.CellHeight+o.captionHeight()+o.Spacing)
+}
+
+// Builder lays images out on contact sheets. Each image is resized into
+// its cell as soon as it is added, so the Builder only keeps the
+// thumbnails of the sheet being filled; finished sheets belong to the
+// caller.
+type Builder struct {
+	opts  Options
+	cells []cell
+}
+
+type cell struct {
+	thumb   *image.NRGBA
+	caption string
+}
+
+func NewBuilder(opts Options) (*Builder, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.FontScale < 1 {
+		opts.FontScale = 1
+	}
+	if opts.Filter == nil {
+		opts.Filter = filters.NewLanczos(3)
+	}
+	return &Builder{opts: opts}, nil
+}
+
+// Add places img with its caption in the next cell. It returns the
+// finished sheet when this fills one, and nil otherwise.
+func (b *Builder) Add(img image.Image, caption string) (*image.NRGBA, error) {
+	if img == nil {
+		return nil, errors.New("image is nil")
+	}
+	thumb, err := fit(img, b.opts.CellWidth, b.opts.CellHeight, b.opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	b.cells = append(b.cells, cell{thumb: thumb, caption: caption})
+
+	if b.opts.Rows > 0 && len(b.cells) == b.opts.Columns*b.opts.Rows {
+		return b.Flush(), nil
+	}
+	return nil, nil
+}
+
+// Flush renders the cells added since the last sheet, or returns nil if
+// there are none. A partial sheet is only as large as its rows.
+func (b *Builder) Flush() *image.NRGBA {
+	if len(b.cells) == 0 {
+		return nil
+	}
+	width, height := b.opts.SheetSize(len(b.cells))
+	sheet := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(sheet, sheet.Rect, image.NewUniform(b.opts.Background), image.Point{}, draw.Src)
+
+	scale := b.opts.FontScale
+	for i, c := range b.cells {
+		rect := b.opts.Cell(i)
+
+		// Centre the thumbnail; the rest of the cell is padding.
+		offset := image.Pt((rect.Dx()-c.thumb.Rect.Dx())/2, (rect.Dy()-c.thumb.Rect.Dy())/2)
+		draw.Draw(sheet, c.thumb.Rect.Add(rect.Min).Add(offset), c.thumb, image.Point{}, draw.Over)
+
+		if b.opts.Captions && c.caption != "" {
+			text := font.Truncate(c.caption, rect.Dx(), scale)
+			x := rect.Min.X + (rect.Dx()-font.Width(text, scale))/2
+			y := rect.Max.Y + scale*2
+			font.Draw(sheet, image.Pt(x, y), text, b.opts.CaptionColor, scale)
+		}
+	}
+
+	b.cells = b.cells[:0]
+	return sheet
+}
+
+// fit scales img to the largest size that fits within width x height
+// without changing its aspect ratio.
+func fit(img image.Image, width, height int, filter filters.Resampler) (*image.NRGBA, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, errors.New("image is empty")
+	}
+	scale := math.Min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	w := int(math.Max(1, math.Round(float64(bounds.Dx())*scale)))
+	h := int(math.Max(1, math.Round(float64(bounds.Dy())*scale)))
+	return resize.ResizeWithFilter(img, min(w, width), min(h, height), filter)
+}
@@ -0,0 +1,139 @@
+package contact
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+var (
+	black = color.NRGBA{0, 0, 0, 255}
+	red   = color.NRGBA{255, 0, 0, 255}
+	white = color.NRGBA{255, 255, 255, 255}
+)
+
+func TestLayout(t *testing.T) {
+	opts := Options{Columns: 3, Rows: 2, CellWidth: 40, CellHeight: 30, Spacing: 4, Background: black}
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatalf("NewBuilder() unexpected error: %v", err)
+	}
+
+	var sheets []*image.NRGBA
+	for i := 0; i < 8; i++ {
+		sheet, err := b.Add(solid(80, 60, red), "")
+		if err != nil {
+			t.Fatalf("Add(%d) unexpected error: %v", i, err)
+		}
+		if sheet != nil {
+			sheets = append(sheets, sheet)
+		}
+	}
+	if sheet := b.Flush(); sheet != nil {
+		sheets = append(sheets, sheet)
+	}
+
+	if len(sheets) != 2 {
+		t.Fatalf("got %d sheets, want 2", len(sheets))
+	}
+	if got := sheets[0].Bounds(); got != image.Rect(0, 0, 4+3*44, 4+2*34) {
+		t.Errorf("full sheet bounds = %v", got)
+	}
+	// The two leftover images fill part of one row.
+	if got := sheets[1].Bounds(); got != image.Rect(0, 0, 4+2*44, 4+34) {
+		t.Errorf("last sheet bounds = %v", got)
+	}
+
+	// Second cell of the second row: spacing around it stays background.
+	cell := opts.Cell(4)
+	if cell != image.Rect(48, 38, 88, 68) {
+		t.Errorf("Cell(4) = %v", cell)
+	}
+	if got := sheets[0].NRGBAAt(cell.Min.X, cell.Min.Y); got != red {
+		t.Errorf("cell corner = %v, want red", got)
+	}
+	if got := sheets[0].NRGBAAt(cell.Min.X-1, cell.Min.Y); got != black {
+		t.Errorf("spacing = %v, want background", got)
+	}
+}
+
+func TestAspectFit(t *testing.T) {
+	b, err := NewBuilder(Options{Columns: 1, CellWidth: 40, CellHeight: 40, Background: black})
+	if err != nil {
+		t.Fatalf("NewBuilder() unexpected error: %v", err)
+	}
+	// A 2:1 image fills the cell's width and is centred vertically.
+	if _, err := b.Add(solid(100, 50, red), ""); err != nil {
+		t.Fatalf("Add() unexpected error: %v", err)
+	}
+	sheet := b.Flush()
+
+	for _, tt := range []struct {
+		y    int
+		want color.NRGBA
+	}{{0, black}, {9, black}, {10, red}, {29, red}, {30, black}} {
+		if got := sheet.NRGBAAt(20, tt.y); got != tt.want {
+			t.Errorf("pixel (20,%d) = %v, want %v", tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestCaptions(t *testing.T) {
+	opts := Options{Columns: 2, CellWidth: 30, CellHeight: 20, Spacing: 2, Background: black, Captions: true, CaptionColor: white}
+	b, err := NewBuilder(opts)
+	if err != nil {
+		t.Fatalf("NewBuilder() unexpected error: %v", err)
+	}
+	b.Add(solid(30, 20, red), "a very long file name.png")
+	b.Add(solid(30, 20, red), "")
+	sheet := b.Flush()
+
+	if got := sheet.Bounds().Dy(); got != 2+20+10+2 {
+		t.Errorf("sheet height = %d, want room for one caption line", got)
+	}
+
+	lit := func(r image.Rectangle) bool {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if sheet.NRGBAAt(x, y) == white {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	first, second := opts.Cell(0), opts.Cell(1)
+	strip := func(cell image.Rectangle) image.Rectangle {
+		return image.Rect(cell.Min.X, cell.Max.Y, cell.Max.X, sheet.Bounds().Max.Y)
+	}
+	if !lit(strip(first)) {
+		t.Error("first caption was not drawn")
+	}
+	if lit(strip(second)) {
+		t.Error("empty caption drew text")
+	}
+	// Long captions are truncated to their cell instead of spilling over.
+	if lit(image.Rect(first.Max.X, first.Max.Y, second.Min.X, sheet.Bounds().Max.Y)) {
+		t.Error("caption overflows its cell")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Columns: 0, CellWidth: 10, CellHeight: 10},
+		{Columns: 2, CellWidth: 0, CellHeight: 10},
+		{Columns: 2, CellWidth: 10, CellHeight: 10, Spacing: -1},
+	} {
+		if _, err := NewBuilder(opts); err == nil {
+			t.Errorf("NewBuilder(%+v) expected error", opts)
+		}
+	}
+}
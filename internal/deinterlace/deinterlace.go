@@ -0,0 +1,121 @@
+package deinterlace
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"sort"
+	"strings"
+
+	"video-processor/internal/filters"
+	"video-processor/internal/resize"
+)
+
+// FieldOrder says which field of each frame was captured first.
+type FieldOrder int
+
+const (
+	TopFieldFirst FieldOrder = iota
+	BottomFieldFirst
+)
+
+// first returns the parity of the earlier field.
+func (o FieldOrder) first() resize.Parity {
+	if o == BottomFieldFirst {
+		return resize.BottomField
+	}
+	return resize.TopField
+}
+
+// Deinterlacer turns an interlaced frame into a progressive one at the time
+// of its first field. prev and next are the neighbouring frames for
+// temporal methods; at the ends of a stream they are nil.
+type Deinterlacer interface {
+	Deinterlace(prev, cur, next *image.NRGBA, order FieldOrder) (*image.NRGBA, error)
+}
+
+// Weave leaves both fields in place. It is exact for progressive content
+// carried in an interlaced stream and shows combing on motion otherwise.
+type Weave struct{}
+
+func (Weave) Deinterlace(prev, cur, next *image.NRGBA, order FieldOrder) (*image.NRGBA, error) {
+	dst := image.NewNRGBA(image.Rect(0, 0, cur.Rect.Dx(), cur.Rect.Dy()))
+	draw.Draw(dst, dst.Rect, cur, cur.Rect.Min, draw.Src)
+	return dst, nil
+}
+
+// Bob drops the second field and rebuilds the frame from the first by
+// resampling it to full height with Filter.
+type Bob struct {
+	Filter filters.Resampler
+}
+
+func (b Bob) Deinterlace(prev, cur, next *image.NRGBA, order FieldOrder) (*image.NRGBA, error) {
+	filter := b.Filter
+	if filter == nil {
+		filter = filters.NewTriangle()
+	}
+	p := order.first()
+	return resize.ResizeField(resize.ExtractField(cur, p), p, cur.Rect.Dy(), cur.Rect.Dx(), cur.Rect.Dy(), filter)
+}
+
+// Blend low-passes every column with a [1 2 1] kernel, averaging each row
+// with its neighbours from the other field. Combing turns into a faint
+// double image and vertical detail is softened.
+type Blend struct{}
+
+func (Blend) Deinterlace(prev, cur, next *image.NRGBA, order FieldOrder) (*image.NRGBA, error) {
+	w, h := cur.Rect.Dx(), cur.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		above, below := rowOffset(cur, clampRow(y-1, h)), rowOffset(cur, clampRow(y+1, h))
+		mid := rowOffset(cur, y)
+		out := dst.Pix[y*dst.Stride:]
+		for i := 0; i < w*4; i++ {
+			out[i] = uint8((int(cur.Pix[above+i]) + 2*int(cur.Pix[mid+i]) + int(cur.Pix[below+i]) + 2) / 4)
+		}
+	}
+	return dst, nil
+}
+
+func rowOffset(img *image.NRGBA, y int) int {
+	return img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+}
+
+func clampRow(y, h int) int {
+	if y < 0 {
+		return 0
+	}
+	if y >= h {
+		return h - 1
+	}
+	return y
+}
+
+var byName = map[string]func(filter filters.Resampler) Deinterlacer{
+	"weave": func(filters.Resampler) Deinterlacer { return Weave{} },
+	"bob":   func(filter filters.Resampler) Deinterlacer { return Bob{Filter: filter} },
+	"blend": func(filters.Resampler) Deinterlacer { return Blend{} },
+	"yadif": func(filters.Resampler) Deinterlacer { return Yadif{} },
+}
+
+// New returns the deinterlacer registered under name. filter is used by
+// methods that resample fields, such as bob; nil selects their default.
+func New(name string, filter filters.Resampler) (Deinterlacer, error) {
+	build, ok := byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown deinterlacer %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return build(filter), nil
+}
+
+// Names lists the registered deinterlacers in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,133 @@
+package deinterlace
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// rowImage returns a gray image whose rows take the given values.
+func rowImage(width int, rows ...uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, len(rows)))
+	for y, v := range rows {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+// comb is a frame whose fields disagree completely, as after fast motion.
+func comb() *image.NRGBA {
+	return rowImage(6, 40, 200, 40, 200, 40, 200, 40, 200)
+}
+
+func rows(img *image.NRGBA) []uint8 {
+	values := make([]uint8, img.Rect.Dy())
+	for y := range values {
+		values[y] = img.NRGBAAt(2, y).R
+	}
+	return values
+}
+
+func TestWeave(t *testing.T) {
+	src := comb()
+	result, err := Weave{}.Deinterlace(nil, src, nil, TopFieldFirst)
+	if err != nil {
+		t.Fatalf("Deinterlace() unexpected error: %v", err)
+	}
+	for y, v := range rows(result) {
+		if v != src.NRGBAAt(2, y).R {
+			t.Errorf("row %d = %d, want it unchanged", y, v)
+		}
+	}
+}
+
+func TestBobKeepsFirstField(t *testing.T) {
+	for order, want := range map[FieldOrder]uint8{TopFieldFirst: 40, BottomFieldFirst: 200} {
+		result, err := Bob{}.Deinterlace(nil, comb(), nil, order)
+		if err != nil {
+			t.Fatalf("Deinterlace() unexpected error: %v", err)
+		}
+		for y, v := range rows(result) {
+			if v != want {
+				t.Errorf("order %d row %d = %d, want %d", order, y, v, want)
+			}
+		}
+	}
+}
+
+func TestBlend(t *testing.T) {
+	result, err := Blend{}.Deinterlace(nil, comb(), nil, TopFieldFirst)
+	if err != nil {
+		t.Fatalf("Deinterlace() unexpected error: %v", err)
+	}
+	for y, v := range rows(result)[1:7] {
+		if v != 120 {
+			t.Errorf("row %d = %d, want 120", y+1, v)
+		}
+	}
+}
+
+func TestYadifRemovesCombing(t *testing.T) {
+	result, err := Yadif{}.Deinterlace(nil, comb(), nil, TopFieldFirst)
+	if err != nil {
+		t.Fatalf("Deinterlace() unexpected error: %v", err)
+	}
+	for y, v := range rows(result) {
+		if v != 40 {
+			t.Errorf("row %d = %d, want 40", y, v)
+		}
+	}
+}
+
+func TestYadifKeepsStaticDetail(t *testing.T) {
+	// A still gradient whose missing rows differ from the spatial average
+	// of their neighbours is rebuilt exactly from the neighbouring frames.
+	// The outermost rows are skipped: one-line extremes look like combing.
+	still := rowImage(6, 0, 10, 30, 60, 100, 150, 210, 240, 250, 255)
+	result, err := Yadif{}.Deinterlace(still, still, still, BottomFieldFirst)
+	if err != nil {
+		t.Fatalf("Deinterlace() unexpected error: %v", err)
+	}
+	for y, v := range rows(result)[1:9] {
+		y++
+		if want := still.NRGBAAt(2, y).R; v != want {
+			t.Errorf("row %d = %d, want %d", y, v, want)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := NewStream(Weave{}, TopFieldFirst)
+	var got []uint8
+	for _, v := range []uint8{10, 20, 30} {
+		out, err := s.Push(rowImage(4, v, v))
+		if err != nil {
+			t.Fatalf("Push() unexpected error: %v", err)
+		}
+		if out != nil {
+			got = append(got, out.NRGBAAt(0, 0).R)
+		}
+	}
+	out, err := s.Flush()
+	if err != nil || out == nil {
+		t.Fatalf("Flush() = %v, %v", out, err)
+	}
+	got = append(got, out.NRGBAAt(0, 0).R)
+
+	if len(got) != 3 || got[0] != 10 || got[1] != 20 || got[2] != 30 {
+		t.Errorf("stream output = %v, want [10 20 30]", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range Names() {
+		if _, err := New(name, nil); err != nil {
+			t.Errorf("New(%q) unexpected error: %v", name, err)
+		}
+	}
+	if _, err := New("telecine", nil); err == nil {
+		t.Error("New() expected error for unknown deinterlacer")
+	}
+}
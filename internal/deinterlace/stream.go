@@ -0,0 +1,41 @@
+package deinterlace
+
+import "image"
+
+// Stream runs a Deinterlacer over a sequence of frames, holding one frame
+// back so each frame is deinterlaced with its successor available.
+type Stream struct {
+	d     Deinterlacer
+	order FieldOrder
+
+	prev, cur *image.NRGBA
+}
+
+func NewStream(d Deinterlacer, order FieldOrder) *Stream {
+	return &Stream{d: d, order: order}
+}
+
+// Push adds the next frame in display order and returns the deinterlaced
+// previous frame, or nil for the first frame.
+func (s *Stream) Push(frame *image.NRGBA) (*image.NRGBA, error) {
+	if s.cur == nil {
+		s.cur = frame
+		return nil, nil
+	}
+	out, err := s.d.Deinterlace(s.prev, s.cur, frame, s.order)
+	if err != nil {
+		return nil, err
+	}
+	s.prev, s.cur = s.cur, frame
+	return out, nil
+}
+
+// Flush returns the last frame pushed, deinterlaced, or nil if none was.
+func (s *Stream) Flush() (*image.NRGBA, error) {
+	if s.cur == nil {
+		return nil, nil
+	}
+	out, err := s.d.Deinterlace(s.prev, s.cur, nil, s.order)
+	s.prev, s.cur = nil, nil
+	return out, err
+}
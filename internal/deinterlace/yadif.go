@@ -0,0 +1,132 @@
+package deinterlace
+
+import "image"
+
+// Yadif is an edge-directed spatial-temporal deinterlacer after the YADIF
+// filter. Rows of the first field are kept. Each missing row is predicted
+// spatially along the best matching edge direction. That prediction is then
+// clamped to the temporal average of the missing field in the neighbouring
+// frames, within a bound set by how much the picture moves. Static areas
+// therefore keep full vertical resolution, and moving areas fall back to
+// spatial interpolation without combing.
+type Yadif struct{}
+
+func (Yadif) Deinterlace(prev, cur, next *image.NRGBA, order FieldOrder) (*image.NRGBA, error) {
+	w, h := cur.Rect.Dx(), cur.Rect.Dy()
+	if h < 2 {
+		return Weave{}.Deinterlace(prev, cur, next, order)
+	}
+	if prev == nil {
+		prev = cur
+	}
+	if next == nil {
+		next = cur
+	}
+
+	// The missing field of cur was captured half a frame after its first
+	// field, and the same field of prev half a frame before, so their
+	// average lines up in time with the kept field.
+	frames := yadifFrames{prev: prev, cur: cur, next: next, prev2: prev, next2: cur, w: w, h: h}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	keep := int(order.first())
+	for y := 0; y < h; y++ {
+		out := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+		if y%2 == keep {
+			copy(out, cur.Pix[rowOffset(cur, y):])
+			continue
+		}
+		for x := 0; x < w; x++ {
+			for ch := 0; ch < 4; ch++ {
+				out[x*4+ch] = frames.predict(x, y, ch)
+			}
+		}
+	}
+	return dst, nil
+}
+
+// yadifFrames holds the frames around the one being deinterlaced. prev and
+// next supply the kept field at the neighbouring frame times; prev2 and
+// next2 supply the missing field just before and after the output time.
+type yadifFrames struct {
+	prev, cur, next, prev2, next2 *image.NRGBA
+	w, h                          int
+}
+
+// sample reads channel ch at (x, y), clamping x to the frame and stepping y
+// back inside it by whole fields so the row keeps its parity.
+func (f *yadifFrames) sample(img *image.NRGBA, x, y, ch int) int {
+	if x < 0 {
+		x = 0
+	} else if x >= f.w {
+		x = f.w - 1
+	}
+	for y < 0 {
+		y += 2
+	}
+	for y >= f.h {
+		y -= 2
+	}
+	return int(img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)+ch])
+}
+
+func (f *yadifFrames) predict(x, y, ch int) uint8 {
+	cur := func(dx, dy int) int { return f.sample(f.cur, x+dx, y+dy, ch) }
+
+	c, e := cur(0, -1), cur(0, 1)
+	p2, n2 := f.sample(f.prev2, x, y, ch), f.sample(f.next2, x, y, ch)
+	d := (p2 + n2) >> 1
+
+	// How far the picture may have moved: the missing field against itself,
+	// and the kept field against the previous and next frames.
+	diff0 := abs(p2-n2) >> 1
+	diff1 := (abs(f.sample(f.prev, x, y-1, ch)-c) + abs(f.sample(f.prev, x, y+1, ch)-e)) >> 1
+	diff2 := (abs(f.sample(f.next, x, y-1, ch)-c) + abs(f.sample(f.next, x, y+1, ch)-e)) >> 1
+	diff := max(diff0, diff1, diff2)
+
+	// Edge-directed spatial prediction: interpolate along the direction
+	// whose 3-pixel windows above and below match best.
+	score := func(j int) int {
+		return abs(cur(j-1, -1)-cur(-j-1, 1)) + abs(cur(j, -1)-cur(-j, 1)) + abs(cur(j+1, -1)-cur(-j+1, 1))
+	}
+	spatial := (c + e) >> 1
+	best := score(0) - 1
+	for _, dir := range [][2]int{{-1, -2}, {1, 2}} {
+		for _, j := range dir {
+			s := score(j)
+			if s >= best {
+				break
+			}
+			best = s
+			spatial = (cur(j, -1) + cur(-j, 1)) >> 1
+		}
+	}
+
+	// Widen the bound where the temporal prediction disagrees with the
+	// vertical neighbours two field lines away.
+	b := (f.sample(f.prev2, x, y-2, ch) + f.sample(f.next2, x, y-2, ch)) >> 1
+	g := (f.sample(f.prev2, x, y+2, ch) + f.sample(f.next2, x, y+2, ch)) >> 1
+	hi := max(d-e, d-c, min(b-c, g-e))
+	lo := min(d-e, d-c, max(b-c, g-e))
+	diff = max(diff, lo, -hi)
+
+	if spatial > d+diff {
+		spatial = d + diff
+	} else if spatial < d-diff {
+		spatial = d - diff
+	}
+	if spatial < 0 {
+		return 0
+	}
+	if spatial > 255 {
+		return 255
+	}
+	return uint8(spatial)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
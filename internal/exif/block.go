@@ -0,0 +1,374 @@
+package exif
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// IFD identifies one of the directories of an EXIF block.
+type IFD int
+
+const (
+	// IFD0 describes the main image.
+	IFD0 IFD = iota
+	// ExifIFD holds the camera settings.
+	ExifIFD
+	// GPSIFD holds the location.
+	GPSIFD
+	// InteropIFD holds interoperability information.
+	InteropIFD
+	numIFDs
+)
+
+// Tags pointing from one IFD to another. Encode writes them for the IFDs
+// that are left, so Parse does not keep them as fields.
+const (
+	tagExifIFD    = 0x8769
+	tagGPSIFD     = 0x8825
+	tagInteropIFD = 0xa005
+)
+
+// Tags Block updates or drops.
+const (
+	tagPixelXDimension = 0xa002
+	tagPixelYDimension = 0xa003
+	tagColorSpace      = 0xa001
+	tagInteropIndex    = 0x0001
+	tagMakerNote       = 0x927c
+)
+
+// colorSpaceSRGB is the ColorSpace value of sRGB images.
+const colorSpaceSRGB = 1
+
+// typeSizes are the sizes in bytes of the TIFF field types, BYTE to DOUBLE.
+var typeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// Tag is a tag number within one IFD.
+type Tag struct {
+	IFD IFD
+	ID  uint16
+}
+
+// tagNames maps the names LookupTag accepts to tags.
+var tagNames = map[string]Tag{
+	"ImageDescription":      {IFD0, 0x010e},
+	"Make":                  {IFD0, 0x010f},
+	"Model":                 {IFD0, 0x0110},
+	"Orientation":           {IFD0, tagOrientation},
+	"XResolution":           {IFD0, 0x011a},
+	"YResolution":           {IFD0, 0x011b},
+	"ResolutionUnit":        {IFD0, 0x0128},
+	"Software":              {IFD0, 0x0131},
+	"DateTime":              {IFD0, 0x0132},
+	"Artist":                {IFD0, 0x013b},
+	"Copyright":             {IFD0, 0x8298},
+	"ExposureTime":          {ExifIFD, 0x829a},
+	"FNumber":               {ExifIFD, 0x829d},
+	"ExposureProgram":       {ExifIFD, 0x8822},
+	"ISOSpeedRatings":       {ExifIFD, 0x8827},
+	"ExifVersion":           {ExifIFD, 0x9000},
+	"DateTimeOriginal":      {ExifIFD, 0x9003},
+	"DateTimeDigitized":     {ExifIFD, 0x9004},
+	"OffsetTime":            {ExifIFD, 0x9010},
+	"OffsetTimeOriginal":    {ExifIFD, 0x9011},
+	"OffsetTimeDigitized":   {ExifIFD, 0x9012},
+	"ShutterSpeedValue":     {ExifIFD, 0x9201},
+	"ApertureValue":         {ExifIFD, 0x9202},
+	"ExposureBiasValue":     {ExifIFD, 0x9204},
+	"MeteringMode":          {ExifIFD, 0x9207},
+	"Flash":                 {ExifIFD, 0x9209},
+	"FocalLength":           {ExifIFD, 0x920a},
+	"MakerNote":             {ExifIFD, 0x927c},
+	"UserComment":           {ExifIFD, 0x9286},
+	"ColorSpace":            {ExifIFD, 0xa001},
+	"PixelXDimension":       {ExifIFD, tagPixelXDimension},
+	"PixelYDimension":       {ExifIFD, tagPixelYDimension},
+	"ImageUniqueID":         {ExifIFD, 0xa420},
+	"WhiteBalance":          {ExifIFD, 0xa403},
+	"FocalLengthIn35mmFilm": {ExifIFD, 0xa405},
+	"CameraOwnerName":       {ExifIFD, 0xa430},
+	"BodySerialNumber":      {ExifIFD, 0xa431},
+	"LensMake":              {ExifIFD, 0xa433},
+	"LensModel":             {ExifIFD, 0xa434},
+	"LensSerialNumber":      {ExifIFD, 0xa435},
+}
+
+// LookupTag returns the tag with the given name, in any case.
+func LookupTag(name string) (Tag, error) {
+	for n, tag := range tagNames {
+		if strings.EqualFold(n, name) {
+			return tag, nil
+		}
+	}
+	return Tag{}, fmt.Errorf("unknown EXIF tag %q (available: %s)", name, strings.Join(TagNames(), ", "))
+}
+
+// TagNames lists the tag names LookupTag accepts, sorted.
+func TagNames() []string {
+	names := make([]string, 0, len(tagNames))
+	for n := range tagNames {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// field is one IFD entry, with its value in the block's byte order.
+type field struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// pointers are the tags leading from one IFD to another.
+var pointers = []struct {
+	from, to IFD
+	tag      uint16
+}{
+	{IFD0, ExifIFD, tagExifIFD},
+	{IFD0, GPSIFD, tagGPSIFD},
+	{ExifIFD, InteropIFD, tagInteropIFD},
+}
+
+// pointsTo reports which IFD f leads to when it is found in ifd.
+func (f field) pointsTo(ifd IFD) (IFD, bool) {
+	for _, p := range pointers {
+		if p.from == ifd && p.tag == f.tag {
+			return p.to, true
+		}
+	}
+	return 0, false
+}
+
+// Block is a parsed EXIF block: IFD0 and the Exif, GPS and interoperability
+// IFDs it leads to. The thumbnail IFD is not kept, as it would no longer
+// match a processed image.
+type Block struct {
+	order byteOrder
+	ifds  [numIFDs][]field
+}
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// Parse parses an EXIF block: TIFF-structured data, as stored after the
+// Exif header of a JPEG APP1 segment or in a PNG eXIf chunk.
+func Parse(data []byte) (*Block, error) {
+	order, offset, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	b := &Block{order: order}
+	offsets := [numIFDs]int{IFD0: offset}
+	for ifd := IFD0; ifd < numIFDs; ifd++ {
+		if offsets[ifd] == 0 {
+			continue
+		}
+		fields, err := b.readIFD(data, offsets[ifd])
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			to, ok := f.pointsTo(ifd)
+			if !ok {
+				b.ifds[ifd] = append(b.ifds[ifd], f)
+				continue
+			}
+			if f.count == 1 && (f.typ == dtLong || f.typ == dtShort) {
+				offsets[to] = int(b.uint(f))
+			}
+		}
+	}
+	return b, nil
+}
+
+// readIFD reads the entries of the IFD at offset, skipping those of
+// unknown type.
+func (b *Block) readIFD(data []byte, offset int) ([]field, error) {
+	if offset < 8 || offset+2 > len(data) {
+		return nil, fmt.Errorf("invalid IFD offset %d", offset)
+	}
+	n := int(b.order.Uint16(data[offset:]))
+	entries := data[offset+2:]
+	if len(entries) < 12*n {
+		return nil, fmt.Errorf("failed to read IFD: %w", io.ErrUnexpectedEOF)
+	}
+	fields := make([]field, 0, n)
+	for i := 0; i < n; i++ {
+		e := entries[12*i : 12*i+12]
+		f := field{tag: b.order.Uint16(e), typ: b.order.Uint16(e[2:]), count: b.order.Uint32(e[4:])}
+		if f.typ == 0 || int(f.typ) >= len(typeSizes) {
+			continue
+		}
+		size := uint64(typeSizes[f.typ]) * uint64(f.count)
+		value := e[8:12]
+		if size > 4 {
+			start := uint64(b.order.Uint32(e[8:]))
+			if start+size > uint64(len(data)) {
+				return nil, fmt.Errorf("tag 0x%04x points past the end of the block", f.tag)
+			}
+			value = data[start:]
+		}
+		f.value = append([]byte(nil), value[:size]...)
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// uint returns the value of a SHORT or LONG field with one value.
+func (b *Block) uint(f field) uint32 {
+	if f.typ == dtShort {
+		return uint32(b.order.Uint16(f.value))
+	}
+	return b.order.Uint32(f.value)
+}
+
+func (b *Block) find(tag Tag) *field {
+	for i := range b.ifds[tag.IFD] {
+		if b.ifds[tag.IFD][i].tag == tag.ID {
+			return &b.ifds[tag.IFD][i]
+		}
+	}
+	return nil
+}
+
+// SetOrientation replaces the Orientation tag; a block without one is
+// already taken as Normal.
+func (b *Block) SetOrientation(o Orientation) {
+	if f := b.find(Tag{IFD0, tagOrientation}); f != nil {
+		*f = field{tag: tagOrientation, typ: dtShort, count: 1, value: b.order.AppendUint16(nil, uint16(o))}
+	}
+}
+
+// SetPixelSize updates the image size recorded in the Exif IFD, if any.
+func (b *Block) SetPixelSize(width, height int) {
+	for _, s := range []struct {
+		tag  uint16
+		size int
+	}{{tagPixelXDimension, width}, {tagPixelYDimension, height}} {
+		if f := b.find(Tag{ExifIFD, s.tag}); f != nil {
+			*f = field{tag: s.tag, typ: dtLong, count: 1, value: b.order.AppendUint32(nil, uint32(s.size))}
+		}
+	}
+}
+
+// SetColorSpaceSRGB marks the image as sRGB where the block says otherwise:
+// the ColorSpace tag becomes sRGB and an Adobe RGB interoperability index,
+// R03, becomes the sRGB one, R98.
+func (b *Block) SetColorSpaceSRGB() {
+	if f := b.find(Tag{ExifIFD, tagColorSpace}); f != nil {
+		*f = field{tag: tagColorSpace, typ: dtShort, count: 1, value: b.order.AppendUint16(nil, colorSpaceSRGB)}
+	}
+	if f := b.find(Tag{InteropIFD, tagInteropIndex}); f != nil && f.typ == dtASCII && string(f.value) == "R03\x00" {
+		*f = field{tag: tagInteropIndex, typ: dtASCII, count: 4, value: []byte("R98\x00")}
+	}
+}
+
+// Filter removes the fields keep rejects.
+func (b *Block) Filter(keep func(Tag) bool) {
+	for ifd := range b.ifds {
+		kept := b.ifds[ifd][:0]
+		for _, f := range b.ifds[ifd] {
+			if keep(Tag{IFD(ifd), f.tag}) {
+				kept = append(kept, f)
+			}
+		}
+		b.ifds[ifd] = kept
+	}
+}
+
+// Encode writes the block back out in its original byte order. An IFD left
+// without fields is omitted, along with the pointer to it. The MakerNote is
+// dropped: most vendors' notes hold offsets relative to the original
+// block, which would point at the wrong data once the note moves.
+func (b *Block) Encode() []byte {
+	// Each IFD gets its fields plus the pointers to the IFDs below it,
+	// sorted by tag as TIFF requires.
+	var ifds [numIFDs][]field
+	for ifd := range b.ifds {
+		for _, f := range b.ifds[ifd] {
+			if IFD(ifd) != ExifIFD || f.tag != tagMakerNote {
+				ifds[ifd] = append(ifds[ifd], f)
+			}
+		}
+	}
+
+	// A sub-IFD is written when it or an IFD below it has fields.
+	present := [numIFDs]bool{IFD0: true}
+	present[InteropIFD] = len(ifds[InteropIFD]) > 0
+	present[ExifIFD] = len(ifds[ExifIFD]) > 0 || present[InteropIFD]
+	present[GPSIFD] = len(ifds[GPSIFD]) > 0
+	pointer := func(from IFD, tag uint16, to IFD) {
+		if present[to] {
+			ifds[from] = append(ifds[from], field{tag: tag, typ: dtLong, count: 1, value: make([]byte, 4)})
+		}
+	}
+	pointer(IFD0, tagExifIFD, ExifIFD)
+	pointer(IFD0, tagGPSIFD, GPSIFD)
+	pointer(ExifIFD, tagInteropIFD, InteropIFD)
+
+	// Lay the IFDs out one after the other, each followed by the values
+	// too long to fit in its entries, at even offsets.
+	var offsets [numIFDs]int
+	size := 8
+	for ifd := range ifds {
+		if !present[ifd] {
+			continue
+		}
+		sort.SliceStable(ifds[ifd], func(i, j int) bool { return ifds[ifd][i].tag < ifds[ifd][j].tag })
+		offsets[ifd] = size
+		size += 2 + 12*len(ifds[ifd]) + 4
+		for _, f := range ifds[ifd] {
+			if len(f.value) > 4 {
+				size += (len(f.value) + 1) &^ 1
+			}
+		}
+	}
+
+	out := make([]byte, 8, size)
+	if b.order == binary.LittleEndian {
+		copy(out, "II")
+	} else {
+		copy(out, "MM")
+	}
+	b.order.PutUint16(out[2:], 42)
+	b.order.PutUint32(out[4:], 8)
+	for ifd := range ifds {
+		if !present[ifd] {
+			continue
+		}
+		fields := ifds[ifd]
+		out = b.order.AppendUint16(out, uint16(len(fields)))
+		data := offsets[ifd] + 2 + 12*len(fields) + 4
+		var values []byte
+		for _, f := range fields {
+			if to, ok := f.pointsTo(IFD(ifd)); ok {
+				f.value = b.order.AppendUint32(nil, uint32(offsets[to]))
+			}
+			out = b.order.AppendUint16(out, f.tag)
+			out = b.order.AppendUint16(out, f.typ)
+			out = b.order.AppendUint32(out, f.count)
+			if len(f.value) > 4 {
+				out = b.order.AppendUint32(out, uint32(data+len(values)))
+				values = append(values, f.value...)
+				if len(values)%2 == 1 {
+					values = append(values, 0)
+				}
+			} else {
+				var inline [4]byte
+				copy(inline[:], f.value)
+				out = append(out, inline[:]...)
+			}
+		}
+		// There is no next IFD; the thumbnail is not written.
+		out = b.order.AppendUint32(out, 0)
+		out = append(out, values...)
+	}
+	return out
+}
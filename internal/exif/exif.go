@@ -0,0 +1,63 @@
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const tagOrientation = 0x0112
+
+// Field types.
+const (
+	dtASCII = 2
+	dtShort = 3
+	dtLong  = 4
+)
+
+// ReadOrientation returns the Orientation tag of an EXIF block, Normal if
+// the tag is absent.
+func ReadOrientation(data []byte) (Orientation, error) {
+	b, err := Parse(data)
+	if err != nil {
+		return 0, err
+	}
+	f := b.find(Tag{IFD0, tagOrientation})
+	if f == nil {
+		return Normal, nil
+	}
+	if f.typ != dtShort || f.count != 1 {
+		return 0, fmt.Errorf("invalid orientation field type %d count %d", f.typ, f.count)
+	}
+	o := Orientation(b.uint(*f))
+	if o < Normal || o > Rotate270 {
+		return 0, fmt.Errorf("invalid orientation %d", int(o))
+	}
+	return o, nil
+}
+
+// readHeader reads the TIFF header at the start of an EXIF block and
+// returns its byte order and the offset of the first IFD.
+func readHeader(data []byte) (byteOrder, int, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("failed to read EXIF header: %w", io.ErrUnexpectedEOF)
+	}
+	var order byteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, fmt.Errorf("invalid EXIF byte order %q", data[:2])
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil, 0, errors.New("invalid EXIF header")
+	}
+	ifd := order.Uint32(data[4:])
+	if ifd < 8 || uint64(ifd) >= uint64(len(data)) {
+		return nil, 0, fmt.Errorf("invalid IFD offset %d", ifd)
+	}
+	return order, int(ifd), nil
+}
@@ -0,0 +1,239 @@
+package exif
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// block builds an EXIF block with one IFD of SHORT entries.
+func block(order binary.AppendByteOrder, tags map[uint16]uint16) []byte {
+	data := []byte("II*\x00")
+	if order == binary.BigEndian {
+		data = []byte("MM\x00*")
+	}
+	data = order.AppendUint32(data, 8)
+	data = order.AppendUint16(data, uint16(len(tags)))
+	for tag, value := range tags {
+		data = order.AppendUint16(data, tag)
+		data = order.AppendUint16(data, dtShort)
+		data = order.AppendUint32(data, 1)
+		data = order.AppendUint16(data, value)
+		data = append(data, 0, 0)
+	}
+	return order.AppendUint32(data, 0)
+}
+
+func TestReadOrientation(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Orientation
+		wantErr bool
+	}{
+		{"little endian", block(binary.LittleEndian, map[uint16]uint16{tagOrientation: 8}), Rotate270, false},
+		{"big endian", block(binary.BigEndian, map[uint16]uint16{tagOrientation: 3}), Rotate180, false},
+		{"among other tags", block(binary.BigEndian, map[uint16]uint16{0x010f: 1, tagOrientation: 6, 0x0131: 2}), Rotate90, false},
+		{"absent", block(binary.LittleEndian, map[uint16]uint16{0x010f: 1}), Normal, false},
+		{"out of range", block(binary.LittleEndian, map[uint16]uint16{tagOrientation: 9}), 0, true},
+		{"bad byte order", []byte("XX*\x00\x08\x00\x00\x00\x00\x00"), 0, true},
+		{"bad IFD offset", []byte("II*\x00\xff\x00\x00\x00"), 0, true},
+		{"truncated IFD", block(binary.LittleEndian, map[uint16]uint16{tagOrientation: 6})[:16], 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ReadOrientation(tt.data)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: ReadOrientation() = %v, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: ReadOrientation() = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// camera builds a little-endian block laid out as cameras write them: IFD0
+// with an out-of-line Make, then the Exif and GPS IFDs, and a thumbnail IFD
+// chained after IFD0.
+func camera() []byte {
+	le := binary.LittleEndian
+	entry := func(b []byte, tag, typ uint16, count uint32, value uint32) []byte {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, count)
+		return le.AppendUint32(b, value)
+	}
+	const (
+		ifd0  = 8
+		maker = ifd0 + 2 + 4*12 + 4
+		exif  = maker + 6
+		gps   = exif + 2 + 12 + 4
+		ifd1  = gps + 2 + 12 + 4
+	)
+	b := []byte("II*\x00")
+	b = le.AppendUint32(b, ifd0)
+	b = le.AppendUint16(b, 4)
+	b = entry(b, 0x010f, 2, 6, maker)
+	b = entry(b, tagOrientation, dtShort, 1, 6)
+	b = entry(b, tagExifIFD, dtLong, 1, exif)
+	b = entry(b, tagGPSIFD, dtLong, 1, gps)
+	b = le.AppendUint32(b, ifd1)
+	b = append(b, "Canon\x00"...)
+	b = le.AppendUint16(b, 1)
+	b = entry(b, tagPixelXDimension, dtLong, 1, 4000)
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint16(b, 1)
+	b = entry(b, 0x0000, 1, 4, 0x00000202)
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint16(b, 1)
+	b = entry(b, 0x0201, dtLong, 1, 0)
+	return le.AppendUint32(b, 0)
+}
+
+func TestBlock(t *testing.T) {
+	b, err := Parse(camera())
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if n := [numIFDs]int{len(b.ifds[IFD0]), len(b.ifds[ExifIFD]), len(b.ifds[GPSIFD]), len(b.ifds[InteropIFD])}; n != [numIFDs]int{2, 1, 1, 0} {
+		t.Fatalf("Parse() fields per IFD = %v, want [2 1 1 0]", n)
+	}
+
+	// Encoding drops the thumbnail and keeps the rest as it was.
+	again, err := Parse(b.Encode())
+	if err != nil {
+		t.Fatalf("Parse(Encode()) unexpected error: %v", err)
+	}
+	if fmt.Sprint(again.ifds) != fmt.Sprint(b.ifds) {
+		t.Errorf("round trip = %v, want %v", again.ifds, b.ifds)
+	}
+
+	b.SetOrientation(Normal)
+	b.SetPixelSize(800, 600)
+	b.Filter(func(tag Tag) bool { return tag.IFD != GPSIFD })
+	data := b.Encode()
+	if o, err := ReadOrientation(data); err != nil || o != Normal {
+		t.Errorf("orientation after SetOrientation = %v, %v; want normal", o, err)
+	}
+	again, err = Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if len(again.ifds[GPSIFD]) != 0 {
+		t.Error("GPS IFD survived filtering")
+	}
+	for _, f := range again.ifds[IFD0] {
+		if f.tag == tagGPSIFD {
+			t.Error("GPS pointer survived filtering")
+		}
+	}
+	if f := again.find(Tag{ExifIFD, tagPixelXDimension}); f == nil || again.uint(*f) != 800 {
+		t.Errorf("PixelXDimension = %v, want 800", f)
+	}
+	if f := again.find(Tag{IFD0, 0x010f}); f == nil || string(f.value) != "Canon\x00" {
+		t.Errorf("Make = %v, want Canon", f)
+	}
+
+	// An Adobe RGB image converted to sRGB says so.
+	b.ifds[ExifIFD] = append(b.ifds[ExifIFD], field{tag: tagColorSpace, typ: dtShort, count: 1, value: b.order.AppendUint16(nil, 0xffff)})
+	b.ifds[InteropIFD] = []field{{tag: tagInteropIndex, typ: dtASCII, count: 4, value: []byte("R03\x00")}}
+	b.SetColorSpaceSRGB()
+	if again, err = Parse(b.Encode()); err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if f := again.find(Tag{ExifIFD, tagColorSpace}); f == nil || again.uint(*f) != colorSpaceSRGB {
+		t.Errorf("ColorSpace = %v, want sRGB", f)
+	}
+	if f := again.find(Tag{InteropIFD, tagInteropIndex}); f == nil || string(f.value) != "R98\x00" {
+		t.Errorf("InteropIndex = %v, want R98", f)
+	}
+
+	// The MakerNote, of type UNDEFINED, is dropped, since its internal
+	// offsets would break.
+	b.ifds[ExifIFD] = append(b.ifds[ExifIFD], field{tag: tagMakerNote, typ: 7, count: 8, value: []byte("Nikon\x00\x02\x10")})
+	if again, err = Parse(b.Encode()); err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if f := again.find(Tag{ExifIFD, tagMakerNote}); f != nil {
+		t.Errorf("MakerNote = %v, want it dropped", f)
+	}
+	if f := again.find(Tag{ExifIFD, tagColorSpace}); f == nil {
+		t.Error("ColorSpace dropped along with the MakerNote")
+	}
+
+	// Removing everything below IFD0 removes the Exif pointer too.
+	b.Filter(func(tag Tag) bool { return tag.IFD == IFD0 })
+	if data := b.Encode(); len(data) != 8+2+2*12+4+6 {
+		t.Errorf("IFD0-only block is %d bytes, want %d", len(data), 8+2+2*12+4+6)
+	}
+}
+
+func TestLookupTag(t *testing.T) {
+	if tag, err := LookupTag("copyright"); err != nil || tag != (Tag{IFD0, 0x8298}) {
+		t.Errorf("LookupTag(copyright) = %v, %v", tag, err)
+	}
+	if _, err := LookupTag("Latitude"); err == nil {
+		t.Error("LookupTag(Latitude) succeeded")
+	}
+}
+
+func TestApply(t *testing.T) {
+	// A 3x2 image stored with each pixel's coordinates in its colour:
+	//
+	//	a b c
+	//	d e f
+	stored := image.NewNRGBA(image.Rect(10, 20, 13, 22))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			stored.SetNRGBA(10+x, 20+y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	// Each row lists the stored coordinates of the upright pixels in
+	// raster order.
+	tests := []struct {
+		o    Orientation
+		size image.Point
+		want [][2]int
+	}{
+		{FlipHorizontal, image.Pt(3, 2), [][2]int{{2, 0}, {1, 0}, {0, 0}, {2, 1}, {1, 1}, {0, 1}}},
+		{Rotate180, image.Pt(3, 2), [][2]int{{2, 1}, {1, 1}, {0, 1}, {2, 0}, {1, 0}, {0, 0}}},
+		{FlipVertical, image.Pt(3, 2), [][2]int{{0, 1}, {1, 1}, {2, 1}, {0, 0}, {1, 0}, {2, 0}}},
+		{Transpose, image.Pt(2, 3), [][2]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {2, 0}, {2, 1}}},
+		{Rotate90, image.Pt(2, 3), [][2]int{{0, 1}, {0, 0}, {1, 1}, {1, 0}, {2, 1}, {2, 0}}},
+		{Transverse, image.Pt(2, 3), [][2]int{{2, 1}, {2, 0}, {1, 1}, {1, 0}, {0, 1}, {0, 0}}},
+		{Rotate270, image.Pt(2, 3), [][2]int{{2, 0}, {2, 1}, {1, 0}, {1, 1}, {0, 0}, {0, 1}}},
+	}
+	for _, tt := range tests {
+		got := tt.o.Apply(stored)
+		if got.Bounds() != (image.Rectangle{Max: tt.size}) {
+			t.Errorf("%v: bounds = %v, want size %v", tt.o, got.Bounds(), tt.size)
+			continue
+		}
+		for i, p := range tt.want {
+			x, y := i%tt.size.X, i/tt.size.X
+			want := color.NRGBA{uint8(p[0]), uint8(p[1]), 0, 255}
+			if c := got.At(x, y); c != want {
+				t.Errorf("%v: pixel (%d, %d) = %v, want stored %v", tt.o, x, y, c, p)
+			}
+		}
+	}
+	if got := Normal.Apply(stored); got != image.Image(stored) {
+		t.Error("Normal.Apply() did not return its input")
+	}
+}
+
+func TestParseOrientation(t *testing.T) {
+	for _, name := range OrientationNames() {
+		o, err := ParseOrientation(name)
+		if err != nil || o.String() != name {
+			t.Errorf("ParseOrientation(%q) = %v, %v", name, o, err)
+		}
+	}
+	if _, err := ParseOrientation("sideways"); err == nil {
+		t.Error(`ParseOrientation("sideways") succeeded`)
+	}
+}
@@ -0,0 +1,68 @@
+package exif
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"video-processor/internal/orient"
+)
+
+// Orientation is the EXIF Orientation tag: the transform that turns the
+// stored pixels upright.
+type Orientation int
+
+const (
+	// Normal needs no transform.
+	Normal Orientation = iota + 1
+	// FlipHorizontal mirrors the image left to right.
+	FlipHorizontal
+	// Rotate180 turns the image upside down.
+	Rotate180
+	// FlipVertical mirrors the image top to bottom.
+	FlipVertical
+	// Transpose mirrors about the top-left to bottom-right diagonal.
+	Transpose
+	// Rotate90 turns the image a quarter turn clockwise.
+	Rotate90
+	// Transverse mirrors about the top-right to bottom-left diagonal.
+	Transverse
+	// Rotate270 turns the image a quarter turn anticlockwise.
+	Rotate270
+)
+
+var orientationNames = []string{"normal", "flip-horizontal", "rotate-180", "flip-vertical", "transpose", "rotate-90", "transverse", "rotate-270"}
+
+// ParseOrientation converts a name such as "rotate-90" to an Orientation.
+func ParseOrientation(name string) (Orientation, error) {
+	for i, n := range orientationNames {
+		if strings.EqualFold(name, n) {
+			return Orientation(i + 1), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown orientation %q (available: %s)", name, strings.Join(OrientationNames(), ", "))
+}
+
+func (o Orientation) String() string {
+	if o < Normal || o > Rotate270 {
+		return fmt.Sprintf("Orientation(%d)", int(o))
+	}
+	return orientationNames[o-1]
+}
+
+// OrientationNames lists the accepted orientation names.
+func OrientationNames() []string {
+	return append([]string(nil), orientationNames...)
+}
+
+// Apply returns m transformed upright, moving its pixels without
+// resampling. Normal returns m itself; the other orientations return a new
+// image at the origin, of the same type as m where the orient package
+// supports it, with width and height swapped for the four that turn the
+// image on its side.
+func (o Orientation) Apply(m image.Image) image.Image {
+	if o <= Normal || o > Rotate270 {
+		return m
+	}
+	return orient.Apply(m, orient.Op(o-Normal))
+}
@@ -1,9 +1,17 @@
 package filters
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
 
+// Resampler is a separable reconstruction kernel. Support returns the
+// radius outside of which Kernel is always zero.
 type Resampler interface {
 	Kernel(value float64) float64
+	Support() float64
 }
 
 func sinc(value float64) float64 {
@@ -30,3 +38,136 @@ func (l *Lanczos) Kernel(value float64) float64 {
 	}
 	return 0
 }
+
+func (l *Lanczos) Support() float64 {
+	return float64(l.Radius)
+}
+
+// Box averages every source pixel under the destination pixel. When
+// upsampling it degenerates to nearest neighbour.
+type Box struct{}
+
+func NewBox() *Box {
+	return &Box{}
+}
+
+func (b *Box) Kernel(value float64) float64 {
+	if value >= -0.5 && value < 0.5 {
+		return 1
+	}
+	return 0
+}
+
+func (b *Box) Support() float64 {
+	return 0.5
+}
+
+// Triangle is the tent filter used for bilinear interpolation.
+type Triangle struct{}
+
+func NewTriangle() *Triangle {
+	return &Triangle{}
+}
+
+func (t *Triangle) Kernel(value float64) float64 {
+	value = math.Abs(value)
+	if value < 1 {
+		return 1 - value
+	}
+	return 0
+}
+
+func (t *Triangle) Support() float64 {
+	return 1
+}
+
+// Cubic is the Mitchell–Netravali family of cubic filters. B=0, C=0.5 is
+// Catmull-Rom and B=C=1/3 is the Mitchell filter.
+type Cubic struct {
+	B float64
+	C float64
+}
+
+func NewCatmullRom() *Cubic {
+	return &Cubic{B: 0, C: 0.5}
+}
+
+func NewMitchell() *Cubic {
+	return &Cubic{B: 1.0 / 3.0, C: 1.0 / 3.0}
+}
+
+func (c *Cubic) Kernel(value float64) float64 {
+	value = math.Abs(value)
+	b, cc := c.B, c.C
+	switch {
+	case value < 1:
+		return ((12-9*b-6*cc)*value*value*value +
+			(-18+12*b+6*cc)*value*value +
+			(6 - 2*b)) / 6
+	case value < 2:
+		return ((-b-6*cc)*value*value*value +
+			(6*b+30*cc)*value*value +
+			(-12*b-48*cc)*value +
+			(8*b + 24*cc)) / 6
+	}
+	return 0
+}
+
+func (c *Cubic) Support() float64 {
+	return 2
+}
+
+// Gaussian is a truncated Gaussian with the given standard deviation.
+type Gaussian struct {
+	Sigma float64
+}
+
+func NewGaussian(sigma float64) *Gaussian {
+	return &Gaussian{Sigma: sigma}
+}
+
+func (g *Gaussian) Kernel(value float64) float64 {
+	if math.Abs(value) >= g.Support() {
+		return 0
+	}
+	return math.Exp(-value * value / (2 * g.Sigma * g.Sigma))
+}
+
+func (g *Gaussian) Support() float64 {
+	return 3 * g.Sigma
+}
+
+var byName = map[string]func() Resampler{
+	"box":        func() Resampler { return NewBox() },
+	"nearest":    func() Resampler { return NewBox() },
+	"neighbor":   func() Resampler { return NewBox() },
+	"bilinear":   func() Resampler { return NewTriangle() },
+	"triangle":   func() Resampler { return NewTriangle() },
+	"bicubic":    func() Resampler { return NewCatmullRom() },
+	"catmullrom": func() Resampler { return NewCatmullRom() },
+	"mitchell":   func() Resampler { return NewMitchell() },
+	"gaussian":   func() Resampler { return NewGaussian(0.5) },
+	"lanczos":    func() Resampler { return NewLanczos(3) },
+	"lanczos2":   func() Resampler { return NewLanczos(2) },
+	"lanczos3":   func() Resampler { return NewLanczos(3) },
+}
+
+// ByName returns the filter registered under name, matching the flag
+// names accepted by the CLI (e.g. "lanczos", "bicubic", "bilinear").
+func ByName(name string) (Resampler, error) {
+	newFilter, ok := byName[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return newFilter(), nil
+}
+
+// Names lists the filter names understood by ByName.
+func Names() []string {
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
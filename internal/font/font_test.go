@@ -0,0 +1,60 @@
+package font
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		text  string
+		scale int
+		want  int
+	}{
+		{"", 1, 0},
+		{"A", 1, 5},
+		{"AB", 1, 11},
+		{"AB", 2, 22},
+	}
+	for _, tt := range tests {
+		if got := Width(tt.text, tt.scale); got != tt.want {
+			t.Errorf("Width(%q, %d) = %d, want %d", tt.text, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := Truncate("short", 100, 1); got != "short" {
+		t.Errorf("Truncate() = %q, want text unchanged", got)
+	}
+	// 8 glyphs fit in 47 pixels: five letters and the ellipsis.
+	if got := Truncate("holiday_photo.jpg", 47, 1); got != "holid..." {
+		t.Errorf("Truncate() = %q, want %q", got, "holid...")
+	}
+	if got := Truncate("holiday", 10, 1); got != "" {
+		t.Errorf("Truncate() = %q, want empty when nothing fits", got)
+	}
+}
+
+func TestDraw(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	white := color.NRGBA{255, 255, 255, 255}
+	Draw(img, image.Pt(2, 3), "|", white, 2)
+
+	// '|' is the middle column lit on every row, doubled in both directions.
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			lit := x >= 6 && x < 8 && y >= 3 && y < 3+Height(2)
+			if got := img.NRGBAAt(x, y).A == 255; got != lit {
+				t.Fatalf("pixel (%d,%d) lit = %v, want %v", x, y, got, lit)
+			}
+		}
+	}
+}
+
+func TestUnknownRune(t *testing.T) {
+	if glyph('é') != glyph('?') || glyph('\n') != glyph('?') {
+		t.Error("characters outside printable ASCII should draw as '?'")
+	}
+}
@@ -0,0 +1,242 @@
+package framerate
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math/big"
+
+	"video-processor/internal/y4m"
+)
+
+// Mode selects how output frames falling between two source frames are
+// produced.
+type Mode int
+
+const (
+	// Nearest drops or duplicates frames, showing the source frame whose
+	// timestamp is closest to each output timestamp.
+	Nearest Mode = iota
+	// Blend mixes the two source frames around each output timestamp,
+	// weighted by their distance from it.
+	Blend
+)
+
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "dup", "drop", "nearest":
+		return Nearest, nil
+	case "blend":
+		return Blend, nil
+	}
+	return 0, fmt.Errorf("unknown frame rate conversion mode %q (available: dup, blend)", name)
+}
+
+func (m Mode) String() string {
+	if m == Blend {
+		return "blend"
+	}
+	return "dup"
+}
+
+// Mapping records which source frames produced one output frame.
+type Mapping struct {
+	Output  int       `json:"output"`
+	Time    string    `json:"time"`
+	Sources []int     `json:"sources"`
+	Weights []float64 `json:"weights"`
+}
+
+// Report summarises a conversion.
+type Report struct {
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Mode       string    `json:"mode"`
+	Inputs     int       `json:"inputs"`
+	Outputs    int       `json:"outputs"`
+	Dropped    int       `json:"dropped"`
+	Duplicated int       `json:"duplicated"`
+	Blended    int       `json:"blended"`
+	Mappings   []Mapping `json:"mappings"`
+}
+
+// Converter resamples a stream of frames from one rational frame rate to
+// another. Output frame k is shown at exactly k*out.Den/out.Num seconds,
+// which lands at source position k * out.Den*in.Num / (out.Num*in.Den);
+// the arithmetic is done on integers so long streams never drift.
+//
+// Frames are pushed in display order and the converter returns output
+// frames as soon as every source frame they depend on has arrived.
+type Converter struct {
+	mode Mode
+	to   y4m.Rational
+	// Output k sits at source position k*num/den.
+	num, den *big.Int
+
+	previous, current *image.YCbCr
+	inputs            int // frames pushed so far; current has index inputs-1
+	next              int // next output index to emit
+
+	report Report
+	used   map[int]int
+}
+
+func NewConverter(from, to y4m.Rational, mode Mode) (*Converter, error) {
+	if from.Num <= 0 || from.Den <= 0 || to.Num <= 0 || to.Den <= 0 {
+		return nil, errors.New("frame rates must be positive")
+	}
+
+	num := new(big.Int).Mul(big.NewInt(to.Den), big.NewInt(from.Num))
+	den := new(big.Int).Mul(big.NewInt(to.Num), big.NewInt(from.Den))
+
+	return &Converter{
+		mode: mode,
+		to:   to,
+		num:  num,
+		den:  den,
+		report: Report{
+			From: from.String(),
+			To:   to.String(),
+			Mode: mode.String(),
+		},
+		used: map[int]int{},
+	}, nil
+}
+
+// position returns the integer source index and fractional offset of
+// output frame k, the fraction as numerator over c.den.
+func (c *Converter) position(k int) (int, *big.Int) {
+	pos := new(big.Int).Mul(big.NewInt(int64(k)), c.num)
+	index, remainder := new(big.Int).QuoRem(pos, c.den, new(big.Int))
+	return int(index.Int64()), remainder
+}
+
+// Push adds the next source frame and returns any output frames that can
+// now be produced.
+func (c *Converter) Push(frame *image.YCbCr) ([]*image.YCbCr, error) {
+	if c.current != nil && (frame.Rect.Size() != c.current.Rect.Size() || frame.SubsampleRatio != c.current.SubsampleRatio ||
+		frame.YStride != c.current.YStride || frame.CStride != c.current.CStride) {
+		return nil, fmt.Errorf("frame %d: layout differs from previous frames", c.inputs)
+	}
+
+	c.previous, c.current = c.current, frame
+	c.inputs++
+	return c.drain(false)
+}
+
+// Flush emits the outputs that fall within the duration of the last
+// source frame. No frames may be pushed afterwards.
+func (c *Converter) Flush() ([]*image.YCbCr, error) {
+	return c.drain(true)
+}
+
+func (c *Converter) drain(final bool) ([]*image.YCbCr, error) {
+	var out []*image.YCbCr
+	last := c.inputs - 1
+
+	for c.current != nil {
+		index, remainder := c.position(c.next)
+		if index > last {
+			break
+		}
+
+		// 2*remainder >= den means the next frame is at least as close.
+		twice := new(big.Int).Lsh(remainder, 1)
+		var frame *image.YCbCr
+		var mapping Mapping
+
+		switch {
+		case remainder.Sign() == 0:
+			if index != last && index != last-1 {
+				return nil, fmt.Errorf("source frame %d no longer buffered", index)
+			}
+			frame = c.frameAt(index)
+			mapping.Sources, mapping.Weights = []int{index}, []float64{1}
+		case c.mode == Nearest && twice.Cmp(c.den) < 0:
+			frame = c.frameAt(index)
+			mapping.Sources, mapping.Weights = []int{index}, []float64{1}
+		case index == last && !final:
+			// Needs the frame after the newest one.
+			return out, nil
+		case index == last:
+			// Past the final frame's timestamp but inside its duration.
+			frame = c.current
+			mapping.Sources, mapping.Weights = []int{index}, []float64{1}
+		case c.mode == Nearest:
+			frame = c.current
+			mapping.Sources, mapping.Weights = []int{index + 1}, []float64{1}
+		default:
+			weight, _ := new(big.Rat).SetFrac(remainder, c.den).Float64()
+			frame = blend(c.previous, c.current, weight)
+			mapping.Sources, mapping.Weights = []int{index, index + 1}, []float64{1 - weight, weight}
+			c.report.Blended++
+		}
+
+		for _, source := range mapping.Sources {
+			c.used[source]++
+		}
+		mapping.Output = c.next
+		mapping.Time = c.timestamp(c.next)
+		c.report.Mappings = append(c.report.Mappings, mapping)
+		out = append(out, frame)
+		c.next++
+	}
+
+	return out, nil
+}
+
+func (c *Converter) frameAt(index int) *image.YCbCr {
+	if index == c.inputs-1 {
+		return c.current
+	}
+	return c.previous
+}
+
+// timestamp renders the presentation time of output k in seconds as an
+// exact fraction.
+func (c *Converter) timestamp(k int) string {
+	return new(big.Rat).SetFrac(big.NewInt(int64(k)*c.to.Den), big.NewInt(c.to.Num)).RatString()
+}
+
+// Report returns the mapping used so far. Dropped counts source frames
+// that contributed to no output; Duplicated counts repeated frames in
+// Nearest mode.
+func (c *Converter) Report() Report {
+	report := c.report
+	report.Inputs = c.inputs
+	report.Outputs = c.next
+	report.Dropped, report.Duplicated = 0, 0
+	for i := 0; i < c.inputs; i++ {
+		switch uses := c.used[i]; {
+		case uses == 0:
+			report.Dropped++
+		case uses > 1 && c.mode == Nearest:
+			report.Duplicated += uses - 1
+		}
+	}
+	return report
+}
+
+// blend returns (1-weight)*a + weight*b plane by plane. Both frames must
+// share the same plane layout, which Push enforces.
+func blend(a, b *image.YCbCr, weight float64) *image.YCbCr {
+	dst := &image.YCbCr{
+		Y:              make([]byte, len(a.Y)),
+		Cb:             make([]byte, len(a.Cb)),
+		Cr:             make([]byte, len(a.Cr)),
+		YStride:        a.YStride,
+		CStride:        a.CStride,
+		SubsampleRatio: a.SubsampleRatio,
+		Rect:           a.Rect,
+	}
+	w := int(weight*256 + 0.5)
+	mix := func(dst, x, y []byte) {
+		for i := range dst {
+			dst[i] = uint8((int(x[i])*(256-w) + int(y[i])*w + 128) >> 8)
+		}
+	}
+	mix(dst.Y, a.Y, b.Y)
+	mix(dst.Cb, a.Cb, b.Cb)
+	mix(dst.Cr, a.Cr, b.Cr)
+	return dst
+}
@@ -0,0 +1,153 @@
+package framerate
+
+import (
+	"image"
+	"testing"
+
+	"video-processor/internal/y4m"
+)
+
+func rate(num, den int64) y4m.Rational {
+	return y4m.Rational{Num: num, Den: den}
+}
+
+// newFrame returns a 4x2 4:2:0 frame whose every luma sample is value.
+func newFrame(value uint8) *image.YCbCr {
+	frame := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	for i := range frame.Y {
+		frame.Y[i] = value
+	}
+	for i := range frame.Cb {
+		frame.Cb[i], frame.Cr[i] = 128, 128
+	}
+	return frame
+}
+
+// convert runs n frames with luma 10*i through a converter and returns
+// the luma of each output frame.
+func convert(t *testing.T, from, to y4m.Rational, mode Mode, n int) ([]uint8, Report) {
+	t.Helper()
+
+	c, err := NewConverter(from, to, mode)
+	if err != nil {
+		t.Fatalf("NewConverter() unexpected error: %v", err)
+	}
+
+	var lumas []uint8
+	for i := 0; i < n; i++ {
+		out, err := c.Push(newFrame(uint8(10 * i)))
+		if err != nil {
+			t.Fatalf("Push(%d) unexpected error: %v", i, err)
+		}
+		for _, frame := range out {
+			lumas = append(lumas, frame.Y[0])
+		}
+	}
+	out, err := c.Flush()
+	if err != nil {
+		t.Fatalf("Flush() unexpected error: %v", err)
+	}
+	for _, frame := range out {
+		lumas = append(lumas, frame.Y[0])
+	}
+	return lumas, c.Report()
+}
+
+func TestDuplicate25To30(t *testing.T) {
+	lumas, report := convert(t, rate(25, 1), rate(30, 1), Nearest, 5)
+
+	// Output k sits at source position 5k/6: 0, .83, 1.67, 2.5, 3.33, 4.17.
+	want := []uint8{0, 10, 20, 30, 30, 40}
+	if len(lumas) != len(want) {
+		t.Fatalf("got %d outputs %v, want %v", len(lumas), lumas, want)
+	}
+	for i := range want {
+		if lumas[i] != want[i] {
+			t.Errorf("output %d = %d, want %d (all: %v)", i, lumas[i], want[i], lumas)
+		}
+	}
+	if report.Inputs != 5 || report.Outputs != 6 || report.Duplicated != 1 || report.Dropped != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.Mappings[3].Time != "1/10" || report.Mappings[3].Sources[0] != 3 {
+		t.Errorf("mapping 3 = %+v", report.Mappings[3])
+	}
+}
+
+func TestDrop30To25(t *testing.T) {
+	lumas, report := convert(t, rate(30, 1), rate(25, 1), Nearest, 6)
+
+	// Output k sits at source position 6k/5: 0, 1.2, 2.4, 3.6, 4.8.
+	want := []uint8{0, 10, 20, 40, 50}
+	if len(lumas) != len(want) {
+		t.Fatalf("got %d outputs %v, want %v", len(lumas), lumas, want)
+	}
+	for i := range want {
+		if lumas[i] != want[i] {
+			t.Errorf("output %d = %d, want %d", i, lumas[i], want[i])
+		}
+	}
+	if report.Dropped != 1 || report.Duplicated != 0 {
+		t.Errorf("report dropped=%d duplicated=%d, want 1 and 0", report.Dropped, report.Duplicated)
+	}
+}
+
+func TestBlend25To30(t *testing.T) {
+	lumas, report := convert(t, rate(25, 1), rate(30, 1), Blend, 5)
+
+	// Positions 0, 5/6, 5/3, 5/2, 10/3 blend neighbours; 25/6 lies past the
+	// last frame and holds it.
+	want := []uint8{0, 8, 17, 25, 33, 40}
+	if len(lumas) != len(want) {
+		t.Fatalf("got %d outputs %v, want %v", len(lumas), lumas, want)
+	}
+	for i := range want {
+		if lumas[i] != want[i] {
+			t.Errorf("output %d = %d, want %d", i, lumas[i], want[i])
+		}
+	}
+	if report.Blended != 4 {
+		t.Errorf("Blended = %d, want 4", report.Blended)
+	}
+	m := report.Mappings[1]
+	if len(m.Sources) != 2 || m.Sources[0] != 0 || m.Sources[1] != 1 || m.Weights[1] < 0.83 || m.Weights[1] > 0.84 {
+		t.Errorf("mapping 1 = %+v", m)
+	}
+}
+
+func TestNTSCFilmTo25IsExact(t *testing.T) {
+	c, err := NewConverter(rate(24000, 1001), rate(25, 1), Nearest)
+	if err != nil {
+		t.Fatalf("NewConverter() unexpected error: %v", err)
+	}
+
+	// After 1001 seconds, output 25025 must land exactly on source frame
+	// 24000; floating point frame durations would have drifted by now.
+	index, remainder := c.position(25025)
+	if index != 24000 || remainder.Sign() != 0 {
+		t.Errorf("position(25025) = %d + %v/%v, want exactly 24000", index, remainder, c.den)
+	}
+	if got := c.timestamp(25025); got != "1001" {
+		t.Errorf("timestamp(25025) = %q, want \"1001\"", got)
+	}
+}
+
+func TestPushLayoutMismatch(t *testing.T) {
+	c, _ := NewConverter(rate(25, 1), rate(30, 1), Nearest)
+	if _, err := c.Push(newFrame(0)); err != nil {
+		t.Fatalf("Push() unexpected error: %v", err)
+	}
+	other := image.NewYCbCr(image.Rect(0, 0, 8, 8), image.YCbCrSubsampleRatio420)
+	if _, err := c.Push(other); err == nil {
+		t.Error("Push() expected error for mismatched frame")
+	}
+}
+
+func TestInvalidRates(t *testing.T) {
+	if _, err := NewConverter(rate(0, 1), rate(25, 1), Nearest); err == nil {
+		t.Error("NewConverter() expected error for zero rate")
+	}
+	if _, err := ParseMode("interpolate"); err == nil {
+		t.Error("ParseMode() expected error")
+	}
+}
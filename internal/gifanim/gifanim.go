@@ -0,0 +1,164 @@
+package gifanim
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+
+	"video-processor/internal/filters"
+	"video-processor/internal/quantize"
+	"video-processor/internal/resize"
+)
+
+// Options controls how processed frames are converted back to indexed
+// colour.
+type Options struct {
+	// Quantizer, when set, builds a fresh palette of up to Colors entries
+	// for every frame instead of reusing the source frame's palette.
+	Quantizer quantize.Quantizer
+	Colors    int
+	// Ditherer maps frames onto their palette; Floyd–Steinberg when nil.
+	Ditherer quantize.Ditherer
+}
+
+// Composite renders every frame of g onto the logical screen the way a
+// player would, honouring each frame's disposal method, and returns the
+// full-screen image shown after each frame. Disposal to background clears
+// to transparent, as browsers do.
+func Composite(g *gif.GIF) ([]*image.NRGBA, error) {
+	if g == nil || len(g.Image) == 0 {
+		return nil, errors.New("gif has no frames")
+	}
+
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		screen = g.Image[0].Bounds()
+	}
+
+	canvas := image.NewNRGBA(screen)
+	frames := make([]*image.NRGBA, len(g.Image))
+
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames[i] = cloneNRGBA(canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return frames, nil
+}
+
+// Map composites g, passes each full-screen frame through fn and encodes
+// the results back into an animation with the original delays and loop
+// count. fn must return frames of identical size. Each output frame is
+// re-quantized to the palette of the source frame it came from.
+func Map(g *gif.GIF, fn func(image.Image) (image.Image, error)) (*gif.GIF, error) {
+	return MapWithOptions(g, fn, Options{})
+}
+
+// MapWithOptions is Map with control over palette generation and
+// dithering.
+func MapWithOptions(g *gif.GIF, fn func(image.Image) (image.Image, error), opts Options) (*gif.GIF, error) {
+	ditherer := opts.Ditherer
+	if ditherer == nil {
+		ditherer = quantize.FloydSteinberg{}
+	}
+
+	frames, err := Composite(g)
+	if err != nil {
+		return nil, err
+	}
+
+	processed := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		processed[i], err = fn(frame)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		if processed[i].Bounds().Size() != processed[0].Bounds().Size() {
+			return nil, fmt.Errorf("frame %d: size %v differs from first frame %v", i, processed[i].Bounds().Size(), processed[0].Bounds().Size())
+		}
+	}
+
+	size := processed[0].Bounds().Size()
+	out := &gif.GIF{
+		Image:           make([]*image.Paletted, len(processed)),
+		Delay:           make([]int, len(processed)),
+		Disposal:        make([]byte, len(processed)),
+		LoopCount:       g.LoopCount,
+		BackgroundIndex: g.BackgroundIndex,
+		Config: image.Config{
+			ColorModel: g.Config.ColorModel,
+			Width:      size.X,
+			Height:     size.Y,
+		},
+	}
+
+	transparent := make([]bool, len(processed))
+	for i, frame := range processed {
+		transparent[i] = quantize.HasTransparency(frame)
+
+		if opts.Quantizer != nil {
+			out.Image[i], err = quantize.ToPaletted(frame, opts.Colors, opts.Quantizer, ditherer)
+			if err != nil {
+				return nil, fmt.Errorf("frame %d: %w", i, err)
+			}
+		} else {
+			palette := g.Image[i].Palette
+			if len(palette) == 0 {
+				if global, ok := g.Config.ColorModel.(color.Palette); ok {
+					palette = global
+				}
+			}
+			out.Image[i] = quantize.Remap(frame, palette, ditherer)
+		}
+
+		if i < len(g.Delay) {
+			out.Delay[i] = g.Delay[i]
+		}
+	}
+
+	// Every output frame covers the whole screen, so a frame only needs to
+	// be cleared when the one after it has holes the old pixels would show
+	// through.
+	for i := range out.Disposal {
+		out.Disposal[i] = gif.DisposalNone
+		if transparent[(i+1)%len(transparent)] {
+			out.Disposal[i] = gif.DisposalBackground
+		}
+	}
+
+	return out, nil
+}
+
+// Resize scales every frame of an animated GIF to width x height with the
+// given filter.
+func Resize(g *gif.GIF, width, height int, filter filters.Resampler) (*gif.GIF, error) {
+	return Map(g, func(frame image.Image) (image.Image, error) {
+		return resize.ResizeWithFilter(frame, width, height, filter)
+	})
+}
+
+func cloneNRGBA(src *image.NRGBA) *image.NRGBA {
+	dst := image.NewNRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
@@ -0,0 +1,187 @@
+package gifanim
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"video-processor/internal/filters"
+	"video-processor/internal/quantize"
+)
+
+var testPalette = color.Palette{
+	color.RGBA{},
+	color.RGBA{R: 255, A: 255},
+	color.RGBA{G: 255, A: 255},
+	color.RGBA{B: 255, A: 255},
+	color.RGBA{R: 255, G: 255, B: 255, A: 255},
+}
+
+func filledFrame(rect image.Rectangle, index uint8) *image.Paletted {
+	frame := image.NewPaletted(rect, testPalette)
+	for i := range frame.Pix {
+		frame.Pix[i] = index
+	}
+	return frame
+}
+
+// newTestGIF builds a 3-frame 8x8 animation:
+//
+//	frame 0: full red background, disposal none
+//	frame 1: green 4x4 square in the top-left, disposal previous
+//	frame 2: blue 4x4 square in the bottom-right, disposal background
+func newTestGIF() *gif.GIF {
+	return &gif.GIF{
+		Image: []*image.Paletted{
+			filledFrame(image.Rect(0, 0, 8, 8), 1),
+			filledFrame(image.Rect(0, 0, 4, 4), 2),
+			filledFrame(image.Rect(4, 4, 8, 8), 3),
+		},
+		Delay:     []int{10, 20, 30},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalBackground},
+		LoopCount: 3,
+		Config:    image.Config{ColorModel: testPalette, Width: 8, Height: 8},
+	}
+}
+
+func TestComposite(t *testing.T) {
+	frames, err := Composite(newTestGIF())
+	if err != nil {
+		t.Fatalf("Composite() unexpected error: %v", err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("Composite() returned %d frames, want 3", len(frames))
+	}
+
+	red := color.NRGBA{R: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	checks := []struct {
+		frame int
+		x, y  int
+		want  color.NRGBA
+	}{
+		{0, 1, 1, red},
+		{1, 1, 1, green},
+		{1, 6, 6, red},
+		// Frame 1 is disposed to previous, so its green square is gone.
+		{2, 1, 1, red},
+		{2, 6, 6, blue},
+	}
+	for _, c := range checks {
+		if got := frames[c.frame].NRGBAAt(c.x, c.y); got != c.want {
+			t.Errorf("frame %d pixel (%d,%d) = %v, want %v", c.frame, c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestCompositeDisposalBackground(t *testing.T) {
+	g := newTestGIF()
+	g.Image = append(g.Image, filledFrame(image.Rect(0, 0, 2, 2), 4))
+	g.Delay = append(g.Delay, 40)
+	g.Disposal = append(g.Disposal, gif.DisposalNone)
+
+	frames, err := Composite(g)
+	if err != nil {
+		t.Fatalf("Composite() unexpected error: %v", err)
+	}
+
+	// Frame 2 cleared the bottom-right square back to transparent.
+	if got := frames[3].NRGBAAt(6, 6); got.A != 0 {
+		t.Errorf("disposed area = %v, want transparent", got)
+	}
+	if got := frames[3].NRGBAAt(0, 0); got != (color.NRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("new frame pixel = %v, want white", got)
+	}
+}
+
+func TestResize(t *testing.T) {
+	g := newTestGIF()
+	g.Image = append(g.Image, filledFrame(image.Rect(0, 0, 2, 2), 4))
+	g.Delay = append(g.Delay, 40)
+	g.Disposal = append(g.Disposal, gif.DisposalNone)
+
+	resized, err := Resize(g, 16, 12, filters.NewTriangle())
+	if err != nil {
+		t.Fatalf("Resize() unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, resized); err != nil {
+		t.Fatalf("EncodeAll() unexpected error: %v", err)
+	}
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAll() unexpected error: %v", err)
+	}
+
+	if len(decoded.Image) != 4 {
+		t.Fatalf("decoded %d frames, want 4", len(decoded.Image))
+	}
+	if decoded.Config.Width != 16 || decoded.Config.Height != 12 {
+		t.Errorf("decoded screen = %dx%d, want 16x12", decoded.Config.Width, decoded.Config.Height)
+	}
+	if decoded.LoopCount != 3 {
+		t.Errorf("LoopCount = %d, want 3", decoded.LoopCount)
+	}
+	for i, want := range []int{10, 20, 30, 40} {
+		if decoded.Delay[i] != want {
+			t.Errorf("Delay[%d] = %d, want %d", i, decoded.Delay[i], want)
+		}
+	}
+
+	// Frame 3 has a transparent hole, so frame 2 must clear the screen.
+	if decoded.Disposal[2] != gif.DisposalBackground {
+		t.Errorf("Disposal[2] = %d, want DisposalBackground", decoded.Disposal[2])
+	}
+
+	frames, err := Composite(decoded)
+	if err != nil {
+		t.Fatalf("Composite() unexpected error: %v", err)
+	}
+	if got := frames[3].NRGBAAt(13, 10); got.A != 0 {
+		t.Errorf("transparent area after round trip = %v, want transparent", got)
+	}
+	if got := frames[0].NRGBAAt(8, 6); got != (color.NRGBA{R: 255, A: 255}) {
+		t.Errorf("frame 0 centre = %v, want red", got)
+	}
+}
+
+func TestMapSizeMismatch(t *testing.T) {
+	calls := 0
+	_, err := Map(newTestGIF(), func(frame image.Image) (image.Image, error) {
+		calls++
+		return image.NewNRGBA(image.Rect(0, 0, calls, calls)), nil
+	})
+	if err == nil {
+		t.Error("Map() expected error for frames of different sizes")
+	}
+}
+
+func TestCompositeEmpty(t *testing.T) {
+	if _, err := Composite(&gif.GIF{}); err == nil {
+		t.Error("Composite() expected error for empty gif")
+	}
+}
+
+func TestMapWithOptionsQuantizer(t *testing.T) {
+	opts := Options{Quantizer: quantize.MedianCut{}, Colors: 4, Ditherer: quantize.None{}}
+	result, err := MapWithOptions(newTestGIF(), func(frame image.Image) (image.Image, error) {
+		return frame, nil
+	}, opts)
+	if err != nil {
+		t.Fatalf("MapWithOptions() unexpected error: %v", err)
+	}
+
+	for i, frame := range result.Image {
+		if len(frame.Palette) > 4 {
+			t.Errorf("frame %d palette has %d colours, want at most 4", i, len(frame.Palette))
+		}
+	}
+	if got := color.NRGBAModel.Convert(result.Image[1].At(1, 1)).(color.NRGBA); got != (color.NRGBA{G: 255, A: 255}) {
+		t.Errorf("frame 1 pixel = %v, want green", got)
+	}
+}
@@ -0,0 +1,110 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+
+	"video-processor/internal/orient"
+	"video-processor/internal/subsample"
+)
+
+// Stage is one node of a filter graph. Apply receives a frame and returns
+// the transformed frame; stages must not modify their input.
+type Stage interface {
+	Apply(frame image.Image) (image.Image, error)
+}
+
+// Graph is a linear chain of stages applied in order. A Graph is itself a
+// Stage, so graphs can be nested.
+type Graph struct {
+	Stages []Stage
+}
+
+func New(stages ...Stage) *Graph {
+	return &Graph{Stages: stages}
+}
+
+func (g *Graph) Append(stages ...Stage) {
+	g.Stages = append(g.Stages, stages...)
+}
+
+func (g *Graph) Apply(frame image.Image) (image.Image, error) {
+	if frame == nil {
+		return nil, errors.New("frame is nil")
+	}
+
+	for i, stage := range g.Stages {
+		next, err := stage.Apply(frame)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d (%s): %w", i, describe(stage), err)
+		}
+		frame = next
+	}
+
+	return frame, nil
+}
+
+// FieldSafe reports whether stage can run on a woven interlaced frame
+// without mixing its two fields: Scale once Interlaced is set, Format
+// unless it subsamples chroma vertically without Interlaced, the per-pixel
+// colour stages, horizontal flips, and crops and pads that move the picture
+// by an even number of rows. Stages that filter or move pixels across rows
+// are not.
+func FieldSafe(stage Stage) bool {
+	switch s := stage.(type) {
+	case *Graph:
+		for _, inner := range s.Stages {
+			if !FieldSafe(inner) {
+				return false
+			}
+		}
+		return true
+	case *Scale:
+		return s.Interlaced
+	case *Crop:
+		return s.Y >= 0 && s.Y%2 == 0
+	case *Pad:
+		return s.Y >= 0 && s.Y%2 == 0
+	case *Orient:
+		return s.Op == orient.None || s.Op == orient.FlipHorizontal
+	case *Format:
+		ratio, ok := subsampleRatios[s.Name]
+		_, fy := subsample.Factors(ratio)
+		return s.Interlaced || !ok || fy == 1
+	case *Primaries, *ICC, *Linearize, *Tonemap, *Flatten:
+		return true
+	}
+	return false
+}
+
+// String renders the graph back into -vf syntax.
+func (g *Graph) String() string {
+	parts := make([]string, len(g.Stages))
+	for i, stage := range g.Stages {
+		parts[i] = describe(stage)
+	}
+	return strings.Join(parts, ",")
+}
+
+func describe(stage Stage) string {
+	if s, ok := stage.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", stage)
+}
+
+// toNRGBA returns img as a tightly packed *image.NRGBA anchored at the
+// origin, copying only when necessary.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) && nrgba.Stride == 4*nrgba.Rect.Dx() {
+		return nrgba
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,762 @@
+package graph
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/hdr"
+	"video-processor/internal/icc"
+	"video-processor/internal/pfm"
+	"video-processor/internal/rgbe"
+)
+
+func newTestImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 5), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		expr      string
+		wantError bool
+		want      string
+	}{
+		{
+			name: "crop scale pad chain",
+			expr: "crop=1920:800:0:140,scale=1280:-2:lanczos,pad=1280:720:-1:-1:black",
+			want: "crop=1920:800:0:140,scale=1280:-2:lanczos,pad=1280:720:-1:-1:0x000000FF",
+		},
+		{
+			name: "named parameters",
+			expr: "scale=h=360:w=-1:flags=bicubic",
+			want: "scale=-1:360:bicubic",
+		},
+		{
+			name: "interlaced scale",
+			expr: "scale=720:288:interl=1",
+			want: "scale=720:288:lanczos:1",
+		},
+		{
+			name: "format and sharpen",
+			expr: "format=gray,unsharp=3:3:0.5",
+			want: "format=gray,unsharp=3:3:0.5",
+		},
+		{
+			name: "format with chroma siting",
+			expr: "format=yuv420p:chroma_loc=left",
+			want: "format=yuv420p:left",
+		},
+		{
+			name: "interlaced format",
+			expr: "format=yuv420p:interl=1",
+			want: "format=yuv420p:center:1",
+		},
+		{
+			name: "primaries",
+			expr: "primaries=displayp3:srgb:gamut=compress",
+			want: "primaries=displayp3:srgb:compress",
+		},
+		{
+			name: "tonemap defaults",
+			expr: "tonemap",
+			want: "tonemap=bt2390:pq:0:203:rec2020:srgb:clip",
+		},
+		{
+			name: "linear hdr scaling",
+			expr: "linearize=hlg:peak=1000,scale=640:-2,tonemap=hable:hlg:out=rec709:out_transfer=bt1886",
+			want: "linearize=hlg:1000,scale=640:-2:lanczos,tonemap=hable:hlg:0:203:rec2020:rec709:clip:bt1886",
+		},
+		{
+			name: "flatten",
+			expr: "flatten=black,flatten=color=gray:checker=8",
+			want: "flatten=0x000000,flatten=0x808080:8:0xFFFFFF",
+		},
+		{
+			name: "rotate defaults",
+			expr: "rotate=30",
+			want: "rotate=30:0x00000000:crop:lanczos",
+		},
+		{
+			name: "rotate and shear",
+			expr: "rotate=angle=-12.5:fill=white:bounds=expand:flags=bicubic,shear=0.2",
+			want: "rotate=-12.5:0xFFFFFFFF:expand:bicubic,shear=0.2:0:0x00000000:crop:lanczos",
+		},
+		{
+			name: "orient",
+			expr: "orient=rotate-90,orient=op=Flip-Horizontal",
+			want: "orient=rotate-90,orient=flip-horizontal",
+		},
+		{
+			name: "crop defaults to centre",
+			expr: "crop=10:10",
+			want: "crop=10:10:-1:-1",
+		},
+		{name: "empty", expr: "", wantError: true},
+		{name: "unknown filter", expr: "blur=3", wantError: true},
+		{name: "unknown parameter", expr: "scale=w=10:h=10:q=1", wantError: true},
+		{name: "too many parameters", expr: "crop=1:2:3:4:5", wantError: true},
+		{name: "missing size", expr: "scale=100", wantError: true},
+		{name: "bad integer", expr: "scale=ten:10", wantError: true},
+		{name: "bad scaler", expr: "scale=10:10:sparkle", wantError: true},
+		{name: "bad colour", expr: "pad=10:10:0:0:mauve", wantError: true},
+		{name: "bad pixel format", expr: "format=nv12", wantError: true},
+		{name: "bad chroma siting", expr: "format=yuv420p:middle", wantError: true},
+		{name: "missing output primaries", expr: "primaries=rec2020", wantError: true},
+		{name: "bad gamut mapping", expr: "primaries=rec2020:srgb:squash", wantError: true},
+		{name: "bad transfer", expr: "linearize=slog3", wantError: true},
+		{name: "bad tone mapping operator", expr: "tonemap=aces", wantError: true},
+		{name: "negative peak", expr: "tonemap=hable:pq:-1", wantError: true},
+		{name: "translucent flatten", expr: "flatten=black@0.5", wantError: true},
+		{name: "negative checker", expr: "flatten=white:-4", wantError: true},
+		{name: "missing angle", expr: "rotate", wantError: true},
+		{name: "bad angle", expr: "rotate=ninety", wantError: true},
+		{name: "bad bounds", expr: "rotate=10:bounds=fit", wantError: true},
+		{name: "bad fill", expr: "shear=0.1:0:mauve", wantError: true},
+		{name: "missing orientation", expr: "orient", wantError: true},
+		{name: "bad orientation", expr: "orient=sideways", wantError: true},
+		{name: "missing overlay", expr: "overlay=does-not-exist.png", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Parse(tt.expr)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Parse(%q) expected error, got %v", tt.expr, g)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) unexpected error: %v", tt.expr, err)
+			}
+			if got := g.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %q, want %q", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphApply(t *testing.T) {
+	g, err := Parse("crop=80:40:10:20,scale=40:-2:bilinear,pad=48:24:-1:-1:white")
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+
+	result, err := g.Apply(newTestImage(100, 100))
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+
+	bounds := result.Bounds()
+	if bounds.Dx() != 48 || bounds.Dy() != 24 {
+		t.Fatalf("Apply() result dimensions = %dx%d, want 48x24", bounds.Dx(), bounds.Dy())
+	}
+
+	// The 40x20 scaled frame is centred, leaving a 4px white border left and right.
+	r, g8, b, _ := result.At(1, 12).RGBA()
+	if r != 0xFFFF || g8 != 0xFFFF || b != 0xFFFF {
+		t.Errorf("padding pixel = %x,%x,%x, want white", r, g8, b)
+	}
+	if _, _, b, _ := result.At(24, 12).RGBA(); b>>8 != 128 {
+		t.Errorf("frame pixel blue = %d, want 128", b>>8)
+	}
+}
+
+func TestGraphApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		stage Stage
+	}{
+		{name: "crop too large", stage: &Crop{Width: 20, Height: 5, X: 0, Y: 0}},
+		{name: "crop out of bounds", stage: &Crop{Width: 5, Height: 5, X: 8, Y: 0}},
+		{name: "pad smaller than input", stage: &Pad{Width: 5, Height: 5}},
+		{name: "even unsharp size", stage: &Unsharp{SizeX: 4, SizeY: 3, Amount: 1}},
+		{name: "overlay without image", stage: &Overlay{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.stage).Apply(newTestImage(10, 10)); err == nil {
+				t.Errorf("Apply() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestScaledSize(t *testing.T) {
+	tests := []struct {
+		srcWidth, srcHeight int
+		width, height       int
+		wantW, wantH        int
+	}{
+		{1920, 800, 1280, -2, 1280, 534},
+		{1920, 800, 1280, -1, 1280, 533},
+		{1920, 1080, -2, 720, 1280, 720},
+		{1920, 1080, -1, -1, 1920, 1080},
+		{3, 1000, -2, 10, 2, 10},
+	}
+
+	for _, tt := range tests {
+		w, h, err := scaledSize(tt.srcWidth, tt.srcHeight, tt.width, tt.height)
+		if err != nil {
+			t.Errorf("scaledSize(%d, %d, %d, %d) unexpected error: %v", tt.srcWidth, tt.srcHeight, tt.width, tt.height, err)
+			continue
+		}
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("scaledSize(%d, %d, %d, %d) = %dx%d, want %dx%d",
+				tt.srcWidth, tt.srcHeight, tt.width, tt.height, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	src := newTestImage(5, 3)
+	for name, want := range map[string]string{
+		"gray":    "*image.Gray",
+		"gray16":  "*image.Gray16",
+		"rgba64":  "*image.NRGBA64",
+		"yuv420p": "*image.YCbCr",
+	} {
+		result, err := (&Format{Name: name}).Apply(src)
+		if err != nil {
+			t.Errorf("format=%s unexpected error: %v", name, err)
+			continue
+		}
+		if got := typeName(result); got != want {
+			t.Errorf("format=%s produced %s, want %s", name, got, want)
+		}
+		if result.Bounds().Dx() != 5 || result.Bounds().Dy() != 3 {
+			t.Errorf("format=%s changed dimensions to %v", name, result.Bounds())
+		}
+	}
+}
+
+func typeName(img image.Image) string {
+	switch img.(type) {
+	case *image.Gray:
+		return "*image.Gray"
+	case *image.Gray16:
+		return "*image.Gray16"
+	case *image.NRGBA64:
+		return "*image.NRGBA64"
+	case *image.YCbCr:
+		return "*image.YCbCr"
+	}
+	return "other"
+}
+
+func TestUnsharpFlatImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range src.Pix {
+		src.Pix[i] = 100
+	}
+
+	result, err := (&Unsharp{SizeX: 5, SizeY: 5, Amount: 2}).Apply(src)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	for i, v := range result.(*image.NRGBA).Pix {
+		if v != 100 {
+			t.Fatalf("flat image changed at byte %d: %d", i, v)
+		}
+	}
+}
+
+func TestPrimaries(t *testing.T) {
+	stage, err := Parse("primaries=srgb:displayp3")
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 128})
+	src.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 255})
+
+	result, err := stage.Apply(src)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	// sRGB red is (234, 51, 35) in Display P3; white stays white.
+	if c := result.At(0, 0).(color.NRGBA); c != (color.NRGBA{234, 51, 35, 128}) {
+		t.Errorf("red = %v, want {234 51 35 128}", c)
+	}
+	if c := result.At(1, 0).(color.NRGBA); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("white = %v", c)
+	}
+
+	// Greys a sixteenth of an 8-bit step apart stay apart at 16 bits:
+	// sRGB and Display P3 share white point and transfer, so they come back
+	// where they were.
+	deep := image.NewNRGBA64(image.Rect(0, 0, 4, 1))
+	for x := 0; x < 4; x++ {
+		v := uint16(0x8000 + 16*x)
+		deep.SetNRGBA64(x, 0, color.NRGBA64{v, v, v, 0x8001})
+	}
+	result, err = stage.Apply(deep)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	got, ok := result.(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("Apply() of a 16-bit frame returned %T", result)
+	}
+	for x := 0; x < 4; x++ {
+		c, want := got.NRGBA64At(x, 0), 0x8000+16*x
+		for _, v := range []uint16{c.R, c.G, c.B} {
+			if d := int(v) - want; d < -2 || d > 2 {
+				t.Errorf("grey %d = %v, want %#x", x, c, want)
+			}
+		}
+		if c.A != 0x8001 {
+			t.Errorf("grey %d alpha = %#x, want 0x8001", x, c.A)
+		}
+	}
+}
+
+func TestICC(t *testing.T) {
+	p3, err := colorspace.RGBSpaceByName("displayp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := icc.Encode(p3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := icc.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 40, 30, 128})
+	src.SetNRGBA(1, 0, color.NRGBA{128, 128, 128, 255})
+	g := New(&ICC{Profile: profile}, &Scale{Width: 4, Height: 2, Filter: filters.NewTriangle()}, &Format{Name: "rgba"})
+	result, err := g.Apply(src)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	if b := result.Bounds(); b.Dx() != 4 || b.Dy() != 2 {
+		t.Fatalf("result is %dx%d, want 4x2", b.Dx(), b.Dy())
+	}
+
+	// Display P3 (200, 40, 30) is a more saturated red than sRGB can
+	// show; grey is the same in both.
+	linear, err := New(&ICC{Profile: profile}).Apply(src)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	got := color.NRGBAModel.Convert(linear.At(0, 0)).(color.NRGBA)
+	if got.R < 217 || got.R > 220 || got.G != 0 || got.B > 6 || got.A != 128 {
+		t.Errorf("red = %v, want about {218 0 4 128}", got)
+	}
+	if got := color.NRGBAModel.Convert(linear.At(1, 0)).(color.NRGBA); got != (color.NRGBA{128, 128, 128, 255}) {
+		t.Errorf("grey = %v, want {128 128 128 255}", got)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	src := newTestImage(4, 2)
+	g, err := Parse("rotate=90:bounds=expand")
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	result, err := g.Apply(src)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	if b := result.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("result is %dx%d, want 2x4", b.Dx(), b.Dy())
+	}
+	// The top left corner turns to the top right.
+	if got, want := result.At(1, 0), src.At(0, 0); got != want {
+		t.Errorf("top right = %v, want %v", got, want)
+	}
+
+	// Linear-light frames stay linear.
+	g, err = Parse("linearize=pq,shear=0.5:0:bounds=expand")
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if result, err = g.Apply(src); err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	if _, ok := result.(*hdr.Image); !ok || result.Bounds().Dx() != 5 {
+		t.Errorf("sheared linear frame is %T of %v, want a 5x2 *hdr.Image", result, result.Bounds().Size())
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio422)
+	for i := range src.Y {
+		src.Y[i] = uint8(40 * i)
+	}
+	g, err := Parse("orient=rotate-270")
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	result, err := g.Apply(src)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	turned, ok := result.(*image.YCbCr)
+	if !ok || turned.Rect.Dx() != 2 || turned.Rect.Dy() != 4 {
+		t.Fatalf("result is %T of %v, want a 2x4 *image.YCbCr", result, result.Bounds().Size())
+	}
+	// The top right corner turns to the top left.
+	if got, want := turned.At(0, 0), src.At(3, 0); got != want {
+		t.Errorf("top left = %v, want %v", got, want)
+	}
+}
+
+func TestFlattenKeepsDepth(t *testing.T) {
+	// A transparent HDR frame with a highlight at 8x SDR white, as a
+	// transparent fill leaves it, must keep the highlight through the
+	// float encoders, which have no alpha.
+	src := hdr.NewImage(image.Rect(0, 0, 3, 1), 1624)
+	copy(src.Pix, []float32{
+		8 * hdr.SDRWhite, 2 * hdr.SDRWhite, 0, 1,
+		8 * hdr.SDRWhite, 0, 0, 0.5,
+		100, 100, 100, 0,
+	})
+	flat, err := (&Flatten{}).Apply(src)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	want := []float32{8, 2, 0, 4.5, 0.5, 0.5, 1, 1, 1}
+	for name, codec := range map[string]struct {
+		encode func(io.Writer, image.Image) error
+		decode func(io.Reader) (image.Image, error)
+	}{
+		"pfm":  {pfm.Encode, pfm.Decode},
+		"rgbe": {rgbe.Encode, rgbe.Decode},
+	} {
+		var buf bytes.Buffer
+		if err := codec.encode(&buf, flat); err != nil {
+			t.Fatalf("%s: Encode() unexpected error: %v", name, err)
+		}
+		decoded, err := codec.decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: Decode() unexpected error: %v", name, err)
+		}
+		linear := decoded.(*hdr.Image)
+		for x := 0; x < 3; x++ {
+			p := linear.Pix[linear.PixOffset(x, 0):]
+			// RGBE shares an 8-bit mantissa scale across the channels
+			tolerance := float64(max(want[3*x], want[3*x+1], want[3*x+2])) / 64
+			for c := 0; c < 3; c++ {
+				if got := p[c] / hdr.SDRWhite; math.Abs(float64(got-want[3*x+c])) > tolerance {
+					t.Errorf("%s: pixel %d channel %d = %.3f, want %.3f", name, x, c, got, want[3*x+c])
+				}
+			}
+		}
+	}
+
+	// 16-bit frames keep 16 bits.
+	deep := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	deep.SetNRGBA64(0, 0, color.NRGBA64{0x1234, 0x5678, 0x9ABC, 0xFFFF})
+	deep16, err := (&Flatten{}).Apply(deep)
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	if got, ok := deep16.(*image.NRGBA64); !ok || got.NRGBA64At(0, 0) != (color.NRGBA64{0x1234, 0x5678, 0x9ABC, 0xFFFF}) {
+		t.Errorf("16-bit frame flattened to %T %v", deep16, deep16.At(0, 0))
+	}
+}
+
+func TestFieldSafe(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"crop=8:4:1:2,pad=10:10:0:4,format=rgba", true},
+		{"orient=flip-horizontal,flatten=white", true},
+		{"format=yuv422p", true},
+		{"format=yuv420p:interl=1", true},
+		{"format=yuv420p", false},
+		{"format=yuv440p:left", false},
+		{"crop=8:4:0:1", false},
+		{"crop=8:4", false},
+		{"pad=10:10:0:3", false},
+		{"orient=rotate-90", false},
+		{"orient=flip-vertical", false},
+		{"unsharp", false},
+		{"rotate=5", false},
+		{"shear=0.1", false},
+	}
+	for _, tt := range tests {
+		g, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) unexpected error: %v", tt.expr, err)
+		}
+		if got := FieldSafe(g); got != tt.want {
+			t.Errorf("FieldSafe(%s) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	scale := &Scale{Width: 8, Height: 8}
+	if FieldSafe(scale) {
+		t.Error("FieldSafe() of a progressive scale = true")
+	}
+	scale.Interlaced = true
+	if !FieldSafe(scale) {
+		t.Error("FieldSafe() of an interlaced scale = false")
+	}
+}
+
+func TestTonemap(t *testing.T) {
+	// A PQ ramp from black to 10000 cd/m², as a 16-bit PNG would decode.
+	src := image.NewNRGBA64(image.Rect(0, 0, 64, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint16(x * 65535 / 63)
+			src.SetNRGBA64(x, y, color.NRGBA64{R: v, G: v, B: v, A: 0xFFFF})
+		}
+	}
+
+	before, err := Parse("tonemap=hable:peak=10000,scale=32:2:bilinear")
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	after, err := Parse("linearize=pq:10000,scale=32:2:bilinear,tonemap=hable")
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	for _, g := range []*Graph{before, after} {
+		result, err := g.Apply(src)
+		if err != nil {
+			t.Fatalf("%s: Apply() unexpected error: %v", g, err)
+		}
+		if b := result.Bounds(); b.Dx() != 32 || b.Dy() != 2 {
+			t.Fatalf("%s: result is %dx%d, want 32x2", g, b.Dx(), b.Dy())
+		}
+		// The brightest input lands on SDR white instead of clipping long
+		// before it, and the ramp keeps rising.
+		previous := uint32(0)
+		for x := 0; x < 32; x++ {
+			r, g8, b, _ := result.At(x, 0).RGBA()
+			if r != g8 || g8 != b {
+				t.Fatalf("%s: grey ramp turned coloured at x=%d: %d,%d,%d", g, x, r, g8, b)
+			}
+			if r < previous {
+				t.Errorf("%s: ramp falls at x=%d: %d after %d", g, x, r, previous)
+			}
+			previous = r
+		}
+		if previous < 0xF000 {
+			t.Errorf("%s: brightest output = %#x, want close to white", g, previous)
+		}
+		if r, _, _, _ := result.At(24, 0).RGBA(); r >= 0xFF00 {
+			t.Errorf("%s: highlights clipped: x=24 is %#x", g, r)
+		}
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	logo := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := range logo.Pix {
+		logo.Pix[i] = 255
+	}
+
+	result, err := (&Overlay{Image: logo, X: "-1", Y: "-1"}).Apply(newTestImage(10, 10))
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	if c := result.At(7, 7).(color.NRGBA); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("overlay pixel = %v, want white", c)
+	}
+	if c := result.At(9, 9).(color.NRGBA); c.R == 255 && c.G == 255 {
+		t.Errorf("pixel outside overlay was overwritten: %v", c)
+	}
+
+	// The 2x2 logo's top-left corner on a 10x10 frame, at every edge.
+	tests := []struct {
+		x, y Offset
+		at   image.Point
+	}{
+		{"", "", image.Pt(0, 0)},
+		{"0", "0", image.Pt(0, 0)},
+		{"W-w", "H-h", image.Pt(8, 8)},
+		{"main_w-overlay_w", "0", image.Pt(8, 0)},
+		{"0", "main_h - overlay_h", image.Pt(0, 8)},
+		{"W-w-3", "-3", image.Pt(5, 5)},
+		{"(W-w)/2", "(H-h)/2", image.Pt(4, 4)},
+		{"W/3", "-(h-H)*0.5+1", image.Pt(3, 5)},
+	}
+	for _, tt := range tests {
+		result, err := (&Overlay{Image: logo, X: tt.x, Y: tt.y}).Apply(newTestImage(10, 10))
+		if err != nil {
+			t.Fatalf("Apply(%q, %q) unexpected error: %v", tt.x, tt.y, err)
+		}
+		for y := 0; y < 10; y++ {
+			for x := 0; x < 10; x++ {
+				inside := image.Pt(x, y).In(image.Rect(tt.at.X, tt.at.Y, tt.at.X+2, tt.at.Y+2))
+				if white := result.At(x, y) == (color.NRGBA{255, 255, 255, 255}); white != inside {
+					t.Fatalf("Apply(%q, %q): pixel (%d, %d) white = %v, want %v", tt.x, tt.y, x, y, white, inside)
+				}
+			}
+		}
+	}
+
+	for _, bad := range []Offset{"W-", "W-x", "(W-w", "W)", "W/(w-w)"} {
+		if _, err := (&Overlay{Image: logo, X: bad}).Apply(newTestImage(10, 10)); err == nil {
+			t.Errorf("Apply() with x = %q expected error", bad)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	// A transparent PNG, as the flattening usually sees it: the hidden
+	// colour of the clear pixels is red.
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 0})
+		}
+	}
+	src.SetNRGBA(1, 0, color.NRGBA{0, 0, 255, 255})
+	src.SetNRGBA(2, 0, color.NRGBA{0, 0, 0, 128})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	input, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		stage *Flatten
+		want  map[image.Point]color.NRGBA
+	}{
+		{
+			name:  "default white",
+			stage: &Flatten{},
+			want: map[image.Point]color.NRGBA{
+				{0, 0}: {255, 255, 255, 255},
+				{1, 0}: {0, 0, 255, 255},
+				{2, 0}: {127, 127, 127, 255},
+			},
+		},
+		{
+			name:  "colour",
+			stage: &Flatten{Color: color.NRGBA{0, 128, 0, 255}},
+			want: map[image.Point]color.NRGBA{
+				{0, 0}: {0, 128, 0, 255},
+				{3, 3}: {0, 128, 0, 255},
+				{2, 0}: {0, 63, 0, 255},
+			},
+		},
+		{
+			name:  "checkerboard",
+			stage: &Flatten{Color: color.Black, Alt: color.White, Checker: 2},
+			want: map[image.Point]color.NRGBA{
+				{0, 0}: {0, 0, 0, 255},
+				{1, 1}: {0, 0, 0, 255},
+				{2, 1}: {255, 255, 255, 255},
+				{0, 2}: {255, 255, 255, 255},
+				{3, 3}: {0, 0, 0, 255},
+				{1, 0}: {0, 0, 255, 255},
+				{2, 0}: {127, 127, 127, 255},
+			},
+		},
+	}
+	for _, tt := range tests {
+		result, err := tt.stage.Apply(input)
+		if err != nil {
+			t.Fatalf("%s: Apply() unexpected error: %v", tt.name, err)
+		}
+		if o, ok := result.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			t.Errorf("%s: result is not opaque", tt.name)
+		}
+		for p, want := range tt.want {
+			if c := result.At(p.X, p.Y).(color.NRGBA); c != want {
+				t.Errorf("%s: pixel %v = %v, want %v", tt.name, p, c, want)
+			}
+		}
+	}
+
+	if _, err := (&Flatten{Color: color.Transparent}).Apply(input); err == nil {
+		t.Error("Apply() with a transparent background expected error")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		value string
+		want  color.NRGBA
+		err   bool
+	}{
+		{value: "black", want: color.NRGBA{0, 0, 0, 255}},
+		{value: "White", want: color.NRGBA{255, 255, 255, 255}},
+		{value: "#FF8000", want: color.NRGBA{255, 128, 0, 255}},
+		{value: "0x00FF0080", want: color.NRGBA{0, 255, 0, 128}},
+		{value: "black@0.5", want: color.NRGBA{0, 0, 0, 128}},
+		{value: "#FFF", err: true},
+		{value: "black@2", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseColor(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseColor(%q) expected error", tt.value)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseColor(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitEscaped(t *testing.T) {
+	g, err := Parse(`scale=w=10:h=10,pad=w=12:h=12:color=white`)
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if len(g.Stages) != 2 {
+		t.Fatalf("got %d stages, want 2", len(g.Stages))
+	}
+
+	parts := splitEscaped(`overlay=C\:\\logo.png:1:2,crop=1:1`, ',')
+	if len(parts) != 2 {
+		t.Fatalf("splitEscaped() = %q, want 2 parts", parts)
+	}
+	params := splitEscaped(parts[0][len("overlay="):], ':')
+	if len(params) != 3 || unescape(params[0]) != `C:\logo.png` {
+		t.Errorf("escaped path parsed as %q", params)
+	}
+
+	// String escapes the path so Parse reads it back
+	path := filepath.Join(t.TempDir(), `a:b,c=d\e.png`)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, newTestImage(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g = New(&Overlay{Image: newTestImage(2, 2), Path: path, X: "1", Y: "main_h-overlay_h-2"})
+	round, err := Parse(g.String())
+	if err != nil {
+		t.Fatalf("Parse(%q) unexpected error: %v", g, err)
+	}
+	if overlay, ok := round.Stages[0].(*Overlay); !ok || overlay.Path != path || overlay.X != "1" || overlay.Y != "main_h-overlay_h-2" {
+		t.Errorf("Parse(%q) = %v, want the overlay back", g, round)
+	}
+}
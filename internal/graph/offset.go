@@ -0,0 +1,151 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Offset is an overlay coordinate: an arithmetic expression with + - * /
+// and parentheses over the frame size W and H (also main_w and main_h) and
+// the overlay size w and h (overlay_w and overlay_h), as in ffmpeg's
+// overlay filter. W-w puts the overlay flush against the right edge and
+// (H-h)/2 centres it vertically. A plain negative number n is shorthand for
+// W-w+n or H-h+n, counting in from the right or bottom edge. The empty
+// Offset is 0.
+type Offset string
+
+// resolve evaluates o along one axis, where the frame and overlay measure
+// frame and overlay pixels, rounding down to whole pixels.
+func (o Offset) resolve(frame, overlay int, sizes map[string]float64) (int, error) {
+	if n, err := strconv.Atoi(string(o)); err == nil {
+		if n < 0 {
+			return frame - overlay + n, nil
+		}
+		return n, nil
+	}
+	v, err := o.eval(sizes)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, fmt.Errorf("invalid offset %q: division by zero", string(o))
+	}
+	return int(math.Floor(v)), nil
+}
+
+// eval evaluates o as an expression.
+func (o Offset) eval(sizes map[string]float64) (float64, error) {
+	if o == "" {
+		return 0, nil
+	}
+	p := offsetParser{s: strings.ReplaceAll(string(o), " ", ""), vars: sizes}
+	v, err := p.sum()
+	if err == nil && p.pos < len(p.s) {
+		err = fmt.Errorf("unexpected %q", p.s[p.pos:])
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %w", string(o), err)
+	}
+	return v, nil
+}
+
+// offsetSizes returns the variables an Offset may use.
+func offsetSizes(frameW, frameH, overlayW, overlayH int) map[string]float64 {
+	return map[string]float64{
+		"W": float64(frameW), "main_w": float64(frameW),
+		"H": float64(frameH), "main_h": float64(frameH),
+		"w": float64(overlayW), "overlay_w": float64(overlayW),
+		"h": float64(overlayH), "overlay_h": float64(overlayH),
+	}
+}
+
+// offsetParser is a recursive descent parser that evaluates as it reads.
+type offsetParser struct {
+	s    string
+	pos  int
+	vars map[string]float64
+}
+
+func (p *offsetParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+// sum reads terms joined by + and -.
+func (p *offsetParser) sum() (float64, error) {
+	v, err := p.product()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.peek()
+		p.pos++
+		var t float64
+		if t, err = p.product(); op == '+' {
+			v += t
+		} else {
+			v -= t
+		}
+	}
+	return v, err
+}
+
+// product reads factors joined by * and /.
+func (p *offsetParser) product() (float64, error) {
+	v, err := p.factor()
+	for err == nil && (p.peek() == '*' || p.peek() == '/') {
+		op := p.peek()
+		p.pos++
+		var f float64
+		if f, err = p.factor(); op == '*' {
+			v *= f
+		} else {
+			v /= f
+		}
+	}
+	return v, err
+}
+
+// factor reads a number, a variable, a parenthesised sum or a negated
+// factor.
+func (p *offsetParser) factor() (float64, error) {
+	switch c := p.peek(); {
+	case c == '-':
+		p.pos++
+		v, err := p.factor()
+		return -v, err
+	case c == '(':
+		p.pos++
+		v, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return v, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for c := p.peek(); c >= '0' && c <= '9' || c == '.'; c = p.peek() {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.s[start:p.pos], 64)
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		start := p.pos
+		for c := p.peek(); c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'; c = p.peek() {
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+		v, ok := p.vars[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown variable %q", name)
+		}
+		return v, nil
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end")
+	default:
+		return 0, fmt.Errorf("unexpected %q", p.s[p.pos:])
+	}
+}
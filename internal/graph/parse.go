@@ -0,0 +1,559 @@
+package graph
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"video-processor/internal/affine"
+	"video-processor/internal/chroma"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/hdr"
+	"video-processor/internal/orient"
+)
+
+// definition describes a filter accepted by Parse: the names of its
+// positional parameters, in order, and a constructor receiving the
+// resolved arguments keyed by parameter name.
+type definition struct {
+	params []string
+	build  func(args arguments) (Stage, error)
+}
+
+var definitions = map[string]definition{
+	"crop": {
+		params: []string{"w", "h", "x", "y"},
+		build: func(args arguments) (Stage, error) {
+			crop := &Crop{X: -1, Y: -1}
+			if err := args.ints(map[string]*int{"w": &crop.Width, "h": &crop.Height, "x": &crop.X, "y": &crop.Y}, "w", "h"); err != nil {
+				return nil, err
+			}
+			return crop, nil
+		},
+	},
+	"scale": {
+		params: []string{"w", "h", "flags", "interl"},
+		build: func(args arguments) (Stage, error) {
+			scale := &Scale{FilterName: "lanczos"}
+			interlaced := 0
+			if err := args.ints(map[string]*int{"w": &scale.Width, "h": &scale.Height, "interl": &interlaced}, "w", "h"); err != nil {
+				return nil, err
+			}
+			scale.Interlaced = interlaced != 0
+			if name, ok := args["flags"]; ok {
+				scale.FilterName = name
+			}
+			filter, err := filters.ByName(scale.FilterName)
+			if err != nil {
+				return nil, err
+			}
+			scale.Filter = filter
+			return scale, nil
+		},
+	},
+	"pad": {
+		params: []string{"w", "h", "x", "y", "color"},
+		build: func(args arguments) (Stage, error) {
+			pad := &Pad{Color: color.Black}
+			if err := args.ints(map[string]*int{"w": &pad.Width, "h": &pad.Height, "x": &pad.X, "y": &pad.Y}, "w", "h"); err != nil {
+				return nil, err
+			}
+			if value, ok := args["color"]; ok {
+				c, err := ParseColor(value)
+				if err != nil {
+					return nil, err
+				}
+				pad.Color = c
+			}
+			return pad, nil
+		},
+	},
+	"rotate": {
+		params: []string{"angle", "fill", "bounds", "flags"},
+		build: func(args arguments) (Stage, error) {
+			rotate := &Rotate{}
+			if err := args.signedFloats(map[string]*float64{"angle": &rotate.Angle}, "angle"); err != nil {
+				return nil, err
+			}
+			var err error
+			rotate.Fill, rotate.Bounds, rotate.Filter, rotate.FilterName, err = transformArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			return rotate, nil
+		},
+	},
+	"shear": {
+		params: []string{"shx", "shy", "fill", "bounds", "flags"},
+		build: func(args arguments) (Stage, error) {
+			shear := &Shear{}
+			if err := args.signedFloats(map[string]*float64{"shx": &shear.X, "shy": &shear.Y}); err != nil {
+				return nil, err
+			}
+			var err error
+			shear.Fill, shear.Bounds, shear.Filter, shear.FilterName, err = transformArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			return shear, nil
+		},
+	},
+	"orient": {
+		params: []string{"op"},
+		build: func(args arguments) (Stage, error) {
+			name, ok := args["op"]
+			if !ok {
+				return nil, fmt.Errorf("missing orientation")
+			}
+			op, err := orient.ParseOp(name)
+			if err != nil {
+				return nil, err
+			}
+			return &Orient{Op: op}, nil
+		},
+	},
+	"format": {
+		params: []string{"pix_fmts", "chroma_loc", "interl"},
+		build: func(args arguments) (Stage, error) {
+			name, ok := args["pix_fmts"]
+			if !ok {
+				return nil, fmt.Errorf("missing pixel format")
+			}
+			format := &Format{Name: name}
+			if err := format.validate(); err != nil {
+				return nil, err
+			}
+			interlaced := 0
+			if err := args.ints(map[string]*int{"interl": &interlaced}); err != nil {
+				return nil, err
+			}
+			format.Interlaced = interlaced != 0
+			if value, ok := args["chroma_loc"]; ok {
+				siting, err := chroma.ParseSiting(value)
+				if err != nil {
+					return nil, err
+				}
+				format.Siting = siting
+			}
+			return format, nil
+		},
+	},
+	"primaries": {
+		params: []string{"in", "out", "gamut"},
+		build: func(args arguments) (Stage, error) {
+			if args["in"] == "" || args["out"] == "" {
+				return nil, fmt.Errorf("missing input or output colour space")
+			}
+			stage := &Primaries{}
+			var err error
+			if stage.From, err = colorspace.RGBSpaceByName(args["in"]); err != nil {
+				return nil, err
+			}
+			if stage.To, err = colorspace.RGBSpaceByName(args["out"]); err != nil {
+				return nil, err
+			}
+			if value, ok := args["gamut"]; ok {
+				if stage.Mapping, err = colorspace.ParseGamutMapping(value); err != nil {
+					return nil, err
+				}
+			}
+			return stage, nil
+		},
+	},
+	"linearize": {
+		params: []string{"transfer", "peak"},
+		build: func(args arguments) (Stage, error) {
+			stage := &Linearize{Transfer: colorspace.TransferPQ}
+			if value, ok := args["transfer"]; ok {
+				var err error
+				if stage.Transfer, err = colorspace.ParseTransfer(value); err != nil {
+					return nil, err
+				}
+			}
+			if err := args.floats(map[string]*float64{"peak": &stage.Peak}); err != nil {
+				return nil, err
+			}
+			return stage, nil
+		},
+	},
+	"tonemap": {
+		params: []string{"tonemap", "transfer", "peak", "target", "in", "out", "gamut", "out_transfer"},
+		build: func(args arguments) (Stage, error) {
+			stage := &Tonemap{Operator: hdr.BT2390, Transfer: colorspace.TransferPQ, Target: hdr.SDRWhite}
+			var err error
+			if value, ok := args["tonemap"]; ok {
+				if stage.Operator, err = hdr.ParseOperator(value); err != nil {
+					return nil, err
+				}
+			}
+			if value, ok := args["transfer"]; ok {
+				if stage.Transfer, err = colorspace.ParseTransfer(value); err != nil {
+					return nil, err
+				}
+			}
+			if err := args.floats(map[string]*float64{"peak": &stage.Peak, "target": &stage.Target}); err != nil {
+				return nil, err
+			}
+			if stage.From, err = colorspace.RGBSpaceByName(args.get("in", "rec2020")); err != nil {
+				return nil, err
+			}
+			if stage.To, err = colorspace.RGBSpaceByName(args.get("out", "srgb")); err != nil {
+				return nil, err
+			}
+			if value, ok := args["gamut"]; ok {
+				if stage.Mapping, err = colorspace.ParseGamutMapping(value); err != nil {
+					return nil, err
+				}
+			}
+			stage.OutTransfer = stage.To.Transfer
+			if value, ok := args["out_transfer"]; ok {
+				if stage.OutTransfer, err = colorspace.ParseTransfer(value); err != nil {
+					return nil, err
+				}
+			}
+			return stage, nil
+		},
+	},
+	"unsharp": {
+		params: []string{"lx", "ly", "la"},
+		build:  buildUnsharp,
+	},
+	"sharpen": {
+		params: []string{"la"},
+		build:  buildUnsharp,
+	},
+	"flatten": {
+		params: []string{"color", "checker", "alt"},
+		build: func(args arguments) (Stage, error) {
+			flatten := &Flatten{Color: color.White, Alt: color.White}
+			if err := args.ints(map[string]*int{"checker": &flatten.Checker}); err != nil {
+				return nil, err
+			}
+			for name, target := range map[string]*color.Color{"color": &flatten.Color, "alt": &flatten.Alt} {
+				if value, ok := args[name]; ok {
+					c, err := ParseColor(value)
+					if err != nil {
+						return nil, err
+					}
+					*target = c
+				}
+			}
+			if err := flatten.validate(); err != nil {
+				return nil, err
+			}
+			return flatten, nil
+		},
+	},
+	"overlay": {
+		params: []string{"file", "x", "y"},
+		build: func(args arguments) (Stage, error) {
+			overlay := &Overlay{Path: args["file"]}
+			if overlay.Path == "" {
+				return nil, fmt.Errorf("missing overlay file")
+			}
+			overlay.X, overlay.Y = Offset(args["x"]), Offset(args["y"])
+			// Catch malformed expressions before any frame arrives
+			sizes := offsetSizes(1, 1, 1, 1)
+			for _, offset := range []Offset{overlay.X, overlay.Y} {
+				if _, err := offset.eval(sizes); err != nil {
+					return nil, err
+				}
+			}
+			img, err := loadOverlay(overlay.Path)
+			if err != nil {
+				return nil, err
+			}
+			overlay.Image = img
+			return overlay, nil
+		},
+	},
+}
+
+func buildUnsharp(args arguments) (Stage, error) {
+	unsharp := &Unsharp{SizeX: 5, SizeY: 5, Amount: 1}
+	if err := args.ints(map[string]*int{"lx": &unsharp.SizeX, "ly": &unsharp.SizeY}); err != nil {
+		return nil, err
+	}
+	if value, ok := args["la"]; ok {
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount %q", value)
+		}
+		unsharp.Amount = amount
+	}
+	return unsharp, nil
+}
+
+// transformArgs parses the parameters rotate and shear share: a fill colour,
+// transparent by default, the output bounds and the resampling filter.
+func transformArgs(args arguments) (color.Color, affine.Bounds, filters.Resampler, string, error) {
+	var fill color.Color
+	if value, ok := args["fill"]; ok {
+		c, err := ParseColor(value)
+		if err != nil {
+			return nil, 0, nil, "", err
+		}
+		fill = c
+	}
+	bounds := affine.Crop
+	if value, ok := args["bounds"]; ok {
+		var err error
+		if bounds, err = affine.ParseBounds(value); err != nil {
+			return nil, 0, nil, "", err
+		}
+	}
+	name := args.get("flags", "lanczos")
+	filter, err := filters.ByName(name)
+	if err != nil {
+		return nil, 0, nil, "", err
+	}
+	return fill, bounds, filter, name, nil
+}
+
+func loadOverlay(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open overlay: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode overlay: %w", err)
+	}
+	return img, nil
+}
+
+// arguments holds the parameters of one filter keyed by name.
+type arguments map[string]string
+
+// ints parses the named integer parameters into their targets. Parameters
+// listed in required must be present; the others keep their defaults.
+func (a arguments) ints(targets map[string]*int, required ...string) error {
+	for _, name := range required {
+		if _, ok := a[name]; !ok {
+			return fmt.Errorf("missing parameter %q", name)
+		}
+	}
+	for name, target := range targets {
+		value, ok := a[name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %q", value, name)
+		}
+		*target = n
+	}
+	return nil
+}
+
+// floats parses the named non-negative numeric parameters into their
+// targets, leaving absent ones at their defaults.
+func (a arguments) floats(targets map[string]*float64) error {
+	for name, target := range targets {
+		value, ok := a[name]
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("invalid value %q for %q", value, name)
+		}
+		*target = f
+	}
+	return nil
+}
+
+// signedFloats parses the named numeric parameters, which may be negative,
+// into their targets. Parameters listed in required must be present.
+func (a arguments) signedFloats(targets map[string]*float64, required ...string) error {
+	for _, name := range required {
+		if _, ok := a[name]; !ok {
+			return fmt.Errorf("missing parameter %q", name)
+		}
+	}
+	for name, target := range targets {
+		value, ok := a[name]
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("invalid value %q for %q", value, name)
+		}
+		*target = f
+	}
+	return nil
+}
+
+// get returns the named parameter, or fallback when it is absent.
+func (a arguments) get(name, fallback string) string {
+	if value, ok := a[name]; ok {
+		return value
+	}
+	return fallback
+}
+
+// Parse builds a graph from an ffmpeg-style filter expression such as
+//
+//	crop=1920:800:0:140,scale=1280:-2:lanczos,pad=1280:720:-1:-1:black
+//
+// Filters are separated by commas and their parameters by colons. Each
+// parameter may be given positionally or as name=value, and a backslash
+// escapes a literal comma, colon, equals sign or backslash.
+func Parse(expr string) (*Graph, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("empty filter expression")
+	}
+
+	g := New()
+	for i, part := range splitEscaped(expr, ',') {
+		stage, err := parseFilter(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("filter %d (%s): %w", i, part, err)
+		}
+		g.Append(stage)
+	}
+	return g, nil
+}
+
+func parseFilter(spec string) (Stage, error) {
+	name, rest, hasArgs := strings.Cut(spec, "=")
+	def, ok := definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %q", name)
+	}
+
+	args := arguments{}
+	if hasArgs {
+		for i, param := range splitEscaped(rest, ':') {
+			key, value, named := cutUnescaped(param, '=')
+			if named {
+				if !contains(def.params, key) {
+					return nil, fmt.Errorf("unknown parameter %q", key)
+				}
+			} else {
+				if i >= len(def.params) {
+					return nil, fmt.Errorf("too many parameters")
+				}
+				key, value = def.params[i], param
+			}
+			args[key] = unescape(value)
+		}
+	}
+
+	return def.build(args)
+}
+
+// splitEscaped splits s on sep, ignoring separators preceded by a
+// backslash. Escapes are left in place for the next level to interpret.
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	parts := splitEscaped(s, sep)
+	if len(parts) < 2 {
+		return s, "", false
+	}
+	return parts[0], s[len(parts[0])+1:], true
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// escape backslashes the characters Parse treats as separators, so
+// String can print values such as file paths that Parse reads back.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`\,:=`, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var namedColors = map[string]color.NRGBA{
+	"black":       {0, 0, 0, 255},
+	"white":       {255, 255, 255, 255},
+	"red":         {255, 0, 0, 255},
+	"green":       {0, 128, 0, 255},
+	"lime":        {0, 255, 0, 255},
+	"blue":        {0, 0, 255, 255},
+	"yellow":      {255, 255, 0, 255},
+	"cyan":        {0, 255, 255, 255},
+	"magenta":     {255, 0, 255, 255},
+	"gray":        {128, 128, 128, 255},
+	"grey":        {128, 128, 128, 255},
+	"transparent": {0, 0, 0, 0},
+}
+
+// ParseColor accepts a colour name (black, white, gray, ...), a hex value
+// written as #RRGGBB, 0xRRGGBB or RRGGBB with an optional alpha byte, and
+// an optional @alpha suffix between 0 and 1 as in "black@0.5".
+func ParseColor(value string) (color.NRGBA, error) {
+	spec, alphaSpec, hasAlpha := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "@")
+
+	c, ok := namedColors[spec]
+	if !ok {
+		hex := strings.TrimPrefix(strings.TrimPrefix(spec, "#"), "0x")
+		if len(hex) != 6 && len(hex) != 8 {
+			return color.NRGBA{}, fmt.Errorf("invalid color %q", value)
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return color.NRGBA{}, fmt.Errorf("invalid color %q", value)
+		}
+		if len(hex) == 6 {
+			n = n<<8 | 0xFF
+		}
+		c = color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}
+	}
+
+	if hasAlpha {
+		alpha, err := strconv.ParseFloat(alphaSpec, 64)
+		if err != nil || alpha < 0 || alpha > 1 {
+			return color.NRGBA{}, fmt.Errorf("invalid alpha in color %q", value)
+		}
+		c.A = uint8(alpha*255 + 0.5)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,820 @@
+package graph
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"video-processor/internal/affine"
+	"video-processor/internal/chroma"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/hdr"
+	"video-processor/internal/icc"
+	"video-processor/internal/orient"
+	"video-processor/internal/resize"
+)
+
+// Crop cuts a Width x Height window out of the frame. A negative X or Y
+// centres the window on that axis.
+type Crop struct {
+	Width, Height int
+	X, Y          int
+}
+
+func (c *Crop) Apply(frame image.Image) (image.Image, error) {
+	bounds := frame.Bounds()
+	if c.Width <= 0 || c.Height <= 0 {
+		return nil, fmt.Errorf("invalid crop size %dx%d", c.Width, c.Height)
+	}
+	if c.Width > bounds.Dx() || c.Height > bounds.Dy() {
+		return nil, fmt.Errorf("crop %dx%d larger than input %dx%d", c.Width, c.Height, bounds.Dx(), bounds.Dy())
+	}
+
+	x, y := c.X, c.Y
+	if x < 0 {
+		x = (bounds.Dx() - c.Width) / 2
+	}
+	if y < 0 {
+		y = (bounds.Dy() - c.Height) / 2
+	}
+	if x+c.Width > bounds.Dx() || y+c.Height > bounds.Dy() {
+		return nil, fmt.Errorf("crop window %dx%d+%d+%d exceeds input %dx%d", c.Width, c.Height, x, y, bounds.Dx(), bounds.Dy())
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, c.Width, c.Height))
+	draw.Draw(dst, dst.Bounds(), frame, bounds.Min.Add(image.Pt(x, y)), draw.Src)
+	return dst, nil
+}
+
+func (c *Crop) String() string {
+	return fmt.Sprintf("crop=%d:%d:%d:%d", c.Width, c.Height, c.X, c.Y)
+}
+
+// Scale resizes the frame. A dimension of -1 is derived from the other one
+// to preserve the aspect ratio; -n does the same and rounds the result to a
+// multiple of n, so -2 yields the even sizes most video encoders require.
+// Interlaced scales each field separately so the output stays interlaced.
+type Scale struct {
+	Width, Height int
+	Filter        filters.Resampler
+	FilterName    string
+	Interlaced    bool
+	// Planar keeps *image.YCbCr frames in YCbCr, resampling each plane with
+	// chroma at Siting and re-encoding them from the From to the To colour
+	// space in the same pass. Other frames are scaled in RGB.
+	Planar   bool
+	Siting   chroma.Siting
+	From, To colorspace.Space
+}
+
+func (s *Scale) Apply(frame image.Image) (image.Image, error) {
+	bounds := frame.Bounds()
+	width, height, err := scaledSize(bounds.Dx(), bounds.Dy(), s.Width, s.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := s.Filter
+	if filter == nil {
+		filter = filters.NewLanczos(3)
+	}
+	if s.Interlaced {
+		return resize.ResizeFields(frame, width, height, filter)
+	}
+	if linear, ok := frame.(*hdr.Image); ok {
+		return resize.ResizeHDR(linear, width, height, filter)
+	}
+	if ycc, ok := frame.(*image.YCbCr); ok && s.Planar {
+		x, y := s.Siting.Position(ycc.SubsampleRatio)
+		return resize.ResizeYCbCr(ycc, width, height, resize.YCbCrOptions{Filter: filter, ChromaX: x, ChromaY: y, From: s.From, To: s.To})
+	}
+	return resize.ResizeWithFilter(frame, width, height, filter)
+}
+
+func (s *Scale) String() string {
+	name := s.FilterName
+	if name == "" {
+		name = "lanczos"
+	}
+	if s.Interlaced {
+		return fmt.Sprintf("scale=%d:%d:%s:1", s.Width, s.Height, name)
+	}
+	return fmt.Sprintf("scale=%d:%d:%s", s.Width, s.Height, name)
+}
+
+func scaledSize(srcWidth, srcHeight, width, height int) (int, int, error) {
+	if width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("invalid scale size %dx%d", width, height)
+	}
+	if width < 0 && height < 0 {
+		return srcWidth, srcHeight, nil
+	}
+
+	if width < 0 {
+		width = scaleDimension(height, srcWidth, srcHeight, -width)
+	}
+	if height < 0 {
+		height = scaleDimension(width, srcHeight, srcWidth, -height)
+	}
+	return width, height, nil
+}
+
+// scaleDimension returns other*num/den rounded to the nearest multiple of
+// step, never less than step.
+func scaleDimension(other, num, den, step int) int {
+	value := float64(other) * float64(num) / float64(den)
+	rounded := int(math.Round(value/float64(step))) * step
+	if rounded < step {
+		rounded = step
+	}
+	return rounded
+}
+
+// Pad places the frame on a Width x Height canvas filled with Color. A
+// zero size keeps the input dimension and a negative X or Y centres the
+// frame on that axis.
+type Pad struct {
+	Width, Height int
+	X, Y          int
+	Color         color.Color
+}
+
+func (p *Pad) Apply(frame image.Image) (image.Image, error) {
+	bounds := frame.Bounds()
+	width, height := p.Width, p.Height
+	if width == 0 {
+		width = bounds.Dx()
+	}
+	if height == 0 {
+		height = bounds.Dy()
+	}
+	if width < bounds.Dx() || height < bounds.Dy() {
+		return nil, fmt.Errorf("pad %dx%d smaller than input %dx%d", width, height, bounds.Dx(), bounds.Dy())
+	}
+
+	x, y := p.X, p.Y
+	if x < 0 {
+		x = (width - bounds.Dx()) / 2
+	}
+	if y < 0 {
+		y = (height - bounds.Dy()) / 2
+	}
+	if x+bounds.Dx() > width || y+bounds.Dy() > height {
+		return nil, fmt.Errorf("input at %d,%d does not fit in pad %dx%d", x, y, width, height)
+	}
+
+	fill := p.Color
+	if fill == nil {
+		fill = color.Black
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+	draw.Draw(dst, bounds.Sub(bounds.Min).Add(image.Pt(x, y)), frame, bounds.Min, draw.Src)
+	return dst, nil
+}
+
+func (p *Pad) String() string {
+	r, g, b, a := p.colorNRGBA()
+	return fmt.Sprintf("pad=%d:%d:%d:%d:0x%02X%02X%02X%02X", p.Width, p.Height, p.X, p.Y, r, g, b, a)
+}
+
+func (p *Pad) colorNRGBA() (uint8, uint8, uint8, uint8) {
+	if p.Color == nil {
+		return 0, 0, 0, 0xFF
+	}
+	c := color.NRGBAModel.Convert(p.Color).(color.NRGBA)
+	return c.R, c.G, c.B, c.A
+}
+
+// Rotate turns the frame clockwise by Angle degrees about its centre,
+// resampling it with Filter. Fill covers what the input no longer reaches,
+// transparent when nil, and Bounds keeps the input size or expands the
+// frame to hold all of the turned input.
+type Rotate struct {
+	Angle      float64
+	Fill       color.Color
+	Bounds     affine.Bounds
+	Filter     filters.Resampler
+	FilterName string
+}
+
+func (r *Rotate) Apply(frame image.Image) (image.Image, error) {
+	return transform(frame, affine.Rotation(r.Angle), r.Fill, r.Bounds, r.Filter)
+}
+
+func (r *Rotate) String() string {
+	return fmt.Sprintf("rotate=%g:%s:%s:%s", r.Angle, fillString(r.Fill), r.Bounds, filterName(r.FilterName))
+}
+
+// Shear slants the frame about its centre, moving each pixel across by X
+// times its distance below the centre and down by Y times its distance
+// right of it, e.g. to deskew a scan. Fill, Bounds and Filter are as for
+// Rotate.
+type Shear struct {
+	X, Y       float64
+	Fill       color.Color
+	Bounds     affine.Bounds
+	Filter     filters.Resampler
+	FilterName string
+}
+
+func (s *Shear) Apply(frame image.Image) (image.Image, error) {
+	return transform(frame, affine.Shearing(s.X, s.Y), s.Fill, s.Bounds, s.Filter)
+}
+
+func (s *Shear) String() string {
+	return fmt.Sprintf("shear=%g:%g:%s:%s:%s", s.X, s.Y, fillString(s.Fill), s.Bounds, filterName(s.FilterName))
+}
+
+// Orient turns the frame by right angles or mirrors it, moving pixels
+// without resampling and keeping the frame's pixel type.
+type Orient struct {
+	Op orient.Op
+}
+
+func (o *Orient) Apply(frame image.Image) (image.Image, error) {
+	return orient.Apply(frame, o.Op), nil
+}
+
+func (o *Orient) String() string {
+	return fmt.Sprintf("orient=%s", o.Op)
+}
+
+// transform resamples frame through m, keeping linear-light frames in
+// floating point.
+func transform(frame image.Image, m affine.Matrix, fill color.Color, bounds affine.Bounds, filter filters.Resampler) (image.Image, error) {
+	opts := &affine.Options{Filter: filter, Background: fill, Bounds: bounds}
+	if linear, ok := frame.(*hdr.Image); ok {
+		return affine.TransformHDR(linear, m, opts)
+	}
+	return affine.Transform(frame, m, opts)
+}
+
+// fillString renders a fill colour for String, nil being transparent.
+func fillString(fill color.Color) string {
+	if fill == nil {
+		return "0x00000000"
+	}
+	c := color.NRGBAModel.Convert(fill).(color.NRGBA)
+	return fmt.Sprintf("0x%02X%02X%02X%02X", c.R, c.G, c.B, c.A)
+}
+
+func filterName(name string) string {
+	if name == "" {
+		return "lanczos"
+	}
+	return name
+}
+
+// Format converts the frame to another pixel format. Supported formats are
+// rgba, rgba64, rgb24 (alpha discarded), gray, gray16 and the planar
+// yuv444p, yuv422p, yuv420p and yuv440p. Planar formats are encoded in
+// Space, with subsampled chroma filtered down to samples at Siting.
+// Interlaced filters the chroma of each field separately so the output
+// stays interlaced.
+type Format struct {
+	Name       string
+	Siting     chroma.Siting
+	Space      colorspace.Space
+	Interlaced bool
+}
+
+var subsampleRatios = map[string]image.YCbCrSubsampleRatio{
+	"yuv444p": image.YCbCrSubsampleRatio444,
+	"yuv422p": image.YCbCrSubsampleRatio422,
+	"yuv420p": image.YCbCrSubsampleRatio420,
+	"yuv440p": image.YCbCrSubsampleRatio440,
+}
+
+func (f *Format) validate() error {
+	if _, ok := subsampleRatios[f.Name]; ok {
+		return nil
+	}
+	switch f.Name {
+	case "rgba", "rgba64", "rgb24", "gray", "gray16":
+		return nil
+	}
+	return fmt.Errorf("unsupported pixel format %q", f.Name)
+}
+
+func (f *Format) Apply(frame image.Image) (image.Image, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+
+	bounds := frame.Bounds()
+	rect := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+
+	if ratio, ok := subsampleRatios[f.Name]; ok {
+		if f.Interlaced {
+			return chroma.FromRGBFields(frame, ratio, f.Siting, f.Space, nil)
+		}
+		return chroma.FromRGB(frame, ratio, f.Siting, f.Space, nil)
+	}
+
+	var dst draw.Image
+	switch f.Name {
+	case "rgba":
+		dst = image.NewNRGBA(rect)
+	case "rgba64":
+		dst = image.NewNRGBA64(rect)
+	case "rgb24":
+		opaque := image.NewNRGBA(rect)
+		draw.Draw(opaque, rect, image.NewUniform(color.Black), image.Point{}, draw.Src)
+		draw.Draw(opaque, rect, frame, bounds.Min, draw.Over)
+		return opaque, nil
+	case "gray":
+		dst = image.NewGray(rect)
+	default: // gray16
+		dst = image.NewGray16(rect)
+	}
+
+	draw.Draw(dst, rect, frame, bounds.Min, draw.Src)
+	return dst, nil
+}
+
+func (f *Format) String() string {
+	if f.Interlaced {
+		return fmt.Sprintf("format=%s:%s:1", f.Name, f.Siting)
+	}
+	if f.Siting != chroma.Center {
+		return fmt.Sprintf("format=%s:%s", f.Name, f.Siting)
+	}
+	return "format=" + f.Name
+}
+
+// Primaries converts the frame from one RGB colour space to another in
+// linear light, e.g. Display P3 or BT.2020 to sRGB. Colours outside the
+// destination gamut are clipped or compressed according to Mapping.
+// 16-bit frames are converted at 16 bits.
+type Primaries struct {
+	From, To colorspace.RGBSpace
+	Mapping  colorspace.GamutMapping
+}
+
+func (p *Primaries) Apply(frame image.Image) (image.Image, error) {
+	conv := colorspace.NewPrimariesConversion(p.From, p.To, p.Mapping)
+	switch frame.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return p.apply16(frame, conv), nil
+	}
+
+	src := toNRGBA(frame)
+
+	var linear [256]float64
+	for i := range linear {
+		linear[i] = p.From.Transfer.ToLinear(float64(i) / 255)
+	}
+	encode := func(v float64) uint8 {
+		return uint8(math.Round(p.To.Transfer.FromLinear(v) * 255))
+	}
+
+	dst := image.NewNRGBA(src.Rect)
+	for i := 0; i < len(src.Pix); i += 4 {
+		r, g, b := conv.ConvertLinear(linear[src.Pix[i]], linear[src.Pix[i+1]], linear[src.Pix[i+2]])
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = encode(r), encode(g), encode(b), src.Pix[i+3]
+	}
+	return dst, nil
+}
+
+// apply16 converts frame through NRGBA64 so 16-bit input keeps its
+// precision.
+func (p *Primaries) apply16(frame image.Image, conv *colorspace.PrimariesConversion) *image.NRGBA64 {
+	bounds := frame.Bounds()
+	src, ok := frame.(*image.NRGBA64)
+	if !ok {
+		src = image.NewNRGBA64(bounds)
+		draw.Draw(src, bounds, frame, bounds.Min, draw.Src)
+	}
+	linear := make([]float64, 65536)
+	for i := range linear {
+		linear[i] = p.From.Transfer.ToLinear(float64(i) / 65535)
+	}
+	encode := func(v float64) (uint8, uint8) {
+		e := uint16(math.Round(p.To.Transfer.FromLinear(v) * 65535))
+		return uint8(e >> 8), uint8(e)
+	}
+
+	dst := image.NewNRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		in := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		out := dst.Pix[y*dst.Stride:]
+		for i := 0; i < 8*bounds.Dx(); i += 8 {
+			r, g, b := conv.ConvertLinear(
+				linear[int(in[i])<<8|int(in[i+1])],
+				linear[int(in[i+2])<<8|int(in[i+3])],
+				linear[int(in[i+4])<<8|int(in[i+5])])
+			out[i], out[i+1] = encode(r)
+			out[i+2], out[i+3] = encode(g)
+			out[i+4], out[i+5] = encode(b)
+			out[i+6], out[i+7] = in[i+6], in[i+7]
+		}
+	}
+	return dst
+}
+
+func (p *Primaries) String() string {
+	return fmt.Sprintf("primaries=%s:%s:%s", p.From.Name, p.To.Name, p.Mapping)
+}
+
+// ICC converts frames described by an embedded ICC profile to sRGB. The
+// result is a linear-light *hdr.Image with sRGB white at hdr.SDRWhite, so
+// later stages such as scale filter in linear light; a format stage at the
+// end of the graph encodes it back to sRGB.
+type ICC struct {
+	Profile *icc.Profile
+	Mapping colorspace.GamutMapping
+}
+
+func (c *ICC) Apply(frame image.Image) (image.Image, error) {
+	srgb, err := colorspace.RGBSpaceByName("srgb")
+	if err != nil {
+		return nil, err
+	}
+	conv := colorspace.NewXYZConversion(c.Profile.ToXYZ, colorspace.D50, srgb, c.Mapping)
+
+	bounds := frame.Bounds()
+	src, ok := frame.(*image.NRGBA64)
+	if !ok {
+		src = image.NewNRGBA64(bounds)
+		draw.Draw(src, bounds, frame, bounds.Min, draw.Src)
+	}
+	var luts [3][]float64
+	for i, curve := range c.Profile.Curves {
+		luts[i] = make([]float64, 65536)
+		for v := range luts[i] {
+			luts[i][v] = curve.ToLinear(float64(v) / 65535)
+		}
+	}
+
+	dst := hdr.NewImage(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), hdr.SDRWhite)
+	for y := 0; y < bounds.Dy(); y++ {
+		in := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < bounds.Dx(); x++ {
+			i, o := x*8, x*4
+			r, g, b := conv.ConvertLinear(
+				luts[0][int(in[i])<<8|int(in[i+1])],
+				luts[1][int(in[i+2])<<8|int(in[i+3])],
+				luts[2][int(in[i+4])<<8|int(in[i+5])])
+			out[o], out[o+1], out[o+2] = float32(r*hdr.SDRWhite), float32(g*hdr.SDRWhite), float32(b*hdr.SDRWhite)
+			out[o+3] = float32(int(in[i+6])<<8|int(in[i+7])) / 65535
+		}
+	}
+	return dst, nil
+}
+
+func (c *ICC) String() string {
+	name := c.Profile.Description
+	if name == "" {
+		name = "unnamed"
+	}
+	return fmt.Sprintf("icc=%s:%s", name, c.Mapping)
+}
+
+// Linearize decodes frames encoded with Transfer to a floating-point
+// linear-light *hdr.Image, so later stages such as scale filter HDR
+// content without clipping it. Peak has the meaning hdr.Decode gives it.
+type Linearize struct {
+	Transfer colorspace.Transfer
+	Peak     float64
+}
+
+func (l *Linearize) Apply(frame image.Image) (image.Image, error) {
+	if linear, ok := frame.(*hdr.Image); ok {
+		return linear, nil
+	}
+	return hdr.Decode(frame, l.Transfer, l.Peak), nil
+}
+
+func (l *Linearize) String() string {
+	return fmt.Sprintf("linearize=%s:%g", l.Transfer, l.Peak)
+}
+
+// Tonemap renders HDR frames for an SDR display. Linear frames from
+// linearize are used as they are; others are decoded with Transfer, so the
+// stage works before or after scaling. Luminance above Target cd/m² is
+// compressed with Operator, colours are converted from the From to the To
+// primaries, and the result is encoded with OutTransfer at 16 bits.
+type Tonemap struct {
+	Operator hdr.Operator
+	Transfer colorspace.Transfer
+	// Peak overrides the content peak in cd/m²; 0 keeps the frame's.
+	Peak, Target float64
+	From, To     colorspace.RGBSpace
+	Mapping      colorspace.GamutMapping
+	OutTransfer  colorspace.Transfer
+}
+
+func (t *Tonemap) Apply(frame image.Image) (image.Image, error) {
+	linear, ok := frame.(*hdr.Image)
+	if !ok {
+		linear = hdr.Decode(frame, t.Transfer, t.Peak)
+	} else if t.Peak > 0 {
+		copied := *linear
+		copied.Peak = t.Peak
+		linear = &copied
+	}
+	target := t.Target
+	if target <= 0 {
+		target = hdr.SDRWhite
+	}
+
+	mapped := hdr.ToneMap(linear, t.Operator, target)
+	conv := colorspace.NewPrimariesConversion(t.From, t.To, t.Mapping)
+	for i := 0; i < len(mapped.Pix); i += 4 {
+		p := mapped.Pix[i : i+3 : i+3]
+		r, g, b := conv.ConvertLinear(float64(p[0])/target, float64(p[1])/target, float64(p[2])/target)
+		p[0], p[1], p[2] = float32(r*target), float32(g*target), float32(b*target)
+	}
+	return mapped.Encode(t.OutTransfer, target), nil
+}
+
+func (t *Tonemap) String() string {
+	s := fmt.Sprintf("tonemap=%s:%s:%g:%g:%s:%s:%s", t.Operator, t.Transfer, t.Peak, t.Target, t.From.Name, t.To.Name, t.Mapping)
+	if t.OutTransfer != t.To.Transfer {
+		s += ":" + t.OutTransfer.String()
+	}
+	return s
+}
+
+// Unsharp sharpens the frame by adding back Amount times the difference
+// between the frame and a Gaussian blur of SizeX x SizeY taps. Negative
+// amounts blur instead.
+type Unsharp struct {
+	SizeX, SizeY int
+	Amount       float64
+}
+
+func (u *Unsharp) Apply(frame image.Image) (image.Image, error) {
+	if u.SizeX < 3 || u.SizeY < 3 || u.SizeX%2 == 0 || u.SizeY%2 == 0 {
+		return nil, fmt.Errorf("unsharp matrix size must be odd and at least 3, got %dx%d", u.SizeX, u.SizeY)
+	}
+
+	src := toNRGBA(frame)
+	blurred := blur(src, gaussianTaps(u.SizeX/2), gaussianTaps(u.SizeY/2))
+
+	dst := image.NewNRGBA(src.Rect)
+	for i := 0; i < len(src.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			original := float64(src.Pix[i+c])
+			value := original + u.Amount*(original-blurred[i+c])
+			dst.Pix[i+c] = clampUint8(value)
+		}
+		dst.Pix[i+3] = src.Pix[i+3]
+	}
+	return dst, nil
+}
+
+func (u *Unsharp) String() string {
+	return fmt.Sprintf("unsharp=%d:%d:%g", u.SizeX, u.SizeY, u.Amount)
+}
+
+// gaussianTaps returns normalised weights for offsets -radius..radius.
+func gaussianTaps(radius int) []float64 {
+	sigma := math.Max(float64(radius)/2, 0.5)
+	kernel := filters.NewGaussian(sigma)
+
+	taps := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range taps {
+		taps[i] = kernel.Kernel(float64(i - radius))
+		sum += taps[i]
+	}
+	for i := range taps {
+		taps[i] /= sum
+	}
+	return taps
+}
+
+// blur runs a separable convolution over the colour channels of src with
+// edge pixels replicated, returning interleaved RGBA floats.
+func blur(src *image.NRGBA, tapsX, tapsY []float64) []float64 {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	radiusX, radiusY := len(tapsX)/2, len(tapsY)/2
+
+	horizontal := make([]float64, len(src.Pix))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				var sum float64
+				for i, w := range tapsX {
+					sx := clampInt(x+i-radiusX, 0, width-1)
+					sum += w * float64(src.Pix[y*src.Stride+sx*4+c])
+				}
+				horizontal[(y*width+x)*4+c] = sum
+			}
+		}
+	}
+
+	out := make([]float64, len(src.Pix))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				var sum float64
+				for i, w := range tapsY {
+					sy := clampInt(y+i-radiusY, 0, height-1)
+					sum += w * horizontal[(sy*width+x)*4+c]
+				}
+				out[y*src.Stride+x*4+c] = sum
+			}
+		}
+	}
+	return out
+}
+
+// Overlay composites Image over the frame with its top-left corner at X,Y,
+// which may be expressions over the frame and overlay sizes.
+type Overlay struct {
+	Image image.Image
+	Path  string
+	X, Y  Offset
+}
+
+func (o *Overlay) Apply(frame image.Image) (image.Image, error) {
+	if o.Image == nil {
+		return nil, fmt.Errorf("overlay image is nil")
+	}
+
+	bounds := frame.Bounds()
+	overlayBounds := o.Image.Bounds()
+
+	sizes := offsetSizes(bounds.Dx(), bounds.Dy(), overlayBounds.Dx(), overlayBounds.Dy())
+	x, err := o.X.resolve(bounds.Dx(), overlayBounds.Dx(), sizes)
+	if err != nil {
+		return nil, err
+	}
+	y, err := o.Y.resolve(bounds.Dy(), overlayBounds.Dy(), sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), frame, bounds.Min, draw.Src)
+	target := overlayBounds.Sub(overlayBounds.Min).Add(image.Pt(x, y))
+	draw.Draw(dst, target, o.Image, overlayBounds.Min, draw.Over)
+	return dst, nil
+}
+
+func (o *Overlay) String() string {
+	x, y := o.X, o.Y
+	if x == "" {
+		x = "0"
+	}
+	if y == "" {
+		y = "0"
+	}
+	return fmt.Sprintf("overlay=%s:%s:%s", escape(o.Path), escape(string(x)), escape(string(y)))
+}
+
+// Flatten composites the frame over an opaque background so formats
+// without alpha do not show whatever colour transparent pixels hide. The
+// background is Color, or a checkerboard of Checker-pixel squares
+// alternating Color and Alt when Checker is positive. Linear-light frames
+// are composited in floating point and 16-bit frames at 16 bits.
+type Flatten struct {
+	Color   color.Color
+	Alt     color.Color
+	Checker int
+}
+
+func (f *Flatten) validate() error {
+	c, alt := f.colors()
+	if c.A != 0xFF || f.Checker > 0 && alt.A != 0xFF {
+		return fmt.Errorf("flatten background must be opaque")
+	}
+	if f.Checker < 0 {
+		return fmt.Errorf("invalid checker size %d", f.Checker)
+	}
+	return nil
+}
+
+func (f *Flatten) Apply(frame image.Image) (image.Image, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	if linear, ok := frame.(*hdr.Image); ok {
+		return f.flattenLinear(linear), nil
+	}
+
+	c, alt := f.colors()
+	bounds := frame.Bounds()
+	r := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	var dst draw.Image
+	switch frame.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		// 16-bit frames keep their precision
+		deep := image.NewNRGBA64(r)
+		for y := 0; y < r.Dy(); y++ {
+			row := deep.Pix[y*deep.Stride:]
+			for x := 0; x < r.Dx(); x++ {
+				fill := c
+				if f.checkered(x, y) {
+					fill = alt
+				}
+				for i, v := range []uint8{fill.R, fill.G, fill.B, fill.A} {
+					row[8*x+2*i], row[8*x+2*i+1] = v, v
+				}
+			}
+		}
+		dst = deep
+	default:
+		shallow := image.NewNRGBA(r)
+		for y := 0; y < r.Dy(); y++ {
+			row := shallow.Pix[y*shallow.Stride:]
+			for x := 0; x < r.Dx(); x++ {
+				fill := c
+				if f.checkered(x, y) {
+					fill = alt
+				}
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = fill.R, fill.G, fill.B, fill.A
+			}
+		}
+		dst = shallow
+	}
+	draw.Draw(dst, r, frame, bounds.Min, draw.Over)
+	return dst, nil
+}
+
+// flattenLinear composites a linear-light frame in floating point, with
+// the sRGB background decoded to linear light at SDR white, so highlights
+// above it survive.
+func (f *Flatten) flattenLinear(src *hdr.Image) *hdr.Image {
+	c, alt := f.colors()
+	linear := func(c color.NRGBA) [3]float32 {
+		var v [3]float32
+		for i, channel := range []uint8{c.R, c.G, c.B} {
+			v[i] = float32(colorspace.TransferSRGB.ToLinear(float64(channel)/255) * hdr.SDRWhite)
+		}
+		return v
+	}
+	fill, altFill := linear(c), linear(alt)
+
+	dst := hdr.NewImage(image.Rect(0, 0, src.Rect.Dx(), src.Rect.Dy()), src.Peak)
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		in := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):]
+		out := dst.Pix[dst.PixOffset(0, y):]
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			bg := fill
+			if f.checkered(x, y) {
+				bg = altFill
+			}
+			p, q := in[4*x:4*x+4], out[4*x:4*x+4]
+			a := min(max(p[3], 0), 1)
+			for i := 0; i < 3; i++ {
+				q[i] = p[i]*a + bg[i]*(1-a)
+			}
+			q[3] = 1
+		}
+	}
+	return dst
+}
+
+// checkered reports whether (x, y) lies on an Alt square.
+func (f *Flatten) checkered(x, y int) bool {
+	return f.Checker > 0 && (x/f.Checker+y/f.Checker)%2 == 1
+}
+
+func (f *Flatten) String() string {
+	c, alt := f.colors()
+	if f.Checker > 0 {
+		return fmt.Sprintf("flatten=0x%02X%02X%02X:%d:0x%02X%02X%02X", c.R, c.G, c.B, f.Checker, alt.R, alt.G, alt.B)
+	}
+	return fmt.Sprintf("flatten=0x%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// colors returns the background colours, white where unset.
+func (f *Flatten) colors() (color.NRGBA, color.NRGBA) {
+	c, alt := color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	if f.Color != nil {
+		c = color.NRGBAModel.Convert(f.Color).(color.NRGBA)
+	}
+	if f.Alt != nil {
+		alt = color.NRGBAModel.Convert(f.Alt).(color.NRGBA)
+	}
+	return c, alt
+}
+
+func clampUint8(value float64) uint8 {
+	if value <= 0 {
+		return 0
+	}
+	if value >= 255 {
+		return 255
+	}
+	return uint8(value + 0.5)
+}
+
+func clampInt(value, low, high int) int {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
@@ -0,0 +1,185 @@
+package hdr
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"video-processor/internal/colorspace"
+)
+
+// SDRWhite is the luminance of SDR reference white in cd/m², following
+// BT.2408. SDR signals decode with full scale at this level by default, and
+// it is the default target of tone mapping.
+const SDRWhite = 203
+
+// PQPeak is the luminance of a full-scale PQ signal in cd/m².
+const PQPeak = 10000
+
+// DefaultPeak is the peak assumed for content whose mastering display is
+// unknown.
+const DefaultPeak = 1000
+
+// BT.2020 luminance weights, used by the HLG system gamma.
+const (
+	lumaR = 0.2627
+	lumaG = 0.6780
+	lumaB = 0.0593
+)
+
+// Image is a floating-point RGBA image holding linear light in cd/m².
+// Colour is not premultiplied and alpha is in 0-1. Peak is the brightest
+// luminance the content reaches, which tone mapping compresses to the
+// target display.
+type Image struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+	Peak   float64
+}
+
+func NewImage(r image.Rectangle, peak float64) *Image {
+	return &Image{Pix: make([]float32, 4*r.Dx()*r.Dy()), Stride: 4 * r.Dx(), Rect: r, Peak: peak}
+}
+
+func (m *Image) ColorModel() color.Model { return color.NRGBA64Model }
+
+func (m *Image) Bounds() image.Rectangle { return m.Rect }
+
+// PixOffset returns the index of the first element of Pix that holds the
+// pixel at (x, y).
+func (m *Image) PixOffset(x, y int) int {
+	return (y-m.Rect.Min.Y)*m.Stride + (x-m.Rect.Min.X)*4
+}
+
+// At renders the pixel as sRGB with SDRWhite at full scale, clipping
+// anything brighter. Use a tone mapping stage to keep highlight detail.
+func (m *Image) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return color.NRGBA64{}
+	}
+	p := m.Pix[m.PixOffset(x, y):]
+	encode := func(v float32) uint16 {
+		return quantize16(colorspace.TransferSRGB.FromLinear(float64(v) / SDRWhite))
+	}
+	return color.NRGBA64{R: encode(p[0]), G: encode(p[1]), B: encode(p[2]), A: quantize16(float64(p[3]))}
+}
+
+// signalPeak returns the luminance of full scale for tf: absolute for PQ,
+// the nominal display peak for HLG and reference white for SDR curves.
+func signalPeak(tf colorspace.Transfer, peak float64) float64 {
+	switch {
+	case tf == colorspace.TransferPQ:
+		return PQPeak
+	case peak > 0:
+		return peak
+	case tf == colorspace.TransferHLG:
+		return DefaultPeak
+	}
+	return SDRWhite
+}
+
+// hlgGamma is the BT.2100 system gamma for an HLG display of the given
+// peak luminance.
+func hlgGamma(peak float64) float64 {
+	return 1.2 + 0.42*math.Log10(peak/1000)
+}
+
+// Decode converts an image encoded with tf to linear light. For SDR curves
+// and HLG, peak is the luminance of full scale, or 0 for the default; HLG
+// is rendered with the system gamma of a display of that peak. PQ is
+// absolute, and peak instead records the content's mastering peak.
+func Decode(src image.Image, tf colorspace.Transfer, peak float64) *Image {
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.NRGBA64)
+	if !ok {
+		rgba = image.NewNRGBA64(bounds)
+		draw.Draw(rgba, bounds, src, bounds.Min, draw.Src)
+	}
+
+	full := signalPeak(tf, peak)
+	lut := make([]float32, 65536)
+	for i := range lut {
+		lut[i] = float32(tf.ToLinear(float64(i) / 65535))
+	}
+	if tf != colorspace.TransferHLG {
+		for i := range lut {
+			lut[i] *= float32(full)
+		}
+	}
+
+	contentPeak := full
+	if tf == colorspace.TransferPQ {
+		contentPeak = peak
+		if contentPeak <= 0 {
+			contentPeak = DefaultPeak
+		}
+	}
+	dst := NewImage(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), contentPeak)
+	gamma := hlgGamma(full)
+	for y := 0; y < bounds.Dy(); y++ {
+		in := rgba.Pix[rgba.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < bounds.Dx(); x++ {
+			i, o := x*8, x*4
+			r := lut[int(in[i])<<8|int(in[i+1])]
+			g := lut[int(in[i+2])<<8|int(in[i+3])]
+			b := lut[int(in[i+4])<<8|int(in[i+5])]
+			if tf == colorspace.TransferHLG {
+				// The OOTF scales scene light by a power of its luminance.
+				ys := lumaR*float64(r) + lumaG*float64(g) + lumaB*float64(b)
+				scale := float32(full)
+				if ys > 0 {
+					scale *= float32(math.Pow(ys, gamma-1))
+				}
+				r, g, b = r*scale, g*scale, b*scale
+			}
+			out[o], out[o+1], out[o+2] = r, g, b
+			out[o+3] = float32(int(in[i+6])<<8|int(in[i+7])) / 65535
+		}
+	}
+	return dst
+}
+
+// FromImage returns img as linear light, decoding images other than an
+// *Image as sRGB.
+func FromImage(img image.Image) *Image {
+	if linear, ok := img.(*Image); ok {
+		return linear
+	}
+	return Decode(img, colorspace.TransferSRGB, 0)
+}
+
+// Encode converts the image to 16-bit values encoded with tf, with the
+// same meaning of peak as Decode. Light beyond full scale is clipped.
+func (m *Image) Encode(tf colorspace.Transfer, peak float64) *image.NRGBA64 {
+	full := signalPeak(tf, peak)
+	gamma := hlgGamma(full)
+	dst := image.NewNRGBA64(image.Rect(0, 0, m.Rect.Dx(), m.Rect.Dy()))
+	for y := 0; y < m.Rect.Dy(); y++ {
+		in := m.Pix[y*m.Stride:]
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < m.Rect.Dx(); x++ {
+			i, o := x*4, x*8
+			r, g, b := float64(in[i])/full, float64(in[i+1])/full, float64(in[i+2])/full
+			if tf == colorspace.TransferHLG {
+				// Invert the OOTF to recover scene light.
+				yd := lumaR*r + lumaG*g + lumaB*b
+				if yd > 0 {
+					scale := math.Pow(yd, (1-gamma)/gamma)
+					r, g, b = r*scale, g*scale, b*scale
+				}
+			}
+			for c, v := range [4]float64{tf.FromLinear(r), tf.FromLinear(g), tf.FromLinear(b), float64(in[i+3])} {
+				q := quantize16(v)
+				out[o+2*c], out[o+2*c+1] = uint8(q>>8), uint8(q)
+			}
+		}
+	}
+	return dst
+}
+
+func quantize16(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(65535, math.Round(v*65535))))
+}
@@ -0,0 +1,99 @@
+package hdr
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"video-processor/internal/colorspace"
+)
+
+func grey(value uint16) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA64(0, 0, color.NRGBA64{R: value, G: value, B: value, A: 0xFFFF})
+	return img
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		tf     colorspace.Transfer
+		signal float64
+		want   float64
+	}{
+		{colorspace.TransferPQ, 0.50808, 100},
+		{colorspace.TransferPQ, 1, PQPeak},
+		// BT.2408: 75% HLG is reference white on a 1000 cd/m² display.
+		{colorspace.TransferHLG, 0.75, 203},
+		{colorspace.TransferSRGB, 1, SDRWhite},
+	}
+	for _, tt := range tests {
+		got := Decode(grey(uint16(math.Round(tt.signal*65535))), tt.tf, 0).Pix[0]
+		if math.Abs(float64(got)-tt.want) > tt.want*0.005 {
+			t.Errorf("%s %.5f decodes to %.1f cd/m², want %.1f", tt.tf, tt.signal, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	src := image.NewNRGBA64(image.Rect(0, 0, 3, 1))
+	src.SetNRGBA64(0, 0, color.NRGBA64{R: 0xF000, G: 0x8000, B: 0x1000, A: 0xFFFF})
+	src.SetNRGBA64(1, 0, color.NRGBA64{R: 0x4000, G: 0x4000, B: 0x4000, A: 0x8000})
+	src.SetNRGBA64(2, 0, color.NRGBA64{R: 0xFFFF, G: 0, B: 0x2000, A: 0xFFFF})
+
+	for _, tf := range []colorspace.Transfer{colorspace.TransferPQ, colorspace.TransferHLG, colorspace.TransferSRGB, colorspace.TransferBT1886} {
+		got := Decode(src, tf, 0).Encode(tf, 0)
+		for i := 0; i < len(src.Pix); i += 2 {
+			d := int(got.Pix[i])<<8 | int(got.Pix[i+1])
+			want := int(src.Pix[i])<<8 | int(src.Pix[i+1])
+			if d-want > 2 || want-d > 2 {
+				t.Errorf("%s: round trip of sample %d = %#x, want %#x", tf, i/2, d, want)
+			}
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	const source, target = 1000.0, SDRWhite
+	for _, name := range OperatorNames() {
+		op, err := ParseOperator(name)
+		if err != nil {
+			t.Fatalf("ParseOperator(%q) unexpected error: %v", name, err)
+		}
+		if got := op.Map(source, source, target); math.Abs(got-target) > 1e-6 {
+			t.Errorf("%s maps the content peak to %.3f, want %v", op, got, target)
+		}
+		previous := 0.0
+		for l := 1.0; l <= 2*source; l *= 1.5 {
+			got := op.Map(l, source, target)
+			if got < previous || got > target {
+				t.Errorf("%s maps %.1f to %.3f after %.3f", op, l, got, previous)
+			}
+			previous = got
+		}
+		if got := op.Map(50, target, target); got != 50 {
+			t.Errorf("%s changed %v cd/m² when no compression is needed: %v", op, 50, got)
+		}
+	}
+	// Below its knee the EETF leaves luminance untouched.
+	if got := BT2390.Map(20, source, target); math.Abs(got-20) > 1e-6 {
+		t.Errorf("bt2390 maps 20 to %.4f", got)
+	}
+	if _, err := ParseOperator("aces"); err == nil {
+		t.Error("ParseOperator(\"aces\") expected error")
+	}
+}
+
+func TestToneMapKeepsHue(t *testing.T) {
+	src := NewImage(image.Rect(0, 0, 1, 1), 4000)
+	copy(src.Pix, []float32{3000, 1500, 300, 1})
+
+	got := ToneMap(src, Hable, SDRWhite)
+	r, g, b := got.Pix[0], got.Pix[1], got.Pix[2]
+	if r > SDRWhite || math.Abs(float64(g/r)-0.5) > 1e-6 || math.Abs(float64(b/r)-0.1) > 1e-6 {
+		t.Errorf("tone mapped to %v, %v, %v; want the 10:5:1 ratio below %d", r, g, b, SDRWhite)
+	}
+	if got.Peak != SDRWhite {
+		t.Errorf("Peak = %v, want %d", got.Peak, SDRWhite)
+	}
+}
@@ -0,0 +1,118 @@
+package hdr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"video-processor/internal/colorspace"
+)
+
+// Operator is a tone mapping curve, compressing luminance up to the
+// content peak into the range of a dimmer display.
+type Operator int
+
+const (
+	// Reinhard is the extended Reinhard curve, reaching the target exactly
+	// at the content peak. It is simple and keeps midtones, but flattens
+	// contrast across the whole range.
+	Reinhard Operator = iota
+	// Hable is the filmic curve from Uncharted 2, with a soft toe and
+	// shoulder. It looks punchier than Reinhard and slightly darker.
+	Hable
+	// BT2390 is the ITU-R BT.2390 EETF: identity below a knee, then a
+	// Hermite spline in the PQ domain up to the target peak. It leaves
+	// most of the picture untouched.
+	BT2390
+)
+
+var operatorNames = map[string]Operator{
+	"reinhard": Reinhard,
+	"hable":    Hable,
+	"bt2390":   BT2390,
+	"bt.2390":  BT2390,
+}
+
+func ParseOperator(name string) (Operator, error) {
+	if op, ok := operatorNames[strings.ToLower(name)]; ok {
+		return op, nil
+	}
+	return 0, fmt.Errorf("unknown tone mapping operator %q (available: %s)", name, strings.Join(OperatorNames(), ", "))
+}
+
+// OperatorNames lists the operator names understood by ParseOperator.
+func OperatorNames() []string {
+	return []string{"reinhard", "hable", "bt2390"}
+}
+
+func (o Operator) String() string {
+	switch o {
+	case Hable:
+		return "hable"
+	case BT2390:
+		return "bt2390"
+	}
+	return "reinhard"
+}
+
+// Map compresses a luminance in cd/m² from content reaching source to a
+// display reaching target. The result never exceeds target.
+func (o Operator) Map(l, source, target float64) float64 {
+	if l <= 0 {
+		return 0
+	}
+	if source <= target {
+		return math.Min(l, target)
+	}
+	l = math.Min(l, source)
+	switch o {
+	case Hable:
+		return hable(l/target) / hable(source/target) * target
+	case BT2390:
+		return bt2390(l, source, target)
+	}
+	x, w := l/target, source/target
+	return x * (1 + x/(w*w)) / (1 + x) * target
+}
+
+// hable is the Uncharted 2 filmic curve.
+func hable(x float64) float64 {
+	const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+	return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+}
+
+// bt2390 applies the BT.2390 EETF for a display with zero black level.
+func bt2390(l, source, target float64) float64 {
+	pq := colorspace.TransferPQ
+	sourcePQ := pq.FromLinear(source / PQPeak)
+	e := pq.FromLinear(l/PQPeak) / sourcePQ
+	maxLum := pq.FromLinear(target/PQPeak) / sourcePQ
+
+	knee := 1.5*maxLum - 0.5
+	if e > knee {
+		t := (e - knee) / (1 - knee)
+		t2, t3 := t*t, t*t*t
+		e = (2*t3-3*t2+1)*knee + (t3-2*t2+t)*(1-knee) + (-2*t3+3*t2)*maxLum
+	}
+	return math.Min(pq.ToLinear(e*sourcePQ)*PQPeak, target)
+}
+
+// ToneMap compresses src from its Peak to target cd/m² with op. Each pixel
+// is scaled by the ratio its largest channel is mapped by, which keeps hue
+// and saturation and cannot push a channel past the target.
+func ToneMap(src *Image, op Operator, target float64) *Image {
+	dst := NewImage(src.Rect, math.Min(src.Peak, target))
+	for y := 0; y < src.Rect.Dy(); y++ {
+		in := src.Pix[y*src.Stride : y*src.Stride+4*src.Rect.Dx()]
+		out := dst.Pix[y*dst.Stride:]
+		for i := 0; i < len(in); i += 4 {
+			m := math.Max(float64(in[i]), math.Max(float64(in[i+1]), float64(in[i+2])))
+			scale := float32(0)
+			if m > 0 {
+				scale = float32(op.Map(m, src.Peak, target) / m)
+			}
+			out[i], out[i+1], out[i+2], out[i+3] = in[i]*scale, in[i+1]*scale, in[i+2]*scale, in[i+3]
+		}
+	}
+	return dst
+}
@@ -0,0 +1,128 @@
+package icc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf16"
+
+	"video-processor/internal/colorspace"
+)
+
+// d50 is the ICC profile connection space illuminant.
+var d50 = [3]float64{0.9642, 1, 0.8249}
+
+// parametric returns the ICC parametric function type and parameters of a
+// transfer curve, or false for curves it cannot express.
+func parametric(t colorspace.Transfer) (uint16, []float64, bool) {
+	switch t {
+	case colorspace.TransferSRGB:
+		return 3, []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}, true
+	case colorspace.TransferBT709:
+		return 3, []float64{1 / 0.45, 1 / 1.099, 0.099 / 1.099, 1 / 4.5, 0.081}, true
+	case colorspace.TransferGamma22:
+		return 0, []float64{563.0 / 256}, true
+	case colorspace.TransferGamma26:
+		return 0, []float64{2.6}, true
+	case colorspace.TransferROMM:
+		return 3, []float64{1.8, 1, 0, 1.0 / 16, 16.0 / 512}, true
+	case colorspace.TransferLinear:
+		return 0, []float64{1}, true
+	case colorspace.TransferBT1886:
+		return 0, []float64{2.4}, true
+	}
+	return 0, nil, false
+}
+
+// Encode writes a version 4 display profile describing space with its
+// colorants and a parametric curve. HDR curves such as PQ cannot be
+// expressed that way.
+func Encode(space colorspace.RGBSpace) ([]byte, error) {
+	kind, params, ok := parametric(space.Transfer)
+	if !ok {
+		return nil, fmt.Errorf("cannot describe %s transfer in an ICC profile", space.Transfer)
+	}
+	m := space.ToXYZ(colorspace.D50)
+	chad := colorspace.ChromaticAdaptation(space.White, colorspace.D50)
+
+	curve := append([]byte("para\x00\x00\x00\x00"), byte(kind>>8), byte(kind), 0, 0)
+	for _, p := range params {
+		curve = appendS15Fixed16(curve, p)
+	}
+	xyz := func(v [3]float64) []byte {
+		return appendS15Fixed16(appendS15Fixed16(appendS15Fixed16([]byte("XYZ \x00\x00\x00\x00"), v[0]), v[1]), v[2])
+	}
+	sf32 := []byte("sf32\x00\x00\x00\x00")
+	for _, row := range chad {
+		for _, v := range row {
+			sf32 = appendS15Fixed16(sf32, v)
+		}
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", mluc(space.Name)},
+		{"cprt", mluc("No copyright, use freely")},
+		{"wtpt", xyz(d50)},
+		{"chad", sf32},
+		{"rXYZ", xyz([3]float64{m[0][0], m[1][0], m[2][0]})},
+		{"gXYZ", xyz([3]float64{m[0][1], m[1][1], m[2][1]})},
+		{"bXYZ", xyz([3]float64{m[0][2], m[1][2], m[2][2]})},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[8:], 0x04300000)
+	copy(header[12:], "mntrRGB XYZ ")
+	copy(header[36:], "acsp")
+	for i, v := range d50 {
+		binary.BigEndian.PutUint32(header[68+4*i:], uint32(int32(math.Round(v*65536))))
+	}
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var body []byte
+	offset := headerSize + 4 + 12*len(tags)
+	// The three curves are the same, so they share one copy
+	shared := map[string]uint32{}
+	for _, tag := range tags {
+		at, ok := shared[string(tag.data)]
+		if !ok {
+			at = uint32(offset + len(body))
+			shared[string(tag.data)] = at
+			body = append(body, tag.data...)
+			for len(body)%4 != 0 {
+				body = append(body, 0)
+			}
+		}
+		table = append(table, tag.sig...)
+		table = binary.BigEndian.AppendUint32(table, at)
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+	}
+
+	profile := append(append(header, table...), body...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile, nil
+}
+
+func appendS15Fixed16(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+}
+
+// mluc encodes text as a multiLocalizedUnicode tag with one en-US record.
+func mluc(text string) []byte {
+	units := utf16.Encode([]rune(text))
+	b := []byte("mluc\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint32(b, 12)
+	b = append(b, "enUS"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(2*len(units)))
+	b = binary.BigEndian.AppendUint32(b, 28)
+	for _, u := range units {
+		b = binary.BigEndian.AppendUint16(b, u)
+	}
+	return b
+}
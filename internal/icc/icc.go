@@ -0,0 +1,292 @@
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+
+	"video-processor/internal/colorspace"
+)
+
+// headerSize is the length of the fixed profile header; the tag table
+// follows it.
+const headerSize = 128
+
+// Profile is an RGB matrix/TRC profile: three curves decode the channels to
+// linear light and a matrix takes that to the D50 XYZ profile connection
+// space.
+type Profile struct {
+	Description string
+	// Version is the profile format version, e.g. 0x02100000 for 2.1.
+	Version uint32
+	// ToXYZ is row-major, so its columns are the red, green and blue
+	// colorants.
+	ToXYZ  [3][3]float64
+	Curves [3]Curve
+}
+
+// Curve is a tone reproduction curve, decoding an encoded channel value to
+// linear light.
+type Curve struct {
+	// table samples the curve evenly over 0-1; when it is nil the curve is
+	// ICC parametric function kind with params g, a, b, c, d, e, f.
+	table  []float64
+	kind   int
+	params [7]float64
+}
+
+// Gamma returns a pure power curve.
+func Gamma(g float64) Curve {
+	return Curve{params: [7]float64{g}}
+}
+
+// ToLinear decodes v, clamped to 0-1, to linear light in 0-1.
+func (c Curve) ToLinear(v float64) float64 {
+	v = min(max(v, 0), 1)
+	if c.table != nil {
+		if len(c.table) == 1 {
+			return c.table[0]
+		}
+		pos := v * float64(len(c.table)-1)
+		i := min(int(pos), len(c.table)-2)
+		frac := pos - float64(i)
+		return c.table[i] + frac*(c.table[i+1]-c.table[i])
+	}
+
+	g, a, b, cc, d, e, f := c.params[0], c.params[1], c.params[2], c.params[3], c.params[4], c.params[5], c.params[6]
+	power := func(x float64) float64 {
+		if x <= 0 {
+			return 0
+		}
+		return math.Pow(x, g)
+	}
+	var y float64
+	switch c.kind {
+	case 0:
+		y = power(v)
+	case 1:
+		if v >= -b/a {
+			y = power(a*v + b)
+		}
+	case 2:
+		y = cc
+		if v >= -b/a {
+			y += power(a*v + b)
+		}
+	case 3:
+		y = cc * v
+		if v >= d {
+			y = power(a*v + b)
+		}
+	case 4:
+		y = cc*v + f
+		if v >= d {
+			y = power(a*v+b) + e
+		}
+	}
+	return min(max(y, 0), 1)
+}
+
+// paramCounts is the number of parameters of each parametric function.
+var paramCounts = [...]int{1, 3, 4, 5, 7}
+
+// Parse reads an ICC profile. Only RGB profiles described by colorants and
+// curves are supported; those built on lookup tables are rejected.
+func Parse(data []byte) (*Profile, error) {
+	if len(data) < headerSize+4 {
+		return nil, errors.New("ICC profile too short")
+	}
+	if string(data[36:40]) != "acsp" {
+		return nil, errors.New("not an ICC profile")
+	}
+	size := binary.BigEndian.Uint32(data)
+	if size < headerSize+4 || int64(size) > int64(len(data)) {
+		return nil, fmt.Errorf("invalid ICC profile size %d", size)
+	}
+	data = data[:size]
+	if space := string(data[16:20]); space != "RGB " {
+		return nil, fmt.Errorf("unsupported ICC colour space %q", space)
+	}
+
+	count := binary.BigEndian.Uint32(data[headerSize:])
+	if int64(count) > int64(len(data)-headerSize-4)/12 {
+		return nil, fmt.Errorf("invalid ICC tag count %d", count)
+	}
+	tags := make(map[string][]byte, count)
+	for i := 0; i < int(count); i++ {
+		entry := data[headerSize+4+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if int64(offset)+int64(size) > int64(len(data)) || size < 8 {
+			return nil, fmt.Errorf("ICC tag %q out of bounds", entry[:4])
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	for _, sig := range []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"} {
+		if _, ok := tags[sig]; ok {
+			continue
+		}
+		if _, ok := tags["A2B0"]; ok {
+			return nil, errors.New("LUT-based ICC profiles are not supported")
+		}
+		return nil, fmt.Errorf("ICC profile has no %s tag", sig)
+	}
+	if pcs := string(data[20:24]); pcs != "XYZ " {
+		return nil, fmt.Errorf("unsupported ICC connection space %q", pcs)
+	}
+
+	p := &Profile{Version: binary.BigEndian.Uint32(data[8:])}
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := readXYZ(tags[sig])
+		if err != nil {
+			return nil, fmt.Errorf("ICC tag %s: %w", sig, err)
+		}
+		for row := range xyz {
+			p.ToXYZ[row][i] = xyz[row]
+		}
+	}
+	for i, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		var err error
+		if p.Curves[i], err = readCurve(tags[sig]); err != nil {
+			return nil, fmt.Errorf("ICC tag %s: %w", sig, err)
+		}
+	}
+	// A missing or unreadable description costs nothing but a name
+	if desc, ok := tags["desc"]; ok {
+		p.Description, _ = readText(desc)
+	}
+	return p, nil
+}
+
+// s15Fixed16 decodes the ICC signed 15.16 fixed point number at b.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func readXYZ(tag []byte) ([3]float64, error) {
+	if string(tag[:4]) != "XYZ " || len(tag) < 20 {
+		return [3]float64{}, fmt.Errorf("unsupported type %q, want XYZ", tag[:4])
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+func readCurve(tag []byte) (Curve, error) {
+	switch string(tag[:4]) {
+	case "curv":
+		if len(tag) < 12 {
+			return Curve{}, errors.New("curve too short")
+		}
+		n := binary.BigEndian.Uint32(tag[8:])
+		if int64(n) > int64(len(tag)-12)/2 {
+			return Curve{}, fmt.Errorf("curve of %d entries too long", n)
+		}
+		switch n {
+		case 0:
+			return Gamma(1), nil
+		case 1:
+			// A single entry is a u8Fixed8 gamma
+			return Gamma(float64(binary.BigEndian.Uint16(tag[12:])) / 256), nil
+		}
+		c := Curve{table: make([]float64, n)}
+		for i := range c.table {
+			c.table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return c, nil
+	case "para":
+		if len(tag) < 12 {
+			return Curve{}, errors.New("parametric curve too short")
+		}
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		if kind >= len(paramCounts) {
+			return Curve{}, fmt.Errorf("unknown parametric curve type %d", kind)
+		}
+		if len(tag) < 12+4*paramCounts[kind] {
+			return Curve{}, errors.New("parametric curve too short")
+		}
+		c := Curve{kind: kind}
+		for i := 0; i < paramCounts[kind]; i++ {
+			c.params[i] = s15Fixed16(tag[12+4*i:])
+		}
+		if kind > 0 && c.params[1] == 0 {
+			return Curve{}, errors.New("parametric curve has a zero slope")
+		}
+		return c, nil
+	}
+	return Curve{}, fmt.Errorf("unsupported curve type %q", tag[:4])
+}
+
+// readText decodes a version 2 textDescription or version 4
+// multiLocalizedUnicode tag, preferring English.
+func readText(tag []byte) (string, error) {
+	switch string(tag[:4]) {
+	case "desc":
+		if len(tag) < 12 {
+			return "", errors.New("description too short")
+		}
+		n := binary.BigEndian.Uint32(tag[8:])
+		if int64(n) > int64(len(tag)-12) {
+			return "", errors.New("description too long")
+		}
+		text := tag[12 : 12+n]
+		for i, c := range text {
+			if c == 0 {
+				text = text[:i]
+				break
+			}
+		}
+		return string(text), nil
+	case "mluc":
+		if len(tag) < 16 {
+			return "", errors.New("description too short")
+		}
+		count, size := binary.BigEndian.Uint32(tag[8:]), binary.BigEndian.Uint32(tag[12:])
+		if size < 12 || int64(count)*int64(size) > int64(len(tag)-16) {
+			return "", errors.New("invalid description records")
+		}
+		var text string
+		for i := 0; i < int(count); i++ {
+			record := tag[16+i*int(size):]
+			n, offset := binary.BigEndian.Uint32(record[4:]), binary.BigEndian.Uint32(record[8:])
+			if int64(offset)+int64(n) > int64(len(tag)) {
+				return "", errors.New("description out of bounds")
+			}
+			units := make([]uint16, n/2)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(tag[int(offset)+2*j:])
+			}
+			if i == 0 || string(record[:2]) == "en" {
+				text = string(utf16.Decode(units))
+			}
+			if string(record[:2]) == "en" {
+				break
+			}
+		}
+		return text, nil
+	}
+	return "", fmt.Errorf("unsupported text type %q", tag[:4])
+}
+
+// Matches reports whether p describes space to within what 8-bit output
+// could show, so that converting between them would change nothing but
+// rounding.
+func (p *Profile) Matches(space colorspace.RGBSpace) bool {
+	want := space.ToXYZ(colorspace.D50)
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(p.ToXYZ[i][j]-want[i][j]) > 0.002 {
+				return false
+			}
+		}
+	}
+	for _, c := range p.Curves {
+		for i := 0; i <= 64; i++ {
+			v := float64(i) / 64
+			if math.Abs(c.ToLinear(v)-space.Transfer.ToLinear(v)) > 0.002 {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package icc
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"video-processor/internal/colorspace"
+)
+
+type tag struct {
+	sig  string
+	data []byte
+}
+
+// build assembles a profile of colour space and connection space pcs
+// holding tags, each stored separately.
+func build(space, pcs string, tags ...tag) []byte {
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr"+space+pcs)
+	copy(header[36:], "acsp")
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var body []byte
+	for _, t := range tags {
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(headerSize+4+12*len(tags)+len(body)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.data)))
+		body = append(body, t.data...)
+	}
+	profile := append(append(header, table...), body...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+func xyzTag(x, y, z float64) []byte {
+	return appendS15Fixed16(appendS15Fixed16(appendS15Fixed16([]byte("XYZ \x00\x00\x00\x00"), x), y), z)
+}
+
+func curv(entries ...uint16) []byte {
+	b := binary.BigEndian.AppendUint32([]byte("curv\x00\x00\x00\x00"), uint32(len(entries)))
+	for _, e := range entries {
+		b = binary.BigEndian.AppendUint16(b, e)
+	}
+	return b
+}
+
+// colorants are Adobe RGB's, adapted to D50.
+func colorants() []tag {
+	return []tag{
+		{"rXYZ", xyzTag(0.6097, 0.3111, 0.0195)},
+		{"gXYZ", xyzTag(0.2053, 0.6257, 0.0609)},
+		{"bXYZ", xyzTag(0.1492, 0.0632, 0.7446)},
+	}
+}
+
+func rgbSpace(t *testing.T, name string) colorspace.RGBSpace {
+	t.Helper()
+	s, err := colorspace.RGBSpaceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestEncode(t *testing.T) {
+	srgb := rgbSpace(t, "srgb")
+	for _, name := range []string{"srgb", "displayp3", "adobergb", "prophoto", "rec709"} {
+		t.Run(name, func(t *testing.T) {
+			space := rgbSpace(t, name)
+			data, err := Encode(space)
+			if err != nil {
+				t.Fatalf("Encode() unexpected error: %v", err)
+			}
+			p, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse() unexpected error: %v", err)
+			}
+			if p.Description != name {
+				t.Errorf("Description = %q, want %q", p.Description, name)
+			}
+			if p.Version != 0x04300000 {
+				t.Errorf("Version = %#x, want 4.3", p.Version)
+			}
+			if !p.Matches(space) {
+				t.Error("profile does not match the space it was made from")
+			}
+			if p.Matches(srgb) != (name == "srgb") {
+				t.Errorf("Matches(srgb) = %v", p.Matches(srgb))
+			}
+			// RGB 1,1,1 is the connection space white.
+			for i, want := range d50 {
+				if got := p.ToXYZ[i][0] + p.ToXYZ[i][1] + p.ToXYZ[i][2]; math.Abs(got-want) > 1e-3 {
+					t.Errorf("white[%d] = %.4f, want %.4f", i, got, want)
+				}
+			}
+		})
+	}
+
+	if _, err := Encode(colorspace.RGBSpace{Name: "pq", Transfer: colorspace.TransferPQ}); err == nil {
+		t.Error("Encode() with a PQ transfer succeeded")
+	}
+}
+
+func TestParseVersion2(t *testing.T) {
+	table := make([]uint16, 1024)
+	for i := range table {
+		table[i] = uint16(math.Round(colorspace.TransferSRGB.ToLinear(float64(i)/1023) * 65535))
+	}
+	desc := append(binary.BigEndian.AppendUint32([]byte("desc\x00\x00\x00\x00"), 10), "Adobe RGB\x00"...)
+	data := build("RGB ", "XYZ ", append(colorants(),
+		tag{"rTRC", curv(563)},
+		tag{"gTRC", curv(table...)},
+		tag{"bTRC", curv()},
+		tag{"desc", desc},
+	)...)
+
+	p, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() unexpected error: %v", err)
+	}
+	if p.Description != "Adobe RGB" {
+		t.Errorf("Description = %q, want Adobe RGB", p.Description)
+	}
+	if math.Abs(p.ToXYZ[1][0]-0.3111) > 1e-4 || math.Abs(p.ToXYZ[0][1]-0.2053) > 1e-4 {
+		t.Errorf("ToXYZ = %v, want the colorants as columns", p.ToXYZ)
+	}
+	tests := []struct {
+		curve int
+		want  func(float64) float64
+	}{
+		{0, colorspace.TransferGamma22.ToLinear},
+		{1, colorspace.TransferSRGB.ToLinear},
+		{2, func(v float64) float64 { return v }},
+	}
+	for _, tt := range tests {
+		for _, v := range []float64{0, 0.02, 0.25, 0.5, 0.9, 1} {
+			if got, want := p.Curves[tt.curve].ToLinear(v), tt.want(v); math.Abs(got-want) > 1e-4 {
+				t.Errorf("curve %d at %g = %.5f, want %.5f", tt.curve, v, got, want)
+			}
+		}
+	}
+	if p.Matches(rgbSpace(t, "adobergb")) {
+		t.Error("profile with mixed curves matches Adobe RGB")
+	}
+}
+
+func TestParametricCurves(t *testing.T) {
+	para := func(kind uint16, params ...float64) Curve {
+		b := append([]byte("para\x00\x00\x00\x00"), byte(kind>>8), byte(kind), 0, 0)
+		for _, p := range params {
+			b = appendS15Fixed16(b, p)
+		}
+		c, err := readCurve(b)
+		if err != nil {
+			t.Fatalf("readCurve() type %d unexpected error: %v", kind, err)
+		}
+		return c
+	}
+	tests := []struct {
+		name  string
+		curve Curve
+		v     float64
+		want  float64
+	}{
+		{"gamma", para(0, 2), 0.5, 0.25},
+		{"offset below", para(1, 1, 2, -0.5), 0.2, 0},
+		{"offset", para(1, 1, 2, -0.5), 0.5, 0.5},
+		{"floor", para(2, 1, 1, 0, 0.25), 0, 0.25},
+		{"linear segment", para(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045), 0.04, 0.04 / 12.92},
+		{"power segment", para(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045), 0.5, colorspace.TransferSRGB.ToLinear(0.5)},
+		{"offsets", para(4, 1, 1, 0, 0.5, 0.5, 0.1, 0.2), 0.2, 0.3},
+		{"clamped", para(4, 1, 1, 0, 0.5, 0.5, 0.9, 0.2), 0.8, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.curve.ToLinear(tt.v); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("%s: ToLinear(%g) = %.5f, want %.5f", tt.name, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	curves := []tag{{"rTRC", curv()}, {"gTRC", curv()}, {"bTRC", curv()}}
+	valid := build("RGB ", "XYZ ", append(colorants(), curves...)...)
+	notProfile := append([]byte(nil), valid...)
+	copy(notProfile[36:], "xxxx")
+	truncated := valid[:len(valid)-4]
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"short", valid[:100], "too short"},
+		{"signature", notProfile, "not an ICC profile"},
+		{"truncated", truncated, "invalid ICC profile size"},
+		{"gray", build("GRAY", "XYZ ", tag{"kTRC", curv()}), "unsupported ICC colour space"},
+		{"lut", build("RGB ", "Lab ", tag{"A2B0", []byte("mft2\x00\x00\x00\x00")}), "LUT-based"},
+		{"missing", build("RGB ", "XYZ ", colorants()...), "no rTRC tag"},
+		{"lab matrix", build("RGB ", "Lab ", append(colorants(), curves...)...), "unsupported ICC connection space"},
+		{"bad curve", build("RGB ", "XYZ ", append(colorants(), tag{"rTRC", []byte("mft1\x00\x00\x00\x00")}, curves[1], curves[2])...), "unsupported curve type"},
+		{"short curve", build("RGB ", "XYZ ", append(colorants(), tag{"rTRC", curv(1, 2)[:13]}, curves[1], curves[2])...), "too long"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.data); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Parse() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
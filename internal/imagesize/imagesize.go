@@ -0,0 +1,13 @@
+package imagesize
+
+// MaxPixels bounds the width x height a decoder accepts from a header before
+// allocating the image. 2^28 pixels is 1 GiB of 8-bit RGBA, far beyond any
+// real image, yet small enough that a corrupt or hostile header cannot make
+// a decoder exhaust memory.
+const MaxPixels = 1 << 28
+
+// Valid reports whether a width x height image is non-empty and within
+// MaxPixels, without overflowing.
+func Valid(width, height int) bool {
+	return width > 0 && height > 0 && width <= MaxPixels/height
+}
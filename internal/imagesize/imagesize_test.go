@@ -0,0 +1,26 @@
+package imagesize
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          bool
+	}{
+		{1, 1, true},
+		{1 << 14, 1 << 14, true},
+		{1<<14 + 1, 1 << 14, false},
+		{MaxPixels, 1, true},
+		{0, 10, false},
+		{10, -1, false},
+		{math.MaxInt, math.MaxInt, false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.width, tt.height); got != tt.want {
+			t.Errorf("Valid(%d, %d) = %v, want %v", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
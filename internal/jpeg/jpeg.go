@@ -0,0 +1,248 @@
+package jpeg
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	stdjpeg "image/jpeg"
+	"io"
+	"math"
+)
+
+// DefaultQuality is the quality used when Options leaves it unset. It is
+// higher than image/jpeg's default, as output is usually final.
+const DefaultQuality = 95
+
+// maxDimension is the largest width or height a JPEG frame header holds.
+const maxDimension = 65535
+
+// Options configures Encode.
+type Options struct {
+	// Quality ranges from 1 to 100; zero means DefaultQuality.
+	Quality int
+	// Progressive writes the coefficients in several scans, so a partial
+	// download shows the whole picture at low detail.
+	Progressive bool
+}
+
+// Encode writes m as a JPEG. Baseline output comes from image/jpeg;
+// progressive output uses the same quantization and colour conversion,
+// with Huffman tables optimized for each scan.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	opts := Options{Quality: DefaultQuality}
+	if o != nil {
+		opts = *o
+	}
+	if opts.Quality == 0 {
+		opts.Quality = DefaultQuality
+	}
+	if opts.Quality < 1 || opts.Quality > 100 {
+		return fmt.Errorf("JPEG quality %d out of range (1-100)", opts.Quality)
+	}
+	if !opts.Progressive {
+		return stdjpeg.Encode(w, m, &stdjpeg.Options{Quality: opts.Quality})
+	}
+
+	bounds := m.Bounds()
+	if bounds.Empty() || bounds.Dx() > maxDimension || bounds.Dy() > maxDimension {
+		return fmt.Errorf("cannot encode %dx%d image as JPEG", bounds.Dx(), bounds.Dy())
+	}
+	e := newEncoder(m, opts.Quality)
+	bw := bufio.NewWriter(w)
+	e.writeProgressive(bw)
+	return bw.Flush()
+}
+
+// unscaledQuant holds the quantization tables of image/jpeg, from section
+// K.1 of the specification, in zig-zag order.
+var unscaledQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// unzig maps zig-zag order to natural order.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// scaleQuant scales the tables for quality the way image/jpeg does.
+func scaleQuant(quality int) (quant [2][64]int) {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i := range quant {
+		for j, q := range unscaledQuant[i] {
+			quant[i][j] = min(max((q*scale+50)/100, 1), 255)
+		}
+	}
+	return quant
+}
+
+// component is one colour plane cut into quantized blocks. The block grid
+// covers whole MCUs; blocksWide and blocksHigh count the blocks that
+// cover the plane itself, which is all a single-component scan visits.
+type component struct {
+	id                     byte
+	sampling               int
+	table                  int
+	stride                 int
+	blocksWide, blocksHigh int
+	// blocks hold coefficients in zig-zag order.
+	blocks [][64]int32
+}
+
+type encoder struct {
+	width, height int
+	quant         [2][64]int
+	components    []*component
+	// mcusWide and mcusHigh count MCUs of 8 or, with subsampled chroma,
+	// 16 pixels square.
+	mcusWide, mcusHigh int
+}
+
+// newEncoder converts m to quantized DCT blocks: greyscale for *image.Gray
+// and otherwise YCbCr with 4:2:0 chroma, as image/jpeg writes.
+func newEncoder(m image.Image, quality int) *encoder {
+	bounds := m.Bounds()
+	e := &encoder{width: bounds.Dx(), height: bounds.Dy(), quant: scaleQuant(quality)}
+
+	planes := make([][]uint8, 3)
+	if gray, ok := m.(*image.Gray); ok {
+		planes = planes[:1]
+		planes[0] = make([]uint8, e.width*e.height)
+		for y := 0; y < e.height; y++ {
+			copy(planes[0][y*e.width:], gray.Pix[gray.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:e.width])
+		}
+	} else {
+		for i := range planes {
+			planes[i] = make([]uint8, e.width*e.height)
+		}
+		for y := 0; y < e.height; y++ {
+			for x := 0; x < e.width; x++ {
+				r, g, b, _ := m.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				i := y*e.width + x
+				planes[0][i], planes[1][i], planes[2][i] = color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			}
+		}
+	}
+
+	mcuSize := 8
+	if len(planes) == 3 {
+		mcuSize = 16
+	}
+	e.mcusWide = (e.width + mcuSize - 1) / mcuSize
+	e.mcusHigh = (e.height + mcuSize - 1) / mcuSize
+	for i, plane := range planes {
+		c := &component{id: byte(i + 1), sampling: 1}
+		if i == 0 && len(planes) == 3 {
+			c.sampling = 2
+		}
+		if i > 0 {
+			c.table = 1
+		}
+		// Chroma planes are averaged over 2x2 pixels.
+		factor := mcuSize / 8 / c.sampling
+		planeWidth := (e.width + factor - 1) / factor
+		planeHeight := (e.height + factor - 1) / factor
+		c.blocksWide, c.blocksHigh = (planeWidth+7)/8, (planeHeight+7)/8
+		c.stride = e.mcusWide * c.sampling
+		c.blocks = make([][64]int32, c.stride*e.mcusHigh*c.sampling)
+		e.components = append(e.components, c)
+
+		var samples [64]float64
+		for by := 0; by < e.mcusHigh*c.sampling; by++ {
+			for bx := 0; bx < c.stride; bx++ {
+				for j := 0; j < 64; j++ {
+					samples[j] = e.sample(plane, factor, bx*8+j%8, by*8+j/8) - 128
+				}
+				e.quantize(&c.blocks[by*c.stride+bx], fdct(&samples), c.table)
+			}
+		}
+	}
+	return e
+}
+
+// sample returns the plane value at (x, y) in a plane subsampled by
+// factor, repeating the edge pixels past the image.
+func (e *encoder) sample(plane []uint8, factor, x, y int) float64 {
+	sum := 0
+	for dy := 0; dy < factor; dy++ {
+		for dx := 0; dx < factor; dx++ {
+			px := min(x*factor+dx, e.width-1)
+			py := min(y*factor+dy, e.height-1)
+			sum += int(plane[py*e.width+px])
+		}
+	}
+	return float64(sum) / float64(factor*factor)
+}
+
+func (e *encoder) quantize(dst *[64]int32, coefficients [64]float64, table int) {
+	for k, n := range unzig {
+		dst[k] = int32(math.Round(coefficients[n] / float64(e.quant[table][k])))
+	}
+}
+
+// dctCos[u][x] is C(u)/2 * cos((2x+1)uπ/16).
+var dctCos = func() (c [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		scale := 0.5
+		if u == 0 {
+			scale = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			c[u][x] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return c
+}()
+
+// fdct is the forward DCT of level-shifted samples, in natural order.
+func fdct(samples *[64]float64) (out [64]float64) {
+	var rows [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 8; x++ {
+				sum += dctCos[u][x] * samples[y*8+x]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < 8; y++ {
+				sum += dctCos[v][y] * rows[y*8+u]
+			}
+			out[v*8+u] = sum
+		}
+	}
+	return out
+}
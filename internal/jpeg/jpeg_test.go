@@ -0,0 +1,168 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	stdjpeg "image/jpeg"
+	"math"
+	"strings"
+	"testing"
+)
+
+func gradient(width, height int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x + y) * 2), 255})
+		}
+	}
+	return m
+}
+
+// psnr compares the RGB channels of two images of the same size.
+func psnr(a, b image.Image) float64 {
+	var sum float64
+	n := 0
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x-bounds.Min.X, y-bounds.Min.Y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+				n++
+			}
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(n)))
+}
+
+func encode(t *testing.T, m image.Image, opts *Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, opts); err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProgressive(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 37, 23))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7)
+	}
+	tests := []struct {
+		name string
+		m    image.Image
+	}{
+		{"1x1", gradient(1, 1)},
+		// Sizes that are not whole MCUs leave the chroma planes with fewer
+		// blocks than the MCU grid.
+		{"17x9", gradient(17, 9)},
+		{"333x211", gradient(333, 211)},
+		{"offset", gradient(40, 40).SubImage(image.Rect(5, 7, 30, 33))},
+		{"gray", gray},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline, err := stdjpeg.Decode(bytes.NewReader(encode(t, tt.m, &Options{Quality: 90})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := encode(t, tt.m, &Options{Quality: 90, Progressive: true})
+			// SOF2 marks a progressive frame.
+			if !bytes.Contains(data, []byte{0xff, markerSOF2}) {
+				t.Error("output has no progressive frame header")
+			}
+			got, err := stdjpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			if got.Bounds().Size() != tt.m.Bounds().Size() {
+				t.Fatalf("size = %v, want %v", got.Bounds().Size(), tt.m.Bounds().Size())
+			}
+			if _, ok := tt.m.(*image.Gray); ok && got.ColorModel() != color.GrayModel {
+				t.Errorf("colour model = %v, want grey", got.ColorModel())
+			}
+			// The same quantization should give the same quality as
+			// image/jpeg's baseline output, to within rounding.
+			if p, want := psnr(tt.m, got), psnr(tt.m, baseline); p < want-0.5 {
+				t.Errorf("PSNR = %.2f dB, baseline %.2f dB", p, want)
+			}
+		})
+	}
+}
+
+func TestProgressiveEOBRun(t *testing.T) {
+	// A flat image of 256x130 blocks has more empty bands than one
+	// end-of-band run can count.
+	m := image.NewGray(image.Rect(0, 0, 2048, 1040))
+	for i := range m.Pix {
+		m.Pix[i] = 100
+	}
+	got, err := stdjpeg.Decode(bytes.NewReader(encode(t, m, &Options{Progressive: true})))
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	if p := psnr(m, got); p < 40 {
+		t.Errorf("PSNR = %.2f dB, want at least 40", p)
+	}
+}
+
+func TestQuality(t *testing.T) {
+	m := gradient(64, 64)
+	for _, progressive := range []bool{false, true} {
+		low := encode(t, m, &Options{Quality: 10, Progressive: progressive})
+		high := encode(t, m, &Options{Quality: 100, Progressive: progressive})
+		if len(low) >= len(high) {
+			t.Errorf("progressive %v: quality 10 gave %d bytes, quality 100 %d", progressive, len(low), len(high))
+		}
+	}
+	// A nil Options is DefaultQuality, not image/jpeg's default.
+	if !bytes.Equal(encode(t, m, nil), encode(t, m, &Options{Quality: DefaultQuality})) {
+		t.Error("nil Options did not use DefaultQuality")
+	}
+	for _, q := range []int{-1, 101} {
+		if err := Encode(&bytes.Buffer{}, m, &Options{Quality: q}); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("Encode() with quality %d error = %v, want out of range", q, err)
+		}
+	}
+}
+
+func TestOptimalTable(t *testing.T) {
+	// Fibonacci frequencies make the deepest possible tree, far beyond
+	// 16 bits before limiting.
+	var freq [256]int
+	a, b := 1, 1
+	for i := 0; i < 40; i++ {
+		freq[i] = a
+		a, b = b, a+b
+	}
+	table := optimalTable(&freq)
+	if len(table.values) != 40 {
+		t.Fatalf("table has %d symbols, want 40", len(table.values))
+	}
+	// The code must leave the all-ones code unused.
+	kraft := 0.0
+	for n, count := range table.counts {
+		kraft += float64(count) / float64(int(1)<<(n+1))
+	}
+	if kraft >= 1 {
+		t.Errorf("Kraft sum = %v, want below 1", kraft)
+	}
+	codes := table.codes()
+	for _, s := range table.values {
+		c := codes[s]
+		if c.length < 1 || c.length > 16 || c.bits == 1<<c.length-1 {
+			t.Errorf("symbol %d has code %b of length %d", s, c.bits, c.length)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package jpeg
+
+import (
+	"bufio"
+	"math/bits"
+)
+
+// Markers.
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOF2 = 0xc2
+	markerDHT  = 0xc4
+	markerSOS  = 0xda
+	markerDQT  = 0xdb
+)
+
+// maxEOBRun is the longest run of empty bands one symbol can code.
+const maxEOBRun = 0x7fff
+
+// scan is one pass over the coefficients from start to end inclusive, in
+// zig-zag order. DC scans cover every component, interleaved; AC scans
+// cover one. Successive approximation is not used.
+type scan struct {
+	components []int
+	start, end int
+}
+
+// scanScript sends the DC coefficients first, then the low frequency luma
+// coefficients, the chroma, and the rest of the luma.
+func (e *encoder) scanScript() []scan {
+	if len(e.components) == 1 {
+		return []scan{{[]int{0}, 0, 0}, {[]int{0}, 1, 5}, {[]int{0}, 6, 63}}
+	}
+	return []scan{
+		{[]int{0, 1, 2}, 0, 0},
+		{[]int{0}, 1, 5},
+		{[]int{1}, 1, 63},
+		{[]int{2}, 1, 63},
+		{[]int{0}, 6, 63},
+	}
+}
+
+func (e *encoder) writeProgressive(w *bufio.Writer) {
+	w.Write([]byte{0xff, markerSOI})
+
+	tables := 1
+	if len(e.components) == 3 {
+		tables = 2
+	}
+	dqt := []byte{}
+	for t := 0; t < tables; t++ {
+		dqt = append(dqt, byte(t))
+		for _, q := range e.quant[t] {
+			dqt = append(dqt, byte(q))
+		}
+	}
+	writeSegment(w, markerDQT, dqt)
+
+	sof := []byte{8, byte(e.height >> 8), byte(e.height), byte(e.width >> 8), byte(e.width), byte(len(e.components))}
+	for _, c := range e.components {
+		sof = append(sof, c.id, byte(c.sampling<<4|c.sampling), byte(c.table))
+	}
+	writeSegment(w, markerSOF2, sof)
+
+	for _, s := range e.scanScript() {
+		e.writeScan(w, s)
+	}
+	w.Write([]byte{0xff, markerEOI})
+}
+
+func writeSegment(w *bufio.Writer, marker byte, data []byte) {
+	n := len(data) + 2
+	w.Write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+	w.Write(data)
+}
+
+// writeScan codes s twice: once to count symbols, then with Huffman tables
+// built from the counts.
+func (e *encoder) writeScan(w *bufio.Writer, s scan) {
+	dc := s.start == 0
+	class := 1
+	if dc {
+		class = 0
+	}
+
+	counter := &huffmanWriter{}
+	e.codeScan(counter, s)
+	var dht []byte
+	var used [2]bool
+	for _, i := range s.components {
+		used[e.components[i].table] = true
+	}
+	var writer huffmanWriter
+	for t, ok := range used {
+		if !ok {
+			continue
+		}
+		table := optimalTable(&counter.counts[t])
+		writer.codes[t] = table.codes()
+		dht = append(dht, byte(class<<4|t))
+		dht = append(dht, table.counts[:]...)
+		dht = append(dht, table.values...)
+	}
+	writeSegment(w, markerDHT, dht)
+
+	sos := []byte{byte(len(s.components))}
+	for _, i := range s.components {
+		c := e.components[i]
+		selector := byte(c.table)
+		if dc {
+			selector <<= 4
+		}
+		sos = append(sos, c.id, selector)
+	}
+	sos = append(sos, byte(s.start), byte(s.end), 0)
+	writeSegment(w, markerSOS, sos)
+
+	writer.w = w
+	e.codeScan(&writer, s)
+	writer.flush()
+}
+
+// codeScan emits the symbols and extra bits of s to h.
+func (e *encoder) codeScan(h *huffmanWriter, s scan) {
+	if s.start == 0 {
+		// Interleaved scans visit blocks an MCU at a time; a scan of one
+		// component visits its own blocks in raster order.
+		var pred [3]int32
+		emit := func(i int, block *[64]int32) {
+			table := e.components[i].table
+			diff := block[0] - pred[i]
+			pred[i] = block[0]
+			n := magnitude(diff)
+			h.symbol(table, byte(n))
+			h.extra(diff, n)
+		}
+		if len(s.components) == 1 {
+			c := e.components[s.components[0]]
+			for by := 0; by < c.blocksHigh; by++ {
+				for bx := 0; bx < c.blocksWide; bx++ {
+					emit(s.components[0], &c.blocks[by*c.stride+bx])
+				}
+			}
+			return
+		}
+		for my := 0; my < e.mcusHigh; my++ {
+			for mx := 0; mx < e.mcusWide; mx++ {
+				for _, i := range s.components {
+					c := e.components[i]
+					for y := 0; y < c.sampling; y++ {
+						for x := 0; x < c.sampling; x++ {
+							emit(i, &c.blocks[(my*c.sampling+y)*c.stride+mx*c.sampling+x])
+						}
+					}
+				}
+			}
+		}
+		return
+	}
+
+	// An AC band is coded as runs of zeros ending in a non-zero value.
+	// Bands that are empty from some point on count towards a run of
+	// end-of-band codes, which is sent once a later band has data.
+	c := e.components[s.components[0]]
+	eobRun := 0
+	flushEOB := func() {
+		if eobRun == 0 {
+			return
+		}
+		n := bits.Len(uint(eobRun)) - 1
+		h.symbol(c.table, byte(n<<4))
+		h.bits(uint32(eobRun), n)
+		eobRun = 0
+	}
+	for by := 0; by < c.blocksHigh; by++ {
+		for bx := 0; bx < c.blocksWide; bx++ {
+			block := &c.blocks[by*c.stride+bx]
+			run := 0
+			for k := s.start; k <= s.end; k++ {
+				if block[k] == 0 {
+					run++
+					continue
+				}
+				flushEOB()
+				for ; run > 15; run -= 16 {
+					h.symbol(c.table, 0xf0)
+				}
+				n := magnitude(block[k])
+				h.symbol(c.table, byte(run<<4|n))
+				h.extra(block[k], n)
+				run = 0
+			}
+			if run > 0 {
+				if eobRun++; eobRun == maxEOBRun {
+					flushEOB()
+				}
+			}
+		}
+	}
+	flushEOB()
+}
+
+// magnitude is the number of bits in the absolute value of v, which is
+// the size category JPEG codes values by.
+func magnitude(v int32) int {
+	if v < 0 {
+		v = -v
+	}
+	return bits.Len32(uint32(v))
+}
+
+// huffmanWriter counts symbols when it has no writer, and otherwise codes
+// them, stuffing a zero byte after every 0xff.
+type huffmanWriter struct {
+	w      *bufio.Writer
+	counts [2][256]int
+	codes  [2][256]code
+	acc    uint32
+	nbits  int
+}
+
+type code struct {
+	bits   uint32
+	length int
+}
+
+func (h *huffmanWriter) symbol(table int, s byte) {
+	if h.w == nil {
+		h.counts[table][s]++
+		return
+	}
+	c := h.codes[table][s]
+	h.bits(c.bits, c.length)
+}
+
+// extra writes the n-bit representation of v that follows a size
+// category: v itself when positive, v-1 when negative.
+func (h *huffmanWriter) extra(v int32, n int) {
+	if v < 0 {
+		v--
+	}
+	h.bits(uint32(v), n)
+}
+
+// bits writes the low n bits of v, most significant first.
+func (h *huffmanWriter) bits(v uint32, n int) {
+	if h.w == nil || n == 0 {
+		return
+	}
+	h.acc = h.acc<<n | v&(1<<n-1)
+	h.nbits += n
+	for h.nbits >= 8 {
+		b := byte(h.acc >> (h.nbits - 8))
+		h.w.WriteByte(b)
+		if b == 0xff {
+			h.w.WriteByte(0)
+		}
+		h.nbits -= 8
+	}
+}
+
+// flush pads the last byte with one bits.
+func (h *huffmanWriter) flush() {
+	if h.nbits > 0 {
+		h.bits(1<<(8-h.nbits)-1, 8-h.nbits)
+	}
+}
+
+// huffmanTable is a table as DHT stores it: the number of codes of each
+// length from 1 to 16, and the symbols in code order.
+type huffmanTable struct {
+	counts [16]byte
+	values []byte
+}
+
+// optimalTable builds a table for the symbol frequencies, limited to
+// 16-bit codes and leaving the all-ones code unused, following section
+// K.2 of the specification.
+func optimalTable(freq *[256]int) huffmanTable {
+	// Before limiting, a code can be as long as there are symbols.
+	const maxLength = 257
+	var f [257]int
+	copy(f[:], freq[:])
+	// A reserved symbol guarantees no real code is all ones.
+	f[256] = 1
+	var size [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// c1 and c2 are the least frequent symbols, c1 the least.
+		c1, c2 := -1, -1
+		for i := range f {
+			if f[i] == 0 {
+				continue
+			}
+			if c1 < 0 || f[i] <= f[c1] {
+				c2, c1 = c1, i
+			} else if c2 < 0 || f[i] <= f[c2] {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		for size[c1]++; others[c1] >= 0; size[c1]++ {
+			c1 = others[c1]
+		}
+		others[c1] = c2
+		for size[c2]++; others[c2] >= 0; size[c2]++ {
+			c2 = others[c2]
+		}
+	}
+
+	var counts [maxLength + 1]int
+	for _, s := range size {
+		if s > 0 {
+			counts[s]++
+		}
+	}
+	// Shorten codes longer than 16 bits by moving pairs up to a shorter
+	// length, splitting a code there to keep the code complete.
+	for i := maxLength; i > 16; i-- {
+		for counts[i] > 0 {
+			j := i - 2
+			for counts[j] == 0 {
+				j--
+			}
+			counts[i] -= 2
+			counts[i-1]++
+			counts[j+1] += 2
+			counts[j]--
+		}
+	}
+	// Drop the reserved symbol, which has the longest code.
+	i := 16
+	for counts[i] == 0 {
+		i--
+	}
+	counts[i]--
+
+	var t huffmanTable
+	for n := 1; n <= 16; n++ {
+		t.counts[n-1] = byte(counts[n])
+	}
+	for n := 1; n <= maxLength; n++ {
+		for s := 0; s < 256; s++ {
+			if size[s] == n {
+				t.values = append(t.values, byte(s))
+			}
+		}
+	}
+	return t
+}
+
+// codes assigns canonical codes to the table's symbols.
+func (t huffmanTable) codes() (codes [256]code) {
+	next, k := uint32(0), 0
+	for n := 1; n <= 16; n++ {
+		for i := 0; i < int(t.counts[n-1]); i++ {
+			codes[t.values[k]] = code{next, n}
+			next++
+			k++
+		}
+		next <<= 1
+	}
+	return codes
+}
@@ -0,0 +1,185 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// JPEG markers.
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP0 = 0xe0
+	markerAPP1 = 0xe1
+	markerAPP2 = 0xe2
+	markerTEM  = 0x01
+	markerRST0 = 0xd0
+	markerRST7 = 0xd7
+)
+
+// Headers of the APPn segments that carry metadata.
+const (
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	iccHeader  = "ICC_PROFILE\x00"
+)
+
+// maxSegment is the most data a segment holds after its length.
+const maxSegment = 0xffff - 2
+
+// iccChunk is the most profile data one APP2 segment holds, after its
+// header and the sequence number and count.
+const iccChunk = maxSegment - len(iccHeader) - 2
+
+// ReadJPEG extracts the metadata of a JPEG file. Only the headers before
+// the first scan are read. An ICC profile with chunks missing is ignored.
+func ReadJPEG(r io.Reader) (*Metadata, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return nil, fmt.Errorf("failed to read JPEG header: %w", err)
+	}
+	if soi[0] != 0xff || soi[1] != markerSOI {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	m := &Metadata{}
+	icc := map[int][]byte{}
+	iccCount := 0
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return nil, err
+		}
+		if marker == markerSOS || marker == markerEOI {
+			break
+		}
+		if marker == markerTEM || marker >= markerRST0 && marker <= markerRST7 {
+			// Stand-alone markers have no length.
+			continue
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+		}
+		n := int(binary.BigEndian.Uint16(length[:])) - 2
+		if n < 0 {
+			return nil, fmt.Errorf("invalid JPEG segment length %d", n+2)
+		}
+		if marker != markerAPP1 && marker != markerAPP2 {
+			if _, err := br.Discard(n); err != nil {
+				return nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+			}
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+		}
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(data, []byte(exifHeader)) && m.EXIF == nil:
+			m.EXIF = data[len(exifHeader):]
+		case marker == markerAPP1 && bytes.HasPrefix(data, []byte(xmpHeader)) && m.XMP == nil:
+			m.XMP = data[len(xmpHeader):]
+		case marker == markerAPP2 && bytes.HasPrefix(data, []byte(iccHeader)) && len(data) >= len(iccHeader)+2:
+			// Chunks are numbered from 1 and each states the total.
+			seq, count := int(data[len(iccHeader)]), int(data[len(iccHeader)+1])
+			icc[seq] = data[len(iccHeader)+2:]
+			iccCount = count
+		}
+	}
+
+	if iccCount > 0 && len(icc) == iccCount {
+		seqs := make([]int, 0, len(icc))
+		for seq := range icc {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		if seqs[0] == 1 && seqs[len(seqs)-1] == iccCount {
+			for _, seq := range seqs {
+				m.ICC = append(m.ICC, icc[seq]...)
+			}
+		}
+	}
+	return m, nil
+}
+
+// nextMarker skips to the next marker and returns its code, ignoring the
+// fill bytes that may precede it.
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read JPEG marker: %w", err)
+	}
+	if b != 0xff {
+		return 0, fmt.Errorf("invalid JPEG marker byte 0x%02x", b)
+	}
+	for b == 0xff {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, fmt.Errorf("failed to read JPEG marker: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// EmbedJPEG writes the JPEG file data with m's blocks added after the
+// start of image marker and any JFIF segment, where readers look for them.
+func EmbedJPEG(w io.Writer, data []byte, m *Metadata) error {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return errors.New("not a JPEG file")
+	}
+	split := 2
+	if len(data) >= 6 && data[2] == 0xff && data[3] == markerAPP0 {
+		split = 4 + int(binary.BigEndian.Uint16(data[4:]))
+		if split > len(data) {
+			return errors.New("invalid JFIF segment")
+		}
+	}
+
+	var segments []byte
+	add := func(marker byte, parts ...[]byte) {
+		n := 2
+		for _, p := range parts {
+			n += len(p)
+		}
+		segments = append(segments, 0xff, marker, byte(n>>8), byte(n))
+		for _, p := range parts {
+			segments = append(segments, p...)
+		}
+	}
+	if m.EXIF != nil {
+		if len(exifHeader)+len(m.EXIF) > maxSegment {
+			return fmt.Errorf("EXIF block of %d bytes too large for JPEG", len(m.EXIF))
+		}
+		add(markerAPP1, []byte(exifHeader), m.EXIF)
+	}
+	if m.XMP != nil {
+		if len(xmpHeader)+len(m.XMP) > maxSegment {
+			return fmt.Errorf("XMP packet of %d bytes too large for JPEG", len(m.XMP))
+		}
+		add(markerAPP1, []byte(xmpHeader), m.XMP)
+	}
+	if m.ICC != nil {
+		count := (len(m.ICC) + iccChunk - 1) / iccChunk
+		if count > 255 {
+			return fmt.Errorf("ICC profile of %d bytes too large for JPEG", len(m.ICC))
+		}
+		for i := 0; i < count; i++ {
+			chunk := m.ICC[i*iccChunk : min((i+1)*iccChunk, len(m.ICC))]
+			add(markerAPP2, []byte(iccHeader), []byte{byte(i + 1), byte(count)}, chunk)
+		}
+	}
+
+	for _, part := range [][]byte{data[:split], segments, data[split:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package metadata
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"video-processor/internal/colorspace"
+	"video-processor/internal/exif"
+	"video-processor/internal/icc"
+)
+
+// Metadata holds the blocks of an image that describe it rather than its
+// pixels, each nil when absent.
+type Metadata struct {
+	// EXIF is TIFF-structured, without the Exif header JPEG puts before
+	// it.
+	EXIF []byte
+	// ICC is an ICC colour profile.
+	ICC []byte
+	// XMP is an XMP packet.
+	XMP []byte
+}
+
+// Empty reports whether m holds nothing.
+func (m *Metadata) Empty() bool {
+	return m == nil || m.EXIF == nil && m.ICC == nil && m.XMP == nil
+}
+
+// Read extracts the metadata of an image in the named format, as
+// image.Decode reports it. Formats other than JPEG and PNG have none.
+func Read(r io.Reader, format string) (*Metadata, error) {
+	switch format {
+	case "jpeg":
+		return ReadJPEG(r)
+	case "png":
+		return ReadPNG(r)
+	}
+	return &Metadata{}, nil
+}
+
+// Mode selects what is written out.
+type Mode int
+
+const (
+	// Keep writes all metadata back out.
+	Keep Mode = iota
+	// Strip writes none.
+	Strip
+	// Filter writes only what a Whitelist allows.
+	Filter
+)
+
+var modeNames = []string{"keep", "strip", "filter"}
+
+// ParseMode converts a name such as "strip" to a Mode.
+func ParseMode(name string) (Mode, error) {
+	for i, n := range modeNames {
+		if strings.EqualFold(name, n) {
+			return Mode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown metadata mode %q (available: %s)", name, strings.Join(ModeNames(), ", "))
+}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// ModeNames lists the accepted mode names.
+func ModeNames() []string {
+	return append([]string(nil), modeNames...)
+}
+
+// DefaultWhitelist keeps the colour profile and descriptive EXIF tags but
+// not the location, serial numbers, owner, maker notes or XMP.
+var DefaultWhitelist = []string{
+	"icc",
+	"ImageDescription", "Make", "Model", "Orientation", "XResolution", "YResolution", "ResolutionUnit",
+	"Software", "DateTime", "Artist", "Copyright",
+	"ExposureTime", "FNumber", "ExposureProgram", "ISOSpeedRatings", "ExifVersion",
+	"DateTimeOriginal", "DateTimeDigitized", "OffsetTime", "OffsetTimeOriginal", "OffsetTimeDigitized",
+	"ShutterSpeedValue", "ApertureValue", "ExposureBiasValue", "MeteringMode", "Flash", "FocalLength",
+	"ColorSpace", "PixelXDimension", "PixelYDimension", "WhiteBalance", "FocalLengthIn35mmFilm",
+	"LensMake", "LensModel",
+}
+
+// Whitelist is the metadata Filter keeps.
+type Whitelist struct {
+	tags         map[exif.Tag]bool
+	gps, interop bool
+	icc, xmp     bool
+}
+
+// ParseWhitelist builds a Whitelist from EXIF tag names, "gps" and
+// "interop" for the whole GPS and interoperability IFDs, and "icc" and
+// "xmp" for the colour profile and XMP packet. XMP cannot be filtered tag
+// by tag, so it is kept whole or not at all.
+func ParseWhitelist(names []string) (*Whitelist, error) {
+	w := &Whitelist{tags: map[exif.Tag]bool{}}
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gps":
+			w.gps = true
+		case "interop":
+			w.interop = true
+		case "icc":
+			w.icc = true
+		case "xmp":
+			w.xmp = true
+		default:
+			tag, err := exif.LookupTag(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			w.tags[tag] = true
+		}
+	}
+	return w, nil
+}
+
+func (w *Whitelist) keeps(tag exif.Tag) bool {
+	switch tag.IFD {
+	case exif.GPSIFD:
+		return w.gps
+	case exif.InteropIFD:
+		return w.interop
+	}
+	return w.tags[tag]
+}
+
+// Filter removes what w does not allow.
+func (m *Metadata) Filter(w *Whitelist) error {
+	if !w.icc {
+		m.ICC = nil
+	}
+	if !w.xmp {
+		m.XMP = nil
+	}
+	if m.EXIF == nil {
+		return nil
+	}
+	b, err := exif.Parse(m.EXIF)
+	if err != nil {
+		return err
+	}
+	b.Filter(w.keeps)
+	m.EXIF = b.Encode()
+	return nil
+}
+
+// Update brings the EXIF block in line with a processed image of the given
+// size. upright says the pixels were turned upright, so the orientation
+// must no longer be applied, in EXIF or XMP. The thumbnail is dropped.
+func (m *Metadata) Update(width, height int, upright bool) error {
+	if upright && m.XMP != nil {
+		m.XMP = xmpOrientation.ReplaceAll(m.XMP, []byte("${1}1$2"))
+	}
+	if m.EXIF == nil {
+		return nil
+	}
+	b, err := exif.Parse(m.EXIF)
+	if err != nil {
+		return err
+	}
+	if upright {
+		b.SetOrientation(exif.Normal)
+	}
+	b.SetPixelSize(width, height)
+	m.EXIF = b.Encode()
+	return nil
+}
+
+// xmpOrientation matches the value of tiff:Orientation written as an
+// attribute or as an element. Rewriting the digit in place keeps the packet
+// the same size, so any padding reserved for in-place edits survives.
+var xmpOrientation = regexp.MustCompile(`(tiff:Orientation\s*=\s*["']|<tiff:Orientation>\s*)[1-8](["']|\s*</tiff:Orientation>)`)
+
+// MarkSRGB records that the pixels were converted to sRGB: an ICC profile
+// is replaced by an sRGB one and the EXIF colour space says sRGB.
+func (m *Metadata) MarkSRGB() error {
+	if m.ICC != nil {
+		srgb, err := colorspace.RGBSpaceByName("srgb")
+		if err != nil {
+			return err
+		}
+		if m.ICC, err = icc.Encode(srgb); err != nil {
+			return err
+		}
+	}
+	if m.EXIF == nil {
+		return nil
+	}
+	b, err := exif.Parse(m.EXIF)
+	if err != nil {
+		return err
+	}
+	b.SetColorSpaceSRGB()
+	m.EXIF = b.Encode()
+	return nil
+}
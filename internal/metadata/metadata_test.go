@@ -0,0 +1,273 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"video-processor/internal/exif"
+	"video-processor/internal/icc"
+)
+
+// exifBlock builds a big-endian EXIF block whose IFD0 holds Orientation 6
+// and points to a GPS IFD with one entry.
+func exifBlock() []byte {
+	be := binary.BigEndian
+	b := []byte("MM\x00*")
+	b = be.AppendUint32(b, 8)
+	b = be.AppendUint16(b, 2)
+	b = append(b, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6, 0, 0)
+	b = append(b, 0x88, 0x25, 0, 4, 0, 0, 0, 1)
+	b = be.AppendUint32(b, 8+2+24+4)
+	b = be.AppendUint32(b, 0)
+	b = be.AppendUint16(b, 1)
+	b = append(b, 0, 0, 0, 1, 0, 0, 0, 4, 2, 2, 0, 0)
+	return be.AppendUint32(b, 0)
+}
+
+func encodeJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func segment(marker byte, parts ...string) []byte {
+	payload := strings.Join(parts, "")
+	s := []byte{0xff, marker}
+	s = binary.BigEndian.AppendUint16(s, uint16(len(payload)+2))
+	return append(s, payload...)
+}
+
+// insert returns data with extra inserted at offset.
+func insert(data []byte, offset int, extra ...[]byte) []byte {
+	out := append([]byte(nil), data[:offset]...)
+	for _, e := range extra {
+		out = append(out, e...)
+	}
+	return append(out, data[offset:]...)
+}
+
+func TestReadJPEG(t *testing.T) {
+	data := encodeJPEG(t)
+	ex := exifBlock()
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Metadata
+		wantErr string
+	}{
+		{
+			name: "all three",
+			data: insert(data, 2,
+				segment(markerAPP1, exifHeader, string(ex)),
+				segment(markerAPP1, xmpHeader, "<x:xmpmeta/>"),
+				// Chunks may arrive out of order.
+				segment(markerAPP2, iccHeader, "\x02\x02", "world"),
+				segment(markerAPP2, iccHeader, "\x01\x02", "hello ")),
+			want: Metadata{EXIF: ex, ICC: []byte("hello world"), XMP: []byte("<x:xmpmeta/>")},
+		},
+		{
+			name: "fill bytes before a marker",
+			data: insert(data, 2, []byte{0xff, 0xff}, segment(markerAPP1, exifHeader, string(ex))),
+			want: Metadata{EXIF: ex},
+		},
+		{
+			name: "incomplete profile",
+			data: insert(data, 2, segment(markerAPP2, iccHeader, "\x01\x02", "hello ")),
+		},
+		{name: "none", data: data},
+		{name: "not jpeg", data: encodePNG(t), wantErr: "not a JPEG file"},
+		{name: "truncated", data: insert(data, 2, segment(markerAPP1, exifHeader, string(ex)))[:20], wantErr: "failed to read JPEG segment"},
+	}
+	for _, tt := range tests {
+		got, err := ReadJPEG(bytes.NewReader(tt.data))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: ReadJPEG() error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ReadJPEG() unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !equal(got, &tt.want) {
+			t.Errorf("%s: ReadJPEG() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func equal(a, b *Metadata) bool {
+	return bytes.Equal(a.EXIF, b.EXIF) && bytes.Equal(a.ICC, b.ICC) && bytes.Equal(a.XMP, b.XMP)
+}
+
+func TestReadPNGCompressedXMP(t *testing.T) {
+	var text bytes.Buffer
+	zw := zlib.NewWriter(&text)
+	zw.Write([]byte("<x:xmpmeta/>"))
+	zw.Close()
+	payload := append([]byte(xmpKeyword+"\x00\x01\x00en\x00\x00"), text.Bytes()...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, "iTXt"...)
+	chunk = append(append(chunk, payload...), 0, 0, 0, 0)
+
+	data := encodePNG(t)
+	got, err := ReadPNG(bytes.NewReader(insert(data, 33, chunk)))
+	if err != nil {
+		t.Fatalf("ReadPNG() unexpected error: %v", err)
+	}
+	if string(got.XMP) != "<x:xmpmeta/>" {
+		t.Errorf("XMP = %q, want <x:xmpmeta/>", got.XMP)
+	}
+}
+
+// TestEmbed writes metadata into each container, reads it back, and checks
+// the file still decodes.
+func TestEmbed(t *testing.T) {
+	m := &Metadata{
+		EXIF: exifBlock(),
+		// Large enough to need three APP2 segments.
+		ICC: bytes.Repeat([]byte("profile "), 20000),
+		XMP: []byte("<x:xmpmeta/>"),
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		embed  func(w *bytes.Buffer, data []byte) error
+		read   func(r *bytes.Reader) (*Metadata, error)
+		decode func(r *bytes.Reader) (image.Image, error)
+	}{
+		{
+			name:   "jpeg",
+			data:   encodeJPEG(t),
+			embed:  func(w *bytes.Buffer, data []byte) error { return EmbedJPEG(w, data, m) },
+			read:   func(r *bytes.Reader) (*Metadata, error) { return ReadJPEG(r) },
+			decode: func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) },
+		},
+		{
+			name:   "png",
+			data:   encodePNG(t),
+			embed:  func(w *bytes.Buffer, data []byte) error { return EmbedPNG(w, data, m) },
+			read:   func(r *bytes.Reader) (*Metadata, error) { return ReadPNG(r) },
+			decode: func(r *bytes.Reader) (image.Image, error) { return png.Decode(r) },
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.embed(&buf, tt.data); err != nil {
+			t.Fatalf("%s: embed unexpected error: %v", tt.name, err)
+		}
+		got, err := tt.read(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: read unexpected error: %v", tt.name, err)
+		}
+		if !equal(got, m) {
+			t.Errorf("%s: read back %d/%d/%d bytes of EXIF/ICC/XMP, want %d/%d/%d", tt.name,
+				len(got.EXIF), len(got.ICC), len(got.XMP), len(m.EXIF), len(m.ICC), len(m.XMP))
+		}
+		if _, err := tt.decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("%s: decode unexpected error: %v", tt.name, err)
+		}
+	}
+
+	if err := EmbedJPEG(&bytes.Buffer{}, encodeJPEG(t), &Metadata{XMP: make([]byte, maxSegment)}); err == nil {
+		t.Error("EmbedJPEG() of an oversized XMP packet expected error")
+	}
+}
+
+func TestFilterAndUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		gps     bool
+		xmp     bool
+		upright bool
+		want    exif.Orientation
+	}{
+		{"default", DefaultWhitelist, false, false, true, exif.Normal},
+		{"gps and xmp", []string{"Orientation", "gps", "xmp", "icc"}, true, true, false, exif.Rotate90},
+		{"no orientation", []string{"icc"}, false, false, false, exif.Normal},
+	}
+	for _, tt := range tests {
+		w, err := ParseWhitelist(tt.names)
+		if err != nil {
+			t.Fatalf("%s: ParseWhitelist() unexpected error: %v", tt.name, err)
+		}
+		m := &Metadata{EXIF: exifBlock(), ICC: []byte("profile"), XMP: []byte("<x:xmpmeta/>")}
+		if err := m.Filter(w); err != nil {
+			t.Fatalf("%s: Filter() unexpected error: %v", tt.name, err)
+		}
+		if err := m.Update(10, 20, tt.upright); err != nil {
+			t.Fatalf("%s: Update() unexpected error: %v", tt.name, err)
+		}
+		if o, err := exif.ReadOrientation(m.EXIF); err != nil || o != tt.want {
+			t.Errorf("%s: orientation = %v, %v; want %v", tt.name, o, err, tt.want)
+		}
+		// The GPS IFD is the only thing that adds bytes after IFD0.
+		if gps := len(m.EXIF) > 8+2+2*12+4; gps != tt.gps {
+			t.Errorf("%s: GPS kept = %v, want %v", tt.name, gps, tt.gps)
+		}
+		if (m.XMP != nil) != tt.xmp || m.ICC == nil {
+			t.Errorf("%s: XMP kept = %v, ICC kept = %v", tt.name, m.XMP != nil, m.ICC != nil)
+		}
+	}
+
+	// An upright image must not keep an XMP orientation either.
+	for _, xmp := range []string{
+		`<rdf:Description tiff:Orientation="6" tiff:Make="x"/>`,
+		`<rdf:Description><tiff:Orientation>6</tiff:Orientation></rdf:Description>`,
+	} {
+		m := &Metadata{XMP: []byte(xmp)}
+		if err := m.Update(10, 20, false); err != nil || string(m.XMP) != xmp {
+			t.Errorf("Update() without upright changed XMP to %s", m.XMP)
+		}
+		if err := m.Update(10, 20, true); err != nil {
+			t.Fatalf("Update() unexpected error: %v", err)
+		}
+		if want := strings.Replace(xmp, "6", "1", 1); string(m.XMP) != want {
+			t.Errorf("XMP after Update() = %s, want %s", m.XMP, want)
+		}
+	}
+
+	// Converted pixels get an sRGB profile in place of the original.
+	m := &Metadata{EXIF: exifBlock(), ICC: []byte("profile")}
+	if err := m.MarkSRGB(); err != nil {
+		t.Fatalf("MarkSRGB() unexpected error: %v", err)
+	}
+	if p, err := icc.Parse(m.ICC); err != nil || p.Description != "srgb" {
+		t.Errorf("profile after MarkSRGB() = %v, %v; want srgb", p, err)
+	}
+	if o, err := exif.ReadOrientation(m.EXIF); err != nil || o != exif.Rotate90 {
+		t.Errorf("orientation after MarkSRGB() = %v, %v", o, err)
+	}
+	if m := (&Metadata{XMP: []byte("x")}); m.MarkSRGB() != nil || m.ICC != nil {
+		t.Error("MarkSRGB() added a profile to untagged metadata")
+	}
+
+	if _, err := ParseWhitelist([]string{"Make", "Latitude"}); err == nil {
+		t.Error("ParseWhitelist() with an unknown tag expected error")
+	}
+	if _, err := ParseMode("drop"); err == nil {
+		t.Error(`ParseMode("drop") expected error`)
+	}
+	if m, err := ParseMode("Filter"); err != nil || m != Filter {
+		t.Errorf(`ParseMode("Filter") = %v, %v`, m, err)
+	}
+}
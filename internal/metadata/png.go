@@ -0,0 +1,174 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// xmpKeyword is the iTXt keyword of an XMP packet.
+const xmpKeyword = "XML:com.adobe.xmp"
+
+// maxICC bounds a decompressed iCCP profile.
+const maxICC = 16 << 20
+
+// ReadPNG extracts the metadata of a PNG file from its iCCP, eXIf and XMP
+// iTXt chunks.
+func ReadPNG(r io.Reader) (*Metadata, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read PNG header: %w", err)
+	}
+	if string(header[:]) != pngHeader {
+		return nil, errors.New("not a PNG file")
+	}
+
+	m := &Metadata{}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("failed to read PNG chunk: %w", err)
+		}
+		n := int64(binary.BigEndian.Uint32(chunk[:4]))
+		name := string(chunk[4:])
+		if name != "iCCP" && name != "eXIf" && name != "iTXt" {
+			// Skip the data and CRC.
+			if _, err := io.CopyN(io.Discard, r, n+4); err != nil {
+				return nil, fmt.Errorf("failed to read PNG chunk: %w", err)
+			}
+			if name == "IEND" {
+				return m, nil
+			}
+			continue
+		}
+		// A truncated file cannot make the read allocate more than it
+		// holds.
+		data, err := io.ReadAll(io.LimitReader(r, n+4))
+		if err == nil && int64(len(data)) < n+4 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PNG chunk: %w", err)
+		}
+		data = data[:n]
+		switch name {
+		case "iCCP":
+			if m.ICC, err = readICCP(data); err != nil {
+				return nil, err
+			}
+		case "eXIf":
+			m.EXIF = data
+		case "iTXt":
+			if xmp, ok := readXMP(data); ok {
+				m.XMP = xmp
+			}
+		}
+	}
+}
+
+// readICCP decompresses the profile of an iCCP chunk: a name, a
+// compression method and zlib data.
+func readICCP(data []byte) ([]byte, error) {
+	_, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 1 || rest[0] != 0 {
+		return nil, errors.New("invalid iCCP chunk")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(rest[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICC profile: %w", err)
+	}
+	profile, err := io.ReadAll(io.LimitReader(zr, maxICC+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICC profile: %w", err)
+	}
+	if len(profile) > maxICC {
+		return nil, errors.New("ICC profile too large")
+	}
+	return profile, nil
+}
+
+// readXMP returns the text of an iTXt chunk holding XMP: the keyword,
+// compression flag and method, language and translated keyword, then the
+// text. Other iTXt chunks are not metadata this package keeps.
+func readXMP(data []byte) ([]byte, bool) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || string(keyword) != xmpKeyword || len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+	for i := 0; i < 2; i++ {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return nil, false
+		}
+	}
+	if !compressed {
+		return rest, true
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return nil, false
+	}
+	text, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, false
+	}
+	return text, true
+}
+
+// EmbedPNG writes the PNG file data with m's blocks added as chunks after
+// IHDR, which puts them before PLTE and IDAT as the specification requires.
+func EmbedPNG(w io.Writer, data []byte, m *Metadata) error {
+	// The signature and the IHDR chunk's length, name, 13 bytes of data
+	// and CRC.
+	const split = 8 + 8 + 13 + 4
+	if len(data) < split || string(data[:8]) != pngHeader || string(data[12:16]) != "IHDR" {
+		return errors.New("not a PNG file")
+	}
+
+	var chunks bytes.Buffer
+	add := func(name string, parts ...[]byte) {
+		n := 0
+		for _, p := range parts {
+			n += len(p)
+		}
+		crc := crc32.NewIEEE()
+		binary.Write(&chunks, binary.BigEndian, uint32(n))
+		chunks.WriteString(name)
+		crc.Write([]byte(name))
+		for _, p := range parts {
+			chunks.Write(p)
+			crc.Write(p)
+		}
+		binary.Write(&chunks, binary.BigEndian, crc.Sum32())
+	}
+	if m.ICC != nil {
+		var profile bytes.Buffer
+		zw := zlib.NewWriter(&profile)
+		zw.Write(m.ICC)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		add("iCCP", []byte("ICC Profile\x00\x00"), profile.Bytes())
+	}
+	if m.EXIF != nil {
+		add("eXIf", m.EXIF)
+	}
+	if m.XMP != nil {
+		// Uncompressed, with no language or translated keyword.
+		add("iTXt", []byte(xmpKeyword+"\x00\x00\x00\x00\x00"), m.XMP)
+	}
+
+	for _, part := range [][]byte{data[:split], chunks.Bytes(), data[split:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
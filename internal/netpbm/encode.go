@@ -0,0 +1,180 @@
+package netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+// Format selects the Netpbm variant Encode writes.
+type Format int
+
+const (
+	// PAM (P7) keeps alpha and picks greyscale or RGB tuples to suit the
+	// image.
+	PAM Format = iota
+	// PBM (P1, P4) is a bitmap; pixels darker than mid-grey are black.
+	PBM
+	// PGM (P2, P5) is greyscale.
+	PGM
+	// PPM (P3, P6) is RGB.
+	PPM
+)
+
+// Options configures Encode. The zero value writes a PAM.
+type Options struct {
+	Format Format
+	// Plain writes the ASCII variants P1-P3 instead of binary. PAM has
+	// no plain form.
+	Plain bool
+}
+
+// plainLineLength is the longest line the plain formats may contain.
+const plainLineLength = 70
+
+// Encode writes m in the format chosen by o. Greyscale and colour formats
+// use a maxval of 65535 for 16-bit images and 255 otherwise.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.Plain && opts.Format == PAM {
+		return fmt.Errorf("PAM has no plain format")
+	}
+
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	maxval := 255
+	if deep(m) {
+		maxval = 65535
+	}
+
+	bw := bufio.NewWriter(w)
+	var channels []int
+	// The plain variants' magic numbers are three below the binary ones.
+	magic := map[Format]int{PBM: 4, PGM: 5, PPM: 6}[opts.Format]
+	if opts.Plain {
+		magic -= 3
+	}
+	switch opts.Format {
+	case PBM:
+		maxval = 1
+		fmt.Fprintf(bw, "P%d\n%d %d\n", magic, width, height)
+	case PGM:
+		channels = []int{4}
+		fmt.Fprintf(bw, "P%d\n%d %d\n%d\n", magic, width, height, maxval)
+	case PPM:
+		channels = []int{0, 1, 2}
+		fmt.Fprintf(bw, "P%d\n%d %d\n%d\n", magic, width, height, maxval)
+	default:
+		tupleType := "RGB"
+		channels = []int{0, 1, 2}
+		if isGrey(m) {
+			tupleType, channels = "GRAYSCALE", []int{4}
+		}
+		if !opaque(m) {
+			tupleType += "_ALPHA"
+			channels = append(channels, 3)
+		}
+		fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\nTUPLTYPE %s\nENDHDR\n", width, height, len(channels), maxval, tupleType)
+	}
+
+	// Each pixel is split into R, G, B, A and the grey of its colour,
+	// scaled to maxval.
+	var samples [5]int
+	pixel := func(x, y int) {
+		c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+		g := color.Gray16Model.Convert(color.NRGBA64{R: c.R, G: c.G, B: c.B, A: 0xFFFF}).(color.Gray16)
+		for i, v := range [5]uint16{c.R, c.G, c.B, c.A, g.Y} {
+			samples[i] = (int(v)*maxval + 32767) / 65535
+		}
+	}
+
+	var bits []byte
+	if opts.Format == PBM && !opts.Plain {
+		bits = make([]byte, (width+7)/8)
+	}
+	lineLength := 0
+	writePlain := func(s string) {
+		if lineLength > 0 && lineLength+1+len(s) > plainLineLength {
+			bw.WriteByte('\n')
+			lineLength = 0
+		} else if lineLength > 0 {
+			bw.WriteByte(' ')
+			lineLength++
+		}
+		bw.WriteString(s)
+		lineLength += len(s)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		clear(bits)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel(x, y)
+			if opts.Format == PBM {
+				// Bitmaps store black as 1.
+				black := 1 - samples[4]
+				if opts.Plain {
+					writePlain(strconv.Itoa(black))
+				} else {
+					i := x - bounds.Min.X
+					bits[i/8] |= byte(black << (7 - i%8))
+				}
+				continue
+			}
+			for _, c := range channels {
+				switch {
+				case opts.Plain:
+					writePlain(strconv.Itoa(samples[c]))
+				case maxval > 255:
+					bw.Write([]byte{byte(samples[c] >> 8), byte(samples[c])})
+				default:
+					bw.WriteByte(byte(samples[c]))
+				}
+			}
+		}
+		if bits != nil {
+			bw.Write(bits)
+		}
+	}
+	if opts.Plain {
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// deep reports whether m carries more than 8 bits per channel.
+func deep(m image.Image) bool {
+	switch m.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return true
+	}
+	return false
+}
+
+func isGrey(m image.Image) bool {
+	switch m.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+func opaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0xFFFF {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,389 @@
+package netpbm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+
+	"video-processor/internal/imagesize"
+)
+
+func init() {
+	for _, magic := range []string{"P1", "P2", "P3", "P4", "P5", "P6", "P7"} {
+		image.RegisterFormat("netpbm", magic, Decode, DecodeConfig)
+	}
+}
+
+// header describes the raster that follows it. Bitmaps (P1, P4) have a
+// maxval of 1 with 1 meaning black.
+type header struct {
+	magic         byte
+	width, height int
+	depth         int
+	maxval        int
+	// alpha is set when the last of depth channels is opacity.
+	alpha bool
+}
+
+func (h header) bitmap() bool {
+	return h.magic == '1' || h.magic == '4'
+}
+
+func (h header) plain() bool {
+	return h.magic >= '1' && h.magic <= '3'
+}
+
+func readHeader(r *bufio.Reader) (header, error) {
+	var magic [2]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return header{}, fmt.Errorf("failed to read header: %w", unexpectedEOF(err))
+	}
+	if magic[0] != 'P' || magic[1] < '1' || magic[1] > '7' {
+		return header{}, errors.New("not a Netpbm file")
+	}
+	h := header{magic: magic[1]}
+	if h.magic == '7' {
+		return readPAMHeader(r, h)
+	}
+
+	fields := []*int{&h.width, &h.height, &h.maxval}
+	if h.bitmap() {
+		fields, h.maxval = fields[:2], 1
+	}
+	for _, field := range fields {
+		token, err := readToken(r)
+		if err != nil {
+			return h, err
+		}
+		if *field, err = strconv.Atoi(token); err != nil {
+			return h, fmt.Errorf("invalid header value %q", token)
+		}
+	}
+	h.depth = 1
+	if h.magic == '3' || h.magic == '6' {
+		h.depth = 3
+	}
+	return h, h.validate()
+}
+
+// readPAMHeader parses the keyword lines of a P7 header up to ENDHDR.
+func readPAMHeader(r *bufio.Reader, h header) (header, error) {
+	tupleType := ""
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return h, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			break
+		}
+		if len(fields) < 2 {
+			return h, fmt.Errorf("invalid PAM header line %q", line)
+		}
+		var target *int
+		switch fields[0] {
+		case "WIDTH":
+			target = &h.width
+		case "HEIGHT":
+			target = &h.height
+		case "DEPTH":
+			target = &h.depth
+		case "MAXVAL":
+			target = &h.maxval
+		case "TUPLTYPE":
+			tupleType += strings.Join(fields[1:], " ")
+			continue
+		default:
+			return h, fmt.Errorf("unknown PAM header field %q", fields[0])
+		}
+		if *target, err = strconv.Atoi(fields[1]); err != nil {
+			return h, fmt.Errorf("invalid PAM header line %q", line)
+		}
+	}
+
+	if h.depth < 1 || h.depth > 4 {
+		return h, fmt.Errorf("unsupported PAM depth %d", h.depth)
+	}
+	h.alpha = h.depth == 2 || h.depth == 4
+	if want := map[string]int{
+		"BLACKANDWHITE": 1, "GRAYSCALE": 1, "RGB": 3,
+		"BLACKANDWHITE_ALPHA": 2, "GRAYSCALE_ALPHA": 2, "RGB_ALPHA": 4,
+	}[tupleType]; want != 0 && want != h.depth {
+		return h, fmt.Errorf("PAM tuple type %s does not match depth %d", tupleType, h.depth)
+	}
+	return h, h.validate()
+}
+
+func (h header) validate() error {
+	if !imagesize.Valid(h.width, h.height) {
+		return fmt.Errorf("invalid Netpbm dimensions %dx%d", h.width, h.height)
+	}
+	if h.maxval < 1 || h.maxval > 65535 {
+		return fmt.Errorf("invalid maxval %d", h.maxval)
+	}
+	return nil
+}
+
+// readToken returns the next whitespace-separated word, skipping comments,
+// and consumes the single whitespace byte that ends it.
+func readToken(r *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", fmt.Errorf("failed to read header: %w", unexpectedEOF(err))
+		}
+		switch {
+		case c == '#':
+			if _, err := readLine(r); err != nil {
+				return "", err
+			}
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		case isSpace(c):
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			if len(token) >= 32 {
+				return "", errors.New("header field too long")
+			}
+			token = append(token, c)
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", fmt.Errorf("failed to read header: %w", unexpectedEOF(err))
+		}
+		line = append(line, chunk...)
+		if len(line) > 4096 {
+			return "", errors.New("header line too long")
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// model returns the colour model Decode produces for h.
+func (h header) model() color.Model {
+	switch {
+	case h.depth <= 2 && !h.alpha && h.maxval > 255:
+		return color.Gray16Model
+	case h.depth <= 2 && !h.alpha:
+		return color.GrayModel
+	case h.maxval > 255:
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.model(), Width: h.width, Height: h.height}, nil
+}
+
+// Decode reads any Netpbm format. Bitmaps and greyscale images decode to
+// *image.Gray, or *image.Gray16 when maxval exceeds 255; colour images and
+// those with alpha decode to *image.NRGBA or *image.NRGBA64. Samples are
+// rescaled from maxval to the full range of the result.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := readSamples(br, h)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	wide := h.maxval > 255
+	full := 255
+	if wide {
+		full = 65535
+	}
+	scale := func(v uint16) int {
+		if h.bitmap() {
+			// 1 is black in a bitmap.
+			return (1 - int(v)) * full
+		}
+		return (int(v)*full + h.maxval/2) / h.maxval
+	}
+
+	n := h.width * h.height
+	switch h.model() {
+	case color.GrayModel:
+		img := image.NewGray(rect)
+		for i := 0; i < n; i++ {
+			img.Pix[i] = uint8(scale(samples[i]))
+		}
+		return img, nil
+	case color.Gray16Model:
+		img := image.NewGray16(rect)
+		for i := 0; i < n; i++ {
+			v := scale(samples[i])
+			img.Pix[2*i], img.Pix[2*i+1] = uint8(v>>8), uint8(v)
+		}
+		return img, nil
+	}
+
+	// Expand each tuple to RGBA: grey is replicated and a missing alpha is
+	// opaque.
+	var pixel [4]int
+	var out []uint8
+	bytesPerSample := 1
+	if wide {
+		out = image.NewNRGBA64(rect).Pix
+		bytesPerSample = 2
+	} else {
+		out = image.NewNRGBA(rect).Pix
+	}
+	colorDepth := h.depth
+	if h.alpha {
+		colorDepth--
+	}
+	for i := 0; i < n; i++ {
+		tuple := samples[i*h.depth : (i+1)*h.depth]
+		for c := 0; c < 3; c++ {
+			pixel[c] = scale(tuple[min(c, colorDepth-1)])
+		}
+		pixel[3] = full
+		if h.alpha {
+			pixel[3] = (int(tuple[h.depth-1])*full + h.maxval/2) / h.maxval
+		}
+		for c, v := range pixel {
+			o := (4*i + c) * bytesPerSample
+			if wide {
+				out[o], out[o+1] = uint8(v>>8), uint8(v)
+			} else {
+				out[o] = uint8(v)
+			}
+		}
+	}
+	if wide {
+		return &image.NRGBA64{Pix: out, Stride: 8 * h.width, Rect: rect}, nil
+	}
+	return &image.NRGBA{Pix: out, Stride: 4 * h.width, Rect: rect}, nil
+}
+
+// readSamples returns the raster as one value per sample, row by row,
+// after checking every sample against maxval.
+func readSamples(r *bufio.Reader, h header) ([]uint16, error) {
+	samples := make([]uint16, h.width*h.height*h.depth)
+	switch {
+	case h.magic == '1':
+		// Plain bitmap digits need not be separated.
+		for i := range samples {
+			c, err := skipSpace(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read raster: %w", err)
+			}
+			if c != '0' && c != '1' {
+				return nil, fmt.Errorf("invalid bitmap sample %q", c)
+			}
+			samples[i] = uint16(c - '0')
+		}
+	case h.plain():
+		for i := range samples {
+			token, err := readToken(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read raster: %w", err)
+			}
+			v, err := strconv.ParseUint(token, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sample %q", token)
+			}
+			samples[i] = uint16(v)
+		}
+	case h.magic == '4':
+		// Rows are packed eight pixels to a byte, most significant first.
+		row := make([]byte, (h.width+7)/8)
+		for y := 0; y < h.height; y++ {
+			if _, err := io.ReadFull(r, row); err != nil {
+				return nil, fmt.Errorf("failed to read raster: %w", unexpectedEOF(err))
+			}
+			for x := 0; x < h.width; x++ {
+				samples[y*h.width+x] = uint16(row[x/8]>>(7-x%8)) & 1
+			}
+		}
+	default:
+		// Samples are one byte, or two big-endian bytes above maxval 255.
+		size := 1
+		if h.maxval > 255 {
+			size = 2
+		}
+		raw := make([]byte, len(samples)*size)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, fmt.Errorf("failed to read raster: %w", unexpectedEOF(err))
+		}
+		for i := range samples {
+			if size == 2 {
+				samples[i] = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+			} else {
+				samples[i] = uint16(raw[i])
+			}
+		}
+	}
+
+	for _, v := range samples {
+		if int(v) > h.maxval {
+			return nil, fmt.Errorf("sample %d exceeds maxval %d", v, h.maxval)
+		}
+	}
+	return samples, nil
+}
+
+// skipSpace returns the next byte that is neither whitespace nor part of a
+// comment.
+func skipSpace(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if c == '#' {
+			if _, err := readLine(r); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return c, nil
+		}
+	}
+}
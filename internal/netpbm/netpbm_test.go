@@ -0,0 +1,140 @@
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// want is the colour of the last pixel.
+		want color.Color
+	}{
+		{"plain bitmap", "P1\n# comment\n3 2\n010\n0 0\n1", color.Gray{0}},
+		{"bitmap", "P4 3 2\n\x40\xa0", color.Gray{0}},
+		{"plain greymap", "P2\n2 1\n10\n0 5\n", color.Gray{128}},
+		{"greymap", "P5\n2 1\n255\n\x00\x80", color.Gray{128}},
+		{"16-bit greymap", "P5\n1 1\n1023\n\x02\x00", color.Gray16{32800}},
+		{"plain pixmap", "P3\n1 1\n255\n255 128 0\n", color.NRGBA{255, 128, 0, 255}},
+		{"pixmap", "P6\n1 1\n255\n\xff\x80\x00", color.NRGBA{255, 128, 0, 255}},
+		{"16-bit pixmap", "P6\n1 1\n65535\n\xff\xff\x80\x00\x00\x01", color.NRGBA64{65535, 32768, 1, 65535}},
+		{
+			"pam with alpha",
+			"P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n\x10\x20\x30\x80",
+			color.NRGBA{0x10, 0x20, 0x30, 0x80},
+		},
+		{
+			"16-bit grey pam with alpha",
+			"P7\nWIDTH 1\nHEIGHT 1\nDEPTH 2\nMAXVAL 65535\nTUPLTYPE GRAYSCALE_ALPHA\nENDHDR\n\x12\x34\x80\x00",
+			color.NRGBA64{0x1234, 0x1234, 0x1234, 0x8000},
+		},
+		{
+			"pam bitmap",
+			"P7\nWIDTH 1\nHEIGHT 1\nDEPTH 1\nMAXVAL 1\nTUPLTYPE BLACKANDWHITE\nENDHDR\n\x01",
+			color.Gray{255},
+		},
+	}
+
+	for _, tt := range tests {
+		img, format, err := image.Decode(strings.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: image.Decode() unexpected error: %v", tt.name, err)
+			continue
+		}
+		if format != "netpbm" {
+			t.Errorf("%s: format = %q, want netpbm", tt.name, format)
+		}
+		b := img.Bounds()
+		if got := img.At(b.Max.X-1, b.Max.Y-1); got != tt.want {
+			t.Errorf("%s: last pixel = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+
+	// The packed bitmap's first row is white, black, white.
+	img, _ := Decode(strings.NewReader("P4 3 2\n\x40\xa0"))
+	for x, want := range []uint8{255, 0, 255} {
+		if got := img.(*image.Gray).GrayAt(x, 0).Y; got != want {
+			t.Errorf("bitmap pixel %d = %d, want %d", x, got, want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := image.NewNRGBA64(image.Rect(0, 0, 13, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 13; x++ {
+			src.SetNRGBA64(x, y, color.NRGBA64{R: uint16(x * 5000), G: uint16(y * 20000), B: 0x1234, A: 0xFFFF - uint16(x)})
+		}
+	}
+
+	for _, opts := range []Options{{Format: PAM}, {Format: PPM}, {Format: PPM, Plain: true}, {Format: PGM}, {Format: PBM}, {Format: PBM, Plain: true}} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, &opts); err != nil {
+			t.Fatalf("%+v: Encode() unexpected error: %v", opts, err)
+		}
+		img, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%+v: Decode() unexpected error: %v", opts, err)
+		}
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 13; x++ {
+				c := src.NRGBA64At(x, y)
+				want := color.Color(color.NRGBA64{c.R, c.G, c.B, 0xFFFF})
+				switch opts.Format {
+				case PAM:
+					want = c
+				case PGM:
+					want = color.Gray16Model.Convert(want)
+				case PBM:
+					if color.Gray16Model.Convert(want).(color.Gray16).Y >= 0x8000 {
+						want = color.Gray{255}
+					} else {
+						want = color.Gray{0}
+					}
+				}
+				if got := img.At(x, y); got != want {
+					t.Fatalf("%+v: pixel %d,%d = %#v, want %#v", opts, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, src, &Options{Plain: true}); err == nil {
+		t.Error("Encode() of a plain PAM expected error")
+	}
+}
+
+func TestEncode8Bit(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 1))
+	src.Pix[1] = 200
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, nil); err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	want := "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 1\nMAXVAL 255\nTUPLTYPE GRAYSCALE\nENDHDR\n\x00\xc8"
+	if buf.String() != want {
+		t.Errorf("Encode() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"not netpbm":       "PF\n1 1\n-1.0\n",
+		"zero maxval":      "P5 1 1 0\n\x00",
+		"sample too large": "P2 1 1 10 11\n",
+		"truncated":        "P6 2 1 255\n\x00\x00\x00",
+		"bad bitmap digit": "P1 1 1 2",
+		"huge":             "P5 100000 100000 255\n",
+		"bad depth":        "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 5\nMAXVAL 255\nENDHDR\n",
+		"depth mismatch":   "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 3\nMAXVAL 255\nTUPLTYPE GRAYSCALE\nENDHDR\n\x00\x00\x00",
+	} {
+		if _, err := Decode(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode() expected error", name)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package orient rotates images by right angles and mirrors them exactly,
+// by moving pixels rather than resampling them.
+package orient
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+
+	"video-processor/internal/hdr"
+	"video-processor/internal/subsample"
+)
+
+// Op is one of the eight ways to turn and mirror a rectangle onto the
+// pixel grid, in the order of the EXIF orientations they correct.
+type Op int
+
+const (
+	// None leaves the image as it is.
+	None Op = iota
+	// FlipHorizontal mirrors the image left to right.
+	FlipHorizontal
+	// Rotate180 turns the image upside down.
+	Rotate180
+	// FlipVertical mirrors the image top to bottom.
+	FlipVertical
+	// Transpose mirrors about the top-left to bottom-right diagonal.
+	Transpose
+	// Rotate90 turns the image a quarter turn clockwise.
+	Rotate90
+	// Transverse mirrors about the top-right to bottom-left diagonal.
+	Transverse
+	// Rotate270 turns the image a quarter turn anticlockwise.
+	Rotate270
+)
+
+var opNames = []string{"none", "flip-horizontal", "rotate-180", "flip-vertical", "transpose", "rotate-90", "transverse", "rotate-270"}
+
+// ParseOp converts a name such as "rotate-90" to an Op.
+func ParseOp(name string) (Op, error) {
+	for i, n := range opNames {
+		if strings.EqualFold(name, n) {
+			return Op(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown orientation %q (available: %s)", name, strings.Join(OpNames(), ", "))
+}
+
+func (o Op) String() string {
+	if o < None || o > Rotate270 {
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+	return opNames[o]
+}
+
+// OpNames lists the accepted operation names.
+func OpNames() []string {
+	return append([]string(nil), opNames...)
+}
+
+// Swaps reports whether o turns the image on its side, exchanging its
+// width and height.
+func (o Op) Swaps() bool {
+	return o >= Transpose
+}
+
+// source returns the pixel of a w x h image that o moves to (x, y).
+func (o Op) source(x, y, w, h int) (int, int) {
+	switch o {
+	case FlipHorizontal:
+		return w - 1 - x, y
+	case Rotate180:
+		return w - 1 - x, h - 1 - y
+	case FlipVertical:
+		return x, h - 1 - y
+	case Transpose:
+		return y, x
+	case Rotate90:
+		return y, h - 1 - x
+	case Transverse:
+		return w - 1 - y, h - 1 - x
+	case Rotate270:
+		return w - 1 - y, x
+	}
+	return x, y
+}
+
+// Apply returns m rearranged by o. None and invalid operations return m
+// itself; otherwise the result is a new image at the origin of the same
+// type as m, with the same palette, subsampling or peak. Subsampled
+// YCbCr keeps its chroma planes when they line up with the rearranged
+// image, turning 4:2:2 into 4:4:0 and back when the image is turned on its
+// side, and otherwise has its chroma repeated up to 4:4:4, so that every
+// pixel keeps exactly its colour. Types the package does not know become
+// *image.NRGBA64.
+func Apply(m image.Image, o Op) image.Image {
+	if o <= None || o > Rotate270 {
+		return m
+	}
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	r := image.Rect(0, 0, w, h)
+	if o.Swaps() {
+		r = image.Rect(0, 0, h, w)
+	}
+
+	switch src := m.(type) {
+	case *image.NRGBA:
+		dst := image.NewNRGBA(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 4, o)
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 4, o)
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 8, o)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 8, o)
+		return dst
+	case *image.Gray:
+		dst := image.NewGray(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 1, o)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 2, o)
+		return dst
+	case *image.Alpha:
+		dst := image.NewAlpha(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 1, o)
+		return dst
+	case *image.Alpha16:
+		dst := image.NewAlpha16(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 2, o)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(r)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 4, o)
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(r, src.Palette)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 1, o)
+		return dst
+	case *image.YCbCr:
+		return ycbcr(src, o, r)
+	case *image.NYCbCrA:
+		dst := &image.NYCbCrA{YCbCr: *ycbcr(&src.YCbCr, o, r), A: make([]uint8, r.Dx()*r.Dy()), AStride: r.Dx()}
+		remap(dst.A, dst.AStride, src.A[src.AOffset(b.Min.X, b.Min.Y):], src.AStride, w, h, 1, o)
+		return dst
+	case *hdr.Image:
+		dst := hdr.NewImage(r, src.Peak)
+		remap(dst.Pix, dst.Stride, src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], src.Stride, w, h, 4, o)
+		return dst
+	}
+
+	rgba := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Rect, m, b.Min, draw.Src)
+	return Apply(rgba, o)
+}
+
+// ycbcr rearranges the planes of src into a new image with bounds r.
+func ycbcr(src *image.YCbCr, o Op, r image.Rectangle) *image.YCbCr {
+	b := src.Rect
+	w, h := b.Dx(), b.Dy()
+	fx, fy := subsample.Factors(src.SubsampleRatio)
+	ratio, ok := src.SubsampleRatio, true
+	if o.Swaps() {
+		ratio, ok = subsample.Ratio(fy, fx)
+	}
+	// Chroma samples covering several pixels only stay with those pixels
+	// when the image holds whole samples
+	if !ok || b.Min.X%fx != 0 || b.Min.Y%fy != 0 || w%fx != 0 || h%fy != 0 {
+		full := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio444)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				i, c := full.YOffset(x, y), src.COffset(b.Min.X+x, b.Min.Y+y)
+				full.Y[i] = src.Y[src.YOffset(b.Min.X+x, b.Min.Y+y)]
+				full.Cb[i], full.Cr[i] = src.Cb[c], src.Cr[c]
+			}
+		}
+		src, b, fx, fy, ratio = full, full.Rect, 1, 1, image.YCbCrSubsampleRatio444
+	}
+
+	dst := image.NewYCbCr(r, ratio)
+	remap(dst.Y, dst.YStride, src.Y[src.YOffset(b.Min.X, b.Min.Y):], src.YStride, w, h, 1, o)
+	c := src.COffset(b.Min.X, b.Min.Y)
+	remap(dst.Cb, dst.CStride, src.Cb[c:], src.CStride, w/fx, h/fy, 1, o)
+	remap(dst.Cr, dst.CStride, src.Cr[c:], src.CStride, w/fx, h/fy, 1, o)
+	return dst
+}
+
+// remap copies the w x h grid of n-element pixels in src to dst,
+// rearranged by o.
+func remap[T any](dst []T, dstStride int, src []T, srcStride, w, h, n int, o Op) {
+	dw, dh := w, h
+	if o.Swaps() {
+		dw, dh = h, w
+	}
+	for y := 0; y < dh; y++ {
+		row := dst[y*dstStride : y*dstStride+dw*n]
+		if o == FlipVertical {
+			copy(row, src[(h-1-y)*srcStride:])
+			continue
+		}
+		for x := 0; x < dw; x++ {
+			sx, sy := o.source(x, y, w, h)
+			copy(row[x*n:x*n+n], src[sy*srcStride+sx*n:])
+		}
+	}
+}
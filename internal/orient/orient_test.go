@@ -0,0 +1,156 @@
+package orient
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"reflect"
+	"testing"
+
+	"video-processor/internal/hdr"
+)
+
+// opaque hides an image's concrete type.
+type opaque struct{ image.Image }
+
+// settable is an image whose pixels can be set.
+type settable interface {
+	image.Image
+	Set(x, y int, c color.Color)
+}
+
+// fill gives every pixel of m a colour made of its coordinates.
+func fill(m settable, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			m.Set(x, y, color.NRGBA{uint8(16 * (x - r.Min.X)), uint8(16 * (y - r.Min.Y)), uint8(x*y%256 + 1), 255})
+		}
+	}
+}
+
+// ycbcrImage returns a YCbCr image whose luma and chroma samples all differ.
+func ycbcrImage(r image.Rectangle, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	m := image.NewYCbCr(r, ratio)
+	for i := range m.Y {
+		m.Y[i] = uint8(i)
+	}
+	for i := range m.Cb {
+		m.Cb[i], m.Cr[i] = uint8(3*i), uint8(255-5*i)
+	}
+	return m
+}
+
+func TestApply(t *testing.T) {
+	r := image.Rect(2, 4, 8, 8)
+	nrgba := image.NewNRGBA(r)
+	fill(nrgba, r)
+	gray16 := image.NewGray16(r)
+	for i := range gray16.Pix {
+		gray16.Pix[i] = uint8(7 * i)
+	}
+	paletted := image.NewPaletted(r, palette.Plan9)
+	fill(paletted, r)
+	cmyk := image.NewCMYK(r)
+	fill(cmyk, r)
+	linear := hdr.NewImage(r, 1000)
+	for i := range linear.Pix {
+		linear.Pix[i] = float32(i)
+	}
+	nycbcra := &image.NYCbCrA{YCbCr: *ycbcrImage(r, image.YCbCrSubsampleRatio420), A: make([]uint8, r.Dx()*r.Dy()), AStride: r.Dx()}
+	for i := range nycbcra.A {
+		nycbcra.A[i] = uint8(200 + i)
+	}
+
+	tests := []struct {
+		name string
+		src  image.Image
+	}{
+		{"nrgba", nrgba},
+		{"gray16", gray16},
+		{"paletted", paletted},
+		{"cmyk", cmyk},
+		{"hdr", linear},
+		{"ycbcr 420", ycbcrImage(r, image.YCbCrSubsampleRatio420)},
+		{"ycbcr 422", ycbcrImage(r, image.YCbCrSubsampleRatio422)},
+		{"ycbcr 411 odd", ycbcrImage(image.Rect(1, 0, 6, 3), image.YCbCrSubsampleRatio411)},
+		{"ycbcr 420 odd", ycbcrImage(image.Rect(0, 0, 5, 3), image.YCbCrSubsampleRatio420)},
+		{"nycbcra", nycbcra},
+		{"other", opaque{nrgba}},
+	}
+	for _, tt := range tests {
+		b := tt.src.Bounds()
+		for o := FlipHorizontal; o <= Rotate270; o++ {
+			got := Apply(tt.src, o)
+			size := b.Size()
+			if o.Swaps() {
+				size = image.Pt(size.Y, size.X)
+			}
+			if got.Bounds() != (image.Rectangle{Max: size}) {
+				t.Errorf("%s %v: bounds = %v, want size %v", tt.name, o, got.Bounds(), size)
+				continue
+			}
+			if _, isOpaque := tt.src.(opaque); !isOpaque && reflect.TypeOf(got) != reflect.TypeOf(tt.src) {
+				t.Errorf("%s %v: result is %T", tt.name, o, got)
+			}
+		pixels:
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					sx, sy := o.source(x, y, b.Dx(), b.Dy())
+					want := tt.src.At(b.Min.X+sx, b.Min.Y+sy)
+					if c := got.At(x, y); c != want && color.NRGBA64Model.Convert(c) != color.NRGBA64Model.Convert(want) {
+						t.Errorf("%s %v: pixel (%d, %d) = %v, want %v from (%d, %d)", tt.name, o, x, y, c, want, sx, sy)
+						break pixels
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestApplyYCbCrSubsampling(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   *image.YCbCr
+		o     Op
+		ratio image.YCbCrSubsampleRatio
+	}{
+		{"flip keeps 420", ycbcrImage(image.Rect(0, 0, 8, 4), image.YCbCrSubsampleRatio420), FlipHorizontal, image.YCbCrSubsampleRatio420},
+		{"turn keeps 420", ycbcrImage(image.Rect(0, 0, 8, 4), image.YCbCrSubsampleRatio420), Rotate90, image.YCbCrSubsampleRatio420},
+		{"turn makes 422 440", ycbcrImage(image.Rect(0, 0, 8, 4), image.YCbCrSubsampleRatio422), Rotate270, image.YCbCrSubsampleRatio440},
+		{"turn makes 440 422", ycbcrImage(image.Rect(0, 0, 8, 4), image.YCbCrSubsampleRatio440), Transpose, image.YCbCrSubsampleRatio422},
+		{"odd width", ycbcrImage(image.Rect(0, 0, 7, 4), image.YCbCrSubsampleRatio420), Rotate180, image.YCbCrSubsampleRatio444},
+		{"odd origin", ycbcrImage(image.Rect(1, 0, 9, 4), image.YCbCrSubsampleRatio422), FlipVertical, image.YCbCrSubsampleRatio444},
+		{"turned 411", ycbcrImage(image.Rect(0, 0, 8, 4), image.YCbCrSubsampleRatio411), Rotate90, image.YCbCrSubsampleRatio444},
+	}
+	for _, tt := range tests {
+		got := Apply(tt.src, tt.o).(*image.YCbCr)
+		if got.SubsampleRatio != tt.ratio {
+			t.Errorf("%s: subsampling = %v, want %v", tt.name, got.SubsampleRatio, tt.ratio)
+		}
+	}
+}
+
+func TestApplyNone(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 3, 2))
+	if got := Apply(m, None); got != image.Image(m) {
+		t.Error("Apply(None) did not return its input")
+	}
+	if got := Apply(image.NewRGBA(image.Rect(0, 0, 0, 5)), Rotate90); got.Bounds() != image.Rect(0, 0, 5, 0) {
+		t.Errorf("empty image turned to %v", got.Bounds())
+	}
+}
+
+func TestParseOp(t *testing.T) {
+	for _, name := range OpNames() {
+		o, err := ParseOp(name)
+		if err != nil || o.String() != name {
+			t.Errorf("ParseOp(%q) = %v, %v", name, o, err)
+		}
+	}
+	if o, err := ParseOp("Rotate-90"); err != nil || o != Rotate90 {
+		t.Errorf(`ParseOp("Rotate-90") = %v, %v`, o, err)
+	}
+	if _, err := ParseOp("sideways"); err == nil {
+		t.Error(`ParseOp("sideways") expected error`)
+	}
+}
@@ -0,0 +1,160 @@
+package pfm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+
+	"video-processor/internal/hdr"
+	"video-processor/internal/imagesize"
+)
+
+func init() {
+	image.RegisterFormat("pfm", "PF", Decode, DecodeConfig)
+	image.RegisterFormat("pfm", "Pf", Decode, DecodeConfig)
+}
+
+type header struct {
+	width, height int
+	channels      int
+	order         binary.ByteOrder
+}
+
+// readHeader parses the three whitespace-separated header fields after the
+// magic. A negative scale marks little-endian samples; its magnitude is
+// conventionally ignored.
+func readHeader(r *bufio.Reader) (header, error) {
+	var h header
+	magic, err := readToken(r)
+	if err != nil {
+		return h, err
+	}
+	switch magic {
+	case "PF":
+		h.channels = 3
+	case "Pf":
+		h.channels = 1
+	default:
+		return h, errors.New("not a PFM file")
+	}
+
+	var fields [3]string
+	for i := range fields {
+		if fields[i], err = readToken(r); err != nil {
+			return h, err
+		}
+	}
+	h.width, err = strconv.Atoi(fields[0])
+	if err == nil {
+		h.height, err = strconv.Atoi(fields[1])
+	}
+	if err != nil || !imagesize.Valid(h.width, h.height) {
+		return h, fmt.Errorf("invalid PFM dimensions %sx%s", fields[0], fields[1])
+	}
+	scale, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || scale == 0 {
+		return h, fmt.Errorf("invalid PFM scale %q", fields[2])
+	}
+	h.order = binary.BigEndian
+	if scale < 0 {
+		h.order = binary.LittleEndian
+	}
+	return h, nil
+}
+
+// readToken skips leading whitespace and returns the next word, consuming
+// the single whitespace byte that ends it.
+func readToken(r *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", fmt.Errorf("failed to read header: %w", err)
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			if len(token) > 0 {
+				return string(token), nil
+			}
+			continue
+		}
+		if len(token) >= 32 {
+			return "", errors.New("header field too long")
+		}
+		token = append(token, c)
+	}
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBA64Model, Width: h.width, Height: h.height}, nil
+}
+
+// Decode reads a PFM file into an *hdr.Image. Rows are stored bottom to
+// top; greyscale files are expanded to RGB and non-finite samples read as
+// zero. A value of 1 is taken as SDR reference white, and the image's Peak
+// is its brightest channel, but no less than that.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	img := hdr.NewImage(image.Rect(0, 0, h.width, h.height), hdr.SDRWhite)
+	line := make([]byte, 4*h.channels*h.width)
+	for i := 0; i < h.height; i++ {
+		if _, err := io.ReadFull(br, line); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("failed to read row %d: %w", i, err)
+		}
+		row := img.Pix[(h.height-1-i)*img.Stride:]
+		for x := 0; x < h.width; x++ {
+			for c := 0; c < 3; c++ {
+				s := x*h.channels + min(c, h.channels-1)
+				v := float64(math.Float32frombits(h.order.Uint32(line[4*s:])))
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					v = 0
+				}
+				row[4*x+c] = float32(v * hdr.SDRWhite)
+				img.Peak = math.Max(img.Peak, float64(row[4*x+c]))
+			}
+			row[4*x+3] = 1
+		}
+	}
+	return img, nil
+}
+
+// Encode writes m as a little-endian colour PFM. Images other than an
+// *hdr.Image are treated as sRGB; alpha is dropped.
+func Encode(w io.Writer, m image.Image) error {
+	img := hdr.FromImage(m)
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", width, height)
+
+	line := make([]byte, 12*width)
+	for y := height - 1; y >= 0; y-- {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				binary.LittleEndian.PutUint32(line[4*(3*x+c):], math.Float32bits(row[4*x+c]/hdr.SDRWhite))
+			}
+		}
+		bw.Write(line)
+	}
+	return bw.Flush()
+}
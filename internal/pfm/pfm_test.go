@@ -0,0 +1,84 @@
+package pfm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"strings"
+	"testing"
+
+	"video-processor/internal/hdr"
+)
+
+func TestRoundTrip(t *testing.T) {
+	src := hdr.NewImage(image.Rect(0, 0, 3, 2), hdr.SDRWhite)
+	for i := range src.Pix {
+		src.Pix[i] = float32(i) * 37.5
+		if i%4 == 3 {
+			src.Pix[i] = 1
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, src); err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	decoded, format, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatalf("image.Decode() unexpected error: %v", err)
+	}
+	if format != "pfm" {
+		t.Errorf("format = %q, want pfm", format)
+	}
+	got := decoded.(*hdr.Image)
+	for i := range src.Pix {
+		if math.Abs(float64(got.Pix[i]-src.Pix[i])) > 1e-3 {
+			t.Fatalf("sample %d = %v, want %v", i, got.Pix[i], src.Pix[i])
+		}
+	}
+	if want := float64(src.Pix[len(src.Pix)-2]); math.Abs(got.Peak-want) > 1e-3 {
+		t.Errorf("Peak = %v, want %v", got.Peak, want)
+	}
+}
+
+func TestDecodeGreyBigEndian(t *testing.T) {
+	// Rows are stored bottom first: the bottom row is 2.0, the top 0.25.
+	var file bytes.Buffer
+	file.WriteString("Pf\n2 2\n1.0\n")
+	for _, v := range []float32{2, 2, 0.25, float32(math.NaN())} {
+		binary.Write(&file, binary.BigEndian, v)
+	}
+
+	img, err := Decode(&file)
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	m := img.(*hdr.Image)
+	if p := m.Pix[m.PixOffset(0, 1):]; p[0] != 2*hdr.SDRWhite || p[1] != p[0] || p[2] != p[0] {
+		t.Errorf("bottom-left = %v, want grey at %v", p[:3], 2*hdr.SDRWhite)
+	}
+	if p := m.Pix[m.PixOffset(0, 0):]; p[0] != 0.25*hdr.SDRWhite {
+		t.Errorf("top-left = %v, want %v", p[:3], 0.25*hdr.SDRWhite)
+	}
+	if p := m.Pix[m.PixOffset(1, 0):]; p[0] != 0 {
+		t.Errorf("NaN sample decoded as %v, want 0", p[0])
+	}
+	if m.Peak != 2*hdr.SDRWhite {
+		t.Errorf("Peak = %v, want %v", m.Peak, 2*hdr.SDRWhite)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"not pfm":    "P6\n1 1\n255\n",
+		"zero scale": "PF\n1 1\n0\n",
+		"bad width":  "PF\n-1 1\n-1.0\n",
+		"truncated":  "PF\n2 1\n-1.0\n\x00\x00\x00\x00",
+		"huge":       "PF\n100000 100000\n-1.0\n",
+	} {
+		if _, err := Decode(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode() expected error", name)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package png
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	stdpng "image/png"
+	"io"
+)
+
+// Options configures Encode.
+type Options struct {
+	CompressionLevel stdpng.CompressionLevel
+	// Interlace writes Adam7 passes, so a partial download shows the whole
+	// picture at low resolution.
+	Interlace bool
+}
+
+// Encode writes m as a PNG. Non-interlaced output comes from image/png;
+// interlaced output picks the colour type the same way and filters each
+// row with whichever filter leaves the smallest residuals.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if !opts.Interlace {
+		enc := stdpng.Encoder{CompressionLevel: opts.CompressionLevel}
+		return enc.Encode(w, m)
+	}
+
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if bounds.Empty() || uint64(width) > 1<<31-1 || uint64(height) > 1<<31-1 {
+		return fmt.Errorf("cannot encode %dx%d image as PNG", width, height)
+	}
+	level, ok := zlibLevels[opts.CompressionLevel]
+	if !ok {
+		return fmt.Errorf("invalid PNG compression level %d", opts.CompressionLevel)
+	}
+
+	f := pixelFormat(m)
+	var idat bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&idat, level)
+	if err != nil {
+		return err
+	}
+	filter := opts.CompressionLevel != stdpng.NoCompression
+	for _, p := range adam7 {
+		cols := (width - p.x + p.dx - 1) / p.dx
+		rows := (height - p.y + p.dy - 1) / p.dy
+		if cols <= 0 || rows <= 0 {
+			continue
+		}
+		// prev starts as the zero row above the first, as the filters
+		// expect at the top of each pass.
+		rowBytes := (cols*f.bitsPerPixel + 7) / 8
+		prev := make([]byte, rowBytes)
+		cur := make([]byte, rowBytes)
+		for r := 0; r < rows; r++ {
+			clear(cur)
+			for c := 0; c < cols; c++ {
+				f.put(cur, c, bounds.Min.X+p.x+c*p.dx, bounds.Min.Y+p.y+r*p.dy)
+			}
+			row := append([]byte{ftNone}, cur...)
+			if filter {
+				row = filterRow(cur, prev, (f.bitsPerPixel+7)/8)
+			}
+			if _, err := zw.Write(row); err != nil {
+				return err
+			}
+			prev, cur = cur, prev
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(width))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(height))
+	ihdr = append(ihdr, byte(f.bitDepth), byte(f.colorType), 0, 0, 1)
+	writeChunk(bw, "IHDR", ihdr)
+	if f.palette != nil {
+		var plte, trns []byte
+		for _, c := range f.palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			plte = append(plte, n.R, n.G, n.B)
+			trns = append(trns, n.A)
+		}
+		writeChunk(bw, "PLTE", plte)
+		// tRNS may stop after the last translucent entry.
+		for len(trns) > 0 && trns[len(trns)-1] == 0xff {
+			trns = trns[:len(trns)-1]
+		}
+		if len(trns) > 0 {
+			writeChunk(bw, "tRNS", trns)
+		}
+	}
+	writeChunk(bw, "IDAT", idat.Bytes())
+	writeChunk(bw, "IEND", nil)
+	return bw.Flush()
+}
+
+var zlibLevels = map[stdpng.CompressionLevel]int{
+	stdpng.DefaultCompression: zlib.DefaultCompression,
+	stdpng.NoCompression:      zlib.NoCompression,
+	stdpng.BestSpeed:          zlib.BestSpeed,
+	stdpng.BestCompression:    zlib.BestCompression,
+}
+
+// adam7 lists each pass's first pixel and spacing.
+var adam7 = []struct{ x, y, dx, dy int }{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+func writeChunk(w *bufio.Writer, name string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], name)
+	w.Write(header[:])
+	w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// Colour types.
+const (
+	ctGray     = 0
+	ctRGB      = 2
+	ctPaletted = 3
+	ctRGBAlpha = 6
+)
+
+// Filter types.
+const (
+	ftNone = iota
+	ftSub
+	ftUp
+	ftAverage
+	ftPaeth
+	numFilters
+)
+
+const maxPalette = 256
+
+// format is how pixels are stored, with put writing pixel (x, y) of the
+// image as column c of a row.
+type format struct {
+	colorType    int
+	bitDepth     int
+	bitsPerPixel int
+	palette      color.Palette
+	put          func(row []byte, c, x, y int)
+}
+
+// pixelFormat follows image/png: paletted images keep their palette,
+// packed into fewer bits when it is small, greyscale stays greyscale, and
+// everything else is RGB, with alpha unless the image is opaque, at 16
+// bits for 16-bit colour models.
+func pixelFormat(m image.Image) format {
+	switch src := m.(type) {
+	case *image.Paletted:
+		if len(src.Palette) > 0 && len(src.Palette) <= maxPalette {
+			depth := 8
+			switch {
+			case len(src.Palette) <= 2:
+				depth = 1
+			case len(src.Palette) <= 4:
+				depth = 2
+			case len(src.Palette) <= 16:
+				depth = 4
+			}
+			return format{ctPaletted, depth, depth, src.Palette, func(row []byte, c, x, y int) {
+				shift := 8 - depth - c*depth%8
+				row[c*depth/8] |= src.ColorIndexAt(x, y) << shift
+			}}
+		}
+	case *image.Gray:
+		return format{ctGray, 8, 8, nil, func(row []byte, c, x, y int) {
+			row[c] = src.GrayAt(x, y).Y
+		}}
+	case *image.Gray16:
+		return format{ctGray, 16, 16, nil, func(row []byte, c, x, y int) {
+			binary.BigEndian.PutUint16(row[2*c:], src.Gray16At(x, y).Y)
+		}}
+	}
+
+	channels, colorType := 3, ctRGB
+	if !opaque(m) {
+		channels, colorType = 4, ctRGBAlpha
+	}
+	bounds := m.Bounds()
+	switch m.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		src, ok := m.(*image.NRGBA64)
+		if !ok {
+			src = image.NewNRGBA64(bounds)
+			draw.Draw(src, bounds, m, bounds.Min, draw.Src)
+		}
+		return format{colorType, 16, 16 * channels, nil, func(row []byte, c, x, y int) {
+			copy(row[2*channels*c:], src.Pix[src.PixOffset(x, y):][:2*channels])
+		}}
+	}
+	src, ok := m.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(bounds)
+		draw.Draw(src, bounds, m, bounds.Min, draw.Src)
+	}
+	return format{colorType, 8, 8 * channels, nil, func(row []byte, c, x, y int) {
+		copy(row[channels*c:], src.Pix[src.PixOffset(x, y):][:channels])
+	}}
+}
+
+func opaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// filterRow returns cur, prefixed by its filter type, filtered with
+// whichever filter gives the smallest sum of absolute residuals, the
+// heuristic the PNG specification suggests. bpp is the distance in bytes
+// to the corresponding byte of the pixel on the left.
+func filterRow(cur, prev []byte, bpp int) []byte {
+	best, bestSum := []byte(nil), -1
+	out := make([]byte, len(cur)+1)
+	for ft := 0; ft < numFilters; ft++ {
+		out[0] = byte(ft)
+		sum := 0
+		for i, x := range cur {
+			var a, c byte
+			if i >= bpp {
+				a, c = cur[i-bpp], prev[i-bpp]
+			}
+			b := prev[i]
+			var pred byte
+			switch ft {
+			case ftSub:
+				pred = a
+			case ftUp:
+				pred = b
+			case ftAverage:
+				pred = byte((int(a) + int(b)) / 2)
+			case ftPaeth:
+				pred = paeth(a, b, c)
+			}
+			out[i+1] = x - pred
+			sum += abs(int(int8(out[i+1])))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = append(best[:0], out...), sum
+		}
+	}
+	return best
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
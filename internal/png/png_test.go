@@ -0,0 +1,131 @@
+package png
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	stdpng "image/png"
+	"strings"
+	"testing"
+)
+
+func fill(m interface {
+	image.Image
+	Set(x, y int, c color.Color)
+}) {
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			m.Set(x, y, color.NRGBA64{uint16(x * 4099), uint16(y * 7919), uint16((x ^ y) * 257), uint16(0xffff - x*y*31)})
+		}
+	}
+}
+
+func TestInterlace(t *testing.T) {
+	palette := func(n int) color.Palette {
+		p := make(color.Palette, n)
+		for i := range p {
+			p[i] = color.NRGBA{uint8(i * 37), uint8(i * 11), uint8(i), uint8(255 - i%3*40)}
+		}
+		return p
+	}
+	images := []struct {
+		name string
+		new  func(r image.Rectangle) image.Image
+	}{
+		{"nrgba", func(r image.Rectangle) image.Image { return image.NewNRGBA(r) }},
+		{"rgba", func(r image.Rectangle) image.Image { return image.NewRGBA(r) }},
+		{"nrgba64", func(r image.Rectangle) image.Image { return image.NewNRGBA64(r) }},
+		{"gray", func(r image.Rectangle) image.Image { return image.NewGray(r) }},
+		{"gray16", func(r image.Rectangle) image.Image { return image.NewGray16(r) }},
+		{"paletted2", func(r image.Rectangle) image.Image { return image.NewPaletted(r, palette(2)) }},
+		{"paletted4", func(r image.Rectangle) image.Image { return image.NewPaletted(r, palette(4)) }},
+		{"paletted16", func(r image.Rectangle) image.Image { return image.NewPaletted(r, palette(16)) }},
+		{"paletted256", func(r image.Rectangle) image.Image { return image.NewPaletted(r, palette(256)) }},
+	}
+	// Sizes smaller than 8 pixels leave some passes empty.
+	sizes := []image.Rectangle{
+		image.Rect(0, 0, 1, 1),
+		image.Rect(0, 0, 3, 2),
+		image.Rect(0, 0, 13, 9),
+		image.Rect(5, -3, 40, 20),
+	}
+	levels := []stdpng.CompressionLevel{stdpng.DefaultCompression, stdpng.NoCompression, stdpng.BestSpeed, stdpng.BestCompression}
+	for _, im := range images {
+		for _, r := range sizes {
+			m := im.new(r)
+			fill(m.(interface {
+				image.Image
+				Set(x, y int, c color.Color)
+			}))
+			for _, level := range levels {
+				var buf bytes.Buffer
+				if err := Encode(&buf, m, &Options{CompressionLevel: level, Interlace: true}); err != nil {
+					t.Fatalf("%s %v level %d: Encode() unexpected error: %v", im.name, r, level, err)
+				}
+				// Byte 28 of the file is the IHDR interlace method.
+				if buf.Bytes()[28] != 1 {
+					t.Errorf("%s %v level %d: output is not interlaced", im.name, r, level)
+				}
+				got, err := stdpng.Decode(&buf)
+				if err != nil {
+					t.Fatalf("%s %v level %d: Decode() unexpected error: %v", im.name, r, level, err)
+				}
+				if got.Bounds().Size() != r.Size() {
+					t.Fatalf("%s %v: size = %v", im.name, r, got.Bounds().Size())
+				}
+				for y := 0; y < r.Dy(); y++ {
+					for x := 0; x < r.Dx(); x++ {
+						// Premultiplied sources lose precision converting
+						// to PNG's straight alpha, as they do in image/png.
+						want := got.ColorModel().Convert(m.At(r.Min.X+x, r.Min.Y+y))
+						if c := got.At(x, y); c != want {
+							t.Fatalf("%s %v level %d: pixel (%d, %d) = %v, want %v", im.name, r, level, x, y, c, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 4, 4))
+	var plain, def bytes.Buffer
+	if err := Encode(&plain, m, nil); err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	if err := stdpng.Encode(&def, m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain.Bytes(), def.Bytes()) {
+		t.Error("nil Options output differs from image/png's")
+	}
+
+	err := Encode(&bytes.Buffer{}, m, &Options{CompressionLevel: 7, Interlace: true})
+	if err == nil || !strings.Contains(err.Error(), "compression level") {
+		t.Errorf("Encode() with level 7 error = %v, want invalid compression level", err)
+	}
+	err = Encode(&bytes.Buffer{}, image.NewGray(image.Rect(0, 0, 0, 4)), &Options{Interlace: true})
+	if err == nil {
+		t.Error("Encode() of an empty image succeeded")
+	}
+}
+
+func TestFilterRow(t *testing.T) {
+	tests := []struct {
+		name      string
+		cur, prev []byte
+		want      byte
+	}{
+		{"flat", []byte{0, 0, 0, 0}, []byte{10, 20, 30, 40}, ftNone},
+		{"ramp", []byte{50, 51, 52, 53}, []byte{0, 0, 0, 0}, ftSub},
+		{"same as above", []byte{10, 20, 30, 40}, []byte{10, 20, 30, 40}, ftUp},
+	}
+	for _, tt := range tests {
+		row := filterRow(tt.cur, tt.prev, 1)
+		if row[0] != tt.want {
+			t.Errorf("%s: filter = %d, want %d", tt.name, row[0], tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package qoi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+const (
+	magic      = "qoif"
+	headerSize = 14
+	// maxPixels is the limit the reference implementation imposes.
+	maxPixels = 400_000_000
+)
+
+// Chunk tags. The 8-bit tags take precedence over the 2-bit ones.
+const (
+	opIndex = 0x00
+	opDiff  = 0x40
+	opLuma  = 0x80
+	opRun   = 0xc0
+	opRGB   = 0xfe
+	opRGBA  = 0xff
+	mask2   = 0xc0
+)
+
+// endMarker terminates the chunk stream.
+var endMarker = [8]byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+func init() {
+	image.RegisterFormat("qoi", magic, Decode, DecodeConfig)
+}
+
+// Colorspace is the informative colour space flag of the header. It does
+// not change how pixels are coded.
+type Colorspace uint8
+
+const (
+	// SRGB is sRGB colour with linear alpha.
+	SRGB Colorspace = 0
+	// Linear is linear colour and alpha.
+	Linear Colorspace = 1
+)
+
+// Header is the fixed-size header at the start of a QOI file.
+type Header struct {
+	Width, Height int
+	// Channels is 3 for RGB and 4 for RGBA.
+	Channels   int
+	Colorspace Colorspace
+}
+
+// ReadHeader reads and validates a header.
+func ReadHeader(r io.Reader) (Header, error) {
+	var buf [headerSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Header{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(buf[:4]) != magic {
+		return Header{}, errors.New("not a QOI file")
+	}
+	w, h := binary.BigEndian.Uint32(buf[4:]), binary.BigEndian.Uint32(buf[8:])
+	header := Header{Width: int(w), Height: int(h), Channels: int(buf[12]), Colorspace: Colorspace(buf[13])}
+	if w == 0 || h == 0 || uint64(w)*uint64(h) > maxPixels {
+		return header, fmt.Errorf("invalid QOI dimensions %dx%d", w, h)
+	}
+	if header.Channels != 3 && header.Channels != 4 {
+		return header, fmt.Errorf("invalid QOI channel count %d", header.Channels)
+	}
+	if header.Colorspace > Linear {
+		return header, fmt.Errorf("invalid QOI colorspace %d", header.Colorspace)
+	}
+	return header, nil
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := ReadHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: h.Width, Height: h.Height}, nil
+}
+
+func hash(p [4]byte) int {
+	return (int(p[0])*3 + int(p[1])*5 + int(p[2])*7 + int(p[3])*11) % 64
+}
+
+// Decode reads a QOI image into an *image.NRGBA. Three-channel images
+// decode as opaque.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, h.Width, h.Height))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	run := 0
+	for o := 0; o < len(img.Pix); o += 4 {
+		if run > 0 {
+			run--
+			copy(img.Pix[o:o+4], px[:])
+			continue
+		}
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, truncated(err)
+		}
+		switch {
+		case b == opRGB || b == opRGBA:
+			n := 3
+			if b == opRGBA {
+				n = 4
+			}
+			if _, err := io.ReadFull(br, px[:n]); err != nil {
+				return nil, truncated(err)
+			}
+		case b&mask2 == opIndex:
+			px = index[b]
+		case b&mask2 == opDiff:
+			px[0] += (b>>4)&3 - 2
+			px[1] += (b>>2)&3 - 2
+			px[2] += b&3 - 2
+		case b&mask2 == opLuma:
+			b2, err := br.ReadByte()
+			if err != nil {
+				return nil, truncated(err)
+			}
+			dg := b&0x3f - 32
+			px[0] += dg + b2>>4 - 8
+			px[1] += dg
+			px[2] += dg + b2&0x0f - 8
+		default:
+			run = int(b & 0x3f)
+		}
+		index[hash(px)] = px
+		copy(img.Pix[o:o+4], px[:])
+	}
+
+	if h.Channels == 3 {
+		for o := 3; o < len(img.Pix); o += 4 {
+			img.Pix[o] = 255
+		}
+	}
+	return img, nil
+}
+
+func truncated(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("failed to read pixels: %w", err)
+}
+
+// Options configures Encode.
+type Options struct {
+	// Channels is 3 to drop alpha, 4 to keep it, or 0 to keep it only when
+	// the image is not opaque.
+	Channels   int
+	Colorspace Colorspace
+}
+
+// Encode writes m as QOI. A nil o writes sRGB with alpha only when needed.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	bounds := m.Bounds()
+	if bounds.Empty() || uint64(bounds.Dx())*uint64(bounds.Dy()) > maxPixels {
+		return fmt.Errorf("cannot encode %dx%d image as QOI", bounds.Dx(), bounds.Dy())
+	}
+	if opts.Colorspace > Linear {
+		return fmt.Errorf("invalid QOI colorspace %d", opts.Colorspace)
+	}
+
+	src, ok := m.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(bounds)
+		draw.Draw(src, bounds, m, bounds.Min, draw.Src)
+	}
+	channels := opts.Channels
+	switch channels {
+	case 0:
+		channels = 3
+		if !src.Opaque() {
+			channels = 4
+		}
+	case 3, 4:
+	default:
+		return fmt.Errorf("invalid QOI channel count %d", channels)
+	}
+
+	bw := bufio.NewWriter(w)
+	var header [headerSize]byte
+	copy(header[:], magic)
+	binary.BigEndian.PutUint32(header[4:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(header[8:], uint32(bounds.Dy()))
+	header[12], header[13] = byte(channels), byte(opts.Colorspace)
+	bw.Write(header[:])
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 255}
+	run := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := src.Pix[src.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			px := [4]byte{row[4*x], row[4*x+1], row[4*x+2], 255}
+			if channels == 4 {
+				px[3] = row[4*x+3]
+			}
+
+			if px == prev {
+				run++
+				if run == 62 {
+					bw.WriteByte(opRun | byte(run-1))
+					run = 0
+				}
+				continue
+			}
+			if run > 0 {
+				bw.WriteByte(opRun | byte(run-1))
+				run = 0
+			}
+
+			h := hash(px)
+			switch {
+			case index[h] == px:
+				bw.WriteByte(opIndex | byte(h))
+			case px[3] != prev[3]:
+				bw.Write([]byte{opRGBA, px[0], px[1], px[2], px[3]})
+			default:
+				// Differences wrap around, as the decoder's additions do.
+				dr, dg, db := int8(px[0]-prev[0]), int8(px[1]-prev[1]), int8(px[2]-prev[2])
+				drg, dbg := dr-dg, db-dg
+				switch {
+				case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+					bw.WriteByte(opDiff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+				case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+					bw.Write([]byte{opLuma | byte(dg+32), byte(drg+8)<<4 | byte(dbg+8)})
+				default:
+					bw.Write([]byte{opRGB, px[0], px[1], px[2]})
+				}
+			}
+			index[h] = px
+			prev = px
+		}
+	}
+	if run > 0 {
+		bw.WriteByte(opRun | byte(run-1))
+	}
+	bw.Write(endMarker[:])
+	return bw.Flush()
+}
@@ -0,0 +1,163 @@
+package qoi
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"video-processor/internal/resize"
+)
+
+func gradient(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Runs, small and large steps, and a translucent corner.
+			c := color.NRGBA{R: uint8(x * 3), G: uint8(y * 40), B: uint8((x / 8) * 100), A: 255}
+			if x > width-4 && y > height-4 {
+				c.A = uint8(x * 20)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func roundTrip(t *testing.T, src image.Image, opts *Options) *image.NRGBA {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, opts); err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	img, format, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatalf("image.Decode() unexpected error: %v", err)
+	}
+	if format != "qoi" {
+		t.Errorf("format = %q, want qoi", format)
+	}
+	return img.(*image.NRGBA)
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := gradient(100, 10)
+	if got := roundTrip(t, src, nil); !bytes.Equal(got.Pix, src.Pix) {
+		t.Error("RGBA round trip changed pixels")
+	}
+
+	got := roundTrip(t, src, &Options{Channels: 3, Colorspace: Linear})
+	for i := 0; i < len(src.Pix); i += 4 {
+		if !bytes.Equal(got.Pix[i:i+3], src.Pix[i:i+3]) || got.Pix[i+3] != 255 {
+			t.Fatalf("RGB round trip pixel %d = %v, want %v opaque", i/4, got.Pix[i:i+4], src.Pix[i:i+3])
+		}
+	}
+}
+
+func TestRoundTripResizeOutput(t *testing.T) {
+	for _, size := range []image.Point{{37, 23}, {64, 64}, {1, 200}} {
+		src, err := resize.Resize(gradient(120, 90), size.X, size.Y)
+		if err != nil {
+			t.Fatalf("Resize() unexpected error: %v", err)
+		}
+		if got := roundTrip(t, src, nil); !bytes.Equal(got.Pix, src.Pix) || got.Rect != src.Rect {
+			t.Errorf("%v: round trip of resized image changed it", size)
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, gradient(5, 4), &Options{Channels: 4, Colorspace: Linear}); err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), endMarker[:]) {
+		t.Error("missing end marker")
+	}
+	h, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatalf("ReadHeader() unexpected error: %v", err)
+	}
+	if h != (Header{Width: 5, Height: 4, Channels: 4, Colorspace: Linear}) {
+		t.Errorf("header = %+v", h)
+	}
+}
+
+func TestEncodeChunks(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 6, 1))
+	for i, c := range []color.NRGBA{{10, 20, 30, 255}, {11, 21, 31, 255}, {11, 21, 31, 255}, {30, 41, 52, 255}, {10, 20, 30, 255}, {10, 20, 30, 128}} {
+		src.SetNRGBA(i, 0, c)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, nil); err != nil {
+		t.Fatalf("Encode() unexpected error: %v", err)
+	}
+	want := []byte{
+		0xfe, 10, 20, 30, // too far from black for a difference
+		0x7f,       // diff +1,+1,+1
+		0xc0,       // run of one
+		0xb4, 0x79, // luma: green +20, red -1 and blue +1 relative to it
+		0x09, // index 9, the first pixel
+		0xff, 10, 20, 30, 128,
+	}
+	if got := buf.Bytes()[headerSize : buf.Len()-len(endMarker)]; !bytes.Equal(got, want) {
+		t.Errorf("chunks = % x, want % x", got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := func() []byte {
+		var buf bytes.Buffer
+		Encode(&buf, gradient(8, 8), nil)
+		return buf.Bytes()
+	}
+	for name, data := range map[string][]byte{
+		"not qoi":      []byte("qoix\x00\x00\x00\x01\x00\x00\x00\x01\x04\x00"),
+		"zero width":   []byte("qoif\x00\x00\x00\x00\x00\x00\x00\x01\x04\x00"),
+		"huge":         []byte("qoif\xff\xff\xff\xff\xff\xff\xff\xff\x04\x00"),
+		"bad channels": []byte("qoif\x00\x00\x00\x01\x00\x00\x00\x01\x02\x00"),
+		"truncated":    valid()[:20],
+	} {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode() expected error", name)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	var buf bytes.Buffer
+	Encode(&buf, gradient(16, 4), nil)
+	f.Add(buf.Bytes())
+	f.Add([]byte("qoif\x00\x00\x00\x02\x00\x00\x00\x01\x03\x01\xfe\x01\x02\x03\xc0"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if h, err := ReadHeader(bytes.NewReader(data)); err != nil || h.Width*h.Height > 1<<16 {
+			return
+		}
+		img, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// Whatever decodes must survive re-encoding unchanged.
+		if got := roundTrip(t, img, &Options{Channels: 4}); !bytes.Equal(got.Pix, img.(*image.NRGBA).Pix) {
+			t.Error("re-encoding a decoded image changed it")
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte{1, 2, 3, 255, 1, 2, 3, 255, 200, 0, 7, 9}, uint8(3))
+	f.Add(bytes.Repeat([]byte{9}, 400), uint8(10))
+
+	f.Fuzz(func(t *testing.T, pix []byte, width uint8) {
+		if width == 0 || len(pix) < 4*int(width) {
+			return
+		}
+		height := len(pix) / 4 / int(width)
+		src := image.NewNRGBA(image.Rect(0, 0, int(width), height))
+		copy(src.Pix, pix)
+		if got := roundTrip(t, src, &Options{Channels: 4}); !bytes.Equal(got.Pix, src.Pix) {
+			t.Error("round trip changed pixels")
+		}
+	})
+}
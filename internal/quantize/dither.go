@@ -0,0 +1,257 @@
+package quantize
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// None maps every pixel to its nearest palette colour.
+type None struct{}
+
+func (None) Dither(dst *image.Paletted, src *image.NRGBA) {
+	p := newPalette(dst.Palette)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*src.Stride + x*4
+			dst.Pix[y*dst.Stride+x] = uint8(p.nearest(int32(src.Pix[i]), int32(src.Pix[i+1]), int32(src.Pix[i+2])))
+		}
+	}
+}
+
+// FloydSteinberg diffuses each pixel's quantization error to its
+// unprocessed neighbours with weights 7/16, 3/16, 5/16 and 1/16.
+type FloydSteinberg struct{}
+
+func (FloydSteinberg) Dither(dst *image.Paletted, src *image.NRGBA) {
+	p := newPalette(dst.Palette)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	// Error for the current and next row, with a pixel of padding either
+	// side so the kernel never needs bounds checks.
+	current := make([][3]float64, width+2)
+	next := make([][3]float64, width+2)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*src.Stride + x*4
+			var value [3]int32
+			for c := 0; c < 3; c++ {
+				value[c] = clamp8(float64(src.Pix[i+c]) + current[x+1][c])
+			}
+
+			index := p.nearest(value[0], value[1], value[2])
+			dst.Pix[y*dst.Stride+x] = uint8(index)
+
+			chosen := p.colors[index]
+			for c := 0; c < 3; c++ {
+				e := float64(value[c] - chosen[c])
+				current[x+2][c] += e * 7 / 16
+				next[x][c] += e * 3 / 16
+				next[x+1][c] += e * 5 / 16
+				next[x+2][c] += e * 1 / 16
+			}
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = [3]float64{}
+		}
+	}
+}
+
+// Bayer is ordered dithering with a Size x Size Bayer threshold matrix.
+// Size must be a power of two; 8 is used when it is not.
+type Bayer struct {
+	Size int
+}
+
+func (b Bayer) Dither(dst *image.Paletted, src *image.NRGBA) {
+	size := b.Size
+	if size < 2 || size&(size-1) != 0 {
+		size = 8
+	}
+	ordered(dst, src, bayerMatrix(size), size)
+}
+
+// bayerMatrix returns thresholds in [0, 1) for an n x n Bayer matrix,
+// built recursively from M(2n) = [4M, 4M+2; 4M+3, 4M+1].
+func bayerMatrix(n int) []float64 {
+	m := []int{0}
+	for size := 1; size < n; size *= 2 {
+		next := make([]int, 4*size*size)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				v := 4 * m[y*size+x]
+				next[y*2*size+x] = v
+				next[y*2*size+x+size] = v + 2
+				next[(y+size)*2*size+x] = v + 3
+				next[(y+size)*2*size+x+size] = v + 1
+			}
+		}
+		m = next
+	}
+
+	thresholds := make([]float64, len(m))
+	for i, v := range m {
+		thresholds[i] = (float64(v) + 0.5) / float64(len(m))
+	}
+	return thresholds
+}
+
+// BlueNoise is ordered dithering with a 64x64 void-and-cluster threshold
+// matrix, which avoids the cross-hatch texture of Bayer dithering.
+type BlueNoise struct{}
+
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce   sync.Once
+	blueNoiseMatrix []float64
+)
+
+func (BlueNoise) Dither(dst *image.Paletted, src *image.NRGBA) {
+	blueNoiseOnce.Do(func() {
+		blueNoiseMatrix = voidAndCluster(blueNoiseSize, 1.5, 1)
+	})
+	ordered(dst, src, blueNoiseMatrix, blueNoiseSize)
+}
+
+// ordered offsets each pixel by its threshold, scaled to the typical gap
+// between palette levels, before picking the nearest colour.
+func ordered(dst *image.Paletted, src *image.NRGBA, thresholds []float64, size int) {
+	p := newPalette(dst.Palette)
+	spread := 255 / math.Max(1, math.Cbrt(float64(len(dst.Palette))))
+
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			offset := (thresholds[(y%size)*size+x%size] - 0.5) * spread
+			i := y*src.Stride + x*4
+			dst.Pix[y*dst.Stride+x] = uint8(p.nearest(
+				clamp8(float64(src.Pix[i])+offset),
+				clamp8(float64(src.Pix[i+1])+offset),
+				clamp8(float64(src.Pix[i+2])+offset),
+			))
+		}
+	}
+}
+
+// voidAndCluster generates an n x n blue-noise threshold matrix with
+// Ulichney's void-and-cluster method on a torus, returning thresholds in
+// [0, 1).
+func voidAndCluster(n int, sigma float64, seed int64) []float64 {
+	size := n * n
+
+	// Gaussian energy contributed by a point at toroidal offset (dx, dy).
+	lut := make([]float64, size)
+	for dy := 0; dy < n; dy++ {
+		for dx := 0; dx < n; dx++ {
+			x, y := float64(min(dx, n-dx)), float64(min(dy, n-dy))
+			lut[dy*n+dx] = math.Exp(-(x*x + y*y) / (2 * sigma * sigma))
+		}
+	}
+
+	pattern := make([]bool, size)
+	energy := make([]float64, size)
+	toggle := func(bits []bool, energy []float64, q int, on bool) {
+		bits[q] = on
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		qx, qy := q%n, q/n
+		for p := 0; p < size; p++ {
+			dx := (p%n - qx + n) % n
+			dy := (p/n - qy + n) % n
+			energy[p] += sign * lut[dy*n+dx]
+		}
+	}
+	// extreme returns the index with the highest (or lowest) energy among
+	// pixels whose bit equals want.
+	extreme := func(bits []bool, energy []float64, want, highest bool) int {
+		best := -1
+		for p := 0; p < size; p++ {
+			if bits[p] != want {
+				continue
+			}
+			if best < 0 || (highest && energy[p] > energy[best]) || (!highest && energy[p] < energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+
+	// Initial binary pattern: about a tenth of the pixels, at random.
+	rng := rand.New(rand.NewSource(seed))
+	ones := size / 10
+	for placed := 0; placed < ones; {
+		if q := rng.Intn(size); !pattern[q] {
+			toggle(pattern, energy, q, true)
+			placed++
+		}
+	}
+
+	// Move points from the tightest cluster to the largest void until
+	// that stops changing anything.
+	for {
+		cluster := extreme(pattern, energy, true, true)
+		toggle(pattern, energy, cluster, false)
+		void := extreme(pattern, energy, false, false)
+		toggle(pattern, energy, void, true)
+		if void == cluster {
+			break
+		}
+	}
+
+	rank := make([]int, size)
+
+	// Phase 1: rank the prototype's points by removing tightest clusters.
+	bits := append([]bool(nil), pattern...)
+	e := append([]float64(nil), energy...)
+	for r := ones - 1; r >= 0; r-- {
+		cluster := extreme(bits, e, true, true)
+		toggle(bits, e, cluster, false)
+		rank[cluster] = r
+	}
+
+	// Phase 2: fill the largest voids up to half coverage.
+	r := ones
+	for ; r < size/2; r++ {
+		void := extreme(pattern, energy, false, false)
+		toggle(pattern, energy, void, true)
+		rank[void] = r
+	}
+
+	// Phase 3: with the roles of ones and zeros swapped, keep filling the
+	// tightest clusters of zeros.
+	zeros := make([]bool, size)
+	zeroEnergy := make([]float64, size)
+	for p := 0; p < size; p++ {
+		if !pattern[p] {
+			toggle(zeros, zeroEnergy, p, true)
+		}
+	}
+	for ; r < size; r++ {
+		cluster := extreme(zeros, zeroEnergy, true, true)
+		toggle(zeros, zeroEnergy, cluster, false)
+		rank[cluster] = r
+	}
+
+	thresholds := make([]float64, size)
+	for p, v := range rank {
+		thresholds[p] = (float64(v) + 0.5) / float64(size)
+	}
+	return thresholds
+}
+
+func clamp8(value float64) int32 {
+	if value <= 0 {
+		return 0
+	}
+	if value >= 255 {
+		return 255
+	}
+	return int32(value + 0.5)
+}
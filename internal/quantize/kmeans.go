@@ -0,0 +1,60 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+// KMeans refines a median cut palette with Lloyd's algorithm over the
+// colour histogram, stopping after Iterations rounds or once no centroid
+// moves.
+type KMeans struct {
+	Iterations int
+}
+
+func (k KMeans) Palette(img image.Image, n int) color.Palette {
+	colors := newHistogram(img).entries()
+	if len(colors) == 0 {
+		return color.Palette{color.Black}
+	}
+
+	seed := MedianCut{}.Palette(img, n)
+	centroids := newPalette(seed).colors
+
+	for iteration := 0; iteration < k.Iterations; iteration++ {
+		sums := make([][3]int64, len(centroids))
+		counts := make([]int64, len(centroids))
+
+		p := &palette{colors: centroids}
+		for _, c := range colors {
+			i := p.nearest(int32(c.rgb[0]), int32(c.rgb[1]), int32(c.rgb[2]))
+			for ch := 0; ch < 3; ch++ {
+				sums[i][ch] += int64(c.rgb[ch]) * int64(c.count)
+			}
+			counts[i] += int64(c.count)
+		}
+
+		moved := false
+		for i := range centroids {
+			if counts[i] == 0 {
+				continue
+			}
+			for ch := 0; ch < 3; ch++ {
+				value := int32((sums[i][ch] + counts[i]/2) / counts[i])
+				if value != centroids[i][ch] {
+					centroids[i][ch] = value
+					moved = true
+				}
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	out := make(color.Palette, len(centroids))
+	for i, c := range centroids {
+		out[i] = color.RGBA{R: uint8(c[0]), G: uint8(c[1]), B: uint8(c[2]), A: 0xFF}
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// MedianCut repeatedly splits the colour box with the largest weighted
+// extent at the median of its longest axis (Heckbert, 1982).
+type MedianCut struct{}
+
+type colorBox struct {
+	colors []weightedColor
+	count  int
+}
+
+func newColorBox(colors []weightedColor) colorBox {
+	box := colorBox{colors: colors}
+	for _, c := range colors {
+		box.count += c.count
+	}
+	return box
+}
+
+// longestAxis returns the channel with the widest range and that range.
+func (b colorBox) longestAxis() (int, int) {
+	low := [3]uint8{255, 255, 255}
+	high := [3]uint8{}
+	for _, c := range b.colors {
+		for i := 0; i < 3; i++ {
+			low[i] = min(low[i], c.rgb[i])
+			high[i] = max(high[i], c.rgb[i])
+		}
+	}
+
+	axis, extent := 0, -1
+	for i := 0; i < 3; i++ {
+		if r := int(high[i]) - int(low[i]); r > extent {
+			axis, extent = i, r
+		}
+	}
+	return axis, extent
+}
+
+// split divides the box at the pixel-weighted median of its longest axis.
+func (b colorBox) split() (colorBox, colorBox) {
+	axis, _ := b.longestAxis()
+	sort.SliceStable(b.colors, func(i, j int) bool {
+		return b.colors[i].rgb[axis] < b.colors[j].rgb[axis]
+	})
+
+	half, seen := b.count/2, 0
+	cut := 1
+	for i, c := range b.colors[:len(b.colors)-1] {
+		seen += c.count
+		cut = i + 1
+		if seen >= half {
+			break
+		}
+	}
+	return newColorBox(b.colors[:cut]), newColorBox(b.colors[cut:])
+}
+
+func (b colorBox) average() color.Color {
+	var r, g, bl int
+	for _, c := range b.colors {
+		r += int(c.rgb[0]) * c.count
+		g += int(c.rgb[1]) * c.count
+		bl += int(c.rgb[2]) * c.count
+	}
+	return color.RGBA{
+		R: uint8((r + b.count/2) / b.count),
+		G: uint8((g + b.count/2) / b.count),
+		B: uint8((bl + b.count/2) / b.count),
+		A: 0xFF,
+	}
+}
+
+func (MedianCut) Palette(img image.Image, n int) color.Palette {
+	colors := newHistogram(img).entries()
+	if len(colors) == 0 {
+		return color.Palette{color.Black}
+	}
+
+	boxes := []colorBox{newColorBox(colors)}
+	for len(boxes) < n {
+		// Split the box whose extent, weighted by population, is largest.
+		best, bestScore := -1, 0
+		for i, box := range boxes {
+			if len(box.colors) < 2 {
+				continue
+			}
+			_, extent := box.longestAxis()
+			if score := extent * box.count; score > bestScore || best < 0 {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		a, b := boxes[best].split()
+		boxes[best] = a
+		boxes = append(boxes, b)
+	}
+
+	palette := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		palette[i] = box.average()
+	}
+	return palette
+}
@@ -0,0 +1,127 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+// Octree builds an 8-level colour octree (Gervautz & Purgathofer, 1988)
+// and merges the least populated leaves of the deepest level until at
+// most n leaves remain.
+type Octree struct{}
+
+type octreeNode struct {
+	children   [8]*octreeNode
+	r, g, b    int
+	count      int
+	pixels     int // pixels anywhere below this node
+	leaf       bool
+	childCount int
+}
+
+type octree struct {
+	root   *octreeNode
+	levels [8][]*octreeNode
+	leaves int
+}
+
+func octreeIndex(c [3]uint8, level int) int {
+	shift := 7 - level
+	return int(c[0]>>shift&1)<<2 | int(c[1]>>shift&1)<<1 | int(c[2]>>shift&1)
+}
+
+func (t *octree) insert(c weightedColor) {
+	node := t.root
+	node.pixels += c.count
+	for level := 0; level < 8; level++ {
+		index := octreeIndex(c.rgb, level)
+		child := node.children[index]
+		if child == nil {
+			child = &octreeNode{leaf: level == 7}
+			node.children[index] = child
+			node.childCount++
+			if level < 7 {
+				t.levels[level+1] = append(t.levels[level+1], child)
+			} else {
+				t.leaves++
+			}
+		}
+		node = child
+		node.pixels += c.count
+	}
+	node.r += int(c.rgb[0]) * c.count
+	node.g += int(c.rgb[1]) * c.count
+	node.b += int(c.rgb[2]) * c.count
+	node.count += c.count
+}
+
+// reduce folds the children of the least populated node on the deepest
+// level that still has internal nodes into that node.
+func (t *octree) reduce() bool {
+	for level := 7; level >= 0; level-- {
+		var best *octreeNode
+		bestIndex := -1
+		for i, node := range t.levels[level] {
+			if node.leaf {
+				continue
+			}
+			if best == nil || node.pixels < best.pixels {
+				best, bestIndex = node, i
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		for i, child := range best.children {
+			if child == nil {
+				continue
+			}
+			best.r += child.r
+			best.g += child.g
+			best.b += child.b
+			best.count += child.count
+			best.children[i] = nil
+		}
+		t.leaves -= best.childCount - 1
+		best.childCount = 0
+		best.leaf = true
+		t.levels[level] = append(t.levels[level][:bestIndex], t.levels[level][bestIndex+1:]...)
+		return true
+	}
+	return false
+}
+
+func (t *octree) palette(node *octreeNode, out color.Palette) color.Palette {
+	if node.leaf {
+		return append(out, color.RGBA{
+			R: uint8((node.r + node.count/2) / node.count),
+			G: uint8((node.g + node.count/2) / node.count),
+			B: uint8((node.b + node.count/2) / node.count),
+			A: 0xFF,
+		})
+	}
+	for _, child := range node.children {
+		if child != nil {
+			out = t.palette(child, out)
+		}
+	}
+	return out
+}
+
+func (Octree) Palette(img image.Image, n int) color.Palette {
+	colors := newHistogram(img).entries()
+	if len(colors) == 0 {
+		return color.Palette{color.Black}
+	}
+
+	t := &octree{root: &octreeNode{}}
+	t.levels[0] = []*octreeNode{t.root}
+	for _, c := range colors {
+		t.insert(c)
+	}
+	for t.leaves > n && t.reduce() {
+	}
+
+	return t.palette(t.root, nil)
+}
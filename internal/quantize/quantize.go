@@ -0,0 +1,268 @@
+package quantize
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strings"
+)
+
+// AlphaThreshold is the 16-bit alpha below which a pixel is mapped to the
+// transparent palette entry; indexed formats have no partial transparency.
+const AlphaThreshold = 0x8000
+
+// Quantizer chooses a palette representative of an image.
+type Quantizer interface {
+	// Palette returns at most n opaque colours for the opaque pixels of img.
+	Palette(img image.Image, n int) color.Palette
+}
+
+// Ditherer maps an opaque image onto the palette of dst. src and dst share
+// the same bounds, anchored at the origin.
+type Ditherer interface {
+	Dither(dst *image.Paletted, src *image.NRGBA)
+}
+
+// ToPaletted reduces img to at most n colours chosen by q and mapped with
+// d. If img has transparent pixels one of the n entries is reserved for
+// them.
+func ToPaletted(img image.Image, n int, q Quantizer, d Ditherer) (*image.Paletted, error) {
+	if n < 2 || n > 256 {
+		return nil, fmt.Errorf("palette size must be between 2 and 256, got %d", n)
+	}
+
+	opaqueColors := n
+	if HasTransparency(img) {
+		opaqueColors--
+	}
+	return Remap(img, q.Palette(img, opaqueColors), d), nil
+}
+
+// Remap maps img onto an existing palette with d. Pixels below
+// AlphaThreshold use the palette's first fully transparent entry; if the
+// palette has none, one is appended (replacing the last colour of a full
+// palette).
+func Remap(img image.Image, palette color.Palette, d Ditherer) *image.Paletted {
+	if d == nil {
+		d = None{}
+	}
+
+	src := flatten(img)
+	rect := src.Rect
+	transparent := HasTransparency(img)
+
+	opaque, transparentIndex := splitPalette(palette)
+	if len(opaque) == 0 {
+		opaque = color.Palette{color.Black, color.White}
+	}
+	if transparent && transparentIndex < 0 {
+		if len(opaque) == 256 {
+			opaque = opaque[:255]
+		}
+		transparentIndex = len(opaque)
+	}
+
+	// Dither against the opaque colours only; transparent pixels are
+	// patched in afterwards.
+	dithered := image.NewPaletted(rect, opaque)
+	d.Dither(dithered, src)
+	if !transparent {
+		return dithered
+	}
+
+	// Put the transparent entry back in its original slot so that an
+	// unchanged palette still compares equal to the one passed in.
+	full := make(color.Palette, 0, len(opaque)+1)
+	full = append(full, opaque[:transparentIndex]...)
+	full = append(full, color.RGBA{})
+	full = append(full, opaque[transparentIndex:]...)
+
+	dst := image.NewPaletted(rect, full)
+	bounds := img.Bounds()
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			if _, _, _, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA(); a < AlphaThreshold {
+				dst.Pix[y*dst.Stride+x] = uint8(transparentIndex)
+				continue
+			}
+			index := int(dithered.Pix[y*dithered.Stride+x])
+			if index >= transparentIndex {
+				index++
+			}
+			dst.Pix[y*dst.Stride+x] = uint8(index)
+		}
+	}
+	return dst
+}
+
+// HasTransparency reports whether any pixel of img is below AlphaThreshold.
+func HasTransparency(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return false
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < AlphaThreshold {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitPalette returns the opaque entries of palette in order and the
+// index of its first transparent entry, or -1.
+func splitPalette(palette color.Palette) (color.Palette, int) {
+	opaque := make(color.Palette, 0, len(palette))
+	transparentIndex := -1
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			if transparentIndex < 0 {
+				transparentIndex = i
+			}
+			continue
+		}
+		opaque = append(opaque, c)
+	}
+	return opaque, transparentIndex
+}
+
+// flatten copies img into an origin-anchored NRGBA with alpha forced to
+// opaque, so translucent edges keep their own colour rather than blending
+// towards black.
+func flatten(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	for i := 3; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = 0xFF
+	}
+	return dst
+}
+
+// histogram counts the opaque colours of img.
+type histogram map[[3]uint8]int
+
+func newHistogram(img image.Image) histogram {
+	h := histogram{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if uint32(c.A)*0x101 < AlphaThreshold {
+				continue
+			}
+			h[[3]uint8{c.R, c.G, c.B}]++
+		}
+	}
+	return h
+}
+
+// entries returns the histogram as a slice in a deterministic order.
+func (h histogram) entries() []weightedColor {
+	colors := make([]weightedColor, 0, len(h))
+	for c, count := range h {
+		colors = append(colors, weightedColor{rgb: c, count: count})
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		a, b := colors[i].rgb, colors[j].rgb
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	return colors
+}
+
+type weightedColor struct {
+	rgb   [3]uint8
+	count int
+}
+
+// palette is an opaque colour table with a nearest-colour search.
+type palette struct {
+	colors [][3]int32
+}
+
+func newPalette(p color.Palette) *palette {
+	out := &palette{colors: make([][3]int32, len(p))}
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		out.colors[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+	}
+	return out
+}
+
+// nearest returns the index of the colour closest to r, g, b in squared
+// Euclidean distance.
+func (p *palette) nearest(r, g, b int32) int {
+	best, bestDistance := 0, int32(1<<31-1)
+	for i, c := range p.colors {
+		dr, dg, db := r-c[0], g-c[1], b-c[2]
+		distance := dr*dr + dg*dg + db*db
+		if distance < bestDistance {
+			best, bestDistance = i, distance
+			if distance == 0 {
+				break
+			}
+		}
+	}
+	return best
+}
+
+var quantizers = map[string]func() Quantizer{
+	"mediancut": func() Quantizer { return MedianCut{} },
+	"octree":    func() Quantizer { return Octree{} },
+	"kmeans":    func() Quantizer { return KMeans{Iterations: 8} },
+}
+
+var ditherers = map[string]func() Ditherer{
+	"none":            func() Ditherer { return None{} },
+	"floyd-steinberg": func() Ditherer { return FloydSteinberg{} },
+	"bayer":           func() Ditherer { return Bayer{Size: 8} },
+	"bluenoise":       func() Ditherer { return BlueNoise{} },
+}
+
+// QuantizerByName returns the quantizer registered under name.
+func QuantizerByName(name string) (Quantizer, error) {
+	newQuantizer, ok := quantizers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown quantizer %q (available: %s)", name, strings.Join(names(quantizers), ", "))
+	}
+	return newQuantizer(), nil
+}
+
+// DithererByName returns the ditherer registered under name.
+func DithererByName(name string) (Ditherer, error) {
+	newDitherer, ok := ditherers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown dither %q (available: %s)", name, strings.Join(names(ditherers), ", "))
+	}
+	return newDitherer(), nil
+}
+
+// QuantizerNames lists the names understood by QuantizerByName.
+func QuantizerNames() []string {
+	return names(quantizers)
+}
+
+// DithererNames lists the names understood by DithererByName.
+func DithererNames() []string {
+	return names(ditherers)
+}
+
+func names[T any](registry map[string]T) []string {
+	out := make([]string, 0, len(registry))
+	for name := range registry {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,187 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// newGradient returns a smooth two-axis gradient with many distinct colours.
+func newGradient(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{
+				R: uint8(x * 255 / (width - 1)),
+				G: uint8(y * 255 / (height - 1)),
+				B: uint8((x + y) * 255 / (width + height - 2)),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+func TestQuantizers(t *testing.T) {
+	src := newGradient(64, 64)
+
+	for _, name := range QuantizerNames() {
+		q, err := QuantizerByName(name)
+		if err != nil {
+			t.Fatalf("QuantizerByName(%q) unexpected error: %v", name, err)
+		}
+
+		for _, n := range []int{2, 16, 256} {
+			palette := q.Palette(src, n)
+			if len(palette) == 0 || len(palette) > n {
+				t.Errorf("%s: Palette(n=%d) returned %d colours", name, n, len(palette))
+			}
+			for _, c := range palette {
+				if _, _, _, a := c.RGBA(); a != 0xFFFF {
+					t.Errorf("%s: palette colour %v is not opaque", name, c)
+				}
+			}
+		}
+	}
+}
+
+func TestQuantizersExactWhenFewColours(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	want := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {10, 20, 30, 255}}
+	for i := range want {
+		for x := 0; x < 4; x++ {
+			src.SetNRGBA(x, i, want[i])
+		}
+	}
+
+	for _, name := range QuantizerNames() {
+		q, _ := QuantizerByName(name)
+		result, err := ToPaletted(src, 8, q, None{})
+		if err != nil {
+			t.Fatalf("%s: ToPaletted() unexpected error: %v", name, err)
+		}
+		for y := 0; y < 4; y++ {
+			got := color.NRGBAModel.Convert(result.At(0, y)).(color.NRGBA)
+			if got != want[y] {
+				t.Errorf("%s: row %d = %v, want %v", name, y, got, want[y])
+			}
+		}
+	}
+}
+
+func TestDitherersPreserveMeanColour(t *testing.T) {
+	// A flat mid-grey mapped onto black and white should come out roughly
+	// half white for any real ditherer.
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := range src.Pix {
+		src.Pix[i] = 128
+	}
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 255
+	}
+	palette := color.Palette{color.Black, color.White}
+
+	for _, name := range []string{"floyd-steinberg", "bayer", "bluenoise"} {
+		d, err := DithererByName(name)
+		if err != nil {
+			t.Fatalf("DithererByName(%q) unexpected error: %v", name, err)
+		}
+
+		result := Remap(src, palette, d)
+		white := 0
+		for _, index := range result.Pix {
+			white += int(index)
+		}
+		fraction := float64(white) / float64(len(result.Pix))
+		if math.Abs(fraction-0.5) > 0.05 {
+			t.Errorf("%s: %.2f of pixels white, want ~0.5", name, fraction)
+		}
+	}
+
+	result := Remap(src, palette, None{})
+	for _, index := range result.Pix {
+		if index != result.Pix[0] {
+			t.Fatal("none: flat input produced more than one colour")
+		}
+	}
+}
+
+func TestRemapTransparency(t *testing.T) {
+	src := newGradient(8, 8)
+	for x := 0; x < 8; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{})
+	}
+
+	palette := color.Palette{color.Black, color.White}
+	result := Remap(src, palette, FloydSteinberg{})
+	if len(result.Palette) != 3 {
+		t.Fatalf("palette has %d entries, want 3 (transparent appended)", len(result.Palette))
+	}
+	for x := 0; x < 8; x++ {
+		if _, _, _, a := result.At(x, 0).RGBA(); a != 0 {
+			t.Errorf("pixel (%d,0) alpha = %d, want transparent", x, a)
+		}
+	}
+	if _, _, _, a := result.At(3, 3).RGBA(); a != 0xFFFF {
+		t.Error("opaque pixel became transparent")
+	}
+
+	// An existing transparent entry keeps its slot.
+	withTransparent := color.Palette{color.RGBA{}, color.Black, color.White}
+	if got := Remap(src, withTransparent, None{}); len(got.Palette) != 3 || got.Pix[0] != 0 {
+		t.Errorf("existing transparent entry not reused: %v, index %d", got.Palette, got.Pix[0])
+	}
+}
+
+func TestToPalettedReservesTransparentEntry(t *testing.T) {
+	src := newGradient(32, 32)
+	src.SetNRGBA(0, 0, color.NRGBA{})
+
+	result, err := ToPaletted(src, 16, MedianCut{}, BlueNoise{})
+	if err != nil {
+		t.Fatalf("ToPaletted() unexpected error: %v", err)
+	}
+	if len(result.Palette) > 16 {
+		t.Errorf("palette has %d entries, want at most 16", len(result.Palette))
+	}
+	if _, _, _, a := result.At(0, 0).RGBA(); a != 0 {
+		t.Error("transparent pixel was not preserved")
+	}
+
+	if _, err := ToPaletted(src, 1, MedianCut{}, None{}); err == nil {
+		t.Error("ToPaletted() expected error for a 1-colour palette")
+	}
+}
+
+func TestBayerMatrix(t *testing.T) {
+	want := []float64{0, 8, 2, 10, 12, 4, 14, 6, 3, 11, 1, 9, 15, 7, 13, 5}
+	got := bayerMatrix(4)
+	for i := range want {
+		if math.Abs(got[i]-(want[i]+0.5)/16) > 1e-9 {
+			t.Fatalf("bayerMatrix(4) = %v, want ranks %v", got, want)
+		}
+	}
+}
+
+func TestVoidAndClusterIsPermutation(t *testing.T) {
+	const n = 16
+	thresholds := voidAndCluster(n, 1.5, 1)
+	seen := make([]bool, n*n)
+	for _, v := range thresholds {
+		rank := int(v*n*n - 0.5 + 1e-6)
+		if rank < 0 || rank >= n*n || seen[rank] {
+			t.Fatalf("rank %d duplicated or out of range", rank)
+		}
+		seen[rank] = true
+	}
+}
+
+func TestByNameErrors(t *testing.T) {
+	if _, err := QuantizerByName("popularity"); err == nil {
+		t.Error("QuantizerByName() expected error")
+	}
+	if _, err := DithererByName("atkinson"); err == nil {
+		t.Error("DithererByName() expected error")
+	}
+}
@@ -0,0 +1,112 @@
+package resize
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"video-processor/internal/filters"
+)
+
+// Parity identifies one field of an interlaced frame.
+type Parity int
+
+const (
+	// TopField holds frame rows 0, 2, 4, ...
+	TopField Parity = iota
+	// BottomField holds frame rows 1, 3, 5, ...
+	BottomField
+)
+
+// fieldHeight returns the number of rows of field p in a frame of the given
+// height.
+func fieldHeight(height int, p Parity) int {
+	return (height + 1 - int(p)) / 2
+}
+
+// ExtractField returns the rows of field p as a half-height image.
+func ExtractField(src image.Image, p Parity) *image.NRGBA {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), fieldHeight(bounds.Dy(), p)))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		row := image.Rect(0, y, bounds.Dx(), y+1)
+		draw.Draw(dst, row, src, image.Pt(bounds.Min.X, bounds.Min.Y+2*y+int(p)), draw.Src)
+	}
+	return dst
+}
+
+// ResizeField scales field p, taken from a frame frameHeight rows tall, to a
+// full width x height progressive frame. The field's rows are taken to sit
+// on their original frame lines rather than spread evenly over the frame,
+// so bobbing between the two fields of a frame does not make the picture
+// jump up and down.
+func ResizeField(field image.Image, p Parity, frameHeight, width, height int, filter filters.Resampler) (*image.NRGBA, error) {
+	if field == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: width=%d, height=%d", width, height)
+	}
+	if filter == nil {
+		return nil, errors.New("filter is nil")
+	}
+	if rows := field.Bounds().Dy(); fieldHeight(frameHeight, p) != rows {
+		return nil, fmt.Errorf("a field of %d rows cannot come from a frame of %d rows", rows, frameHeight)
+	}
+
+	horizontal, err := resizeHorizontal(field, width, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Output row y lies on source frame row (y+1/2)*frameHeight/height - 1/2,
+	// which is field row (that - p) / 2
+	s := sampling{scale: float64(frameHeight) / float64(2*height), offset: 0.25 - float64(p)/2}
+	return resizeVerticalSampled(horizontal, height, filter, s)
+}
+
+// ResizeFields resizes an interlaced frame to width x height while keeping
+// it interlaced: each field is scaled on its own and woven back into the
+// matching rows of the output, so no output row mixes the two fields.
+func ResizeFields(src image.Image, width, height int, filter filters.Resampler) (*image.NRGBA, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: width=%d, height=%d", width, height)
+	}
+	if filter == nil {
+		return nil, errors.New("filter is nil")
+	}
+	srcHeight := src.Bounds().Dy()
+	if srcHeight < 2 || height < 2 {
+		return nil, fmt.Errorf("interlaced frames need at least two rows, got %d -> %d", srcHeight, height)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	scale := float64(srcHeight) / float64(height)
+
+	for _, p := range []Parity{TopField, BottomField} {
+		field, err := resizeHorizontal(ExtractField(src, p), width, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		// Output field row j sits on frame row 2j+p. Mapping that through the
+		// frame scale and back into source field rows differs from the
+		// centred field-to-field mapping by (scale-1)(p/2 - 1/4).
+		s := sampling{scale: scale, offset: (scale - 1) * (float64(p)/2 - 0.25)}
+		scaled, err := resizeVerticalSampled(field, fieldHeight(height, p), filter, s)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < scaled.Rect.Dy(); j++ {
+			row := image.Rect(0, 2*j+int(p), width, 2*j+int(p)+1)
+			draw.Draw(dst, row, scaled, image.Pt(0, j), draw.Src)
+		}
+	}
+
+	return dst, nil
+}
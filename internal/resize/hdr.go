@@ -0,0 +1,61 @@
+package resize
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"video-processor/internal/filters"
+	"video-processor/internal/hdr"
+)
+
+// ResizeHDR resizes a linear-light image in floating point, so highlights
+// are neither clipped nor resampled in a gamma-encoded space. Colour is
+// premultiplied by alpha while filtering.
+func ResizeHDR(src *hdr.Image, width, height int, filter filters.Resampler) (*hdr.Image, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: width=%d, height=%d", width, height)
+	}
+	if filter == nil {
+		return nil, errors.New("filter is nil")
+	}
+
+	srcWidth, srcHeight := src.Rect.Dx(), src.Rect.Dy()
+	if srcWidth <= 0 || srcHeight <= 0 {
+		return nil, errors.New("source image is empty")
+	}
+	var channels [4][]float64
+	for c := range channels {
+		channels[c] = make([]float64, srcWidth*srcHeight)
+	}
+	for y := 0; y < srcHeight; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < srcWidth; x++ {
+			i := y*srcWidth + x
+			alpha := float64(row[x*4+3])
+			for c := 0; c < 3; c++ {
+				channels[c][i] = float64(row[x*4+c]) * alpha
+			}
+			channels[3][i] = alpha
+		}
+	}
+	for c := range channels {
+		channels[c] = resampleValues(channels[c], srcWidth, srcHeight, width, height, filter, Axis{}, Axis{})
+	}
+
+	dst := hdr.NewImage(image.Rect(0, 0, width, height), src.Peak)
+	for i := 0; i < width*height; i++ {
+		alpha := min(max(channels[3][i], 0), 1)
+		if alpha == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			dst.Pix[i*4+c] = float32(channels[c][i] / alpha)
+		}
+		dst.Pix[i*4+3] = float32(alpha)
+	}
+	return dst, nil
+}
@@ -0,0 +1,138 @@
+package resize
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"video-processor/internal/filters"
+)
+
+// Plane is a single 8-bit channel stored row by row, such as the luma or
+// one chroma plane of an image.YCbCr.
+type Plane struct {
+	Pix           []uint8
+	Stride        int
+	Width, Height int
+}
+
+func NewPlane(width, height int) Plane {
+	return Plane{Pix: make([]uint8, width*height), Stride: width, Width: width, Height: height}
+}
+
+// At returns the sample at column x of row y.
+func (p Plane) At(x, y int) uint8 {
+	return p.Pix[y*p.Stride+x]
+}
+
+// Axis maps destination samples along one axis onto the source: sample i
+// is centred on source position (i+0.5)*Scale-0.5+Offset. A zero Scale
+// aligns the edges of the source and destination.
+type Axis struct {
+	Scale, Offset float64
+}
+
+func (a Axis) sampling(srcSize, dstSize int) sampling {
+	if a.Scale == 0 {
+		s := newSampling(srcSize, dstSize)
+		s.offset = a.Offset
+		return s
+	}
+	return sampling{scale: a.Scale, offset: a.Offset}
+}
+
+// ResamplePlane resizes src to width x height with the given mapping along
+// each axis. Unlike ResizeWithFilter the grids need not share their edges,
+// which lets callers move samples by a fraction of a pixel, e.g. to change
+// chroma siting.
+func ResamplePlane(src Plane, width, height int, filter filters.Resampler, x, y Axis) (Plane, error) {
+	values, err := resamplePlane(src, width, height, filter, x, y)
+	if err != nil {
+		return Plane{}, err
+	}
+	dst := NewPlane(width, height)
+	for i, v := range values {
+		dst.Pix[i] = clampUint8(v)
+	}
+	return dst, nil
+}
+
+// resamplePlane is ResamplePlane without the final rounding, so callers
+// can process the samples further before quantizing them once.
+func resamplePlane(src Plane, width, height int, filter filters.Resampler, x, y Axis) ([]float64, error) {
+	if src.Width <= 0 || src.Height <= 0 {
+		return nil, errors.New("source plane is empty")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: width=%d, height=%d", width, height)
+	}
+	if filter == nil {
+		return nil, errors.New("filter is nil")
+	}
+
+	values := make([]float64, src.Width*src.Height)
+	for row := 0; row < src.Height; row++ {
+		for col := 0; col < src.Width; col++ {
+			values[row*src.Width+col] = float64(src.At(col, row))
+		}
+	}
+	return resampleValues(values, src.Width, src.Height, width, height, filter, x, y), nil
+}
+
+// resampleValues resizes a srcWidth x srcHeight grid of samples stored row
+// by row. The result is src itself when neither axis changes.
+func resampleValues(src []float64, srcWidth, srcHeight, width, height int, filter filters.Resampler, x, y Axis) []float64 {
+	sx := x.sampling(srcWidth, width)
+	sy := y.sampling(srcHeight, height)
+
+	tmp := src
+	if srcWidth != width || !sx.identity() {
+		tmp = make([]float64, width*srcHeight)
+		weights := calculateSampledWeights(srcWidth, width, filter, sx)
+		support := sx.support(filter)
+		for col := 0; col < width; col++ {
+			left := firstTap(sx, support, col)
+			for row := 0; row < srcHeight; row++ {
+				line := src[row*srcWidth : (row+1)*srcWidth]
+				var sum float64
+				for i, weight := range weights[col] {
+					if weight != 0 && left+i < srcWidth {
+						sum += line[left+i] * weight
+					}
+				}
+				tmp[row*width+col] = sum
+			}
+		}
+	}
+
+	if srcHeight == height && sy.identity() {
+		return tmp
+	}
+
+	dst := make([]float64, width*height)
+	weights := calculateSampledWeights(srcHeight, height, filter, sy)
+	support := sy.support(filter)
+	for row := 0; row < height; row++ {
+		top := firstTap(sy, support, row)
+		for col := 0; col < width; col++ {
+			var sum float64
+			for i, weight := range weights[row] {
+				if weight != 0 && top+i < srcHeight {
+					sum += tmp[(top+i)*width+col] * weight
+				}
+			}
+			dst[row*width+col] = sum
+		}
+	}
+	return dst
+}
+
+// firstTap returns the source index of the first weight for destination
+// sample i, matching the window calculateSampledWeights uses.
+func firstTap(s sampling, support float64, i int) int {
+	return max(int(s.center(i)-support), 0)
+}
+
+func clampUint8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
@@ -6,10 +6,16 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"math"
 	"video-processor/internal/filters"
 )
 
 func Resize(src image.Image, width, height int) (*image.NRGBA, error) {
+	return ResizeWithFilter(src, width, height, filters.NewLanczos(3))
+}
+
+// ResizeWithFilter is Resize with an explicit resampling filter.
+func ResizeWithFilter(src image.Image, width, height int, filter filters.Resampler) (*image.NRGBA, error) {
 	dstWidth := width
 	dstHeight := height
 
@@ -19,31 +25,40 @@ func Resize(src image.Image, width, height int) (*image.NRGBA, error) {
 	if dstWidth <= 0 || dstHeight <= 0 {
 		return nil, fmt.Errorf("invalid dimensions: width=%d, height=%d", width, height)
 	}
+	if filter == nil {
+		return nil, errors.New("filter is nil")
+	}
 
 	srcWidth := src.Bounds().Dx()
 	srcHeight := src.Bounds().Dy()
 
 	if srcWidth != dstWidth && srcHeight != dstHeight {
-		image, err := resizeHorizontal(src, dstWidth)
+		image, err := resizeHorizontal(src, dstWidth, filter)
 		if err != nil {
 			return nil, err
 		}
-		return resizeVertical(image, dstHeight)
+		return resizeVertical(image, dstHeight, filter)
 	}
 
 	if srcWidth != dstWidth {
-		return resizeHorizontal(src, dstWidth)
+		return resizeHorizontal(src, dstWidth, filter)
 	}
 
-	return resizeVertical(src, dstHeight)
+	return resizeVertical(src, dstHeight, filter)
 }
 
-func resizeVertical(src image.Image, height int) (*image.NRGBA, error) {
+func resizeVertical(src image.Image, height int, filter filters.Resampler) (*image.NRGBA, error) {
+	return resizeVerticalSampled(src, height, filter, newSampling(src.Bounds().Dy(), height))
+}
+
+// resizeVerticalSampled is resizeVertical with an explicit mapping from
+// destination rows to source rows.
+func resizeVerticalSampled(src image.Image, height int, filter filters.Resampler, s sampling) (*image.NRGBA, error) {
 	srcBounds := src.Bounds()
 	srcWidth := srcBounds.Dx()
 	srcHeight := srcBounds.Dy()
 
-	if srcHeight == height {
+	if srcHeight == height && s.identity() {
 		// No vertical resize needed, just copy
 		dst := image.NewNRGBA(image.Rect(0, 0, srcWidth, height))
 		draw.Draw(dst, dst.Bounds(), src, srcBounds.Min, draw.Src)
@@ -51,8 +66,7 @@ func resizeVertical(src image.Image, height int) (*image.NRGBA, error) {
 	}
 
 	dst := image.NewNRGBA(image.Rect(0, 0, srcWidth, height))
-	filter := filters.NewLanczos(3)
-	weights := calculateWeights(srcHeight, height, filter)
+	weights := calculateSampledWeights(srcHeight, height, filter, s)
 
 	if weights == nil {
 		return nil, fmt.Errorf("failed to calculate weights for vertical resize")
@@ -64,12 +78,8 @@ func resizeVertical(src image.Image, height int) (*image.NRGBA, error) {
 			var r, g, b, a float64
 			pixelWeights := weights[dstY]
 
-			scale := float64(srcHeight) / float64(height)
-			center := (float64(dstY)+0.5)*scale - 0.5
-			support := float64(filter.Radius)
-			if scale > 1.0 {
-				support *= scale
-			}
+			center := s.center(dstY)
+			support := s.support(filter)
 
 			left := int(center - support)
 			right := int(center + support)
@@ -96,29 +106,30 @@ func resizeVertical(src image.Image, height int) (*image.NRGBA, error) {
 				weightIdx++
 			}
 
-			// Clamp values and convert back
+			// Clamp values and convert back. The sums are alpha-premultiplied,
+			// so colour channels may not exceed alpha.
+			if a < 0 {
+				a = 0
+			} else if a > 65535 {
+				a = 65535
+			}
 			if r < 0 {
 				r = 0
-			} else if r > 65535 {
-				r = 65535
+			} else if r > a {
+				r = a
 			}
 			if g < 0 {
 				g = 0
-			} else if g > 65535 {
-				g = 65535
+			} else if g > a {
+				g = a
 			}
 			if b < 0 {
 				b = 0
-			} else if b > 65535 {
-				b = 65535
-			}
-			if a < 0 {
-				a = 0
-			} else if a > 65535 {
-				a = 65535
+			} else if b > a {
+				b = a
 			}
 
-			dst.Set(x, dstY, color.NRGBA64{
+			dst.Set(x, dstY, color.RGBA64{
 				R: uint16(r),
 				G: uint16(g),
 				B: uint16(b),
@@ -130,7 +141,7 @@ func resizeVertical(src image.Image, height int) (*image.NRGBA, error) {
 	return dst, nil
 }
 
-func resizeHorizontal(src image.Image, width int) (*image.NRGBA, error) {
+func resizeHorizontal(src image.Image, width int, filter filters.Resampler) (*image.NRGBA, error) {
 	srcBounds := src.Bounds()
 	srcWidth := srcBounds.Dx()
 	srcHeight := srcBounds.Dy()
@@ -143,8 +154,8 @@ func resizeHorizontal(src image.Image, width int) (*image.NRGBA, error) {
 	}
 
 	dst := image.NewNRGBA(image.Rect(0, 0, width, srcHeight))
-	filter := filters.NewLanczos(3)
 	weights := calculateWeights(srcWidth, width, filter)
+	s := newSampling(srcWidth, width)
 
 	if weights == nil {
 		return nil, fmt.Errorf("failed to calculate weights for horizontal resize")
@@ -156,12 +167,8 @@ func resizeHorizontal(src image.Image, width int) (*image.NRGBA, error) {
 			var r, g, b, a float64
 			pixelWeights := weights[dstX]
 
-			scale := float64(srcWidth) / float64(width)
-			center := (float64(dstX)+0.5)*scale - 0.5
-			support := float64(filter.Radius)
-			if scale > 1.0 {
-				support *= scale
-			}
+			center := s.center(dstX)
+			support := s.support(filter)
 
 			left := int(center - support)
 			right := int(center + support)
@@ -188,29 +195,30 @@ func resizeHorizontal(src image.Image, width int) (*image.NRGBA, error) {
 				weightIdx++
 			}
 
-			// Clamp values and convert back
+			// Clamp values and convert back. The sums are alpha-premultiplied,
+			// so colour channels may not exceed alpha.
+			if a < 0 {
+				a = 0
+			} else if a > 65535 {
+				a = 65535
+			}
 			if r < 0 {
 				r = 0
-			} else if r > 65535 {
-				r = 65535
+			} else if r > a {
+				r = a
 			}
 			if g < 0 {
 				g = 0
-			} else if g > 65535 {
-				g = 65535
+			} else if g > a {
+				g = a
 			}
 			if b < 0 {
 				b = 0
-			} else if b > 65535 {
-				b = 65535
-			}
-			if a < 0 {
-				a = 0
-			} else if a > 65535 {
-				a = 65535
+			} else if b > a {
+				b = a
 			}
 
-			dst.Set(dstX, y, color.NRGBA64{
+			dst.Set(dstX, y, color.RGBA64{
 				R: uint16(r),
 				G: uint16(g),
 				B: uint16(b),
@@ -222,33 +230,62 @@ func resizeHorizontal(src image.Image, width int) (*image.NRGBA, error) {
 	return dst, nil
 }
 
+// sampling maps destination pixels along one axis onto source pixels.
+type sampling struct {
+	// scale is the number of source pixels per destination pixel.
+	scale float64
+	// offset shifts every source position, in source pixels.
+	offset float64
+}
+
+// newSampling aligns the edges of a srcSize axis with a dstSize axis.
+func newSampling(srcSize, dstSize int) sampling {
+	return sampling{scale: float64(srcSize) / float64(dstSize)}
+}
+
+func (s sampling) identity() bool {
+	return s.scale == 1 && s.offset == 0
+}
+
+// center returns the source position of destination pixel dstIdx.
+func (s sampling) center(dstIdx int) float64 {
+	return (float64(dstIdx)+0.5)*s.scale - 0.5 + s.offset
+}
+
+// support returns the filter radius in source pixels. Support radius should
+// be at least as large as the scaling factor for downsampling.
+func (s sampling) support(filter filters.Resampler) float64 {
+	support := filter.Support()
+	if s.scale > 1.0 {
+		support *= s.scale
+	}
+	return support
+}
+
 func calculateWeights(srcSize, dstSize int, filter filters.Resampler) [][]float64 {
 	if srcSize <= 0 || dstSize <= 0 {
 		return nil
 	}
+	return calculateSampledWeights(srcSize, dstSize, filter, newSampling(srcSize, dstSize))
+}
 
-	// Calculate the scaling factor
-	scale := float64(srcSize) / float64(dstSize)
-
-	// For downsampling, we need to expand the filter support
-	filterRadius := 1.0
-	if lanczos, ok := filter.(*filters.Lanczos); ok {
-		filterRadius = float64(lanczos.Radius)
+func calculateSampledWeights(srcSize, dstSize int, filter filters.Resampler, s sampling) [][]float64 {
+	if srcSize <= 0 || dstSize <= 0 {
+		return nil
 	}
 
-	// Support radius should be at least as large as the scaling factor for downsampling
-	support := filterRadius
-	if scale > 1.0 {
-		support *= scale
-	}
+	scale := s.scale
+	support := s.support(filter)
 
-	// Total number of weights needed per pixel
-	weightsPerPixel := int(2*support) + 1
+	// Total number of weights needed per pixel. The window [center-support,
+	// center+support] can straddle one more integer than its width when
+	// support is fractional, so round up and leave room for both ends.
+	weightsPerPixel := int(math.Ceil(2*support)) + 1
 	weights := make([][]float64, dstSize)
 
 	for dstIdx := 0; dstIdx < dstSize; dstIdx++ {
 		// Calculate the center position in source coordinates
-		center := (float64(dstIdx)+0.5)*scale - 0.5
+		center := s.center(dstIdx)
 
 		// Calculate the range of source pixels that contribute to this destination pixel
 		left := int(center - support)
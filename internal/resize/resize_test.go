@@ -3,8 +3,11 @@ package resize
 import (
 	"image"
 	"image/color"
+	"math"
 	"testing"
+	"video-processor/internal/colorspace"
 	"video-processor/internal/filters"
+	"video-processor/internal/hdr"
 )
 
 func TestCalculateWeights(t *testing.T) {
@@ -233,7 +236,7 @@ func TestResize(t *testing.T) {
 func TestResizeHorizontal(t *testing.T) {
 	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
 	
-	result, err := resizeHorizontal(src, 8)
+	result, err := resizeHorizontal(src, 8, filters.NewLanczos(3))
 	if err != nil {
 		t.Errorf("resizeHorizontal() unexpected error: %v", err)
 		return
@@ -249,7 +252,7 @@ func TestResizeHorizontal(t *testing.T) {
 func TestResizeVertical(t *testing.T) {
 	src := image.NewNRGBA(image.Rect(0, 0, 2, 4))
 	
-	result, err := resizeVertical(src, 8)
+	result, err := resizeVertical(src, 8, filters.NewLanczos(3))
 	if err != nil {
 		t.Errorf("resizeVertical() unexpected error: %v", err)
 		return
@@ -362,4 +365,323 @@ func BenchmarkResize(b *testing.B) {
 			Resize(src, 50, 50)
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestResizeFractionalSupport(t *testing.T) {
+	// 20 -> 16 gives a downsampling support of 3.75 whose window can cover
+	// one more source pixel than its width suggests.
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+
+	result, err := Resize(src, 16, 16)
+	if err != nil {
+		t.Fatalf("Resize() unexpected error: %v", err)
+	}
+	if bounds := result.Bounds(); bounds.Dx() != 16 || bounds.Dy() != 16 {
+		t.Errorf("Resize() result dimensions = %dx%d, want 16x16", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestResizeWithFilter(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 9, 7))
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = 200, 100, 50, 255
+	}
+
+	for _, name := range filters.Names() {
+		filter, err := filters.ByName(name)
+		if err != nil {
+			t.Fatalf("ByName(%q) unexpected error: %v", name, err)
+		}
+
+		for _, size := range [][2]int{{4, 3}, {13, 17}} {
+			result, err := ResizeWithFilter(src, size[0], size[1], filter)
+			if err != nil {
+				t.Fatalf("%s: ResizeWithFilter() unexpected error: %v", name, err)
+			}
+
+			// A flat image must stay flat whatever the kernel.
+			for y := 0; y < size[1]; y++ {
+				for x := 0; x < size[0]; x++ {
+					c := result.NRGBAAt(x, y)
+					if c.R < 199 || c.R > 201 || c.G < 99 || c.G > 101 || c.B < 49 || c.B > 51 {
+						t.Fatalf("%s %dx%d: pixel (%d,%d) = %v, want ~{200 100 50}", name, size[0], size[1], x, y, c)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := ResizeWithFilter(src, 4, 4, nil); err == nil {
+		t.Error("ResizeWithFilter() with nil filter expected error")
+	}
+}
+
+func TestResizePreservesTranslucentColor(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 6, 6))
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = 255, 128, 0, 128
+	}
+
+	result, err := Resize(src, 3, 3)
+	if err != nil {
+		t.Fatalf("Resize() unexpected error: %v", err)
+	}
+
+	// Straight-alpha colour must survive; treating premultiplied sums as
+	// straight values would halve it.
+	c := result.NRGBAAt(1, 1)
+	if c.R < 250 || c.G < 123 || c.G > 133 || c.A < 126 || c.A > 130 {
+		t.Errorf("translucent pixel = %v, want ~{255 128 0 128}", c)
+	}
+}
+
+func TestResizeKeepsColourAtAlphaEdges(t *testing.T) {
+	// Opaque red next to transparent black: the filter's overshoot must
+	// neither darken the fading edge nor leave colour brighter than its
+	// coverage allows.
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 1))
+	for x := 0; x < 4; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{255, 0, 0, 255})
+	}
+
+	result, err := Resize(src, 32, 1)
+	if err != nil {
+		t.Fatalf("Resize() unexpected error: %v", err)
+	}
+	faded := 0
+	for x := 0; x < 32; x++ {
+		c := result.NRGBAAt(x, 0)
+		if c.A == 0 {
+			continue
+		}
+		if c.A < 255 {
+			faded++
+		}
+		if c.R != 255 || c.G != 0 || c.B != 0 {
+			t.Errorf("pixel %d = %v, want red at any alpha", x, c)
+		}
+	}
+	if faded == 0 {
+		t.Error("no translucent pixels at the edge")
+	}
+}
+
+// rowImage returns a gray image whose rows take the given values.
+func rowImage(width int, rows ...uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, len(rows)))
+	for y, v := range rows {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestResizeFieldsKeepsFieldsApart(t *testing.T) {
+	src := rowImage(8, 40, 200, 40, 200, 40, 200, 40, 200)
+
+	for _, size := range [][2]int{{4, 6}, {12, 12}, {8, 5}} {
+		result, err := ResizeFields(src, size[0], size[1], filters.NewLanczos(3))
+		if err != nil {
+			t.Fatalf("ResizeFields(%v) unexpected error: %v", size, err)
+		}
+		for y := 0; y < size[1]; y++ {
+			want := uint8(40)
+			if y%2 == 1 {
+				want = 200
+			}
+			if got := result.NRGBAAt(1, y).R; got != want {
+				t.Errorf("ResizeFields(%v) row %d = %d, want %d", size, y, got, want)
+			}
+		}
+	}
+
+	if _, err := ResizeFields(src, 4, 1, filters.NewLanczos(3)); err == nil {
+		t.Error("ResizeFields() expected error for a single output row")
+	}
+}
+
+func TestResizeFieldSitsOnItsLines(t *testing.T) {
+	// Field rows hold 0, 40, 80, 120; with a linear filter each must land
+	// unchanged on its own frame line.
+	field := rowImage(2, 0, 40, 80, 120)
+
+	for _, p := range []Parity{TopField, BottomField} {
+		frame, err := ResizeField(field, p, 8, 2, 8, filters.NewTriangle())
+		if err != nil {
+			t.Fatalf("ResizeField(%d) unexpected error: %v", p, err)
+		}
+		for i := 0; i < 4; i++ {
+			if got := frame.NRGBAAt(0, 2*i+int(p)).R; got != uint8(40*i) {
+				t.Errorf("parity %d: frame row %d = %d, want %d", p, 2*i+int(p), got, 40*i)
+			}
+		}
+	}
+
+	// Scaled to half the frame height, output row y covers frame rows 2y
+	// and 2y+1, centred a quarter of a field row from field row y.
+	for _, p := range []Parity{TopField, BottomField} {
+		frame, err := ResizeField(field, p, 8, 2, 4, filters.NewTriangle())
+		if err != nil {
+			t.Fatalf("ResizeField(%d) unexpected error: %v", p, err)
+		}
+		for y := 1; y < 3; y++ {
+			want := 40*y + 10 - 20*int(p)
+			if got := int(frame.NRGBAAt(0, y).R); got < want-1 || got > want+1 {
+				t.Errorf("parity %d at half height: row %d = %d, want %d", p, y, got, want)
+			}
+		}
+	}
+}
+
+func TestResizeFieldOddFrameHeight(t *testing.T) {
+	// A 13-row frame has a 7-row top field and a 6-row bottom field. Field
+	// row i holds 20i, so output row y must read 20 times the field row it
+	// lands on, wherever the filter sees whole neighbourhoods.
+	const frameHeight = 13
+	for _, p := range []Parity{TopField, BottomField} {
+		rows := make([]uint8, fieldHeight(frameHeight, p))
+		for i := range rows {
+			rows[i] = uint8(20 * i)
+		}
+		field := rowImage(2, rows...)
+
+		for _, height := range []int{9, 13, 20} {
+			frame, err := ResizeField(field, p, frameHeight, 2, height, filters.NewTriangle())
+			if err != nil {
+				t.Fatalf("ResizeField(%d, %d) unexpected error: %v", p, height, err)
+			}
+			for y := 0; y < height; y++ {
+				frameRow := (float64(y)+0.5)*frameHeight/float64(height) - 0.5
+				c := (frameRow - float64(p)) / 2
+				if c < 1 || c > float64(len(rows)-2) {
+					continue
+				}
+				want := 20 * c
+				if got := float64(frame.NRGBAAt(0, y).R); math.Abs(got-want) > 1 {
+					t.Errorf("parity %d to %d rows: row %d = %v, want %.1f", p, height, y, got, want)
+				}
+			}
+		}
+	}
+
+	// The bottom field of a 13-row frame has 6 rows, not 7.
+	field := rowImage(2, 0, 20, 40, 60, 80, 100, 120)
+	if _, err := ResizeField(field, BottomField, frameHeight, 2, 13, filters.NewTriangle()); err == nil {
+		t.Error("ResizeField() expected error for a field that does not fit the frame")
+	}
+}
+
+func TestExtractField(t *testing.T) {
+	src := rowImage(1, 1, 2, 3, 4, 5)
+	top, bottom := ExtractField(src, TopField), ExtractField(src, BottomField)
+	if top.Rect.Dy() != 3 || bottom.Rect.Dy() != 2 {
+		t.Fatalf("field heights = %d, %d; want 3, 2", top.Rect.Dy(), bottom.Rect.Dy())
+	}
+	if top.NRGBAAt(0, 2).R != 5 || bottom.NRGBAAt(0, 1).R != 4 {
+		t.Errorf("fields hold the wrong rows")
+	}
+}
+
+func TestResamplePlaneOffset(t *testing.T) {
+	src := NewPlane(8, 1)
+	for x := range src.Pix {
+		src.Pix[x] = uint8(10 * x)
+	}
+
+	// A quarter-pixel shift of a ramp is a quarter of a step on every
+	// sample away from the clamped right edge.
+	dst, err := ResamplePlane(src, 8, 1, filters.NewTriangle(), Axis{Scale: 1, Offset: 0.25}, Axis{})
+	if err != nil {
+		t.Fatalf("ResamplePlane() unexpected error: %v", err)
+	}
+	for x := 0; x < 7; x++ {
+		if want := uint8(10*x + 3); dst.At(x, 0) != want {
+			t.Errorf("sample %d = %d, want %d", x, dst.At(x, 0), want)
+		}
+	}
+
+	// Without an offset the plane is copied.
+	same, err := ResamplePlane(src, 8, 1, filters.NewLanczos(3), Axis{}, Axis{})
+	if err != nil {
+		t.Fatalf("ResamplePlane() unexpected error: %v", err)
+	}
+	for x := range src.Pix {
+		if same.At(x, 0) != src.At(x, 0) {
+			t.Errorf("identity sample %d = %d, want %d", x, same.At(x, 0), src.At(x, 0))
+		}
+	}
+}
+
+func TestResizeYCbCrConvertsInSamePass(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 16, 8), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = 0
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = 128, 255
+	}
+
+	from := colorspace.Space{Matrix: colorspace.BT601, Range: colorspace.Full}
+	to := colorspace.Space{Matrix: colorspace.BT709, Range: colorspace.Limited}
+	dst, err := ResizeYCbCr(src, 32, 16, YCbCrOptions{ChromaY: 0.5, From: from, To: to})
+	if err != nil {
+		t.Fatalf("ResizeYCbCr() unexpected error: %v", err)
+	}
+	if dst.Rect != image.Rect(0, 0, 32, 16) || dst.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		t.Fatalf("got %v %v, want 32x16 4:2:0", dst.Rect, dst.SubsampleRatio)
+	}
+
+	y, cb, cr := colorspace.NewConversion(from, to).Apply(0, 128, 255)
+	want := [3]uint8{colorspace.Quantize(y), colorspace.Quantize(cb), colorspace.Quantize(cr)}
+	if got := [3]uint8{dst.Y[dst.YOffset(20, 9)], dst.Cb[dst.COffset(20, 9)], dst.Cr[dst.COffset(20, 9)]}; got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestResizeYCbCrKeepsCositedChroma(t *testing.T) {
+	// Chroma co-sited with even luma columns carries the luma column index.
+	src := image.NewYCbCr(image.Rect(0, 0, 32, 2), image.YCbCrSubsampleRatio422)
+	for x := 0; x < 16; x++ {
+		src.Cb[x], src.Cb[src.CStride+x] = uint8(8*2*x), uint8(8*2*x)
+	}
+
+	dst, err := ResizeYCbCr(src, 16, 2, YCbCrOptions{Filter: filters.NewTriangle()})
+	if err != nil {
+		t.Fatalf("ResizeYCbCr() unexpected error: %v", err)
+	}
+	// Output chroma j sits on output column 2j, which is source column
+	// 4j+0.5, so it should carry that value.
+	for j := 1; j < 7; j++ {
+		if got, want := int(dst.Cb[j]), 8*(4*j)+4; got < want-1 || got > want+1 {
+			t.Errorf("Cb[%d] = %d, want %d", j, got, want)
+		}
+	}
+}
+
+func TestResizeHDRKeepsHighlights(t *testing.T) {
+	src := hdr.NewImage(image.Rect(0, 0, 8, 8), 4000)
+	for i := 0; i < len(src.Pix); i += 4 {
+		// A 4000 cd/m² highlight on the left half, transparent on the right.
+		if (i/4)%8 < 4 {
+			copy(src.Pix[i:], []float32{4000, 2000, 100, 1})
+		}
+	}
+
+	dst, err := ResizeHDR(src, 4, 4, filters.NewTriangle())
+	if err != nil {
+		t.Fatalf("ResizeHDR() unexpected error: %v", err)
+	}
+	if dst.Peak != 4000 {
+		t.Errorf("Peak = %v, want 4000", dst.Peak)
+	}
+	p := dst.Pix[dst.PixOffset(0, 1):]
+	if p[0] != 4000 || p[1] != 2000 || p[2] != 100 || p[3] != 1 {
+		t.Errorf("highlight = %v, want {4000 2000 100 1}", p[:4])
+	}
+	// The edge pixel is half covered; premultiplication keeps its colour.
+	p = dst.Pix[dst.PixOffset(2, 1):]
+	if p[3] <= 0 || p[3] >= 1 || p[0] < 3999 || p[0] > 4001 {
+		t.Errorf("edge pixel = %v, want the highlight colour partly transparent", p[:4])
+	}
+}
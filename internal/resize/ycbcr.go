@@ -0,0 +1,113 @@
+package resize
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/subsample"
+)
+
+// YCbCrOptions configures ResizeYCbCr.
+type YCbCrOptions struct {
+	// Filter resamples every plane; nil selects Lanczos-3.
+	Filter filters.Resampler
+	// ChromaX and ChromaY are the luma coordinates of the first chroma
+	// sample: 0.5 for 4:2:0 chroma centred in its 2x2 block, 0 for chroma
+	// co-sited with the first luma sample. chroma.Siting.Position derives
+	// them for a layout.
+	ChromaX, ChromaY float64
+	// From and To are the source and output encodings. When they differ
+	// the matrix and range are converted in the same pass.
+	From, To colorspace.Space
+}
+
+// ResizeYCbCr resizes a planar YCbCr image without converting it to RGB,
+// keeping its chroma subsampling and siting. Every plane is resampled in
+// floating point and, if requested, re-encoded before being rounded once.
+func ResizeYCbCr(src *image.YCbCr, width, height int, opts YCbCrOptions) (*image.YCbCr, error) {
+	if src == nil {
+		return nil, errors.New("source image is nil")
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid dimensions: width=%d, height=%d", width, height)
+	}
+	filter := opts.Filter
+	if filter == nil {
+		filter = filters.NewLanczos(3)
+	}
+
+	bounds := src.Rect
+	fx, fy := subsample.Factors(src.SubsampleRatio)
+	yPlane := Plane{Pix: src.Y[src.YOffset(bounds.Min.X, bounds.Min.Y):], Stride: src.YStride, Width: bounds.Dx(), Height: bounds.Dy()}
+	cw, ch := (bounds.Dx()+fx-1)/fx, (bounds.Dy()+fy-1)/fy
+	cOffset := src.COffset(bounds.Min.X, bounds.Min.Y)
+	cbPlane := Plane{Pix: src.Cb[cOffset:], Stride: src.CStride, Width: cw, Height: ch}
+	crPlane := Plane{Pix: src.Cr[cOffset:], Stride: src.CStride, Width: cw, Height: ch}
+
+	dst := image.NewYCbCr(image.Rect(0, 0, width, height), src.SubsampleRatio)
+	dcw, dch := (width+fx-1)/fx, (height+fy-1)/fy
+
+	yValues, err := resamplePlane(yPlane, width, height, filter, Axis{}, Axis{})
+	if err != nil {
+		return nil, err
+	}
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+	chromaX := chromaAxis(scaleX, fx, opts.ChromaX)
+	chromaY := chromaAxis(scaleY, fy, opts.ChromaY)
+	cbValues, err := resamplePlane(cbPlane, dcw, dch, filter, chromaX, chromaY)
+	if err != nil {
+		return nil, err
+	}
+	crValues, err := resamplePlane(crPlane, dcw, dch, filter, chromaX, chromaY)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := colorspace.NewConversion(opts.From, opts.To)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := (y/fy)*dcw + x/fx
+			luma, _, _ := conv.Apply(yValues[y*width+x], cbValues[c], crValues[c])
+			dst.Y[y*dst.YStride+x] = colorspace.Quantize(luma)
+		}
+	}
+	for cy := 0; cy < dch; cy++ {
+		for cx := 0; cx < dcw; cx++ {
+			c := cy*dcw + cx
+			luma := yValues[cy*fy*width+cx*fx]
+			if !conv.Identity() {
+				luma = blockMean(yValues, width, height, cx*fx, cy*fy, fx, fy)
+			}
+			_, cb, cr := conv.Apply(luma, cbValues[c], crValues[c])
+			dst.Cb[cy*dst.CStride+cx] = colorspace.Quantize(cb)
+			dst.Cr[cy*dst.CStride+cx] = colorspace.Quantize(cr)
+		}
+	}
+	return dst, nil
+}
+
+// chromaAxis maps output chroma samples onto input chroma samples when the
+// luma is scaled by scale. Chroma sits at luma position first + i*factor on
+// both grids, so this keeps the siting rather than aligning chroma edges.
+func chromaAxis(scale float64, factor int, first float64) Axis {
+	f := float64(factor)
+	return Axis{Scale: scale, Offset: ((first+0.5)*scale-0.5-first)/f - scale/2 + 0.5}
+}
+
+// blockMean averages the w x h block of values at (x, y), clipped to the
+// plane.
+func blockMean(values []float64, width, height, x, y, w, h int) float64 {
+	var sum float64
+	n := 0
+	for row := y; row < y+h && row < height; row++ {
+		for col := x; col < x+w && col < width; col++ {
+			sum += values[row*width+col]
+			n++
+		}
+	}
+	return sum / float64(n)
+}
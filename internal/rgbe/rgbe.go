@@ -0,0 +1,344 @@
+package rgbe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"video-processor/internal/hdr"
+	"video-processor/internal/imagesize"
+)
+
+const (
+	// maxHeaderLength bounds header lines so a corrupt file cannot make the
+	// reader buffer without limit.
+	maxHeaderLength = 4096
+	// Scanlines between these widths are written run-length encoded, as
+	// the format allows.
+	minRLEWidth = 8
+	maxRLEWidth = 0x7fff
+	// minRun is the shortest run worth encoding as a run.
+	minRun = 4
+)
+
+func init() {
+	image.RegisterFormat("hdr", "#?", Decode, DecodeConfig)
+}
+
+// header is the parsed information block and resolution line.
+type header struct {
+	width, height int
+	// bottomUp is set for "+Y" files, which store the bottom row first.
+	bottomUp bool
+	exposure float64
+}
+
+func readHeader(r *bufio.Reader) (header, error) {
+	h := header{exposure: 1}
+	line, err := readLine(r)
+	if err != nil {
+		return h, err
+	}
+	if !strings.HasPrefix(line, "#?") {
+		return h, errors.New("not a Radiance HDR file")
+	}
+
+	// Variables run until the first empty line.
+	for {
+		if line, err = readLine(r); err != nil {
+			return h, err
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "FORMAT":
+			if format := strings.TrimSpace(value); format != "32-bit_rle_rgbe" {
+				return h, fmt.Errorf("unsupported Radiance format %q", format)
+			}
+		case "EXPOSURE":
+			// Exposures accumulate; dividing by them restores radiance.
+			exposure, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || exposure <= 0 {
+				return h, fmt.Errorf("invalid exposure %q", value)
+			}
+			h.exposure *= exposure
+		}
+	}
+
+	if line, err = readLine(r); err != nil {
+		return h, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 4 || (fields[0] != "-Y" && fields[0] != "+Y") || fields[2] != "+X" {
+		return h, fmt.Errorf("unsupported resolution line %q", line)
+	}
+	h.bottomUp = fields[0] == "+Y"
+	h.height, err = strconv.Atoi(fields[1])
+	if err == nil {
+		h.width, err = strconv.Atoi(fields[3])
+	}
+	if err != nil || !imagesize.Valid(h.width, h.height) {
+		return h, fmt.Errorf("invalid dimensions in %q", line)
+	}
+	return h, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", fmt.Errorf("failed to read header: %w", err)
+		}
+		line = append(line, chunk...)
+		if len(line) > maxHeaderLength {
+			return "", errors.New("header line too long")
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBA64Model, Width: h.width, Height: h.height}, nil
+}
+
+// Decode reads a Radiance RGBE file into an *hdr.Image. A value of 1 is
+// taken as SDR reference white, and the image's Peak is its brightest
+// channel, but no less than that.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	img := hdr.NewImage(image.Rect(0, 0, h.width, h.height), hdr.SDRWhite)
+	scanline := make([]byte, 4*h.width)
+	scale := hdr.SDRWhite / h.exposure
+	for i := 0; i < h.height; i++ {
+		if err := readScanline(br, scanline); err != nil {
+			return nil, fmt.Errorf("failed to read scanline %d: %w", i, err)
+		}
+		y := i
+		if h.bottomUp {
+			y = h.height - 1 - i
+		}
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < h.width; x++ {
+			r, g, b := toFloat(scanline[4*x:])
+			row[4*x] = float32(r * scale)
+			row[4*x+1] = float32(g * scale)
+			row[4*x+2] = float32(b * scale)
+			row[4*x+3] = 1
+			img.Peak = math.Max(img.Peak, float64(max(row[4*x], row[4*x+1], row[4*x+2])))
+		}
+	}
+	return img, nil
+}
+
+// readScanline reads one row of RGBE pixels in any of the three layouts:
+// flat, the original run-length encoding and per-channel run-length
+// encoding.
+func readScanline(r *bufio.Reader, dst []byte) error {
+	width := len(dst) / 4
+	start, err := r.Peek(4)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if width < minRLEWidth || width > maxRLEWidth || start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 {
+		return readFlat(r, dst)
+	}
+	if int(start[2])<<8|int(start[3]) != width {
+		return fmt.Errorf("scanline width %d does not match image width %d", int(start[2])<<8|int(start[3]), width)
+	}
+	r.Discard(4)
+
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			n := int(count)
+			run := n > 128
+			if run {
+				n -= 128
+			}
+			if n == 0 || x+n > width {
+				return errors.New("bad run length")
+			}
+			if run {
+				value, err := r.ReadByte()
+				if err != nil {
+					return unexpectedEOF(err)
+				}
+				for ; n > 0; n-- {
+					dst[4*x+c] = value
+					x++
+				}
+				continue
+			}
+			for ; n > 0; n-- {
+				value, err := r.ReadByte()
+				if err != nil {
+					return unexpectedEOF(err)
+				}
+				dst[4*x+c] = value
+				x++
+			}
+		}
+	}
+	return nil
+}
+
+// readFlat reads uncompressed pixels, expanding the original encoding's
+// runs: a pixel of 1,1,1,n repeats the previous pixel n times, shifted
+// left by 8 bits for each consecutive run pixel.
+func readFlat(r *bufio.Reader, dst []byte) error {
+	var pixel [4]byte
+	shift := 0
+	for x := 0; x < len(dst)/4; {
+		if _, err := io.ReadFull(r, pixel[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		if pixel[0] == 1 && pixel[1] == 1 && pixel[2] == 1 {
+			if x == 0 {
+				return errors.New("run without a preceding pixel")
+			}
+			n := int(pixel[3]) << shift
+			if x+n > len(dst)/4 {
+				return errors.New("bad run length")
+			}
+			for ; n > 0; n-- {
+				copy(dst[4*x:4*x+4], dst[4*x-4:4*x])
+				x++
+			}
+			shift += 8
+			continue
+		}
+		copy(dst[4*x:], pixel[:])
+		x++
+		shift = 0
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// toFloat decodes a pixel whose mantissas share the exponent in p[3].
+func toFloat(p []byte) (float64, float64, float64) {
+	if p[3] == 0 {
+		return 0, 0, 0
+	}
+	f := math.Ldexp(1, int(p[3])-(128+8))
+	return (float64(p[0]) + 0.5) * f, (float64(p[1]) + 0.5) * f, (float64(p[2]) + 0.5) * f
+}
+
+// fromFloat encodes a pixel, giving the largest channel 8 bits of mantissa.
+// Negative values are stored as zero.
+func fromFloat(r, g, b float64) [4]byte {
+	v := max(r, g, b)
+	if v < 1e-32 {
+		return [4]byte{}
+	}
+	mantissa, exponent := math.Frexp(v)
+	if exponent > 127 {
+		return [4]byte{255, 255, 255, 255}
+	}
+	scale := mantissa * 256 / v
+	channel := func(c float64) byte {
+		return byte(math.Max(0, c*scale))
+	}
+	return [4]byte{channel(r), channel(g), channel(b), byte(exponent + 128)}
+}
+
+// Encode writes m as a Radiance RGBE file with run-length encoded
+// scanlines. Images other than an *hdr.Image are treated as sRGB; alpha is
+// dropped.
+func Encode(w io.Writer, m image.Image) error {
+	img := hdr.FromImage(m)
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", height, width)
+
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			p := fromFloat(float64(row[4*x])/hdr.SDRWhite, float64(row[4*x+1])/hdr.SDRWhite, float64(row[4*x+2])/hdr.SDRWhite)
+			copy(scanline[4*x:], p[:])
+		}
+		if width < minRLEWidth || width > maxRLEWidth {
+			bw.Write(scanline)
+			continue
+		}
+		bw.Write([]byte{2, 2, byte(width >> 8), byte(width)})
+		channel := make([]byte, width)
+		for c := 0; c < 4; c++ {
+			for x := range channel {
+				channel[x] = scanline[4*x+c]
+			}
+			writeRLE(bw, channel)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeRLE encodes one channel of a scanline as runs of up to 127 equal
+// bytes and literal spans of up to 128 bytes.
+func writeRLE(w *bufio.Writer, data []byte) {
+	for i := 0; i < len(data); {
+		// Find the next run long enough to be worth encoding.
+		runStart, runLength := i, 0
+		for runStart < len(data) {
+			runLength = 1
+			for runStart+runLength < len(data) && runLength < 127 && data[runStart+runLength] == data[runStart] {
+				runLength++
+			}
+			if runLength >= minRun {
+				break
+			}
+			runStart += runLength
+		}
+		if runLength < minRun {
+			runStart = len(data)
+		}
+
+		for i < runStart {
+			n := min(runStart-i, 128)
+			w.WriteByte(byte(n))
+			w.Write(data[i : i+n])
+			i += n
+		}
+		if runStart < len(data) {
+			w.WriteByte(byte(128 + runLength))
+			w.WriteByte(data[runStart])
+			i = runStart + runLength
+		}
+	}
+}
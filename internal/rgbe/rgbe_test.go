@@ -0,0 +1,106 @@
+package rgbe
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"strings"
+	"testing"
+
+	"video-processor/internal/hdr"
+)
+
+func testImage(width, height int) *hdr.Image {
+	img := hdr.NewImage(image.Rect(0, 0, width, height), 50*hdr.SDRWhite)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			// Flat spans for the run-length encoder, and values far above
+			// SDR white.
+			p[0] = float32(x/4) * 10 * hdr.SDRWhite
+			p[1] = float32(y+1) * 0.01 * hdr.SDRWhite
+			p[2] = 0.5 * hdr.SDRWhite
+			p[3] = 1
+		}
+	}
+	return img
+}
+
+func TestRoundTrip(t *testing.T) {
+	// 20 pixels wide uses run-length scanlines; 5 is too narrow for them.
+	for _, width := range []int{20, 5} {
+		src := testImage(width, 3)
+		var buf bytes.Buffer
+		if err := Encode(&buf, src); err != nil {
+			t.Fatalf("Encode() unexpected error: %v", err)
+		}
+		if width >= minRLEWidth && buf.Len() >= 4*width*3+40 {
+			t.Errorf("width %d: %d bytes, expected run-length compression", width, buf.Len())
+		}
+
+		decoded, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("image.Decode() unexpected error: %v", err)
+		}
+		if format != "hdr" {
+			t.Errorf("format = %q, want hdr", format)
+		}
+		got := decoded.(*hdr.Image)
+		if got.Rect != src.Rect {
+			t.Fatalf("bounds = %v, want %v", got.Rect, src.Rect)
+		}
+		for i := 0; i < len(src.Pix); i += 4 {
+			// The shared exponent leaves smaller channels fewer bits.
+			largest := max(src.Pix[i], src.Pix[i+1], src.Pix[i+2])
+			for c := 0; c < 3; c++ {
+				if d := math.Abs(float64(got.Pix[i+c] - src.Pix[i+c])); d > float64(largest)/128 {
+					t.Fatalf("width %d: sample %d channel %d = %v, want %v", width, i/4, c, got.Pix[i+c], src.Pix[i+c])
+				}
+			}
+		}
+		if want := float64(max(src.Pix[len(src.Pix)-4], hdr.SDRWhite)); math.Abs(got.Peak-want) > want/128 {
+			t.Errorf("width %d: Peak = %v, want %v", width, got.Peak, want)
+		}
+	}
+}
+
+func TestDecodeVariants(t *testing.T) {
+	// Bottom-up rows, an exposure and the original run-length encoding:
+	// the second pixel of the first row repeats the first.
+	var file bytes.Buffer
+	file.WriteString("#?RGBE\n# comment\nEXPOSURE=2\nFORMAT=32-bit_rle_rgbe\n\n+Y 2 +X 2\n")
+	file.Write([]byte{128, 64, 0, 129, 1, 1, 1, 1})
+	file.Write([]byte{0, 0, 0, 0, 128, 128, 128, 128})
+
+	img, err := Decode(&file)
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	m := img.(*hdr.Image)
+	// Mantissas decode from the centre of their interval: red is
+	// 128.5 * 2^(129-136), halved by the exposure.
+	want := float32(128.5 / 128 / 2 * hdr.SDRWhite)
+	for _, x := range []int{0, 1} {
+		if p := m.Pix[m.PixOffset(x, 1):]; math.Abs(float64(p[0]-want)) > 1e-3 || math.Abs(float64(p[1]-want*64.5/128.5)) > 1e-3 {
+			t.Errorf("pixel %d,1 = %v, want red %v and green half that", x, p[:3], want)
+		}
+	}
+	if p := m.Pix[m.PixOffset(0, 0):]; p[0] != 0 {
+		t.Errorf("pixel 0,0 = %v, want black", p[:3])
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"not radiance":      "P6\n1 1\n255\n",
+		"bad format":        "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n\x00\x00\x00\x00",
+		"rotated":           "#?RADIANCE\n\n+X 1 -Y 1\n\x00\x00\x00\x00",
+		"truncated":         "#?RADIANCE\n\n-Y 2 +X 1\n\x00\x00\x00\x00",
+		"run past the edge": "#?RADIANCE\n\n-Y 1 +X 8\n\x02\x02\x00\x08\xff\x00",
+		"huge":              "#?RADIANCE\n\n-Y 100000 +X 100000\n",
+	} {
+		if _, err := Decode(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode() expected error", name)
+		}
+	}
+}
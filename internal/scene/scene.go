@@ -0,0 +1,308 @@
+package scene
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"video-processor/internal/filters"
+	"video-processor/internal/resize"
+	"video-processor/internal/y4m"
+)
+
+// Metric selects how the difference between consecutive frames is scored.
+// Every metric yields a score between 0 (identical) and 1.
+type Metric int
+
+const (
+	// Combined averages the Histogram and SAD scores.
+	Combined Metric = iota
+	// Histogram compares per-channel colour histograms. It ignores motion
+	// within a shot but misses cuts between shots with similar colours.
+	Histogram
+	// SAD is the mean absolute luma difference between co-located pixels.
+	// It catches any change of content but also reacts to fast motion.
+	SAD
+)
+
+func ParseMetric(name string) (Metric, error) {
+	switch name {
+	case "combined":
+		return Combined, nil
+	case "histogram":
+		return Histogram, nil
+	case "sad":
+		return SAD, nil
+	}
+	return 0, fmt.Errorf("unknown scene metric %q (available: combined, histogram, sad)", name)
+}
+
+func (m Metric) String() string {
+	switch m {
+	case Histogram:
+		return "histogram"
+	case SAD:
+		return "sad"
+	}
+	return "combined"
+}
+
+// Options configures a Detector. Zero values select the defaults.
+type Options struct {
+	Metric Metric
+	// AnalysisWidth and AnalysisHeight give the size frames are reduced
+	// to before scoring; default 64x36.
+	AnalysisWidth, AnalysisHeight int
+	// A frame starts a new scene when its score exceeds the mean of the
+	// previous Window scores by Sensitivity standard deviations, and is at
+	// least MinThreshold. Defaults: 30 frames, 3, 0.15.
+	Window       int
+	Sensitivity  float64
+	MinThreshold float64
+	// MinSceneLength suppresses cuts within this many frames of the
+	// previous one; default 12.
+	MinSceneLength int
+	// FrameRate converts frame numbers to timestamps; if unset, times are
+	// reported as zero.
+	FrameRate y4m.Rational
+}
+
+func (o Options) withDefaults() Options {
+	if o.AnalysisWidth <= 0 || o.AnalysisHeight <= 0 {
+		o.AnalysisWidth, o.AnalysisHeight = 64, 36
+	}
+	if o.Window <= 0 {
+		o.Window = 30
+	}
+	if o.Sensitivity <= 0 {
+		o.Sensitivity = 3
+	}
+	if o.MinThreshold <= 0 {
+		o.MinThreshold = 0.15
+	}
+	if o.MinSceneLength <= 0 {
+		o.MinSceneLength = 12
+	}
+	return o
+}
+
+// Cut is a scene boundary: Frame is the first frame of the new scene.
+type Cut struct {
+	Frame     int     `json:"frame"`
+	Time      float64 `json:"time"`
+	Score     float64 `json:"score"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Scene is a run of frames between cuts. Representative is the frame whose
+// colours are closest to the scene's average, a good poster frame.
+type Scene struct {
+	Start          int     `json:"start"`
+	End            int     `json:"end"`
+	StartTime      float64 `json:"start_time"`
+	EndTime        float64 `json:"end_time"`
+	Representative int     `json:"representative"`
+}
+
+// Result is the outcome of a detection run.
+type Result struct {
+	Metric string  `json:"metric"`
+	Frames int     `json:"frames"`
+	Cuts   []Cut   `json:"cuts"`
+	Scenes []Scene `json:"scenes"`
+}
+
+const histogramBins = 16
+
+// signature is the reduced form of a frame that scores are computed from.
+type signature struct {
+	luma      []uint8
+	histogram [3 * histogramBins]float64
+}
+
+// Detector finds scene cuts in a stream of frames pushed in display order.
+type Detector struct {
+	opts Options
+
+	frames   int
+	previous *signature
+	history  []float64 // recent scores of frames that were not cuts
+
+	// Histograms of the current scene, for picking its representative.
+	sceneStart int
+	scene      [][3 * histogramBins]float64
+
+	result Result
+}
+
+func NewDetector(opts Options) *Detector {
+	opts = opts.withDefaults()
+	return &Detector{opts: opts, result: Result{Metric: opts.Metric.String()}}
+}
+
+// Push scores the next frame against the previous one and reports whether
+// it starts a new scene.
+func (d *Detector) Push(frame image.Image) (bool, error) {
+	small, err := resize.ResizeWithFilter(frame, d.opts.AnalysisWidth, d.opts.AnalysisHeight, filters.NewBox())
+	if err != nil {
+		return false, fmt.Errorf("frame %d: %w", d.frames, err)
+	}
+	sig := newSignature(small)
+	index := d.frames
+	d.frames++
+
+	cut := false
+	if d.previous != nil {
+		score := d.score(d.previous, sig)
+		threshold := d.threshold()
+		if score > threshold && index-d.sceneStart >= d.opts.MinSceneLength {
+			cut = true
+			d.result.Cuts = append(d.result.Cuts, Cut{Frame: index, Time: d.time(index), Score: score, Threshold: threshold})
+			d.closeScene(index - 1)
+			d.sceneStart = index
+			// Scores around a cut say nothing about the new shot's motion.
+			d.history = d.history[:0]
+		} else {
+			d.history = append(d.history, score)
+			if len(d.history) > d.opts.Window {
+				d.history = d.history[1:]
+			}
+		}
+	}
+
+	d.previous = sig
+	d.scene = append(d.scene, sig.histogram)
+	return cut, nil
+}
+
+// Result closes the final scene and returns all cuts and scenes found.
+func (d *Detector) Result() Result {
+	result := d.result
+	result.Frames = d.frames
+	if len(d.scene) > 0 {
+		result.Scenes = append(append([]Scene(nil), result.Scenes...), d.describeScene(d.frames-1))
+	}
+	return result
+}
+
+func (d *Detector) time(frame int) float64 {
+	if d.opts.FrameRate.Num <= 0 || d.opts.FrameRate.Den <= 0 {
+		return 0
+	}
+	return float64(int64(frame)*d.opts.FrameRate.Den) / float64(d.opts.FrameRate.Num)
+}
+
+func (d *Detector) score(a, b *signature) float64 {
+	switch d.opts.Metric {
+	case Histogram:
+		return histogramDistance(a.histogram, b.histogram)
+	case SAD:
+		return sad(a.luma, b.luma)
+	}
+	return (histogramDistance(a.histogram, b.histogram) + sad(a.luma, b.luma)) / 2
+}
+
+// threshold adapts to the recent scores, so a handheld or action shot
+// needs a bigger jump to count as a cut than a static interview.
+func (d *Detector) threshold() float64 {
+	if len(d.history) == 0 {
+		return d.opts.MinThreshold
+	}
+	var sum, sumSq float64
+	for _, s := range d.history {
+		sum += s
+		sumSq += s * s
+	}
+	n := float64(len(d.history))
+	mean := sum / n
+	stddev := math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+	return math.Max(d.opts.MinThreshold, mean+d.opts.Sensitivity*stddev)
+}
+
+func (d *Detector) closeScene(end int) {
+	d.result.Scenes = append(d.result.Scenes, d.describeScene(end))
+	d.scene = d.scene[:0]
+}
+
+func (d *Detector) describeScene(end int) Scene {
+	var mean [3 * histogramBins]float64
+	for _, h := range d.scene {
+		for i, v := range h {
+			mean[i] += v / float64(len(d.scene))
+		}
+	}
+
+	best, bestDistance := 0, math.Inf(1)
+	for i, h := range d.scene {
+		if distance := histogramDistance(h, mean); distance < bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+
+	return Scene{
+		Start:          d.sceneStart,
+		End:            end,
+		StartTime:      d.time(d.sceneStart),
+		EndTime:        d.time(end + 1),
+		Representative: d.sceneStart + best,
+	}
+}
+
+func newSignature(img *image.NRGBA) *signature {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	sig := &signature{luma: make([]uint8, 0, w*h)}
+	weight := 1 / float64(w*h)
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			r, g, b := row[x*4], row[x*4+1], row[x*4+2]
+			addSample(sig.histogram[:histogramBins], r, weight)
+			addSample(sig.histogram[histogramBins:2*histogramBins], g, weight)
+			addSample(sig.histogram[2*histogramBins:], b, weight)
+			// BT.601 luma in integer arithmetic.
+			sig.luma = append(sig.luma, uint8((19595*int(r)+38470*int(g)+7471*int(b)+1<<15)>>16))
+		}
+	}
+	return sig
+}
+
+// addSample splits weight between the two bins nearest to v, so a slow
+// drift in brightness moves mass gradually instead of jumping a whole bin.
+func addSample(bins []float64, v uint8, weight float64) {
+	position := (float64(v)+0.5)*histogramBins/256 - 0.5
+	lower := int(math.Floor(position))
+	frac := position - float64(lower)
+	if lower < 0 {
+		bins[0] += weight
+		return
+	}
+	if lower >= histogramBins-1 {
+		bins[histogramBins-1] += weight
+		return
+	}
+	bins[lower] += weight * (1 - frac)
+	bins[lower+1] += weight * frac
+}
+
+// histogramDistance is half the L1 distance averaged over the three
+// channels, 0 for identical and 1 for disjoint histograms.
+func histogramDistance(a, b [3 * histogramBins]float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += math.Abs(a[i] - b[i])
+	}
+	return sum / 6
+}
+
+func sad(a, b []uint8) float64 {
+	var sum int
+	for i := range a {
+		diff := int(a[i]) - int(b[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		sum += diff
+	}
+	return float64(sum) / float64(255*len(a))
+}
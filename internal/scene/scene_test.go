@@ -0,0 +1,141 @@
+package scene
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"video-processor/internal/y4m"
+)
+
+func solid(c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 18))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// stripes returns vertical stripes shifted by offset pixels, giving a
+// steady amount of motion from frame to frame.
+func stripes(offset int, a, b color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 18))
+	for y := 0; y < 18; y++ {
+		for x := 0; x < 32; x++ {
+			c := a
+			if (x+offset)/4%2 == 1 {
+				c = b
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func run(t *testing.T, opts Options, frames []image.Image) Result {
+	t.Helper()
+	d := NewDetector(opts)
+	for i, frame := range frames {
+		if _, err := d.Push(frame); err != nil {
+			t.Fatalf("Push(%d) unexpected error: %v", i, err)
+		}
+	}
+	return d.Result()
+}
+
+func TestDetectCuts(t *testing.T) {
+	var frames []image.Image
+	for i := 0; i < 20; i++ {
+		frames = append(frames, solid(color.NRGBA{B: uint8(100 + i), A: 255}))
+	}
+	for i := 0; i < 20; i++ {
+		frames = append(frames, stripes(i, color.NRGBA{R: 200, A: 255}, color.NRGBA{R: 250, G: 60, A: 255}))
+	}
+	for i := 0; i < 20; i++ {
+		frames = append(frames, solid(color.NRGBA{R: 120, G: uint8(240 - i), B: 200, A: 255}))
+	}
+
+	for _, metric := range []Metric{Combined, Histogram, SAD} {
+		t.Run(metric.String(), func(t *testing.T) {
+			result := run(t, Options{Metric: metric, FrameRate: y4m.Rational{Num: 25, Den: 1}}, frames)
+
+			if len(result.Cuts) != 2 || result.Cuts[0].Frame != 20 || result.Cuts[1].Frame != 40 {
+				t.Fatalf("cuts = %+v, want frames 20 and 40", result.Cuts)
+			}
+			if result.Cuts[0].Time != 0.8 {
+				t.Errorf("first cut time = %v, want 0.8", result.Cuts[0].Time)
+			}
+			if len(result.Scenes) != 3 || result.Scenes[1].Start != 20 || result.Scenes[1].End != 39 || result.Scenes[2].EndTime != 2.4 {
+				t.Errorf("scenes = %+v", result.Scenes)
+			}
+			if result.Frames != 60 {
+				t.Errorf("Frames = %d, want 60", result.Frames)
+			}
+		})
+	}
+}
+
+func TestSteadyMotionIsNotACut(t *testing.T) {
+	// Panning stripes change every pixel on every frame, but by the same
+	// amount; the adaptive threshold learns that after the first frames.
+	var frames []image.Image
+	for i := 0; i < 60; i++ {
+		frames = append(frames, stripes(i, color.NRGBA{A: 255}, color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+	}
+
+	result := run(t, Options{Metric: SAD}, frames)
+	if len(result.Cuts) != 0 {
+		t.Errorf("cuts = %+v, want none", result.Cuts)
+	}
+	if len(result.Scenes) != 1 || result.Scenes[0].End != 59 {
+		t.Errorf("scenes = %+v, want a single scene", result.Scenes)
+	}
+}
+
+func TestMinSceneLength(t *testing.T) {
+	// A two-frame flash registers as a cut, but the return to the shot
+	// two frames later is closer than MinSceneLength and is ignored.
+	var frames []image.Image
+	for i := 0; i < 30; i++ {
+		c := color.NRGBA{R: 50, G: 50, B: 50, A: 255}
+		if i >= 15 && i < 17 {
+			c = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		}
+		frames = append(frames, solid(c))
+	}
+
+	result := run(t, Options{}, frames)
+	if len(result.Cuts) != 1 || result.Cuts[0].Frame != 15 {
+		t.Errorf("cuts = %+v, want only frame 15", result.Cuts)
+	}
+}
+
+func TestRepresentativeFrame(t *testing.T) {
+	// A fade in from black: the representative should be a frame from the
+	// settled part of the shot, not the dark first frames.
+	var frames []image.Image
+	for i := 0; i < 20; i++ {
+		v := uint8(180)
+		if i < 4 {
+			v = uint8(45 * i)
+		}
+		frames = append(frames, solid(color.NRGBA{R: v, G: v, B: v, A: 255}))
+	}
+
+	result := run(t, Options{}, frames)
+	if len(result.Scenes) != 1 || result.Scenes[0].Representative < 4 {
+		t.Errorf("scenes = %+v, want representative after the fade", result.Scenes)
+	}
+}
+
+func TestParseMetric(t *testing.T) {
+	for _, name := range []string{"combined", "histogram", "sad"} {
+		m, err := ParseMetric(name)
+		if err != nil || m.String() != name {
+			t.Errorf("ParseMetric(%q) = %v, %v", name, m, err)
+		}
+	}
+	if _, err := ParseMetric("edges"); err == nil {
+		t.Error("ParseMetric() expected error")
+	}
+}
@@ -0,0 +1,225 @@
+package sprite
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"math/big"
+	"time"
+
+	"video-processor/internal/chroma"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/filters"
+	"video-processor/internal/resize"
+	"video-processor/internal/y4m"
+)
+
+// Options configures a sprite sheet Builder.
+type Options struct {
+	// Interval is the time between sampled frames.
+	Interval time.Duration
+	// Columns and Rows give the grid of tiles on each sheet.
+	Columns, Rows int
+	// TileWidth and TileHeight give the size of each thumbnail. One of
+	// them may be zero or negative to keep the video's aspect ratio.
+	TileWidth, TileHeight int
+	// Filter resamples the frames; nil selects Lanczos-3.
+	Filter filters.Resampler
+	// Siting and Space describe how *image.YCbCr frames are encoded, and
+	// Interlaced whether their chroma alternates between fields. Such
+	// frames are decoded to RGB with them before resizing.
+	Siting     chroma.Siting
+	Space      colorspace.Space
+	Interlaced bool
+}
+
+// Sheet is one tiled image. Sheets are numbered from 0.
+type Sheet struct {
+	Index int
+	Image *image.NRGBA
+}
+
+// Cue maps a time range to a tile on a sheet.
+type Cue struct {
+	Start, End time.Duration
+	Sheet      int
+	Rect       image.Rectangle
+}
+
+// Builder samples frames at a fixed interval and tiles them into sheets.
+type Builder struct {
+	opts Options
+	rate y4m.Rational
+
+	tileWidth, tileHeight int
+	frames                int // frames pushed so far
+	samples               int // tiles placed so far
+	sheet                 *Sheet
+	cues                  []Cue
+}
+
+func NewBuilder(opts Options, rate y4m.Rational) (*Builder, error) {
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("invalid sprite interval %v", opts.Interval)
+	}
+	if opts.Columns <= 0 || opts.Rows <= 0 {
+		return nil, fmt.Errorf("invalid sprite grid %dx%d", opts.Columns, opts.Rows)
+	}
+	if opts.TileWidth <= 0 && opts.TileHeight <= 0 {
+		return nil, errors.New("sprite tile width or height is required")
+	}
+	if rate.Num <= 0 || rate.Den <= 0 {
+		return nil, fmt.Errorf("invalid frame rate %v", rate)
+	}
+	if opts.Filter == nil {
+		opts.Filter = filters.NewLanczos(3)
+	}
+	return &Builder{opts: opts, rate: rate}, nil
+}
+
+// Push adds the next frame in display order and returns the sheets whose
+// tiles are now all filled.
+func (b *Builder) Push(frame image.Image) ([]*Sheet, error) {
+	index := b.frames
+	b.frames++
+
+	var full []*Sheet
+	// A frame may stand in for several samples when the interval is
+	// shorter than a frame.
+	for b.sampleFrame(b.samples) == index {
+		sheet, err := b.place(frame)
+		if err != nil {
+			return nil, err
+		}
+		if sheet != nil {
+			full = append(full, sheet)
+		}
+	}
+	return full, nil
+}
+
+// Flush returns the partially filled last sheet, cropped to the rows in
+// use, or nil if the last sheet was already returned by Push.
+func (b *Builder) Flush() *Sheet {
+	// The final tile ends with the stream rather than a full interval later.
+	if n := len(b.cues); n > 0 {
+		if end := b.frameTime(b.frames); end < b.cues[n-1].End {
+			b.cues[n-1].End = end
+		}
+	}
+
+	sheet := b.sheet
+	b.sheet = nil
+	if sheet == nil {
+		return nil
+	}
+	used := b.samples % (b.opts.Columns * b.opts.Rows)
+	rows := (used + b.opts.Columns - 1) / b.opts.Columns
+	sheet.Image = sheet.Image.SubImage(image.Rect(0, 0, sheet.Image.Rect.Dx(), rows*b.tileHeight)).(*image.NRGBA)
+	return sheet
+}
+
+// Cues returns the cue for every tile placed so far.
+func (b *Builder) Cues() []Cue {
+	return b.cues
+}
+
+// sampleFrame returns the frame shown at the time of sample k, which is
+// floor(k * interval * fps) computed exactly.
+func (b *Builder) sampleFrame(k int) int {
+	num := new(big.Int).Mul(big.NewInt(int64(k)), big.NewInt(int64(b.opts.Interval)))
+	num.Mul(num, big.NewInt(b.rate.Num))
+	den := new(big.Int).Mul(big.NewInt(int64(time.Second)), big.NewInt(b.rate.Den))
+	return int(num.Quo(num, den).Int64())
+}
+
+// frameTime returns the presentation time of frame i.
+func (b *Builder) frameTime(i int) time.Duration {
+	t := new(big.Int).Mul(big.NewInt(int64(i)), big.NewInt(int64(time.Second)))
+	t.Mul(t, big.NewInt(b.rate.Den))
+	return time.Duration(t.Quo(t, big.NewInt(b.rate.Num)).Int64())
+}
+
+func (b *Builder) place(frame image.Image) (*Sheet, error) {
+	if ycc, ok := frame.(*image.YCbCr); ok {
+		decode := chroma.ToRGB
+		if b.opts.Interlaced {
+			decode = chroma.ToRGBFields
+		}
+		rgb, err := decode(ycc, b.opts.Siting, b.opts.Space, nil)
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: %w", b.samples, err)
+		}
+		frame = rgb
+	}
+	if b.tileWidth == 0 {
+		b.tileWidth, b.tileHeight = tileSize(frame.Bounds(), b.opts.TileWidth, b.opts.TileHeight)
+	}
+	tile, err := resize.ResizeWithFilter(frame, b.tileWidth, b.tileHeight, b.opts.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("sample %d: %w", b.samples, err)
+	}
+
+	perSheet := b.opts.Columns * b.opts.Rows
+	slot := b.samples % perSheet
+	if b.sheet == nil {
+		b.sheet = &Sheet{
+			Index: b.samples / perSheet,
+			Image: image.NewNRGBA(image.Rect(0, 0, b.opts.Columns*b.tileWidth, b.opts.Rows*b.tileHeight)),
+		}
+	}
+
+	x, y := slot%b.opts.Columns*b.tileWidth, slot/b.opts.Columns*b.tileHeight
+	rect := image.Rect(x, y, x+b.tileWidth, y+b.tileHeight)
+	draw.Draw(b.sheet.Image, rect, tile, image.Point{}, draw.Src)
+
+	start := time.Duration(b.samples) * b.opts.Interval
+	b.cues = append(b.cues, Cue{Start: start, End: start + b.opts.Interval, Sheet: b.sheet.Index, Rect: rect})
+	b.samples++
+
+	if slot == perSheet-1 {
+		sheet := b.sheet
+		b.sheet = nil
+		return sheet, nil
+	}
+	return nil, nil
+}
+
+// tileSize derives a missing tile dimension from the frame's aspect ratio.
+func tileSize(bounds image.Rectangle, width, height int) (int, int) {
+	aspect := float64(bounds.Dx()) / float64(bounds.Dy())
+	if width <= 0 {
+		width = int(math.Max(1, math.Round(float64(height)*aspect)))
+	}
+	if height <= 0 {
+		height = int(math.Max(1, math.Round(float64(width)/aspect)))
+	}
+	return width, height
+}
+
+// WriteWebVTT writes a WebVTT track with one cue per tile. url returns the
+// address of a sheet as the player should fetch it; each cue's payload is
+// that address with a #xywh= media fragment selecting the tile.
+func WriteWebVTT(w io.Writer, cues []Cue, url func(sheet int) string) error {
+	if _, err := io.WriteString(w, "WEBVTT\n"); err != nil {
+		return err
+	}
+	for _, cue := range cues {
+		_, err := fmt.Fprintf(w, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			timestamp(cue.Start), timestamp(cue.End), url(cue.Sheet),
+			cue.Rect.Min.X, cue.Rect.Min.Y, cue.Rect.Dx(), cue.Rect.Dy())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timestamp formats d as a WebVTT hh:mm:ss.ttt timestamp.
+func timestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,179 @@
+package sprite
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+
+	"video-processor/internal/chroma"
+	"video-processor/internal/colorspace"
+	"video-processor/internal/y4m"
+)
+
+func frame(v uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 36))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = v, v, v, 255
+	}
+	return img
+}
+
+// build pushes n frames with luma i at rate and returns every sheet.
+func build(t *testing.T, opts Options, rate y4m.Rational, n int) (*Builder, []*Sheet) {
+	t.Helper()
+	b, err := NewBuilder(opts, rate)
+	if err != nil {
+		t.Fatalf("NewBuilder() unexpected error: %v", err)
+	}
+	var sheets []*Sheet
+	for i := 0; i < n; i++ {
+		full, err := b.Push(frame(uint8(i)))
+		if err != nil {
+			t.Fatalf("Push(%d) unexpected error: %v", i, err)
+		}
+		sheets = append(sheets, full...)
+	}
+	if last := b.Flush(); last != nil {
+		sheets = append(sheets, last)
+	}
+	return b, sheets
+}
+
+func TestSheets(t *testing.T) {
+	// 10 seconds at 25fps sampled every second: 10 tiles on 2x2 sheets.
+	opts := Options{Interval: time.Second, Columns: 2, Rows: 2, TileWidth: 16}
+	b, sheets := build(t, opts, y4m.Rational{Num: 25, Den: 1}, 250)
+
+	if len(sheets) != 3 {
+		t.Fatalf("got %d sheets, want 3", len(sheets))
+	}
+	if got := sheets[0].Image.Bounds(); got != image.Rect(0, 0, 32, 18) {
+		t.Errorf("full sheet bounds = %v, want 32x18", got)
+	}
+	// The last sheet holds two tiles in its first row only.
+	if got := sheets[2].Image.Bounds(); got != image.Rect(0, 0, 32, 9) {
+		t.Errorf("last sheet bounds = %v, want 32x9", got)
+	}
+
+	cues := b.Cues()
+	if len(cues) != 10 {
+		t.Fatalf("got %d cues, want 10", len(cues))
+	}
+	c := cues[5]
+	if c.Start != 5*time.Second || c.End != 6*time.Second || c.Sheet != 1 || c.Rect != image.Rect(16, 0, 32, 9) {
+		t.Errorf("cue 5 = %+v", c)
+	}
+
+	// Tile 5 shows frame 125, whose luma is 125.
+	if got := sheets[1].Image.NRGBAAt(20, 4).R; got < 124 || got > 126 {
+		t.Errorf("tile 5 luma = %d, want 125", got)
+	}
+}
+
+func TestSamplingIsExact(t *testing.T) {
+	// At 30000/1001 fps a sample every 1001ms lands exactly on every 30th
+	// frame; accumulating a rounded frame duration would drift.
+	opts := Options{Interval: 1001 * time.Millisecond, Columns: 10, Rows: 10, TileWidth: 8}
+	b, err := NewBuilder(opts, y4m.Rational{Num: 30000, Den: 1001})
+	if err != nil {
+		t.Fatalf("NewBuilder() unexpected error: %v", err)
+	}
+	for k := 0; k < 1000; k++ {
+		if got := b.sampleFrame(k); got != 30*k {
+			t.Fatalf("sampleFrame(%d) = %d, want %d", k, got, 30*k)
+		}
+	}
+}
+
+func TestLastCueEndsWithStream(t *testing.T) {
+	opts := Options{Interval: 2 * time.Second, Columns: 4, Rows: 1, TileHeight: 9}
+	b, _ := build(t, opts, y4m.Rational{Num: 10, Den: 1}, 25)
+
+	cues := b.Cues()
+	if len(cues) != 2 || cues[1].End != 2500*time.Millisecond {
+		t.Errorf("cues = %+v, want the second to end at 2.5s", cues)
+	}
+	if cues[0].Rect.Dx() != 16 {
+		t.Errorf("tile width = %d, want 16 from the 16:9 aspect ratio", cues[0].Rect.Dx())
+	}
+}
+
+func TestShortIntervalRepeatsFrames(t *testing.T) {
+	// Four samples per frame fill a 2x2 sheet from each frame.
+	opts := Options{Interval: 10 * time.Millisecond, Columns: 2, Rows: 2, TileWidth: 4}
+	b, sheets := build(t, opts, y4m.Rational{Num: 25, Den: 1}, 3)
+	if len(sheets) != 3 || len(b.Cues()) != 12 {
+		t.Errorf("got %d sheets and %d cues, want 3 and 12", len(sheets), len(b.Cues()))
+	}
+}
+
+func TestLimitedRangeFrames(t *testing.T) {
+	// Limited-range black and white: luma 16 and 235 with neutral chroma.
+	opts := Options{Interval: time.Second, Columns: 3, Rows: 1, TileWidth: 8,
+		Space: colorspace.Space{Matrix: colorspace.BT709, Range: colorspace.Limited}, Siting: chroma.Left}
+	b, err := NewBuilder(opts, y4m.Rational{Num: 1, Den: 1})
+	if err != nil {
+		t.Fatalf("NewBuilder() unexpected error: %v", err)
+	}
+	for _, luma := range []uint8{16, 235} {
+		ycc := image.NewYCbCr(image.Rect(0, 0, 16, 8), image.YCbCrSubsampleRatio420)
+		for i := range ycc.Y {
+			ycc.Y[i] = luma
+		}
+		for i := range ycc.Cb {
+			ycc.Cb[i], ycc.Cr[i] = 128, 128
+		}
+		if _, err := b.Push(ycc); err != nil {
+			t.Fatalf("Push() unexpected error: %v", err)
+		}
+	}
+	sheet := b.Flush()
+	if sheet == nil {
+		t.Fatal("Flush() returned no sheet")
+	}
+	for i, want := range []color.NRGBA{{0, 0, 0, 255}, {255, 255, 255, 255}} {
+		if got := sheet.Image.NRGBAAt(8*i+4, 2); got != want {
+			t.Errorf("tile %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestWriteWebVTT(t *testing.T) {
+	cues := []Cue{
+		{Start: 0, End: 10 * time.Second, Sheet: 0, Rect: image.Rect(0, 0, 160, 90)},
+		{Start: 3599 * time.Second, End: 3600*time.Second + 250*time.Millisecond, Sheet: 3, Rect: image.Rect(160, 90, 320, 180)},
+	}
+
+	var b strings.Builder
+	err := WriteWebVTT(&b, cues, func(sheet int) string {
+		return []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}[sheet]
+	})
+	if err != nil {
+		t.Fatalf("WriteWebVTT() unexpected error: %v", err)
+	}
+
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:10.000\na.jpg#xywh=0,0,160,90\n" +
+		"\n00:59:59.000 --> 01:00:00.250\nd.jpg#xywh=160,90,160,90\n"
+	if b.String() != want {
+		t.Errorf("WriteWebVTT() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestNewBuilderErrors(t *testing.T) {
+	rate := y4m.Rational{Num: 25, Den: 1}
+	for _, opts := range []Options{
+		{Interval: 0, Columns: 1, Rows: 1, TileWidth: 8},
+		{Interval: time.Second, Columns: 0, Rows: 1, TileWidth: 8},
+		{Interval: time.Second, Columns: 1, Rows: 1},
+	} {
+		if _, err := NewBuilder(opts, rate); err == nil {
+			t.Errorf("NewBuilder(%+v) expected error", opts)
+		}
+	}
+	if _, err := NewBuilder(Options{Interval: time.Second, Columns: 1, Rows: 1, TileWidth: 8}, y4m.Rational{}); err == nil {
+		t.Error("NewBuilder() expected error for missing frame rate")
+	}
+}
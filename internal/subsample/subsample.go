@@ -0,0 +1,35 @@
+package subsample
+
+import "image"
+
+// Factors returns how many luma samples across and down share one chroma
+// sample in ratio.
+func Factors(ratio image.YCbCrSubsampleRatio) (int, int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
+
+// Ratio is the inverse of Factors. It reports false for sharing that
+// image.YCbCr cannot represent.
+func Ratio(fx, fy int) (image.YCbCrSubsampleRatio, bool) {
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440, image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410,
+	} {
+		if x, y := Factors(ratio); x == fx && y == fy {
+			return ratio, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,23 @@
+package subsample
+
+import (
+	"image"
+	"testing"
+)
+
+func TestRatioInvertsFactors(t *testing.T) {
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440, image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410,
+	} {
+		fx, fy := Factors(ratio)
+		if got, ok := Ratio(fx, fy); !ok || got != ratio {
+			t.Errorf("Ratio(Factors(%v)) = %v, %v; want %v", ratio, got, ok, ratio)
+		}
+	}
+	// Transposing 4:1:1 asks for sharing down four rows, which
+	// image.YCbCr has no ratio for.
+	if _, ok := Ratio(1, 4); ok {
+		t.Error("Ratio(1, 4) reported a ratio")
+	}
+}
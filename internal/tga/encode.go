@@ -0,0 +1,162 @@
+package tga
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// footer marks a file as TGA 2.0. Neither the extension area nor the
+// developer directory is written, so both offsets are zero.
+var footer = []byte("\x00\x00\x00\x00\x00\x00\x00\x00TRUEVISION-XFILE.\x00")
+
+// maxPacket is the most pixels one run-length packet can hold.
+const maxPacket = 128
+
+// Options configures Encode.
+type Options struct {
+	// Uncompressed writes raw pixels instead of run-length packets.
+	Uncompressed bool
+}
+
+// Encode writes m as a bottom-up TGA, run-length encoded unless o says
+// otherwise. Paletted images with at most 256 colours are colour-mapped,
+// *image.Gray is greyscale, opaque images are 24-bit and others 32-bit
+// with 8 alpha bits.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if bounds.Empty() || width > 0xffff || height > 0xffff {
+		return fmt.Errorf("cannot encode %dx%d image as TGA", width, height)
+	}
+
+	var buf [headerSize]byte
+	le := binary.LittleEndian
+	le.PutUint16(buf[12:], uint16(width))
+	le.PutUint16(buf[14:], uint16(height))
+
+	// pixel writes the stored form of the pixel at (x, y) to dst.
+	var pixel func(dst []byte, x, y int)
+	var colorMap []byte
+	switch src := m.(type) {
+	case *image.Paletted:
+		if len(src.Palette) == 0 || len(src.Palette) > 256 {
+			break
+		}
+		mapDepth, mapAlpha := 24, paletteAlpha(src.Palette)
+		if mapAlpha {
+			mapDepth, buf[17] = 32, 8
+		}
+		for _, c := range src.Palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			colorMap = append(colorMap, n.B, n.G, n.R)
+			if mapAlpha {
+				colorMap = append(colorMap, n.A)
+			}
+		}
+		buf[1], buf[2], buf[16] = 1, typeColorMapped, 8
+		le.PutUint16(buf[5:], uint16(len(src.Palette)))
+		buf[7] = byte(mapDepth)
+		pixel = func(dst []byte, x, y int) {
+			dst[0] = src.Pix[src.PixOffset(x, y)]
+		}
+	case *image.Gray:
+		buf[2], buf[16] = typeGrey, 8
+		pixel = func(dst []byte, x, y int) {
+			dst[0] = src.Pix[src.PixOffset(x, y)]
+		}
+	}
+	if pixel == nil {
+		src := toNRGBA(m)
+		buf[2], buf[16] = typeTrueColor, 24
+		if !src.Opaque() {
+			buf[16], buf[17] = 32, 8
+		}
+		pixel = func(dst []byte, x, y int) {
+			p := src.Pix[src.PixOffset(x, y):]
+			dst[0], dst[1], dst[2] = p[2], p[1], p[0]
+			if len(dst) == 4 {
+				dst[3] = p[3]
+			}
+		}
+	}
+	if !opts.Uncompressed {
+		buf[2] |= typeRLE
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(buf[:])
+	bw.Write(colorMap)
+	size := int(buf[16]) / 8
+	line := make([]byte, width*size)
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		for x := 0; x < width; x++ {
+			pixel(line[x*size:(x+1)*size], bounds.Min.X+x, y)
+		}
+		if opts.Uncompressed {
+			bw.Write(line)
+		} else {
+			writeRLE(bw, line, size)
+		}
+	}
+	bw.Write(footer)
+	return bw.Flush()
+}
+
+// writeRLE encodes one scanline of pixels as run-length packets. Packets
+// never cross scanlines, as TGA 2.0 requires.
+func writeRLE(w *bufio.Writer, line []byte, size int) {
+	n := len(line) / size
+	at := func(i int) []byte {
+		return line[i*size : (i+1)*size]
+	}
+	for i := 0; i < n; {
+		run := 1
+		for i+run < n && run < maxPacket && bytes.Equal(at(i+run), at(i)) {
+			run++
+		}
+		if run > 1 {
+			w.WriteByte(0x80 | byte(run-1))
+			w.Write(at(i))
+			i += run
+			continue
+		}
+		// A raw packet extends up to the next pair of equal pixels.
+		raw := 1
+		for i+raw < n && raw < maxPacket && (i+raw+1 >= n || !bytes.Equal(at(i+raw), at(i+raw+1))) {
+			raw++
+		}
+		w.WriteByte(byte(raw - 1))
+		w.Write(line[i*size : (i+raw)*size])
+		i += raw
+	}
+}
+
+// paletteAlpha reports whether any palette entry is not opaque.
+func paletteAlpha(p color.Palette) bool {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return true
+		}
+	}
+	return false
+}
+
+func toNRGBA(m image.Image) *image.NRGBA {
+	if img, ok := m.(*image.NRGBA); ok {
+		return img
+	}
+	bounds := m.Bounds()
+	img := image.NewNRGBA(bounds)
+	draw.Draw(img, bounds, m, bounds.Min, draw.Src)
+	return img
+}
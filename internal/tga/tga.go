@@ -0,0 +1,309 @@
+package tga
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"video-processor/internal/imagesize"
+)
+
+const headerSize = 18
+
+// Image types. The run-length encoded variants are the plain ones plus 8.
+const (
+	typeColorMapped = 1
+	typeTrueColor   = 2
+	typeGrey        = 3
+	typeRLE         = 8
+)
+
+// Bits of the image descriptor byte.
+const (
+	alphaBitsMask = 0x0f
+	rightToLeft   = 0x10
+	topToBottom   = 0x20
+)
+
+func init() {
+	// TGA has no signature at the start of the file, so detection relies on
+	// the colour map flag and image type that follow the ID length. The
+	// combinations are specific enough not to match the other registered
+	// formats.
+	for _, magic := range []string{
+		"?\x01\x01", "?\x00\x02", "?\x00\x03",
+		"?\x01\x09", "?\x00\x0a", "?\x00\x0b",
+	} {
+		image.RegisterFormat("tga", magic, Decode, DecodeConfig)
+	}
+}
+
+type header struct {
+	idLength     int
+	colorMapType int
+	imageType    int
+	// mapFirst is the index of the first colour map entry, mapLength the
+	// number of entries and mapDepth their size in bits.
+	mapFirst, mapLength, mapDepth int
+	width, height                 int
+	depth                         int
+	descriptor                    byte
+}
+
+func (h header) rle() bool {
+	return h.imageType&typeRLE != 0
+}
+
+func (h header) baseType() int {
+	return h.imageType &^ typeRLE
+}
+
+func (h header) alphaBits() int {
+	return int(h.descriptor & alphaBitsMask)
+}
+
+func readHeader(r io.Reader) (header, error) {
+	var buf [headerSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return header{}, fmt.Errorf("failed to read header: %w", unexpectedEOF(err))
+	}
+	le := binary.LittleEndian
+	h := header{
+		idLength:     int(buf[0]),
+		colorMapType: int(buf[1]),
+		imageType:    int(buf[2]),
+		mapFirst:     int(le.Uint16(buf[3:])),
+		mapLength:    int(le.Uint16(buf[5:])),
+		mapDepth:     int(buf[7]),
+		width:        int(le.Uint16(buf[12:])),
+		height:       int(le.Uint16(buf[14:])),
+		depth:        int(buf[16]),
+		descriptor:   buf[17],
+	}
+
+	if h.colorMapType > 1 {
+		return h, errors.New("not a TGA file")
+	}
+	if h.colorMapType == 1 {
+		switch h.mapDepth {
+		case 15, 16, 24, 32:
+		default:
+			return h, fmt.Errorf("unsupported TGA colour map depth %d", h.mapDepth)
+		}
+	}
+	switch h.baseType() {
+	case typeColorMapped:
+		if h.colorMapType != 1 || h.depth != 8 {
+			return h, fmt.Errorf("unsupported colour-mapped TGA with depth %d", h.depth)
+		}
+		if h.mapLength == 0 || h.mapLength > 256 {
+			return h, fmt.Errorf("unsupported TGA colour map length %d", h.mapLength)
+		}
+	case typeTrueColor:
+		switch h.depth {
+		case 15, 16, 24, 32:
+		default:
+			return h, fmt.Errorf("unsupported TGA depth %d", h.depth)
+		}
+	case typeGrey:
+		if h.depth != 8 && h.depth != 16 {
+			return h, fmt.Errorf("unsupported greyscale TGA depth %d", h.depth)
+		}
+	default:
+		return h, fmt.Errorf("unsupported TGA image type %d", h.imageType)
+	}
+	if !imagesize.Valid(h.width, h.height) {
+		return h, fmt.Errorf("invalid TGA dimensions %dx%d", h.width, h.height)
+	}
+	return h, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readColorMap skips the image ID and reads the colour map. It returns the
+// palette of a colour-mapped image and nil for other types, whose colour
+// maps are skipped.
+func readColorMap(r *bufio.Reader, h header) (color.Palette, error) {
+	if _, err := r.Discard(h.idLength); err != nil {
+		return nil, fmt.Errorf("failed to read image ID: %w", unexpectedEOF(err))
+	}
+	if h.colorMapType == 0 {
+		return nil, nil
+	}
+	size := (h.mapDepth + 7) / 8
+	raw := make([]byte, h.mapLength*size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("failed to read colour map: %w", unexpectedEOF(err))
+	}
+	if h.baseType() != typeColorMapped {
+		return nil, nil
+	}
+	palette := make(color.Palette, h.mapLength)
+	for i := range palette {
+		palette[i] = h.pixel(raw[i*size:], h.mapDepth)
+	}
+	return palette, nil
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return image.Config{}, err
+	}
+	var model color.Model = color.NRGBAModel
+	switch {
+	case h.baseType() == typeColorMapped:
+		palette, err := readColorMap(br, h)
+		if err != nil {
+			return image.Config{}, err
+		}
+		model = palette
+	case h.baseType() == typeGrey && h.depth == 8:
+		model = color.GrayModel
+	}
+	return image.Config{ColorModel: model, Width: h.width, Height: h.height}, nil
+}
+
+// Decode reads a TGA image. Colour-mapped images decode to
+// *image.Paletted, 8-bit greyscale to *image.Gray and everything else to
+// *image.NRGBA. Alpha is taken from 32-bit pixels and the attribute bit of
+// 16-bit ones only when the descriptor declares alpha bits.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	palette, err := readColorMap(br, h)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (h.depth + 7) / 8
+	raw := make([]byte, h.width*h.height*size)
+	if h.rle() {
+		err = readRLE(br, raw, size)
+	} else if _, err = io.ReadFull(br, raw); err != nil {
+		err = fmt.Errorf("failed to read pixels: %w", unexpectedEOF(err))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// offset maps the i-th stored pixel to its position in the image;
+	// pixels are stored bottom-up and left to right unless the descriptor
+	// says otherwise.
+	offset := func(i int) int {
+		x, y := i%h.width, i/h.width
+		if h.descriptor&rightToLeft != 0 {
+			x = h.width - 1 - x
+		}
+		if h.descriptor&topToBottom == 0 {
+			y = h.height - 1 - y
+		}
+		return y*h.width + x
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	n := h.width * h.height
+	switch {
+	case palette != nil:
+		img := image.NewPaletted(rect, palette)
+		for i := 0; i < n; i++ {
+			index := int(raw[i]) - h.mapFirst
+			if index < 0 || index >= len(palette) {
+				return nil, fmt.Errorf("colour map index %d out of range", raw[i])
+			}
+			img.Pix[offset(i)] = uint8(index)
+		}
+		return img, nil
+	case h.baseType() == typeGrey && h.depth == 8:
+		img := image.NewGray(rect)
+		for i := 0; i < n; i++ {
+			img.Pix[offset(i)] = raw[i]
+		}
+		return img, nil
+	}
+
+	img := image.NewNRGBA(rect)
+	for i := 0; i < n; i++ {
+		p := raw[i*size:]
+		var c color.NRGBA
+		if h.baseType() == typeGrey {
+			// 16-bit greyscale is grey and alpha.
+			c = color.NRGBA{R: p[0], G: p[0], B: p[0], A: p[1]}
+		} else {
+			c = h.pixel(p, h.depth)
+		}
+		o := 4 * offset(i)
+		img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = c.R, c.G, c.B, c.A
+	}
+	return img, nil
+}
+
+// pixel decodes a little-endian BGR(A) pixel or colour map entry of the
+// given depth.
+func (h header) pixel(p []byte, depth int) color.NRGBA {
+	switch depth {
+	case 15, 16:
+		v := binary.LittleEndian.Uint16(p)
+		c := color.NRGBA{R: expand5(v >> 10), G: expand5(v >> 5), B: expand5(v), A: 0xff}
+		if depth == 16 && h.alphaBits() == 1 && v&0x8000 == 0 {
+			c.A = 0
+		}
+		return c
+	case 24:
+		return color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+	}
+	c := color.NRGBA{R: p[2], G: p[1], B: p[0], A: p[3]}
+	if h.alphaBits() == 0 {
+		c.A = 0xff
+	}
+	return c
+}
+
+// expand5 scales the low five bits of v to eight.
+func expand5(v uint16) uint8 {
+	v &= 0x1f
+	return uint8(v<<3 | v>>2)
+}
+
+// readRLE expands run-length packets into dst. Packets may cross
+// scanlines, as the original specification allowed.
+func readRLE(r *bufio.Reader, dst []byte, size int) error {
+	for i := 0; i < len(dst); {
+		b, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read pixels: %w", unexpectedEOF(err))
+		}
+		n := (int(b&0x7f) + 1) * size
+		if i+n > len(dst) {
+			return errors.New("run-length packet overruns image")
+		}
+		if b&0x80 == 0 {
+			if _, err := io.ReadFull(r, dst[i:i+n]); err != nil {
+				return fmt.Errorf("failed to read pixels: %w", unexpectedEOF(err))
+			}
+			i += n
+			continue
+		}
+		if _, err := io.ReadFull(r, dst[i:i+size]); err != nil {
+			return fmt.Errorf("failed to read pixels: %w", unexpectedEOF(err))
+		}
+		for j := i + size; j < i+n; j += size {
+			copy(dst[j:j+size], dst[i:i+size])
+		}
+		i += n
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package tga
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// file builds a TGA from a header, an optional colour map and pixels. The
+// one-byte image ID checks that it is skipped.
+func file(imageType, width, height, depth int, descriptor byte, colorMap []byte, mapDepth int, pixels []byte) []byte {
+	h := make([]byte, headerSize)
+	h[0] = 1
+	if colorMap != nil {
+		h[1] = 1
+		n := len(colorMap) / ((mapDepth + 7) / 8)
+		h[5], h[6], h[7] = byte(n), byte(n>>8), byte(mapDepth)
+	}
+	h[2] = byte(imageType)
+	h[12], h[13], h[14], h[15] = byte(width), byte(width>>8), byte(height), byte(height>>8)
+	h[16], h[17] = byte(depth), descriptor
+	out := append(h, 'x')
+	out = append(out, colorMap...)
+	return append(out, pixels...)
+}
+
+func TestDecode(t *testing.T) {
+	red, green := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}
+	tests := []struct {
+		name string
+		data []byte
+		// want lists the top row of a 2x2 image, then the bottom row.
+		want [4]color.Color
+	}{
+		{
+			"24-bit bottom-up",
+			file(2, 2, 2, 24, 0, nil, 0, []byte{
+				0, 0, 255, 0, 255, 0,
+				255, 0, 0, 255, 255, 255,
+			}),
+			[4]color.Color{color.NRGBA{0, 0, 255, 255}, color.NRGBA{255, 255, 255, 255}, red, green},
+		},
+		{
+			"32-bit top-down right-to-left",
+			file(2, 2, 2, 32, 8|topToBottom|rightToLeft, nil, 0, []byte{
+				0, 0, 255, 128, 0, 255, 0, 255,
+				255, 0, 0, 0, 0, 0, 0, 255,
+			}),
+			[4]color.Color{green, color.NRGBA{255, 0, 0, 128}, color.NRGBA{0, 0, 0, 255}, color.NRGBA{0, 0, 255, 0}},
+		},
+		{
+			"32-bit without alpha bits",
+			file(2, 2, 2, 32, topToBottom, nil, 0, []byte{
+				0, 0, 255, 0, 0, 255, 0, 0,
+				0, 0, 255, 0, 0, 255, 0, 0,
+			}),
+			[4]color.Color{red, green, red, green},
+		},
+		{
+			"16-bit with attribute alpha",
+			file(2, 2, 2, 16, 1|topToBottom, nil, 0, []byte{
+				0x00, 0xfc, 0xe0, 0x03,
+				0x1f, 0x80, 0xff, 0xff,
+			}),
+			[4]color.Color{red, color.NRGBA{0, 255, 0, 0}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{255, 255, 255, 255}},
+		},
+		{
+			"RLE across scanlines",
+			file(10, 2, 2, 24, topToBottom, nil, 0, []byte{0x82, 0, 0, 255, 0x00, 0, 255, 0}),
+			[4]color.Color{red, red, red, green},
+		},
+		{
+			"colour-mapped RLE",
+			file(9, 2, 2, 8, 0, []byte{0, 0, 255, 0, 255, 0}, 24, []byte{0x81, 1, 0x01, 0, 1}),
+			[4]color.Color{red, green, green, green},
+		},
+		{
+			"greyscale",
+			file(3, 2, 2, 8, topToBottom, nil, 0, []byte{0, 64, 128, 255}),
+			[4]color.Color{color.Gray{0}, color.Gray{64}, color.Gray{128}, color.Gray{255}},
+		},
+		{
+			"greyscale RLE with alpha",
+			file(11, 2, 2, 16, 8|topToBottom, nil, 0, []byte{0x83, 100, 200}),
+			[4]color.Color{color.NRGBA{100, 100, 100, 200}, color.NRGBA{100, 100, 100, 200}, color.NRGBA{100, 100, 100, 200}, color.NRGBA{100, 100, 100, 200}},
+		},
+	}
+
+	for _, tt := range tests {
+		img, format, err := image.Decode(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: image.Decode() unexpected error: %v", tt.name, err)
+			continue
+		}
+		if format != "tga" {
+			t.Errorf("%s: format = %q, want tga", tt.name, format)
+		}
+		for i, want := range tt.want {
+			got := img.At(i%2, i/2)
+			if _, ok := want.(color.NRGBA); ok {
+				got = color.NRGBAModel.Convert(got)
+			}
+			if got != want {
+				t.Errorf("%s: pixel (%d, %d) = %#v, want %#v", tt.name, i%2, i/2, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated":     file(2, 2, 2, 24, 0, nil, 0, make([]byte, 11)),
+		"zero height":   file(2, 2, 0, 24, 0, nil, 0, nil),
+		"bad type":      file(4, 1, 1, 24, 0, nil, 0, make([]byte, 3)),
+		"bad depth":     file(2, 1, 1, 12, 0, nil, 0, make([]byte, 2)),
+		"missing map":   file(1, 1, 1, 8, 0, nil, 0, []byte{0}),
+		"bad index":     file(1, 1, 1, 8, 0, []byte{0, 0, 0}, 24, []byte{3}),
+		"RLE overrun":   file(10, 2, 1, 24, 0, nil, 0, []byte{0x82, 0, 0, 0}),
+		"RLE truncated": file(10, 2, 1, 24, 0, nil, 0, []byte{0x01, 0, 0, 0}),
+	}
+	for name, data := range tests {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode() expected error, got nil", name)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	opaque := image.NewNRGBA(image.Rect(0, 0, 300, 3))
+	translucent := image.NewNRGBA(image.Rect(2, 1, 7, 4))
+	grey := image.NewGray(image.Rect(0, 0, 3, 2))
+	paletted := image.NewPaletted(image.Rect(0, 0, 7, 2), color.Palette{color.Black, color.Transparent, color.RGBA{10, 20, 30, 255}})
+	for i := range opaque.Pix {
+		// Long runs with literal spans between them.
+		opaque.Pix[i] = uint8(i / 40 * 7)
+		if i%4 == 3 {
+			opaque.Pix[i] = 255
+		} else if i%13 == 0 {
+			opaque.Pix[i] = uint8(i)
+		}
+	}
+	for i := range translucent.Pix {
+		translucent.Pix[i] = uint8(i * 5)
+	}
+	for i := range grey.Pix {
+		grey.Pix[i] = uint8(i * 40)
+	}
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % 3)
+	}
+
+	tests := []struct {
+		name  string
+		src   image.Image
+		depth int
+	}{
+		{"opaque", opaque, 24},
+		{"translucent", translucent, 32},
+		{"grey", grey, 8},
+		{"paletted", paletted, 8},
+	}
+	for _, tt := range tests {
+		for _, opts := range []*Options{nil, {Uncompressed: true}} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.src, opts); err != nil {
+				t.Fatalf("%s: Encode() unexpected error: %v", tt.name, err)
+			}
+			if got := int(buf.Bytes()[16]); got != tt.depth {
+				t.Errorf("%s: depth = %d, want %d", tt.name, got, tt.depth)
+			}
+			if !bytes.HasSuffix(buf.Bytes(), footer) {
+				t.Errorf("%s: missing TGA 2.0 footer", tt.name)
+			}
+			img, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("%s: Decode() unexpected error: %v", tt.name, err)
+			}
+			b := tt.src.Bounds()
+			if img.Bounds().Size() != b.Size() {
+				t.Fatalf("%s: size = %v, want %v", tt.name, img.Bounds().Size(), b.Size())
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.src.At(b.Min.X+x, b.Min.Y+y))
+					if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+						t.Fatalf("%s: pixel (%d, %d) = %v, want %v", tt.name, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeRLE(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeRLE(w, []byte{1, 1, 1, 2, 3, 4, 4}, 1)
+	w.Flush()
+	// A run of three, a raw span of two and a run of two.
+	want := []byte{0x82, 1, 0x01, 2, 3, 0x81, 4}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("writeRLE() = %v, want %v", buf.Bytes(), want)
+	}
+}
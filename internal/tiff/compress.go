@@ -0,0 +1,259 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// decompress expands one strip or tile to exactly size bytes. Trailing
+// data past size is ignored, as some writers pad their output.
+func (d *decoder) decompress(src []byte, size int) ([]byte, error) {
+	switch d.compression {
+	case cLZW:
+		return decodeLZW(src, size)
+	case cPackBits:
+		return decodePackBits(src, size)
+	case cDeflate, cDeflateOld:
+		zr, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		dst := make([]byte, size)
+		if _, err := io.ReadFull(zr, dst); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return dst, nil
+	}
+	if len(src) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	// Copied so that undoing the predictor leaves the file intact.
+	return append([]byte(nil), src[:size]...), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// LZW as TIFF uses it: codes are packed most significant bit first and
+// grow from 9 to 12 bits one code earlier than in GIF's variant.
+const (
+	lzwClear     = 256
+	lzwEOI       = 257
+	lzwFirst     = 258
+	lzwMinWidth  = 9
+	lzwMaxWidth  = 12
+	lzwTableSize = 1 << lzwMaxWidth
+	// lzwHashSize is the encoder's hash table size, four times the number
+	// of codes so probes stay short.
+	lzwHashSize = 4 * lzwTableSize
+)
+
+// decodeLZW expands src until size bytes are produced or an end of
+// information code is read.
+func decodeLZW(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+	// Each entry is the previous entry's string plus one byte, so strings
+	// are rebuilt by walking back through prefix.
+	var prefix [lzwTableSize]uint16
+	var suffix, first [lzwTableSize]byte
+	var lengths [lzwTableSize]int
+	for i := 0; i < 256; i++ {
+		suffix[i], first[i], lengths[i] = byte(i), byte(i), 1
+	}
+
+	next, width := lzwFirst, lzwMinWidth
+	prev := -1
+	var bits uint32
+	nbits := 0
+	for len(dst) < size {
+		for nbits < width {
+			if len(src) == 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			bits = bits<<8 | uint32(src[0])
+			src = src[1:]
+			nbits += 8
+		}
+		code := int(bits>>(nbits-width)) & (1<<width - 1)
+		nbits -= width
+
+		switch {
+		case code == lzwClear:
+			next, width, prev = lzwFirst, lzwMinWidth, -1
+			continue
+		case code == lzwEOI:
+			return nil, io.ErrUnexpectedEOF
+		case prev < 0:
+			if code > 255 {
+				return nil, fmt.Errorf("invalid LZW code %d", code)
+			}
+			dst = append(dst, byte(code))
+			prev = code
+			continue
+		case code > next:
+			return nil, fmt.Errorf("invalid LZW code %d", code)
+		}
+
+		// A code one past the table is the previous string plus its own
+		// first byte.
+		entry := code
+		if code == next {
+			entry = prev
+		}
+		start := len(dst)
+		n := lengths[entry]
+		dst = append(dst, make([]byte, n)...)
+		for i, c := start+n-1, entry; i >= start; i-- {
+			dst[i] = suffix[c]
+			c = int(prefix[c])
+		}
+		if code == next {
+			dst = append(dst, first[prev])
+		}
+
+		if next < lzwTableSize {
+			prefix[next], suffix[next] = uint16(prev), first[entry]
+			first[next], lengths[next] = first[prev], lengths[prev]+1
+			next++
+			if next >= 1<<width-1 && width < lzwMaxWidth {
+				width++
+			}
+		}
+		prev = code
+	}
+	return dst[:size], nil
+}
+
+// encodeLZW compresses src, starting with a clear code and ending with an
+// end of information code.
+func encodeLZW(src []byte) []byte {
+	var out bytes.Buffer
+	var bits uint32
+	nbits := 0
+	width := lzwMinWidth
+	put := func(code int) {
+		bits = bits<<width | uint32(code)
+		nbits += width
+		for nbits >= 8 {
+			out.WriteByte(byte(bits >> (nbits - 8)))
+			nbits -= 8
+		}
+	}
+
+	// The table maps a prefix code and the byte that follows it to a
+	// code, stored together as key<<12 | code with zero marking an empty
+	// slot. Clearing it is cheap, which matters as it fills every few
+	// kilobytes.
+	var table [lzwHashSize]uint32
+	lookup := func(key uint32) (slot int, code int) {
+		slot = int((key>>12 ^ key) & (lzwHashSize - 1))
+		for table[slot] != 0 {
+			if table[slot]>>12 == key {
+				return slot, int(table[slot] & (lzwTableSize - 1))
+			}
+			slot = (slot + 1) & (lzwHashSize - 1)
+		}
+		return slot, -1
+	}
+
+	next := lzwFirst
+	put(lzwClear)
+	if len(src) > 0 {
+		code := int(src[0])
+		for _, c := range src[1:] {
+			key := uint32(code)<<8 | uint32(c)
+			slot, existing := lookup(key)
+			if existing >= 0 {
+				code = existing
+				continue
+			}
+			put(code)
+			table[slot] = key<<12 | uint32(next)
+			next++
+			// Widen once the next entry no longer fits. The decoder is an
+			// entry behind, so it widens one code earlier by its count.
+			if next == lzwTableSize-2 {
+				put(lzwClear)
+				clear(table[:])
+				next, width = lzwFirst, lzwMinWidth
+			} else if next == 1<<width {
+				width++
+			}
+			code = int(c)
+		}
+		put(code)
+		// The decoder adds an entry for the last code before it reads
+		// the end code.
+		if next++; next == 1<<width && width < lzwMaxWidth {
+			width++
+		}
+	}
+	put(lzwEOI)
+	if nbits > 0 {
+		out.WriteByte(byte(bits << (8 - nbits)))
+	}
+	return out.Bytes()
+}
+
+// decodePackBits expands the byte-oriented run-length encoding of src.
+func decodePackBits(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+	for len(dst) < size {
+		if len(src) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := int(int8(src[0]))
+		src = src[1:]
+		switch {
+		case n >= 0:
+			if len(src) < n+1 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			dst = append(dst, src[:n+1]...)
+			src = src[n+1:]
+		case n != -128:
+			if len(src) == 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			for i := 0; i < 1-n; i++ {
+				dst = append(dst, src[0])
+			}
+			src = src[1:]
+		}
+	}
+	// A run may overshoot the end of the chunk; the excess is dropped.
+	return dst[:size], nil
+}
+
+// encodePackBits compresses one row: runs of three or more equal bytes are
+// repeated, everything else is copied literally.
+func encodePackBits(out *bytes.Buffer, row []byte) {
+	for i := 0; i < len(row); {
+		run := 1
+		for i+run < len(row) && run < 128 && row[i+run] == row[i] {
+			run++
+		}
+		if run >= 3 {
+			out.WriteByte(byte(1 - run))
+			out.WriteByte(row[i])
+			i += run
+			continue
+		}
+		literal := 0
+		for i+literal < len(row) && literal < 128 {
+			if i+literal+2 < len(row) && row[i+literal] == row[i+literal+1] && row[i+literal] == row[i+literal+2] {
+				break
+			}
+			literal++
+		}
+		out.WriteByte(byte(literal - 1))
+		out.Write(row[i : i+literal])
+		i += literal
+	}
+}
@@ -0,0 +1,358 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"video-processor/internal/imagesize"
+)
+
+// Compression selects how Encode compresses strips.
+type Compression int
+
+const (
+	// Uncompressed stores raw samples.
+	Uncompressed Compression = iota
+	// LZW is the most widely supported lossless compression.
+	LZW
+	// Deflate usually compresses better than LZW.
+	Deflate
+	// PackBits is simple run-length encoding.
+	PackBits
+)
+
+var compressionNames = []string{"none", "lzw", "deflate", "packbits"}
+
+// ParseCompression converts a name such as "lzw" to a Compression.
+func ParseCompression(name string) (Compression, error) {
+	for i, n := range compressionNames {
+		if strings.EqualFold(name, n) {
+			return Compression(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown TIFF compression %q (available: %s)", name, strings.Join(CompressionNames(), ", "))
+}
+
+func (c Compression) String() string {
+	if c < 0 || int(c) >= len(compressionNames) {
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+	return compressionNames[c]
+}
+
+// CompressionNames lists the accepted compression names.
+func CompressionNames() []string {
+	return append([]string(nil), compressionNames...)
+}
+
+// Options configures Encode.
+type Options struct {
+	Compression Compression
+	// Predictor applies horizontal differencing before LZW or Deflate
+	// compression, which helps with photographic images.
+	Predictor bool
+}
+
+// stripSize is the uncompressed size Encode aims for in each strip.
+const stripSize = 64 << 10
+
+// Encode writes m as a little-endian TIFF in strips. A nil o writes LZW
+// with the horizontal predictor. Greyscale images stay greyscale, paletted
+// images with an opaque palette keep it, and everything else is RGB, with
+// unassociated alpha when m is not opaque. Images with 16-bit colour
+// models are written with 16 bits per sample, others with 8.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	opts := Options{Compression: LZW, Predictor: true}
+	if o != nil {
+		opts = *o
+	}
+	if opts.Compression < Uncompressed || opts.Compression > PackBits {
+		return fmt.Errorf("invalid TIFF compression %d", opts.Compression)
+	}
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if !imagesize.Valid(width, height) {
+		return fmt.Errorf("cannot encode %dx%d image as TIFF", width, height)
+	}
+
+	layout, row := rasterize(m)
+	rowBytes := width * layout.samples * layout.bitsPerSample / 8
+	rowsPerStrip := max(1, min(height, stripSize/rowBytes))
+	predictor := opts.Predictor && (opts.Compression == LZW || opts.Compression == Deflate)
+
+	var strips [][]byte
+	raw := make([]byte, 0, rowsPerStrip*rowBytes)
+	for y := 0; y < height; y += rowsPerStrip {
+		raw = raw[:0]
+		for i := y; i < min(y+rowsPerStrip, height); i++ {
+			start := len(raw)
+			raw = row(raw, bounds.Min.Y+i)
+			if predictor {
+				applyPredictor(raw[start:], layout.samples, layout.bitsPerSample)
+			}
+		}
+		strip, err := compressStrip(raw, rowBytes, opts.Compression)
+		if err != nil {
+			return fmt.Errorf("failed to compress strip: %w", err)
+		}
+		strips = append(strips, strip)
+	}
+
+	// The strips follow the header and the directory follows them, on a
+	// word boundary.
+	offset := 8
+	offsets := make([]uint32, len(strips))
+	counts := make([]uint32, len(strips))
+	for i, s := range strips {
+		offsets[i], counts[i] = uint32(offset), uint32(len(s))
+		offset += len(s)
+	}
+	ifdOffset := offset + offset%2
+	if ifdOffset > math.MaxUint32/2 {
+		return fmt.Errorf("TIFF output of %d bytes is too large", ifdOffset)
+	}
+
+	compression := map[Compression]uint32{Uncompressed: cNone, LZW: cLZW, Deflate: cDeflate, PackBits: cPackBits}[opts.Compression]
+	bits := make([]uint32, layout.samples)
+	for i := range bits {
+		bits[i] = uint32(layout.bitsPerSample)
+	}
+	fields := []field{
+		{tImageWidth, dtLong, []uint32{uint32(width)}},
+		{tImageLength, dtLong, []uint32{uint32(height)}},
+		{tBitsPerSample, dtShort, bits},
+		{tCompression, dtShort, []uint32{compression}},
+		{tPhotometric, dtShort, []uint32{uint32(layout.photometric)}},
+		{tStripOffsets, dtLong, offsets},
+		{tSamplesPerPixel, dtShort, []uint32{uint32(layout.samples)}},
+		{tRowsPerStrip, dtLong, []uint32{uint32(rowsPerStrip)}},
+		{tStripByteCounts, dtLong, counts},
+		{tXResolution, dtRational, []uint32{72, 1}},
+		{tYResolution, dtRational, []uint32{72, 1}},
+		{tPlanarConfig, dtShort, []uint32{1}},
+		{tResolutionUnit, dtShort, []uint32{2}},
+	}
+	if predictor {
+		fields = append(fields, field{tPredictor, dtShort, []uint32{predictorHorizontal}})
+	}
+	if layout.colorMap != nil {
+		fields = append(fields, field{tColorMap, dtShort, layout.colorMap})
+	}
+	if layout.alpha {
+		fields = append(fields, field{tExtraSamples, dtShort, []uint32{extraUnassociated}})
+	}
+
+	var out bytes.Buffer
+	out.WriteString("II*\x00")
+	binary.Write(&out, binary.LittleEndian, uint32(ifdOffset))
+	for _, s := range strips {
+		out.Write(s)
+	}
+	if offset%2 != 0 {
+		out.WriteByte(0)
+	}
+	writeIFD(&out, fields)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// layout describes the samples Encode writes.
+type layout struct {
+	photometric   int
+	samples       int
+	bitsPerSample int
+	alpha         bool
+	colorMap      []uint32
+}
+
+// rasterize picks the layout for m and returns a function that appends
+// row y in that layout, little-endian, to dst.
+func rasterize(m image.Image) (layout, func(dst []byte, y int) []byte) {
+	bounds := m.Bounds()
+	switch src := m.(type) {
+	case *image.Paletted:
+		if len(src.Palette) == 0 || len(src.Palette) > 256 || !paletteOpaque(src.Palette) {
+			break
+		}
+		// The colour map always has 256 entries for 8-bit indices.
+		colorMap := make([]uint32, 3*256)
+		for i, c := range src.Palette {
+			r, g, b, _ := c.RGBA()
+			colorMap[i], colorMap[256+i], colorMap[512+i] = r, g, b
+		}
+		return layout{photometric: pPaletted, samples: 1, bitsPerSample: 8, colorMap: colorMap},
+			func(dst []byte, y int) []byte {
+				return append(dst, src.Pix[src.PixOffset(bounds.Min.X, y):][:bounds.Dx()]...)
+			}
+	case *image.Gray:
+		return layout{photometric: pBlackIsZero, samples: 1, bitsPerSample: 8},
+			func(dst []byte, y int) []byte {
+				return append(dst, src.Pix[src.PixOffset(bounds.Min.X, y):][:bounds.Dx()]...)
+			}
+	case *image.Gray16:
+		return layout{photometric: pBlackIsZero, samples: 1, bitsPerSample: 16},
+			func(dst []byte, y int) []byte {
+				row := src.Pix[src.PixOffset(bounds.Min.X, y):]
+				for x := 0; x < bounds.Dx(); x++ {
+					// Gray16 is big-endian.
+					dst = append(dst, row[2*x+1], row[2*x])
+				}
+				return dst
+			}
+	}
+
+	l := layout{photometric: pRGB, samples: 3, bitsPerSample: 8}
+	if !opaque(m) {
+		l.samples, l.alpha = 4, true
+	}
+	switch m.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		l.bitsPerSample = 16
+		src, ok := m.(*image.NRGBA64)
+		if !ok {
+			src = image.NewNRGBA64(bounds)
+			draw.Draw(src, bounds, m, bounds.Min, draw.Src)
+		}
+		return l, func(dst []byte, y int) []byte {
+			row := src.Pix[src.PixOffset(bounds.Min.X, y):]
+			for x := 0; x < bounds.Dx(); x++ {
+				for c := 0; c < l.samples; c++ {
+					dst = append(dst, row[8*x+2*c+1], row[8*x+2*c])
+				}
+			}
+			return dst
+		}
+	}
+	src, ok := m.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(bounds)
+		draw.Draw(src, bounds, m, bounds.Min, draw.Src)
+	}
+	return l, func(dst []byte, y int) []byte {
+		row := src.Pix[src.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			dst = append(dst, row[4*x:4*x+l.samples]...)
+		}
+		return dst
+	}
+}
+
+func paletteOpaque(p color.Palette) bool {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+func opaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyPredictor replaces each little-endian sample of row with its
+// difference from the same sample of the pixel to its left.
+func applyPredictor(row []byte, samples, bitsPerSample int) {
+	if bitsPerSample == 8 {
+		for i := len(row) - 1; i >= samples; i-- {
+			row[i] -= row[i-samples]
+		}
+		return
+	}
+	le := binary.LittleEndian
+	for i := len(row) - 2; i >= 2*samples; i -= 2 {
+		le.PutUint16(row[i:], le.Uint16(row[i:])-le.Uint16(row[i-2*samples:]))
+	}
+}
+
+func compressStrip(raw []byte, rowBytes int, c Compression) ([]byte, error) {
+	var out bytes.Buffer
+	switch c {
+	case LZW:
+		return encodeLZW(raw), nil
+	case Deflate:
+		zw := zlib.NewWriter(&out)
+		if _, err := zw.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	case PackBits:
+		// Rows are packed separately, as the specification requires.
+		for i := 0; i < len(raw); i += rowBytes {
+			encodePackBits(&out, raw[i:i+rowBytes])
+		}
+	default:
+		return append([]byte(nil), raw...), nil
+	}
+	return out.Bytes(), nil
+}
+
+// field is a directory entry to write. Rationals take two values each.
+type field struct {
+	tag    int
+	typ    int
+	values []uint32
+}
+
+// writeIFD appends a directory with no successor to out, followed by the
+// values too large to fit in their entries.
+func writeIFD(out *bytes.Buffer, fields []field) {
+	sort.Slice(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+	le := binary.LittleEndian
+	extraOffset := out.Len() + 2 + 12*len(fields) + 4
+	var extra bytes.Buffer
+
+	binary.Write(out, le, uint16(len(fields)))
+	for _, f := range fields {
+		var value bytes.Buffer
+		for _, v := range f.values {
+			if f.typ == dtShort {
+				binary.Write(&value, le, uint16(v))
+			} else {
+				binary.Write(&value, le, v)
+			}
+		}
+		count := len(f.values)
+		if f.typ == dtRational {
+			count /= 2
+		}
+		binary.Write(out, le, uint16(f.tag))
+		binary.Write(out, le, uint16(f.typ))
+		binary.Write(out, le, uint32(count))
+		if value.Len() <= 4 {
+			var inline [4]byte
+			copy(inline[:], value.Bytes())
+			out.Write(inline[:])
+			continue
+		}
+		binary.Write(out, le, uint32(extraOffset+extra.Len()))
+		extra.Write(value.Bytes())
+		if extra.Len()%2 != 0 {
+			extra.WriteByte(0)
+		}
+	}
+	binary.Write(out, le, uint32(0))
+	out.Write(extra.Bytes())
+}
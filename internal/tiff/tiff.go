@@ -0,0 +1,499 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"video-processor/internal/imagesize"
+)
+
+// Tags read or written by this package.
+const (
+	tImageWidth      = 256
+	tImageLength     = 257
+	tBitsPerSample   = 258
+	tCompression     = 259
+	tPhotometric     = 262
+	tStripOffsets    = 273
+	tSamplesPerPixel = 277
+	tRowsPerStrip    = 278
+	tStripByteCounts = 279
+	tXResolution     = 282
+	tYResolution     = 283
+	tPlanarConfig    = 284
+	tResolutionUnit  = 296
+	tPredictor       = 317
+	tColorMap        = 320
+	tTileWidth       = 322
+	tTileLength      = 323
+	tTileOffsets     = 324
+	tTileByteCounts  = 325
+	tExtraSamples    = 338
+	tSampleFormat    = 339
+)
+
+// Field types, indexing their sizes in typeSizes.
+const (
+	dtByte     = 1
+	dtASCII    = 2
+	dtShort    = 3
+	dtLong     = 4
+	dtRational = 5
+)
+
+var typeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// Compression schemes.
+const (
+	cNone       = 1
+	cLZW        = 5
+	cDeflate    = 8
+	cPackBits   = 32773
+	cDeflateOld = 32946
+)
+
+// Photometric interpretations.
+const (
+	pWhiteIsZero = 0
+	pBlackIsZero = 1
+	pRGB         = 2
+	pPaletted    = 3
+)
+
+// Values of ExtraSamples describing an alpha channel.
+const (
+	extraUnspecified  = 0
+	extraAssociated   = 1
+	extraUnassociated = 2
+)
+
+const (
+	predictorNone       = 1
+	predictorHorizontal = 2
+)
+
+func init() {
+	image.RegisterFormat("tiff", "II*\x00", Decode, DecodeConfig)
+	image.RegisterFormat("tiff", "MM\x00*", Decode, DecodeConfig)
+}
+
+// decoder holds the whole file, which TIFF's offsets address at random,
+// and the integer fields of its first image file directory.
+type decoder struct {
+	data  []byte
+	order binary.ByteOrder
+	tags  map[int][]uint
+
+	width, height int
+	bitsPerSample int
+	samples       int
+	photometric   int
+	compression   int
+	predictor     int
+	planar        bool
+	// alpha is the ExtraSamples value of a channel after the colour ones,
+	// or -1 when there is none.
+	alpha   int
+	palette color.Palette
+}
+
+func newDecoder(r io.Reader) (*decoder, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TIFF: %w", err)
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("failed to read header: %w", io.ErrUnexpectedEOF)
+	}
+	d := &decoder{data: data, tags: map[int][]uint{}}
+	switch string(data[:4]) {
+	case "II*\x00":
+		d.order = binary.LittleEndian
+	case "MM\x00*":
+		d.order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF file")
+	}
+	if err := d.readIFD(int(d.order.Uint32(data[4:]))); err != nil {
+		return nil, err
+	}
+	return d, d.parse()
+}
+
+// readIFD collects the integer fields of the directory at offset; fields
+// of other types are not needed to decode pixels and are skipped.
+func (d *decoder) readIFD(offset int) error {
+	if offset < 8 || offset+2 > len(d.data) {
+		return fmt.Errorf("invalid IFD offset %d", offset)
+	}
+	n := int(d.order.Uint16(d.data[offset:]))
+	entries := d.data[offset+2:]
+	if len(entries) < 12*n {
+		return fmt.Errorf("failed to read IFD: %w", io.ErrUnexpectedEOF)
+	}
+	for i := 0; i < n; i++ {
+		e := entries[12*i : 12*i+12]
+		tag, typ, count := int(d.order.Uint16(e)), int(d.order.Uint16(e[2:])), uint64(d.order.Uint32(e[4:]))
+		if typ != dtByte && typ != dtShort && typ != dtLong {
+			continue
+		}
+		size := uint64(typeSizes[typ]) * count
+		value := e[8:12]
+		if size > 4 {
+			start := uint64(d.order.Uint32(e[8:]))
+			if start+size > uint64(len(d.data)) {
+				return fmt.Errorf("tag %d points past the end of the file", tag)
+			}
+			value = d.data[start : start+size]
+		}
+		values := make([]uint, count)
+		for j := range values {
+			switch typ {
+			case dtByte:
+				values[j] = uint(value[j])
+			case dtShort:
+				values[j] = uint(d.order.Uint16(value[2*j:]))
+			default:
+				values[j] = uint(d.order.Uint32(value[4*j:]))
+			}
+		}
+		d.tags[tag] = values
+	}
+	return nil
+}
+
+// first returns the first value of tag, or fallback when it is absent.
+func (d *decoder) first(tag int, fallback int) int {
+	if v := d.tags[tag]; len(v) > 0 {
+		return int(v[0])
+	}
+	return fallback
+}
+
+// parse validates the fields that describe the raster.
+func (d *decoder) parse() error {
+	d.width, d.height = d.first(tImageWidth, 0), d.first(tImageLength, 0)
+	if !imagesize.Valid(d.width, d.height) {
+		return fmt.Errorf("invalid TIFF dimensions %dx%d", d.width, d.height)
+	}
+	d.samples = d.first(tSamplesPerPixel, 1)
+	d.bitsPerSample = d.first(tBitsPerSample, 1)
+	for _, bits := range d.tags[tBitsPerSample] {
+		if int(bits) != d.bitsPerSample {
+			return errors.New("unsupported TIFF with mixed sample sizes")
+		}
+	}
+	for _, format := range d.tags[tSampleFormat] {
+		if format != 1 {
+			return fmt.Errorf("unsupported TIFF sample format %d", format)
+		}
+	}
+	d.compression = d.first(tCompression, cNone)
+	switch d.compression {
+	case cNone, cLZW, cDeflate, cDeflateOld, cPackBits:
+	default:
+		return fmt.Errorf("unsupported TIFF compression %d", d.compression)
+	}
+	d.predictor = d.first(tPredictor, predictorNone)
+	if d.predictor != predictorNone && (d.predictor != predictorHorizontal || d.bitsPerSample < 8) {
+		return fmt.Errorf("unsupported TIFF predictor %d", d.predictor)
+	}
+	d.planar = d.first(tPlanarConfig, 1) == 2
+
+	d.photometric = d.first(tPhotometric, -1)
+	colorSamples := 1
+	switch d.photometric {
+	case pWhiteIsZero, pBlackIsZero:
+		if d.bitsPerSample != 1 && d.bitsPerSample != 2 && d.bitsPerSample != 4 && d.bitsPerSample != 8 && d.bitsPerSample != 16 {
+			return fmt.Errorf("unsupported greyscale TIFF with %d bits per sample", d.bitsPerSample)
+		}
+	case pRGB:
+		colorSamples = 3
+		if d.bitsPerSample != 8 && d.bitsPerSample != 16 {
+			return fmt.Errorf("unsupported RGB TIFF with %d bits per sample", d.bitsPerSample)
+		}
+	case pPaletted:
+		if d.bitsPerSample > 8 {
+			return fmt.Errorf("unsupported paletted TIFF with %d bits per sample", d.bitsPerSample)
+		}
+		colorMap := d.tags[tColorMap]
+		n := 1 << d.bitsPerSample
+		if len(colorMap) != 3*n {
+			return errors.New("missing or invalid TIFF colour map")
+		}
+		d.palette = make(color.Palette, n)
+		for i := range d.palette {
+			d.palette[i] = color.RGBA64{R: uint16(colorMap[i]), G: uint16(colorMap[n+i]), B: uint16(colorMap[2*n+i]), A: 0xffff}
+		}
+	default:
+		return fmt.Errorf("unsupported TIFF photometric interpretation %d", d.photometric)
+	}
+	if d.samples < colorSamples {
+		return fmt.Errorf("TIFF has %d samples per pixel, want at least %d", d.samples, colorSamples)
+	}
+	if d.samples > colorSamples+4 {
+		return fmt.Errorf("unsupported TIFF with %d samples per pixel", d.samples)
+	}
+	d.alpha = -1
+	if d.samples > colorSamples && d.photometric != pPaletted {
+		if d.bitsPerSample < 8 {
+			return fmt.Errorf("unsupported TIFF alpha with %d bits per sample", d.bitsPerSample)
+		}
+		d.alpha = d.first(tExtraSamples, extraUnspecified)
+	}
+	return nil
+}
+
+func (d *decoder) model() color.Model {
+	wide := d.bitsPerSample == 16
+	switch {
+	case d.palette != nil:
+		return d.palette
+	case d.alpha == extraAssociated && wide:
+		return color.RGBA64Model
+	case d.alpha == extraAssociated:
+		return color.RGBAModel
+	case d.alpha >= 0 && wide:
+		return color.NRGBA64Model
+	case d.alpha >= 0:
+		return color.NRGBAModel
+	case d.photometric == pRGB && wide:
+		return color.RGBA64Model
+	case d.photometric == pRGB:
+		return color.RGBAModel
+	case wide:
+		return color.Gray16Model
+	}
+	return color.GrayModel
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: d.model(), Width: d.width, Height: d.height}, nil
+}
+
+// Decode reads the first image of a TIFF file. Greyscale decodes to
+// *image.Gray or *image.Gray16, palette images to *image.Paletted and RGB
+// to *image.RGBA or *image.RGBA64. An alpha channel gives *image.RGBA(64)
+// when it is associated (premultiplied) and *image.NRGBA(64) otherwise;
+// greyscale with alpha is expanded to RGB.
+func Decode(r io.Reader) (image.Image, error) {
+	d, err := newDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	planes, err := d.readPlanes()
+	if err != nil {
+		return nil, err
+	}
+	return d.convert(planes), nil
+}
+
+// planeSamples is the number of samples a plane holds for each pixel.
+func (d *decoder) planeSamples() int {
+	if d.planar {
+		return 1
+	}
+	return d.samples
+}
+
+// rowBytes is the size of one unpadded row of n pixels in a plane.
+func (d *decoder) rowBytes(n int) int {
+	return (n*d.planeSamples()*d.bitsPerSample + 7) / 8
+}
+
+// readPlanes decompresses every strip or tile into one buffer per plane:
+// a single buffer of interleaved samples, or one per sample when planar.
+func (d *decoder) readPlanes() ([][]byte, error) {
+	numPlanes := 1
+	if d.planar {
+		numPlanes = d.samples
+	}
+	rowBytes := d.rowBytes(d.width)
+	planes := make([][]byte, numPlanes)
+	for i := range planes {
+		planes[i] = make([]byte, rowBytes*d.height)
+	}
+
+	// Strips are tiles as wide as the image.
+	tileW, tileH := d.width, min(max(d.first(tRowsPerStrip, d.height), 1), d.height)
+	offsets, counts := d.tags[tStripOffsets], d.tags[tStripByteCounts]
+	_, tiled := d.tags[tTileWidth]
+	if tiled {
+		tileW, tileH = d.first(tTileWidth, 0), d.first(tTileLength, 0)
+		offsets, counts = d.tags[tTileOffsets], d.tags[tTileByteCounts]
+		if !imagesize.Valid(tileW, tileH) || tileW%16 != 0 || tileH%16 != 0 {
+			return nil, fmt.Errorf("invalid TIFF tile size %dx%d", tileW, tileH)
+		}
+	}
+	across, down := (d.width+tileW-1)/tileW, (d.height+tileH-1)/tileH
+	perPlane := across * down
+	if len(offsets) < perPlane*numPlanes || len(counts) < len(offsets) {
+		return nil, errors.New("missing TIFF strip or tile offsets")
+	}
+
+	tileRowBytes := d.rowBytes(tileW)
+	for i := 0; i < perPlane*numPlanes; i++ {
+		plane := planes[i/perPlane]
+		tx, ty := i%perPlane%across, i%perPlane/across
+		// Strips end at the last row; tiles are always full size.
+		rows := tileH
+		if !tiled {
+			rows = min(tileH, d.height-ty*tileH)
+		}
+		start, size := uint64(offsets[i]), uint64(counts[i])
+		if start+size > uint64(len(d.data)) {
+			return nil, fmt.Errorf("TIFF chunk %d points past the end of the file", i)
+		}
+		chunk, err := d.decompress(d.data[start:start+size], rows*tileRowBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode chunk %d: %w", i, err)
+		}
+		if d.predictor == predictorHorizontal {
+			d.undoPredictor(chunk, tileRowBytes)
+		}
+
+		// Copy the rows and columns that fall inside the image. Tile
+		// widths are multiples of 16, so tiles start on byte boundaries
+		// even for sub-byte samples.
+		x0 := tx * tileW * d.planeSamples() * d.bitsPerSample / 8
+		width := min(tileRowBytes, rowBytes-x0)
+		for y := 0; y < rows && ty*tileH+y < d.height; y++ {
+			copy(plane[(ty*tileH+y)*rowBytes+x0:][:width], chunk[y*tileRowBytes:])
+		}
+	}
+	return planes, nil
+}
+
+// undoPredictor reverses horizontal differencing, row by row. Samples of
+// 16 bits are differenced in the file's byte order.
+func (d *decoder) undoPredictor(chunk []byte, rowBytes int) {
+	n := d.planeSamples()
+	for row := chunk; len(row) >= rowBytes; row = row[rowBytes:] {
+		if d.bitsPerSample == 8 {
+			for i := n; i < rowBytes; i++ {
+				row[i] += row[i-n]
+			}
+			continue
+		}
+		for i := 2 * n; i+1 < rowBytes; i += 2 {
+			d.order.PutUint16(row[i:], d.order.Uint16(row[i:])+d.order.Uint16(row[i-2*n:]))
+		}
+	}
+}
+
+// convert builds the output image from decoded planes.
+func (d *decoder) convert(planes [][]byte) image.Image {
+	rect := image.Rect(0, 0, d.width, d.height)
+	rowBytes := d.rowBytes(d.width)
+	wide := d.bitsPerSample == 16
+
+	// sample returns sample c of pixel (x, y) at its stored precision.
+	sample := func(x, y, c int) int {
+		plane, index := planes[0], x*d.samples+c
+		if d.planar {
+			plane, index = planes[c], x
+		}
+		row := plane[y*rowBytes:]
+		switch d.bitsPerSample {
+		case 16:
+			return int(d.order.Uint16(row[2*index:]))
+		case 8:
+			return int(row[index])
+		}
+		bit := index * d.bitsPerSample
+		return int(row[bit/8]>>(8-d.bitsPerSample-bit%8)) & (1<<d.bitsPerSample - 1)
+	}
+	maxval := 1<<d.bitsPerSample - 1
+	// grey scales a greyscale sample to 16 bits.
+	grey := func(x, y int) int {
+		v := sample(x, y, 0) * 0xffff / maxval
+		if d.photometric == pWhiteIsZero {
+			v = 0xffff - v
+		}
+		return v
+	}
+
+	if d.palette != nil {
+		img := image.NewPaletted(rect, d.palette)
+		for y := 0; y < d.height; y++ {
+			for x := 0; x < d.width; x++ {
+				img.Pix[y*img.Stride+x] = uint8(sample(x, y, 0))
+			}
+		}
+		return img
+	}
+	if d.alpha < 0 && d.photometric != pRGB {
+		if wide {
+			img := image.NewGray16(rect)
+			for y := 0; y < d.height; y++ {
+				for x := 0; x < d.width; x++ {
+					img.SetGray16(x, y, color.Gray16{Y: uint16(grey(x, y))})
+				}
+			}
+			return img
+		}
+		img := image.NewGray(rect)
+		for y := 0; y < d.height; y++ {
+			for x := 0; x < d.width; x++ {
+				img.Pix[y*img.Stride+x] = uint8(grey(x, y) >> 8)
+			}
+		}
+		return img
+	}
+
+	// The rest share a layout of four 8- or 16-bit channels, and differ
+	// only in whether alpha is premultiplied.
+	var pix []uint8
+	bytesPerChannel := 1
+	if wide {
+		bytesPerChannel = 2
+	}
+	pix = make([]uint8, 4*bytesPerChannel*d.width*d.height)
+	colorSamples := 3
+	if d.photometric != pRGB {
+		colorSamples = 1
+	}
+	var px [4]int
+	for y := 0; y < d.height; y++ {
+		for x := 0; x < d.width; x++ {
+			for c := 0; c < 3; c++ {
+				if colorSamples == 1 {
+					px[c] = grey(x, y) >> (8 * (2 - bytesPerChannel))
+				} else {
+					px[c] = sample(x, y, c)
+				}
+			}
+			px[3] = maxval
+			if d.alpha >= 0 {
+				px[3] = sample(x, y, colorSamples)
+			}
+			o := (y*d.width + x) * 4 * bytesPerChannel
+			for c, v := range px {
+				if wide {
+					pix[o+2*c], pix[o+2*c+1] = uint8(v>>8), uint8(v)
+				} else {
+					pix[o+c] = uint8(v)
+				}
+			}
+		}
+	}
+
+	stride := 4 * bytesPerChannel * d.width
+	switch {
+	case d.alpha == extraAssociated && wide, d.alpha < 0 && wide:
+		return &image.RGBA64{Pix: pix, Stride: stride, Rect: rect}
+	case d.alpha == extraAssociated, d.alpha < 0:
+		return &image.RGBA{Pix: pix, Stride: stride, Rect: rect}
+	case wide:
+		return &image.NRGBA64{Pix: pix, Stride: stride, Rect: rect}
+	}
+	return &image.NRGBA{Pix: pix, Stride: stride, Rect: rect}
+}
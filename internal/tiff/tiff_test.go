@@ -0,0 +1,319 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestLZW(t *testing.T) {
+	// Clear, 7, the new code for "7 7", 8 and end of information, all
+	// 9 bits wide.
+	want := []byte{0x80, 0x01, 0xe0, 0x40, 0x88, 0x08}
+	if got := encodeLZW([]byte{7, 7, 7, 8}); !bytes.Equal(got, want) {
+		t.Errorf("encodeLZW() = %x, want %x", got, want)
+	}
+
+	// Enough data to widen codes to 12 bits and clear the table several
+	// times.
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(rng.Intn(8))
+		if rng.Intn(4) == 0 {
+			data[i] = byte(rng.Intn(256))
+		}
+	}
+	for _, src := range [][]byte{{7, 7, 7, 8}, {42}, data} {
+		got, err := decodeLZW(encodeLZW(src), len(src))
+		if err != nil {
+			t.Fatalf("decodeLZW() unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("LZW round trip of %d bytes changed the data", len(src))
+		}
+	}
+
+	if _, err := decodeLZW(encodeLZW([]byte{1, 2, 3}), 4); err == nil {
+		t.Error("decodeLZW() of short data expected error, got nil")
+	}
+}
+
+func TestPackBits(t *testing.T) {
+	// The example from the TIFF 6.0 specification.
+	unpacked := []byte{
+		0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa, 0x80, 0x00,
+		0x2a, 0x22, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+	}
+	packed := []byte{0xfe, 0xaa, 0x02, 0x80, 0x00, 0x2a, 0xfd, 0xaa, 0x03, 0x80, 0x00, 0x2a, 0x22, 0xf7, 0xaa}
+
+	var buf bytes.Buffer
+	encodePackBits(&buf, unpacked)
+	if !bytes.Equal(buf.Bytes(), packed) {
+		t.Errorf("encodePackBits() = %x, want %x", buf.Bytes(), packed)
+	}
+	got, err := decodePackBits(packed, len(unpacked))
+	if err != nil {
+		t.Fatalf("decodePackBits() unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, unpacked) {
+		t.Errorf("decodePackBits() = %x, want %x", got, unpacked)
+	}
+}
+
+func testImages() map[string]image.Image {
+	rgb := image.NewNRGBA(image.Rect(0, 0, 37, 300))
+	rgba := image.NewNRGBA(image.Rect(3, 5, 40, 25))
+	deep := image.NewNRGBA64(image.Rect(0, 0, 19, 7))
+	grey := image.NewGray(image.Rect(0, 0, 30, 20))
+	grey16 := image.NewGray16(image.Rect(0, 0, 9, 4))
+	paletted := image.NewPaletted(image.Rect(0, 0, 17, 3), color.Palette{color.Black, color.White, color.RGBA{200, 100, 50, 255}})
+	for i := range rgb.Pix {
+		rgb.Pix[i] = uint8(i / 7)
+		if i%4 == 3 {
+			rgb.Pix[i] = 255
+		}
+	}
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i * 3)
+	}
+	for i := range deep.Pix {
+		deep.Pix[i] = uint8(i * 11)
+	}
+	for i := range grey.Pix {
+		grey.Pix[i] = uint8(i)
+	}
+	for i := range grey16.Pix {
+		grey16.Pix[i] = uint8(i * 29)
+	}
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % 3)
+	}
+	return map[string]image.Image{
+		"rgb": rgb, "rgba": rgba, "16-bit rgba": deep,
+		"grey": grey, "16-bit grey": grey16, "paletted": paletted,
+	}
+}
+
+// samePixels reports the first pixel where a and b differ in 16-bit
+// non-premultiplied colour.
+func samePixels(t *testing.T, name string, got, want image.Image) {
+	t.Helper()
+	b := want.Bounds()
+	if got.Bounds().Size() != b.Size() {
+		t.Fatalf("%s: size = %v, want %v", name, got.Bounds().Size(), b.Size())
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			w := color.NRGBA64Model.Convert(want.At(b.Min.X+x, b.Min.Y+y))
+			if g := color.NRGBA64Model.Convert(got.At(x, y)); g != w {
+				t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, src := range testImages() {
+		for _, opts := range []*Options{
+			nil,
+			{Compression: Uncompressed},
+			{Compression: LZW},
+			{Compression: Deflate, Predictor: true},
+			{Compression: PackBits},
+		} {
+			var buf bytes.Buffer
+			if err := Encode(&buf, src, opts); err != nil {
+				t.Fatalf("%s: Encode() unexpected error: %v", name, err)
+			}
+			img, format, err := image.Decode(&buf)
+			if err != nil {
+				t.Fatalf("%s %+v: image.Decode() unexpected error: %v", name, opts, err)
+			}
+			if format != "tiff" {
+				t.Errorf("%s: format = %q, want tiff", name, format)
+			}
+			if got, want := img.ColorModel(), src.ColorModel(); name != "rgb" && name != "rgba" && name != "paletted" && got != want {
+				t.Errorf("%s: colour model = %v, want %v", name, got, want)
+			}
+			samePixels(t, name, img, src)
+		}
+	}
+}
+
+// build assembles a little-endian TIFF from fields and compressed chunks,
+// adding the tags that locate the chunks as strips or tiles.
+func build(fields []field, chunks [][]byte, tiled bool) []byte {
+	var out bytes.Buffer
+	out.WriteString("II*\x00\x00\x00\x00\x00")
+	var offsets, counts []uint32
+	for _, c := range chunks {
+		offsets = append(offsets, uint32(out.Len()))
+		counts = append(counts, uint32(len(c)))
+		out.Write(c)
+	}
+	if out.Len()%2 != 0 {
+		out.WriteByte(0)
+	}
+	offsetTag, countTag := tStripOffsets, tStripByteCounts
+	if tiled {
+		offsetTag, countTag = tTileOffsets, tTileByteCounts
+	}
+	fields = append(fields, field{offsetTag, dtLong, offsets}, field{countTag, dtLong, counts})
+	ifd := out.Len()
+	writeIFD(&out, fields)
+	data := out.Bytes()
+	binary.LittleEndian.PutUint32(data[4:], uint32(ifd))
+	return data
+}
+
+func short(tag int, values ...uint32) field {
+	return field{tag, dtShort, values}
+}
+
+func TestDecodeTiled(t *testing.T) {
+	// A 20x18 RGB image in four 16x16 tiles, deflated with the predictor.
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 18))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+		if i%4 == 3 {
+			src.Pix[i] = 255
+		}
+	}
+	var chunks [][]byte
+	for ty := 0; ty < 2; ty++ {
+		for tx := 0; tx < 2; tx++ {
+			tile := make([]byte, 16*16*3)
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 16; x++ {
+					if c := src.NRGBAAt(tx*16+x, ty*16+y); tx*16+x < 20 && ty*16+y < 18 {
+						copy(tile[(y*16+x)*3:], []byte{c.R, c.G, c.B})
+					}
+				}
+				applyPredictor(tile[y*48:(y+1)*48], 3, 8)
+			}
+			var z bytes.Buffer
+			zw := zlib.NewWriter(&z)
+			zw.Write(tile)
+			zw.Close()
+			chunks = append(chunks, z.Bytes())
+		}
+	}
+	data := build([]field{
+		short(tImageWidth, 20), short(tImageLength, 18), short(tBitsPerSample, 8, 8, 8),
+		short(tCompression, cDeflateOld), short(tPhotometric, pRGB), short(tSamplesPerPixel, 3),
+		short(tPredictor, predictorHorizontal), short(tTileWidth, 16), short(tTileLength, 16),
+	}, chunks, true)
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	samePixels(t, "tiled", img, src)
+}
+
+func TestDecodeVariants(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		want   []color.Color
+		format color.Model
+	}{
+		{
+			"planar RGB in two strips",
+			build([]field{
+				short(tImageWidth, 2), short(tImageLength, 2), short(tBitsPerSample, 8, 8, 8),
+				short(tPhotometric, pRGB), short(tSamplesPerPixel, 3), short(tPlanarConfig, 2),
+				short(tRowsPerStrip, 1),
+			}, [][]byte{{255, 0}, {0, 1}, {0, 255}, {2, 3}, {0, 0}, {4, 5}}, false),
+			[]color.Color{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 2, 4, 255}, color.RGBA{1, 3, 5, 255}},
+			color.RGBAModel,
+		},
+		{
+			"4-bit white is zero",
+			build([]field{
+				short(tImageWidth, 3), short(tImageLength, 1), short(tBitsPerSample, 4),
+				short(tPhotometric, pWhiteIsZero),
+			}, [][]byte{{0x0f, 0x80}}, false),
+			[]color.Color{color.Gray{255}, color.Gray{0}, color.Gray{119}},
+			color.GrayModel,
+		},
+		{
+			"2-bit palette",
+			build([]field{
+				short(tImageWidth, 4), short(tImageLength, 1), short(tBitsPerSample, 2),
+				short(tPhotometric, pPaletted),
+				short(tColorMap, 0, 0xffff, 0, 0, 0, 0, 0xffff, 0, 0, 0, 0, 0xffff),
+			}, [][]byte{{0x1b}}, false),
+			[]color.Color{color.RGBA64{0, 0, 0, 0xffff}, color.RGBA64{0xffff, 0, 0, 0xffff}, color.RGBA64{0, 0xffff, 0, 0xffff}, color.RGBA64{0, 0, 0xffff, 0xffff}},
+			nil,
+		},
+		{
+			"associated alpha",
+			build([]field{
+				short(tImageWidth, 1), short(tImageLength, 1), short(tBitsPerSample, 16, 16),
+				short(tPhotometric, pBlackIsZero), short(tSamplesPerPixel, 2), short(tExtraSamples, extraAssociated),
+			}, [][]byte{{0x00, 0x40, 0x00, 0x80}}, false),
+			[]color.Color{color.RGBA64{0x4000, 0x4000, 0x4000, 0x8000}},
+			color.RGBA64Model,
+		},
+		{
+			"big-endian 16-bit grey",
+			[]byte("MM\x00*\x00\x00\x00\x0a\x12\x34" +
+				"\x00\x06" +
+				"\x01\x00\x00\x03\x00\x00\x00\x01\x00\x01\x00\x00" +
+				"\x01\x01\x00\x03\x00\x00\x00\x01\x00\x01\x00\x00" +
+				"\x01\x02\x00\x03\x00\x00\x00\x01\x00\x10\x00\x00" +
+				"\x01\x06\x00\x03\x00\x00\x00\x01\x00\x01\x00\x00" +
+				"\x01\x11\x00\x04\x00\x00\x00\x01\x00\x00\x00\x08" +
+				"\x01\x17\x00\x04\x00\x00\x00\x01\x00\x00\x00\x02" +
+				"\x00\x00\x00\x00"),
+			[]color.Color{color.Gray16{0x1234}},
+			color.Gray16Model,
+		},
+	}
+
+	for _, tt := range tests {
+		img, err := Decode(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: Decode() unexpected error: %v", tt.name, err)
+			continue
+		}
+		if tt.format != nil && img.ColorModel() != tt.format {
+			t.Errorf("%s: colour model = %v, want %v", tt.name, img.ColorModel(), tt.format)
+		}
+		w := img.Bounds().Dx()
+		for i, want := range tt.want {
+			if got := img.At(i%w, i/w); got != want {
+				t.Errorf("%s: pixel %d = %#v, want %#v", tt.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := []field{short(tImageWidth, 2), short(tImageLength, 1), short(tBitsPerSample, 8), short(tPhotometric, pBlackIsZero)}
+	with := func(extra ...field) []field {
+		return append(append([]field(nil), valid...), extra...)
+	}
+	tests := map[string][]byte{
+		"not TIFF":        []byte("II+\x00\x08\x00\x00\x00"),
+		"bad IFD offset":  []byte("II*\x00\xff\x00\x00\x00"),
+		"short strip":     build(valid, [][]byte{{1}}, false),
+		"JPEG":            build(with(short(tCompression, 7)), [][]byte{{1, 2}}, false),
+		"no photometric":  build(valid[:3], [][]byte{{1, 2}}, false),
+		"float samples":   build(with(short(tSampleFormat, 3)), [][]byte{{1, 2}}, false),
+		"missing strips":  build(with(short(tRowsPerStrip, 1), short(tImageLength, 3)), [][]byte{{1, 2}}, false),
+		"bad tile size":   build(with(short(tTileWidth, 10), short(tTileLength, 16)), [][]byte{{1, 2}}, true),
+		"missing palette": build(with(short(tPhotometric, pPaletted)), [][]byte{{1, 2}}, false),
+	}
+	for name, data := range tests {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: Decode() expected error, got nil", name)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	maxCodeLength = 15
+	// tableBits is how many bits the lookup table resolves at once; longer
+	// codes are decoded a bit at a time.
+	tableBits = 8
+)
+
+// codeLengthOrder is the order in which the lengths of the code-length
+// code are stored.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// huffman is a canonical prefix code. Codes are read starting from their
+// most significant bit, so the table is indexed by bit-reversed codes.
+type huffman struct {
+	// single is set for a code with one symbol, which takes no bits.
+	single bool
+	// table maps the next tableBits bits to symbol<<4 | length, or zero
+	// for codes longer than tableBits.
+	table [1 << tableBits]uint32
+	// counts and symbols, ordered by length then value, drive the slow
+	// path.
+	counts  [maxCodeLength + 1]int
+	symbols []uint32
+}
+
+func newHuffman(lengths []int) (*huffman, error) {
+	h := &huffman{}
+	for symbol, n := range lengths {
+		if n > 0 {
+			h.counts[n]++
+			h.symbols = append(h.symbols, uint32(symbol))
+		}
+	}
+	switch len(h.symbols) {
+	case 0:
+		return nil, errors.New("empty VP8L prefix code")
+	case 1:
+		h.single = true
+		return h, nil
+	}
+	// The code must be complete: every bit string starts some code.
+	left := 1
+	for n := 1; n <= maxCodeLength; n++ {
+		left = left<<1 - h.counts[n]
+		if left < 0 {
+			return nil, errors.New("over-subscribed VP8L prefix code")
+		}
+	}
+	if left != 0 {
+		return nil, errors.New("incomplete VP8L prefix code")
+	}
+
+	// Sort symbols by length, stably, as canonical codes are assigned.
+	var offsets [maxCodeLength + 2]int
+	for n := 1; n <= maxCodeLength; n++ {
+		offsets[n+1] = offsets[n] + h.counts[n]
+	}
+	for symbol, n := range lengths {
+		if n > 0 {
+			h.symbols[offsets[n]] = uint32(symbol)
+			offsets[n]++
+		}
+	}
+
+	code, i := 0, 0
+	for n := 1; n <= tableBits; n++ {
+		for j := 0; j < h.counts[n]; j++ {
+			reversed := reverseBits(code, n)
+			for k := reversed; k < len(h.table); k += 1 << n {
+				h.table[k] = h.symbols[i]<<4 | uint32(n)
+			}
+			code++
+			i++
+		}
+		code <<= 1
+	}
+	return h, nil
+}
+
+func reverseBits(code, n int) int {
+	r := 0
+	for ; n > 0; n-- {
+		r = r<<1 | code&1
+		code >>= 1
+	}
+	return r
+}
+
+func (h *huffman) decode(b *bitReader) uint32 {
+	if h.single {
+		return h.symbols[0]
+	}
+	if e := h.table[b.peek(tableBits)]; e != 0 {
+		b.skip(uint(e & 0xf))
+		return e >> 4
+	}
+	code, first, index := 0, 0, 0
+	for n := 1; n <= maxCodeLength; n++ {
+		code |= int(b.read(1))
+		count := h.counts[n]
+		if code-first < count {
+			return h.symbols[index+code-first]
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	// Unreachable for the complete codes newHuffman accepts.
+	return 0
+}
+
+// readCode reads a prefix code for an alphabet of size symbols, in
+// either the simple form of one or two symbols or as code lengths that
+// are themselves prefix coded.
+func readCode(b *bitReader, size int) (*huffman, error) {
+	lengths := make([]int, size)
+	if b.read(1) == 1 {
+		count := int(b.read(1)) + 1
+		first := int(b.read(1 + 7*uint(b.read(1))))
+		symbols := []int{first}
+		if count == 2 {
+			symbols = append(symbols, int(b.read(8)))
+		}
+		for _, s := range symbols {
+			if s >= size {
+				return nil, fmt.Errorf("VP8L symbol %d out of range", s)
+			}
+			lengths[s] = 1
+		}
+		return newHuffman(lengths)
+	}
+
+	var codeLengthLengths [len(codeLengthOrder)]int
+	n := int(b.read(4)) + 4
+	for i := 0; i < n; i++ {
+		codeLengthLengths[codeLengthOrder[i]] = int(b.read(3))
+	}
+	codeLengths, err := newHuffman(codeLengthLengths[:])
+	if err != nil {
+		return nil, err
+	}
+
+	maxSymbol := size
+	if b.read(1) == 1 {
+		nbits := 2 + 2*uint(b.read(3))
+		maxSymbol = 2 + int(b.read(nbits))
+		if maxSymbol > size {
+			return nil, fmt.Errorf("VP8L code length count %d exceeds alphabet of %d", maxSymbol, size)
+		}
+	}
+
+	prev := 8
+	for symbol := 0; symbol < size && maxSymbol > 0; maxSymbol-- {
+		c := int(codeLengths.decode(b))
+		if c < 16 {
+			lengths[symbol] = c
+			symbol++
+			if c != 0 {
+				prev = c
+			}
+			continue
+		}
+		value, repeat := 0, 0
+		switch c {
+		case 16:
+			value, repeat = prev, 3+int(b.read(2))
+		case 17:
+			repeat = 3 + int(b.read(3))
+		default:
+			repeat = 11 + int(b.read(7))
+		}
+		if symbol+repeat > size {
+			return nil, errors.New("VP8L code lengths overflow alphabet")
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[symbol] = value
+			symbol++
+		}
+	}
+	if err := b.err(); err != nil {
+		return nil, err
+	}
+	return newHuffman(lengths)
+}
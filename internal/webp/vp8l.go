@@ -0,0 +1,461 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	vp8lSignature = 0x2f
+	// numLengthCodes and numDistanceCodes are the sizes of the prefix
+	// alphabets for backward reference lengths and distances.
+	numLengthCodes   = 24
+	numDistanceCodes = 40
+	maxCacheBits     = 11
+)
+
+// Transform types, each of which may appear at most once.
+const (
+	predictorTransform = iota
+	colorTransform
+	subtractGreenTransform
+	colorIndexingTransform
+)
+
+// bitReader reads bits least significant first. Reading past the end of
+// the data yields zeros and sets an error that decoding checks for.
+type bitReader struct {
+	data  []byte
+	pos   int
+	value uint64
+	nbits uint
+	// overrun counts the zero bits supplied past the end of data.
+	overrun uint
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (b *bitReader) fill() {
+	for b.nbits <= 56 {
+		if b.pos < len(b.data) {
+			b.value |= uint64(b.data[b.pos]) << b.nbits
+			b.pos++
+		} else {
+			b.overrun += 8
+		}
+		b.nbits += 8
+	}
+}
+
+// peek returns the next n bits, n at most 32, without consuming them.
+func (b *bitReader) peek(n uint) uint32 {
+	if b.nbits < n {
+		b.fill()
+	}
+	return uint32(b.value & (1<<n - 1))
+}
+
+func (b *bitReader) skip(n uint) {
+	b.value >>= n
+	b.nbits -= n
+}
+
+func (b *bitReader) read(n uint) uint32 {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// err reports whether bits past the end of the data were consumed.
+func (b *bitReader) err() error {
+	if b.overrun > b.nbits {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func readHeader(b *bitReader) (width, height int, err error) {
+	if b.read(8) != vp8lSignature {
+		return 0, 0, errors.New("invalid VP8L signature")
+	}
+	width, height = int(b.read(14))+1, int(b.read(14))+1
+	b.read(1) // alpha_is_used is only a hint.
+	if version := b.read(3); version != 0 {
+		return 0, 0, fmt.Errorf("unsupported VP8L version %d", version)
+	}
+	return width, height, b.err()
+}
+
+// transform is one decoded transform and the image width at the point it
+// was read, which colour indexing reduces for later transforms.
+type transform struct {
+	kind  int
+	width int
+	bits  uint
+	data  []uint32
+}
+
+// decodeImageStream reads the transforms and the main image, then undoes
+// the transforms in reverse order, returning width*height ARGB pixels.
+func decodeImageStream(b *bitReader, width, height int) ([]uint32, error) {
+	var transforms []transform
+	seen := 0
+	xsize := width
+	for b.read(1) == 1 {
+		t := transform{kind: int(b.read(2)), width: xsize}
+		if seen&(1<<t.kind) != 0 {
+			return nil, fmt.Errorf("repeated VP8L transform %d", t.kind)
+		}
+		seen |= 1 << t.kind
+
+		var err error
+		switch t.kind {
+		case predictorTransform, colorTransform:
+			t.bits = uint(b.read(3)) + 2
+			t.data, err = decodeImageData(b, subsample(xsize, t.bits), subsample(height, t.bits), false)
+		case colorIndexingTransform:
+			size := int(b.read(8)) + 1
+			if t.data, err = decodeImageData(b, size, 1, false); err != nil {
+				return nil, err
+			}
+			// The table is delta-coded, component by component.
+			for i := 1; i < size; i++ {
+				t.data[i] = addPixels(t.data[i], t.data[i-1])
+			}
+			switch {
+			case size <= 2:
+				t.bits = 3
+			case size <= 4:
+				t.bits = 2
+			case size <= 16:
+				t.bits = 1
+			}
+			xsize = subsample(xsize, t.bits)
+		}
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+
+	pixels, err := decodeImageData(b, xsize, height, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(transforms) - 1; i >= 0; i-- {
+		pixels = transforms[i].inverse(pixels, height)
+	}
+	return pixels, nil
+}
+
+// subsample is the size of a dimension divided into blocks of 1<<bits.
+func subsample(size int, bits uint) int {
+	return (size + 1<<bits - 1) >> bits
+}
+
+// decodeImageData reads one entropy-coded image. Only the main image may
+// use several groups of prefix codes, chosen per block by an entropy image.
+func decodeImageData(b *bitReader, width, height int, main bool) ([]uint32, error) {
+	cacheBits := uint(0)
+	if b.read(1) == 1 {
+		cacheBits = uint(b.read(4))
+		if cacheBits < 1 || cacheBits > maxCacheBits {
+			return nil, fmt.Errorf("invalid VP8L colour cache size %d", cacheBits)
+		}
+	}
+
+	var entropy []uint32
+	entropyBits, entropyWidth := uint(0), 0
+	numGroups := 1
+	if main && b.read(1) == 1 {
+		entropyBits = uint(b.read(3)) + 2
+		entropyWidth = subsample(width, entropyBits)
+		var err error
+		if entropy, err = decodeImageData(b, entropyWidth, subsample(height, entropyBits), false); err != nil {
+			return nil, err
+		}
+		// The group of each block is in the red and green channels.
+		for i, p := range entropy {
+			entropy[i] = p >> 8 & 0xffff
+			numGroups = max(numGroups, int(entropy[i])+1)
+		}
+	}
+
+	groups := make([][5]*huffman, numGroups)
+	cacheSize := 0
+	if cacheBits > 0 {
+		cacheSize = 1 << cacheBits
+	}
+	alphabets := [5]int{256 + numLengthCodes + cacheSize, 256, 256, 256, numDistanceCodes}
+	for i := range groups {
+		for j, size := range alphabets {
+			h, err := readCode(b, size)
+			if err != nil {
+				return nil, err
+			}
+			groups[i][j] = h
+		}
+	}
+	if err := b.err(); err != nil {
+		return nil, err
+	}
+
+	var cache []uint32
+	if cacheSize > 0 {
+		cache = make([]uint32, cacheSize)
+	}
+	pixels := make([]uint32, width*height)
+	cached := 0
+	for pos := 0; pos < len(pixels); {
+		group := &groups[0]
+		if entropy != nil {
+			x, y := pos%width, pos/width
+			group = &groups[entropy[(y>>entropyBits)*entropyWidth+x>>entropyBits]]
+		}
+
+		s := int(group[0].decode(b))
+		switch {
+		case s < 256:
+			red, blue, alpha := group[1].decode(b), group[2].decode(b), group[3].decode(b)
+			pixels[pos] = alpha<<24 | red<<16 | uint32(s)<<8 | blue
+			pos++
+		case s < 256+numLengthCodes:
+			length := prefixValue(b, s-256)
+			distance := distanceFor(prefixValue(b, int(group[4].decode(b))), width)
+			if distance > pos || length > len(pixels)-pos {
+				return nil, errors.New("invalid VP8L backward reference")
+			}
+			// The source may overlap what is being written.
+			for i := 0; i < length; i++ {
+				pixels[pos+i] = pixels[pos+i-distance]
+			}
+			pos += length
+		default:
+			if cache == nil {
+				return nil, errors.New("VP8L colour cache code without a cache")
+			}
+			pixels[pos] = cache[s-256-numLengthCodes]
+			pos++
+		}
+
+		// Every pixel enters the cache, whichever way it was coded.
+		if cache != nil {
+			for ; cached < pos; cached++ {
+				cache[0x1e35a7bd*pixels[cached]>>(32-cacheBits)] = pixels[cached]
+			}
+		}
+		if pos%width == 0 || pos == len(pixels) {
+			if err := b.err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pixels, nil
+}
+
+// prefixValue decodes a length or distance from its prefix code and the
+// extra bits that follow it.
+func prefixValue(b *bitReader, prefix int) int {
+	if prefix < 4 {
+		return prefix + 1
+	}
+	extra := uint(prefix-2) >> 1
+	offset := (2 + prefix&1) << extra
+	return offset + int(b.read(extra)) + 1
+}
+
+// distanceMap gives the (x, y) offsets of the 120 nearest neighbours that
+// small distance codes stand for.
+var distanceMap = [120][2]int8{
+	{0, 1}, {1, 0}, {1, 1}, {-1, 1}, {0, 2}, {2, 0}, {1, 2}, {-1, 2},
+	{2, 1}, {-2, 1}, {2, 2}, {-2, 2}, {0, 3}, {3, 0}, {1, 3}, {-1, 3},
+	{3, 1}, {-3, 1}, {2, 3}, {-2, 3}, {3, 2}, {-3, 2}, {0, 4}, {4, 0},
+	{1, 4}, {-1, 4}, {4, 1}, {-4, 1}, {3, 3}, {-3, 3}, {2, 4}, {-2, 4},
+	{4, 2}, {-4, 2}, {0, 5}, {3, 4}, {-3, 4}, {4, 3}, {-4, 3}, {5, 0},
+	{1, 5}, {-1, 5}, {5, 1}, {-5, 1}, {2, 5}, {-2, 5}, {5, 2}, {-5, 2},
+	{4, 4}, {-4, 4}, {3, 5}, {-3, 5}, {5, 3}, {-5, 3}, {0, 6}, {6, 0},
+	{1, 6}, {-1, 6}, {6, 1}, {-6, 1}, {2, 6}, {-2, 6}, {6, 2}, {-6, 2},
+	{4, 5}, {-4, 5}, {5, 4}, {-5, 4}, {3, 6}, {-3, 6}, {6, 3}, {-6, 3},
+	{0, 7}, {7, 0}, {1, 7}, {-1, 7}, {5, 5}, {-5, 5}, {7, 1}, {-7, 1},
+	{4, 6}, {-4, 6}, {6, 4}, {-6, 4}, {2, 7}, {-2, 7}, {7, 2}, {-7, 2},
+	{3, 7}, {-3, 7}, {7, 3}, {-7, 3}, {5, 6}, {-5, 6}, {6, 5}, {-6, 5},
+	{8, 0}, {4, 7}, {-4, 7}, {7, 4}, {-7, 4}, {8, 1}, {8, 2}, {6, 6},
+	{-6, 6}, {8, 3}, {5, 7}, {-5, 7}, {7, 5}, {-7, 5}, {8, 4}, {6, 7},
+	{-6, 7}, {7, 6}, {-7, 6}, {8, 5}, {7, 7}, {-7, 7}, {8, 6}, {8, 7},
+}
+
+// distanceFor converts a distance code to a pixel distance in an image of
+// the given width.
+func distanceFor(code, width int) int {
+	if code > len(distanceMap) {
+		return code - len(distanceMap)
+	}
+	offset := distanceMap[code-1]
+	return max(1, int(offset[0])+int(offset[1])*width)
+}
+
+// addPixels adds two ARGB pixels component by component, modulo 256.
+func addPixels(a, b uint32) uint32 {
+	alphaGreen := (a & 0xff00ff00) + (b & 0xff00ff00)
+	redBlue := (a & 0x00ff00ff) + (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// inverse undoes t on pixels, which are t.width wide except for colour
+// indexing, whose input is packed.
+func (t transform) inverse(pixels []uint32, height int) []uint32 {
+	switch t.kind {
+	case predictorTransform:
+		t.unpredict(pixels, height)
+	case colorTransform:
+		blocksWide := subsample(t.width, t.bits)
+		for i, p := range pixels {
+			x, y := i%t.width, i/t.width
+			e := t.data[(y>>t.bits)*blocksWide+x>>t.bits]
+			greenToRed, greenToBlue, redToBlue := int8(e), int8(e>>8), int8(e>>16)
+			green := int8(p >> 8)
+			red := uint8(p>>16) + colorDelta(greenToRed, green)
+			blue := uint8(p) + colorDelta(greenToBlue, green) + colorDelta(redToBlue, int8(red))
+			pixels[i] = p&0xff00ff00 | uint32(red)<<16 | uint32(blue)
+		}
+	case subtractGreenTransform:
+		for i, p := range pixels {
+			green := p >> 8 & 0xff
+			pixels[i] = addPixels(p, green<<16|green)
+		}
+	case colorIndexingTransform:
+		if t.bits == 0 {
+			for i, p := range pixels {
+				pixels[i] = t.lookup(p >> 8 & 0xff)
+			}
+			return pixels
+		}
+		// Several indices share each packed pixel's green channel, the
+		// first in the least significant bits.
+		packedWidth := subsample(t.width, t.bits)
+		bitsPerIndex := 8 >> t.bits
+		mask := uint32(1)<<bitsPerIndex - 1
+		out := make([]uint32, t.width*height)
+		for i := range out {
+			x, y := i%t.width, i/t.width
+			packed := pixels[y*packedWidth+x>>t.bits] >> 8 & 0xff
+			shift := uint(x&(1<<t.bits-1)) * uint(bitsPerIndex)
+			out[i] = t.lookup(packed >> shift & mask)
+		}
+		return out
+	}
+	return pixels
+}
+
+// lookup returns a colour table entry; indices past the table are
+// transparent black.
+func (t transform) lookup(index uint32) uint32 {
+	if int(index) < len(t.data) {
+		return t.data[index]
+	}
+	return 0
+}
+
+func colorDelta(t, c int8) uint8 {
+	return uint8((int(t) * int(c)) >> 5)
+}
+
+// unpredict adds each pixel's prediction to its residual, in place. The
+// first row predicts from the left and the first column from above; the
+// rest use the mode of their block. Predicting from the top-right pixel at
+// the end of a row reads the first pixel of the current row, as the
+// format specifies.
+func (t transform) unpredict(pixels []uint32, height int) {
+	width := t.width
+	blocksWide := subsample(width, t.bits)
+	pixels[0] = addPixels(pixels[0], 0xff000000)
+	for x := 1; x < width; x++ {
+		pixels[x] = addPixels(pixels[x], pixels[x-1])
+	}
+	for y := 1; y < height; y++ {
+		row := y * width
+		pixels[row] = addPixels(pixels[row], pixels[row-width])
+		for x := 1; x < width; x++ {
+			i := row + x
+			mode := t.data[(y>>t.bits)*blocksWide+x>>t.bits] >> 8 & 0xf
+			l, tp, tl, tr := pixels[i-1], pixels[i-width], pixels[i-width-1], pixels[i-width+1]
+			pixels[i] = addPixels(pixels[i], predict(mode, l, tp, tl, tr))
+		}
+	}
+}
+
+func predict(mode, l, t, tl, tr uint32) uint32 {
+	switch mode {
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPredictor(l, t, tl)
+	case 12:
+		return perComponent(func(c int) int { return channel(l, c) + channel(t, c) - channel(tl, c) })
+	case 13:
+		a := average2(l, t)
+		return perComponent(func(c int) int { return channel(a, c) + (channel(a, c)-channel(tl, c))/2 })
+	}
+	// Mode 0 and the unused modes 14 and 15 predict opaque black.
+	return 0xff000000
+}
+
+func channel(p uint32, c int) int {
+	return int(p >> (8 * c) & 0xff)
+}
+
+// perComponent builds a pixel from f applied to each channel, clamped.
+func perComponent(f func(c int) int) uint32 {
+	var p uint32
+	for c := 0; c < 4; c++ {
+		p |= uint32(min(max(f(c), 0), 255)) << (8 * c)
+	}
+	return p
+}
+
+func average2(a, b uint32) uint32 {
+	return (a^b)&0xfefefefe>>1 + a&b
+}
+
+// selectPredictor picks whichever of the left and top pixels is closer,
+// in Manhattan distance, to the gradient estimate l + t - tl.
+func selectPredictor(l, t, tl uint32) uint32 {
+	distL, distT := 0, 0
+	for c := 0; c < 4; c++ {
+		estimate := channel(l, c) + channel(t, c) - channel(tl, c)
+		distL += abs(estimate - channel(l, c))
+		distT += abs(estimate - channel(t, c))
+	}
+	if distL < distT {
+		return l
+	}
+	return t
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
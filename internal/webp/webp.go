@@ -0,0 +1,122 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// maxChunkSize bounds the chunk read into memory; VP8L images cannot be
+// much larger than their 16384x16384 pixel limit allows.
+const maxChunkSize = 1 << 30
+
+// vp8xAnimation is the VP8X flag marking an animated file.
+const vp8xAnimation = 0x02
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBPVP8", Decode, DecodeConfig)
+}
+
+// readChunk returns the fourcc and payload of the next RIFF chunk,
+// skipping the padding byte that follows odd-sized payloads.
+func readChunk(r io.Reader) (string, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size > maxChunkSize {
+		return "", nil, fmt.Errorf("WebP chunk %q too large", header[:4])
+	}
+	// Read through a limit rather than into a buffer of the declared size,
+	// so a truncated file cannot make us allocate the maximum.
+	data, err := io.ReadAll(io.LimitReader(r, int64(size+size&1)))
+	if err == nil && len(data) < int(size) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %q chunk: %w", header[:4], err)
+	}
+	return string(header[:4]), data[:size], nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// findImage reads the RIFF header and returns the payload of the VP8L
+// chunk, from a simple file or an extended (VP8X) one.
+func findImage(r io.Reader) ([]byte, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", unexpectedEOF(err))
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return nil, errors.New("not a WebP file")
+	}
+	for {
+		fourcc, data, err := readChunk(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("WebP file has no image")
+			}
+			return nil, err
+		}
+		switch fourcc {
+		case "VP8L":
+			return data, nil
+		case "VP8 ":
+			return nil, errors.New("lossy WebP is not supported")
+		case "VP8X":
+			if len(data) > 0 && data[0]&vp8xAnimation != 0 {
+				return nil, errors.New("animated WebP is not supported")
+			}
+		}
+	}
+}
+
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	data, err := findImage(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	br := newBitReader(data)
+	width, height, err := readHeader(br)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: width, Height: height}, nil
+}
+
+// Decode reads a lossless WebP image into an *image.NRGBA. Lossy and
+// animated files are rejected.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := findImage(r)
+	if err != nil {
+		return nil, err
+	}
+	br := newBitReader(data)
+	width, height, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	argb, err := decodeImageStream(br, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, p := range argb {
+		img.Pix[4*i] = uint8(p >> 16)
+		img.Pix[4*i+1] = uint8(p >> 8)
+		img.Pix[4*i+2] = uint8(p)
+		img.Pix[4*i+3] = uint8(p >> 24)
+	}
+	return img, nil
+}
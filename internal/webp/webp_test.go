@@ -0,0 +1,591 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"math/bits"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata come from the CPython test suite: python.webp
+// is a lossy file whose alpha plane is a headerless VP8L stream, which
+// python-alpha.webp wraps in a VP8L header; python.png holds the same
+// picture, so its alpha is the expected green channel. 1x1.webp is the
+// lossless image commonly used for feature detection. gradient.webp was
+// written by libwebp 1.2's WebPEncodeLosslessRGBA from gradient.png, a noisy
+// 64x48 gradient with translucent columns on the right; the encoder chose
+// the subtract-green, predictor and colour transforms and a colour cache.
+
+func TestDecodeFixtures(t *testing.T) {
+	data, err := os.ReadFile("testdata/python-alpha.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("image.Decode() unexpected error: %v", err)
+	}
+	if format != "webp" {
+		t.Errorf("format = %q, want webp", format)
+	}
+	f, err := os.Open("testdata/python.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds() != want.Bounds() {
+		t.Fatalf("bounds = %v, want %v", m.Bounds(), want.Bounds())
+	}
+	got := m.(*image.NRGBA)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			_, _, _, a := want.At(x, y).RGBA()
+			if g := got.NRGBAAt(x, y).G; g != uint8(a>>8) {
+				t.Fatalf("pixel (%d, %d) green = %d, want %d", x, y, g, a>>8)
+			}
+		}
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeConfig() unexpected error: %v", err)
+	}
+	if cfg.Width != 16 || cfg.Height != 16 {
+		t.Errorf("DecodeConfig() = %dx%d, want 16x16", cfg.Width, cfg.Height)
+	}
+
+	data, err = os.ReadFile("testdata/1x1.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	if m.Bounds() != image.Rect(0, 0, 1, 1) {
+		t.Errorf("bounds = %v, want 1x1", m.Bounds())
+	}
+}
+
+func TestDecodeLosslessFixture(t *testing.T) {
+	data, err := os.ReadFile("testdata/gradient.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode() unexpected error: %v", err)
+	}
+	f, err := os.Open("testdata/gradient.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds() != want.Bounds() {
+		t.Fatalf("bounds = %v, want %v", m.Bounds(), want.Bounds())
+	}
+	got, ref := m.(*image.NRGBA), want.(*image.NRGBA)
+	for y := 0; y < ref.Rect.Dy(); y++ {
+		for x := 0; x < ref.Rect.Dx(); x++ {
+			if g, w := got.NRGBAAt(x, y), ref.NRGBAAt(x, y); g != w {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+// bitWriter packs bits least significant first, as VP8L is read.
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << (w.nbits % 8)
+		w.nbits++
+	}
+}
+
+// completeLengths assigns code lengths to the used symbols that make a
+// complete prefix code.
+func completeLengths(size int, used []int) []int {
+	lengths := make([]int, size)
+	if len(used) == 1 {
+		lengths[used[0]] = 1
+		return lengths
+	}
+	k := bits.Len(uint(len(used) - 1))
+	short := 1<<k - len(used)
+	for i, s := range used {
+		lengths[s] = k
+		if i < short {
+			lengths[s] = k - 1
+		}
+	}
+	return lengths
+}
+
+// canonicalCodes returns the code of each symbol given its length.
+func canonicalCodes(lengths []int) []int {
+	codes := make([]int, len(lengths))
+	code := 0
+	for n := 1; n <= maxCodeLength; n++ {
+		for s, l := range lengths {
+			if l == n {
+				codes[s] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// writeLengths writes lengths in the normal form and returns a function
+// that writes a symbol of the resulting code.
+func (w *bitWriter) writeLengths(lengths []int) func(symbol int) {
+	var values []int
+	for v := 0; v <= maxCodeLength; v++ {
+		for _, l := range lengths {
+			if l == v {
+				values = append(values, v)
+				break
+			}
+		}
+	}
+	clLengths := completeLengths(len(codeLengthOrder), values)
+	w.write(0, 1)
+	w.write(uint32(len(codeLengthOrder)-4), 4)
+	for _, s := range codeLengthOrder {
+		w.write(uint32(clLengths[s]), 3)
+	}
+	w.write(0, 1)
+	writeCL := w.symbolWriter(clLengths)
+	for _, l := range lengths {
+		writeCL(l)
+	}
+	return w.symbolWriter(lengths)
+}
+
+func (w *bitWriter) symbolWriter(lengths []int) func(symbol int) {
+	codes := canonicalCodes(lengths)
+	single := 0
+	for _, l := range lengths {
+		if l > 0 {
+			single++
+		}
+	}
+	return func(symbol int) {
+		if single == 1 {
+			return
+		}
+		for i := lengths[symbol] - 1; i >= 0; i-- {
+			w.write(uint32(codes[symbol]>>i&1), 1)
+		}
+	}
+}
+
+// token is a literal pixel, a backward reference with a distance code,
+// or a colour cache hit.
+type token struct {
+	argb             uint32
+	length, distance int
+	cached           bool
+}
+
+func literal(argb uint32) token          { return token{argb: argb} }
+func backref(length, distance int) token { return token{length: length, distance: distance} }
+func cacheHit(argb uint32) token         { return token{argb: argb, cached: true} }
+func literals(pixels ...uint32) (t []token) {
+	for _, p := range pixels {
+		t = append(t, literal(p))
+	}
+	return t
+}
+
+// prefixEncode splits a length or distance code into its prefix symbol
+// and extra bits.
+func prefixEncode(v int) (prefix int, extra uint32, n uint) {
+	if v <= 4 {
+		return v - 1, 0, 0
+	}
+	d := v - 1
+	h := bits.Len(uint(d)) - 1
+	n = uint(h - 1)
+	return 2*h + d>>n&1, uint32(d) & (1<<n - 1), n
+}
+
+// writeImage writes an entropy-coded image without meta prefix codes.
+func (w *bitWriter) writeImage(tokens []token, cacheBits uint, main bool) {
+	if cacheBits > 0 {
+		w.write(1, 1)
+		w.write(uint32(cacheBits), 4)
+	} else {
+		w.write(0, 1)
+	}
+	if main {
+		w.write(0, 1)
+	}
+	w.writeGroup(tokens, cacheBits)
+}
+
+// writeGroup writes the five codes the tokens need, then the tokens.
+func (w *bitWriter) writeGroup(tokens []token, cacheBits uint) {
+	cacheSize := 0
+	if cacheBits > 0 {
+		cacheSize = 1 << cacheBits
+	}
+	index := func(argb uint32) int { return int(0x1e35a7bd * argb >> (32 - cacheBits)) }
+	sizes := [5]int{256 + numLengthCodes + cacheSize, 256, 256, 256, numDistanceCodes}
+	var used [5]map[int]bool
+	for i := range used {
+		used[i] = map[int]bool{}
+	}
+	for _, t := range tokens {
+		switch {
+		case t.cached:
+			used[0][256+numLengthCodes+index(t.argb)] = true
+		case t.length > 0:
+			l, _, _ := prefixEncode(t.length)
+			d, _, _ := prefixEncode(t.distance)
+			used[0][256+l], used[4][d] = true, true
+		default:
+			used[0][int(t.argb>>8&0xff)] = true
+			used[1][int(t.argb>>16&0xff)] = true
+			used[2][int(t.argb&0xff)] = true
+			used[3][int(t.argb>>24)] = true
+		}
+	}
+	var writers [5]func(int)
+	for i, size := range sizes {
+		var symbols []int
+		for s := 0; s < size; s++ {
+			if used[i][s] {
+				symbols = append(symbols, s)
+			}
+		}
+		if symbols == nil {
+			symbols = []int{0}
+		}
+		writers[i] = w.writeLengths(completeLengths(size, symbols))
+	}
+	for _, t := range tokens {
+		switch {
+		case t.cached:
+			writers[0](256 + numLengthCodes + index(t.argb))
+		case t.length > 0:
+			l, extra, n := prefixEncode(t.length)
+			writers[0](256 + l)
+			w.write(extra, n)
+			d, extra, n := prefixEncode(t.distance)
+			writers[4](d)
+			w.write(extra, n)
+		default:
+			writers[0](int(t.argb >> 8 & 0xff))
+			writers[1](int(t.argb >> 16 & 0xff))
+			writers[2](int(t.argb & 0xff))
+			writers[3](int(t.argb >> 24))
+		}
+	}
+}
+
+func (w *bitWriter) header(width, height int) {
+	w.write(vp8lSignature, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	w.write(0, 4)
+}
+
+// riff wraps chunks in a WebP container.
+func riff(chunks ...[]byte) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for i := 0; i+1 < len(chunks); i += 2 {
+		body.Write(chunks[i])
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunks[i+1])))
+		body.Write(chunks[i+1])
+		if len(chunks[i+1])%2 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(body.Len()))
+	return append(out, body.Bytes()...)
+}
+
+func TestDecodeStreams(t *testing.T) {
+	const a, b, c = 0xff102030, 0x80405060, 0x00a0b0c0
+	tests := []struct {
+		name          string
+		width, height int
+		stream        func(w *bitWriter)
+		want          []uint32
+	}{
+		{
+			name: "literals", width: 3, height: 1,
+			stream: func(w *bitWriter) {
+				w.write(0, 1)
+				w.writeImage(literals(a, b, c), 0, true)
+			},
+			want: []uint32{a, b, c},
+		},
+		{
+			// Distance code 2 is the pixel to the left, overlapping the
+			// copy; code 1 the pixel above; codes past 120 are linear.
+			name: "backward references and cache", width: 4, height: 2,
+			stream: func(w *bitWriter) {
+				w.write(0, 1)
+				w.writeImage([]token{literal(a), backref(3, 2), literal(b), literal(c), cacheHit(b), backref(1, 122)}, 10, true)
+			},
+			want: []uint32{a, a, a, a, b, c, b, c},
+		},
+		{
+			name: "long backward reference", width: 16, height: 3,
+			stream: func(w *bitWriter) {
+				w.write(0, 1)
+				w.writeImage([]token{literal(a), literal(b), backref(30, 122), literal(c), backref(15, 137)}, 0, true)
+			},
+			want: append(append(repeat([]uint32{a, b}, 16), c), repeat([]uint32{a, b}, 8)[:15]...),
+		},
+		{
+			name: "subtract green", width: 2, height: 1,
+			stream: func(w *bitWriter) {
+				w.write(1, 1)
+				w.write(subtractGreenTransform, 2)
+				w.write(0, 1)
+				w.writeImage(literals(0xff102030, 0x80f020f0), 0, true)
+			},
+			want: []uint32{0xff302050, 0x80102010},
+		},
+		{
+			// green_to_red and red_to_blue are 1.0 and green_to_blue is
+			// -1.0; the second pixel's new red is negative as int8.
+			name: "colour transform", width: 2, height: 1,
+			stream: func(w *bitWriter) {
+				w.write(1, 1)
+				w.write(colorTransform, 2)
+				w.write(0, 3)
+				w.writeImage(literals(0xff20e020), 0, false)
+				w.write(0, 1)
+				w.writeImage(literals(0xff201040, 0xff801040), 0, true)
+			},
+			want: []uint32{0xff301060, 0xff9010c0},
+		},
+		{
+			// Mode 3 predicts from the top-right pixel, which for the last
+			// pixel of a row is the first pixel of the same row.
+			name: "predictor", width: 3, height: 2,
+			stream: func(w *bitWriter) {
+				w.write(1, 1)
+				w.write(predictorTransform, 2)
+				w.write(0, 3)
+				w.writeImage(literals(0xff000300), 0, false)
+				w.write(0, 1)
+				w.writeImage(literals(0x00102030, 0x00010101, 0x00010101, 0x00010101, 0x00010101, 0x00010101), 0, true)
+			},
+			want: []uint32{0xff102030, 0xff112131, 0xff122232, 0xff112131, 0xff132333, 0xff122232},
+		},
+		{
+			// Three colours pack four 2-bit indices into each pixel; the
+			// table is delta-coded and index 3 is past its end.
+			name: "colour indexing", width: 5, height: 1,
+			stream: func(w *bitWriter) {
+				w.write(1, 1)
+				w.write(colorIndexingTransform, 2)
+				w.write(2, 8)
+				w.writeImage(literals(0xff112233, 0x00010101, 0x00010101), 0, false)
+				w.write(0, 1)
+				w.writeImage(literals(0xff00e400, 0xff000200), 0, true)
+			},
+			want: []uint32{0xff112233, 0xff122334, 0xff132435, 0, 0xff132435},
+		},
+		{
+			name: "colour indexing 1 bit", width: 10, height: 1,
+			stream: func(w *bitWriter) {
+				w.write(1, 1)
+				w.write(colorIndexingTransform, 2)
+				w.write(1, 8)
+				w.writeImage(literals(0xff0000ff, 0x0100ff01), 0, false)
+				w.write(0, 1)
+				w.writeImage(literals(0xff008d00, 0xff000100), 0, true)
+			},
+			want: []uint32{0x0000ff00, 0xff0000ff, 0x0000ff00, 0x0000ff00, 0xff0000ff, 0xff0000ff, 0xff0000ff, 0x0000ff00, 0x0000ff00, 0xff0000ff},
+		},
+		{
+			// Each 4x4 block has its own group, and each group's codes
+			// have a single symbol, which takes no bits.
+			name: "meta prefix codes", width: 8, height: 1,
+			stream: func(w *bitWriter) {
+				w.write(0, 2)
+				w.write(1, 1)
+				w.write(0, 3)
+				w.writeImage(literals(0, 0x100), 0, false)
+				w.writeGroup(literals(a, a, a, a), 0)
+				w.writeGroup(literals(b, b, b, b), 0)
+			},
+			want: []uint32{a, a, a, a, b, b, b, b},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bitWriter{}
+			w.header(tt.width, tt.height)
+			tt.stream(w)
+			w.write(0, 1)
+
+			m, err := Decode(bytes.NewReader(riff([]byte("VP8L"), w.buf)))
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			img := m.(*image.NRGBA)
+			for i, want := range tt.want {
+				c := img.NRGBAAt(i%tt.width, i/tt.width)
+				got := uint32(c.A)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+				if got != want {
+					t.Errorf("pixel %d = %08x, want %08x", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func repeat(s []uint32, n int) []uint32 {
+	var out []uint32
+	for i := 0; i < n; i++ {
+		out = append(out, s...)
+	}
+	return out
+}
+
+func TestPredict(t *testing.T) {
+	const l, top, tl, tr = 0x10101010, 0x30303030, 0x20202020, 0x50505050
+	tests := []struct {
+		mode, l, t, tl, tr uint32
+		want               uint32
+	}{
+		{0, l, top, tl, tr, 0xff000000},
+		{1, l, top, tl, tr, l},
+		{2, l, top, tl, tr, top},
+		{3, l, top, tl, tr, tr},
+		{4, l, top, tl, tr, tl},
+		{5, l, top, tl, tr, 0x30303030},
+		{6, l, top, tl, tr, 0x18181818},
+		{7, l, top, tl, tr, 0x20202020},
+		{8, l, top, tl, tr, 0x28282828},
+		{9, l, top, tl, tr, 0x40404040},
+		{10, l, top, tl, tr, 0x2c2c2c2c},
+		{11, l, top, tl, tr, top},
+		{11, 0x10101010, 0x80808080, 0x80808080, tr, 0x10101010},
+		{12, l, top, tl, tr, 0x20202020},
+		{12, 0xf0f0f0f0, 0xf0f0f0f0, 0x10101010, tr, 0xffffffff},
+		{12, 0x10101010, 0x10101010, 0xf0f0f0f0, tr, 0},
+		{13, l, top, 0, tr, 0x30303030},
+		// (0x20 - 0x21) / 2 truncates towards zero.
+		{13, l, top, 0x21212121, tr, 0x20202020},
+		{14, l, top, tl, tr, 0xff000000},
+		{15, l, top, tl, tr, 0xff000000},
+	}
+	for _, tt := range tests {
+		if got := predict(tt.mode, tt.l, tt.t, tt.tl, tt.tr); got != tt.want {
+			t.Errorf("predict(%d, %08x, %08x, %08x, %08x) = %08x, want %08x", tt.mode, tt.l, tt.t, tt.tl, tt.tr, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	lossy, err := os.ReadFile("testdata/python.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alpha, err := os.ReadFile("testdata/python-alpha.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := func(width, height int, f func(w *bitWriter)) []byte {
+		w := &bitWriter{}
+		w.header(width, height)
+		f(w)
+		return riff([]byte("VP8L"), w.buf)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "unexpected EOF"},
+		{"not webp", []byte("RIFF\x04\x00\x00\x00WAVE"), "not a WebP file"},
+		{"no image", riff([]byte("VP8X"), make([]byte, 10)), "no image"},
+		{"lossy", lossy, "lossy WebP is not supported"},
+		{"animated", riff([]byte("VP8X"), []byte{vp8xAnimation, 0, 0, 0, 0, 0, 0, 0, 0, 0}), "animated"},
+		{"signature", riff([]byte("VP8L"), []byte{0x2e, 0, 0, 0, 0}), "signature"},
+		{"version", riff([]byte("VP8L"), []byte{0x2f, 0, 0, 0, 0x20}), "version"},
+		{"truncated chunk", alpha[:100], "unexpected EOF"},
+		{"truncated stream", riff([]byte("VP8L"), alpha[20:120]), "unexpected EOF"},
+		{"repeated transform", stream(1, 1, func(w *bitWriter) {
+			w.write(1, 1)
+			w.write(subtractGreenTransform, 2)
+			w.write(1, 1)
+			w.write(subtractGreenTransform, 2)
+		}), "repeated"},
+		{"cache size", stream(1, 1, func(w *bitWriter) {
+			w.write(0, 1)
+			w.write(1, 1)
+			w.write(12, 4)
+		}), "cache size"},
+		{"distance", stream(2, 1, func(w *bitWriter) {
+			w.write(0, 1)
+			w.writeImage([]token{literal(0), backref(1, 122)}, 0, true)
+		}), "backward reference"},
+		{"length", stream(2, 1, func(w *bitWriter) {
+			w.write(0, 1)
+			w.writeImage([]token{literal(0), backref(2, 121)}, 0, true)
+		}), "backward reference"},
+		{"over-subscribed code", stream(1, 1, func(w *bitWriter) {
+			w.write(0, 3)
+			lengths := make([]int, 256+numLengthCodes)
+			lengths[0], lengths[1], lengths[2] = 1, 1, 1
+			w.writeLengths(lengths)
+		}), "over-subscribed"},
+		{"incomplete code", stream(1, 1, func(w *bitWriter) {
+			w.write(0, 3)
+			lengths := make([]int, 256+numLengthCodes)
+			lengths[0], lengths[1] = 1, 2
+			w.writeLengths(lengths)
+		}), "incomplete"},
+		{"simple code symbol", stream(1, 1, func(w *bitWriter) {
+			w.write(0, 3)
+			for _, size := range []int{256 + numLengthCodes, 256, 256, 256} {
+				w.writeLengths(completeLengths(size, []int{0}))
+			}
+			// A simple code for the distance alphabet with symbol 100.
+			w.write(1, 1)
+			w.write(0, 1)
+			w.write(1, 1)
+			w.write(100, 8)
+		}), "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(bytes.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decode() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package y4m
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+
+	"video-processor/internal/imagesize"
+)
+
+const (
+	streamMagic = "YUV4MPEG2"
+	frameMagic  = "FRAME"
+	// maxHeaderLength bounds header lines so a corrupt stream cannot make
+	// the reader buffer without limit.
+	maxHeaderLength = 4096
+)
+
+// Interlacing modes of the I header parameter.
+const (
+	Progressive       = 'p'
+	TopFieldFirst     = 't'
+	BottomFieldFirst  = 'b'
+	MixedInterlacing  = 'm'
+	UnknownInterlaced = '?'
+)
+
+// Rational is an exact ratio such as a frame rate (30000/1001) or a pixel
+// aspect ratio.
+type Rational struct {
+	Num, Den int64
+}
+
+// ParseRational accepts "num/den", "num:den" or a plain integer. The
+// denominator must be positive.
+func ParseRational(s string) (Rational, error) {
+	sep := strings.IndexAny(s, "/:")
+	if sep < 0 {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return Rational{}, fmt.Errorf("invalid rational %q", s)
+		}
+		return Rational{Num: n, Den: 1}, nil
+	}
+
+	num, err1 := strconv.ParseInt(s[:sep], 10, 64)
+	den, err2 := strconv.ParseInt(s[sep+1:], 10, 64)
+	if err1 != nil || err2 != nil || den <= 0 || num < 0 {
+		return Rational{}, fmt.Errorf("invalid rational %q", s)
+	}
+	return Rational{Num: num, Den: den}, nil
+}
+
+func (r Rational) Float() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+func (r Rational) String() string {
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// Header is the stream header of a YUV4MPEG2 file.
+type Header struct {
+	Width, Height int
+	FrameRate     Rational
+	Interlace     byte
+	AspectRatio   Rational
+	// Colorspace is the C parameter, e.g. "420jpeg", "420mpeg2", "444" or
+	// "mono". Only 8-bit layouts are supported.
+	Colorspace string
+	// Extra holds X parameters verbatim, without the leading X.
+	Extra []string
+}
+
+// SubsampleRatio returns the chroma layout of the header's colorspace.
+// Monochrome streams are represented as 4:2:0 with neutral chroma.
+func (h Header) SubsampleRatio() (image.YCbCrSubsampleRatio, error) {
+	switch h.Colorspace {
+	case "", "420", "420jpeg", "420mpeg2", "420paldv", "mono":
+		return image.YCbCrSubsampleRatio420, nil
+	case "422":
+		return image.YCbCrSubsampleRatio422, nil
+	case "444":
+		return image.YCbCrSubsampleRatio444, nil
+	}
+	return 0, fmt.Errorf("unsupported y4m colorspace %q", h.Colorspace)
+}
+
+// ChromaSiting returns the position of the chroma samples implied by the
+// colorspace, using ffmpeg's chroma_loc names. 420paldv sites Cr at the
+// top-left luma sample and Cb one line below; it is reported as topleft.
+func (h Header) ChromaSiting() string {
+	switch h.Colorspace {
+	case "420mpeg2", "422":
+		return "left"
+	case "420paldv":
+		return "topleft"
+	}
+	return "center"
+}
+
+// ColorRange returns the value of the XCOLORRANGE extension ffmpeg writes,
+// "FULL" or "LIMITED", or "" if the header has none.
+func (h Header) ColorRange() string {
+	for _, x := range h.Extra {
+		if value, ok := strings.CutPrefix(x, "COLORRANGE="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// SetColorRange replaces the XCOLORRANGE extension with value. It does not
+// modify the Extra slice h had before, which may be shared with a copy.
+func (h *Header) SetColorRange(value string) {
+	extra := []string{"COLORRANGE=" + value}
+	for _, x := range h.Extra {
+		if !strings.HasPrefix(x, "COLORRANGE=") {
+			extra = append(extra, x)
+		}
+	}
+	h.Extra = extra
+}
+
+// Interlaced reports whether the header flags field-based content.
+func (h Header) Interlaced() bool {
+	return h.Interlace == TopFieldFirst || h.Interlace == BottomFieldFirst
+}
+
+// String renders the header line without its trailing newline.
+func (h Header) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s W%d H%d", streamMagic, h.Width, h.Height)
+	if h.FrameRate.Den != 0 {
+		fmt.Fprintf(&b, " F%d:%d", h.FrameRate.Num, h.FrameRate.Den)
+	}
+	if h.Interlace != 0 {
+		fmt.Fprintf(&b, " I%c", h.Interlace)
+	}
+	if h.AspectRatio.Den != 0 {
+		fmt.Fprintf(&b, " A%d:%d", h.AspectRatio.Num, h.AspectRatio.Den)
+	}
+	if h.Colorspace != "" {
+		fmt.Fprintf(&b, " C%s", h.Colorspace)
+	}
+	for _, x := range h.Extra {
+		fmt.Fprintf(&b, " X%s", x)
+	}
+	return b.String()
+}
+
+func parseHeader(line string) (Header, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != streamMagic {
+		return Header{}, errors.New("not a YUV4MPEG2 stream")
+	}
+
+	h := Header{Interlace: Progressive}
+	for _, field := range fields[1:] {
+		value := field[1:]
+		var err error
+		switch field[0] {
+		case 'W':
+			h.Width, err = strconv.Atoi(value)
+		case 'H':
+			h.Height, err = strconv.Atoi(value)
+		case 'F':
+			h.FrameRate, err = ParseRational(value)
+		case 'I':
+			if len(value) != 1 || !strings.ContainsRune("ptbm?", rune(value[0])) {
+				err = fmt.Errorf("invalid interlacing %q", value)
+			} else {
+				h.Interlace = value[0]
+			}
+		case 'A':
+			// A0:0 marks an unknown aspect ratio, left as the zero value
+			if value != "0:0" {
+				h.AspectRatio, err = ParseRational(value)
+			}
+		case 'C':
+			h.Colorspace = value
+		case 'X':
+			h.Extra = append(h.Extra, value)
+		default:
+			err = fmt.Errorf("unknown header parameter %q", field)
+		}
+		if err != nil {
+			return Header{}, fmt.Errorf("invalid y4m header: %w", err)
+		}
+	}
+
+	if !imagesize.Valid(h.Width, h.Height) {
+		return Header{}, fmt.Errorf("invalid y4m dimensions %dx%d", h.Width, h.Height)
+	}
+	if _, err := h.SubsampleRatio(); err != nil {
+		return Header{}, err
+	}
+	return h, nil
+}
+
+// Reader decodes frames from a YUV4MPEG2 stream.
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+	ratio  image.YCbCrSubsampleRatio
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	line, err := readLine(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read y4m header: %w", err)
+	}
+
+	header, err := parseHeader(line)
+	if err != nil {
+		return nil, err
+	}
+	ratio, _ := header.SubsampleRatio()
+
+	return &Reader{Header: header, r: br, ratio: ratio}, nil
+}
+
+// ReadFrame returns the next frame, or io.EOF after the last one.
+func (r *Reader) ReadFrame() (*image.YCbCr, error) {
+	line, err := readLine(r.r)
+	if err == io.EOF && line == "" {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frame header: %w", err)
+	}
+	if !strings.HasPrefix(line, frameMagic) {
+		return nil, fmt.Errorf("invalid frame header %q", line)
+	}
+
+	frame := image.NewYCbCr(image.Rect(0, 0, r.Header.Width, r.Header.Height), r.ratio)
+	if _, err := io.ReadFull(r.r, frame.Y); err != nil {
+		return nil, fmt.Errorf("failed to read luma plane: %w", unexpectedEOF(err))
+	}
+
+	if r.Header.Colorspace == "mono" {
+		for i := range frame.Cb {
+			frame.Cb[i] = 128
+			frame.Cr[i] = 128
+		}
+		return frame, nil
+	}
+
+	if _, err := io.ReadFull(r.r, frame.Cb); err != nil {
+		return nil, fmt.Errorf("failed to read Cb plane: %w", unexpectedEOF(err))
+	}
+	if _, err := io.ReadFull(r.r, frame.Cr); err != nil {
+		return nil, fmt.Errorf("failed to read Cr plane: %w", unexpectedEOF(err))
+	}
+	return frame, nil
+}
+
+// Writer encodes frames into a YUV4MPEG2 stream.
+type Writer struct {
+	Header Header
+	w      *bufio.Writer
+	ratio  image.YCbCrSubsampleRatio
+}
+
+// NewWriter writes the stream header and returns a Writer for its frames.
+// Call Flush once all frames are written.
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	if header.Width <= 0 || header.Height <= 0 {
+		return nil, fmt.Errorf("invalid y4m dimensions %dx%d", header.Width, header.Height)
+	}
+	ratio, err := header.SubsampleRatio()
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(header.String() + "\n"); err != nil {
+		return nil, err
+	}
+	return &Writer{Header: header, w: bw, ratio: ratio}, nil
+}
+
+// WriteFrame writes one frame. Its size and chroma layout must match the
+// header.
+func (w *Writer) WriteFrame(frame *image.YCbCr) error {
+	bounds := frame.Bounds()
+	if bounds.Dx() != w.Header.Width || bounds.Dy() != w.Header.Height {
+		return fmt.Errorf("frame size %dx%d does not match stream %dx%d", bounds.Dx(), bounds.Dy(), w.Header.Width, w.Header.Height)
+	}
+	if frame.SubsampleRatio != w.ratio {
+		return fmt.Errorf("frame chroma subsampling %v does not match stream colorspace %q", frame.SubsampleRatio, w.Header.Colorspace)
+	}
+
+	if _, err := w.w.WriteString(frameMagic + "\n"); err != nil {
+		return err
+	}
+
+	// Planes may be sub-images, so write them row by row.
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := frame.YOffset(bounds.Min.X, y)
+		if _, err := w.w.Write(frame.Y[offset : offset+bounds.Dx()]); err != nil {
+			return err
+		}
+	}
+	if w.Header.Colorspace == "mono" {
+		return nil
+	}
+
+	chromaWidth, chromaHeight, stepY := chromaSize(bounds.Dx(), bounds.Dy(), w.ratio)
+	for _, plane := range [][]byte{frame.Cb, frame.Cr} {
+		for row := 0; row < chromaHeight; row++ {
+			offset := frame.COffset(bounds.Min.X, bounds.Min.Y+row*stepY)
+			if _, err := w.w.Write(plane[offset : offset+chromaWidth]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// chromaSize returns the chroma plane dimensions for a width x height
+// frame and the number of luma rows per chroma row.
+func chromaSize(width, height int, ratio image.YCbCrSubsampleRatio) (int, int, int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		return (width + 1) / 2, (height + 1) / 2, 2
+	case image.YCbCrSubsampleRatio422:
+		return (width + 1) / 2, height, 1
+	}
+	return width, height, 1
+}
+
+// PixelFormat returns the graph format name (e.g. "yuv420p") matching a
+// chroma layout.
+func PixelFormat(ratio image.YCbCrSubsampleRatio) string {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return "yuv422p"
+	case image.YCbCrSubsampleRatio444:
+		return "yuv444p"
+	}
+	return "yuv420p"
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return b.String(), err
+		}
+		if c == '\n' {
+			return b.String(), nil
+		}
+		if b.Len() >= maxHeaderLength {
+			return "", errors.New("header line too long")
+		}
+		b.WriteByte(c)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
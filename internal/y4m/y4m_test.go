@@ -0,0 +1,213 @@
+package y4m
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantError bool
+		check     func(Header) bool
+	}{
+		{
+			name: "full header",
+			line: "YUV4MPEG2 W1920 H1080 F30000:1001 It A1:1 C420mpeg2 XYSCSS=420MPEG2",
+			check: func(h Header) bool {
+				return h.Width == 1920 && h.Height == 1080 && h.FrameRate == (Rational{30000, 1001}) &&
+					h.Interlace == TopFieldFirst && h.Interlaced() && h.Colorspace == "420mpeg2" &&
+					len(h.Extra) == 1 && h.Extra[0] == "YSCSS=420MPEG2"
+			},
+		},
+		{
+			name: "defaults",
+			line: "YUV4MPEG2 W4 H2 F25:1",
+			check: func(h Header) bool {
+				return h.Interlace == Progressive && !h.Interlaced() && h.Colorspace == ""
+			},
+		},
+		{
+			name: "unknown aspect ratio",
+			line: "YUV4MPEG2 W4 H2 F25:1 A0:0",
+			check: func(h Header) bool {
+				return h.AspectRatio == Rational{} && !strings.Contains(h.String(), " A")
+			},
+		},
+		{name: "bad magic", line: "YUV4MPEG W4 H2", wantError: true},
+		{name: "missing size", line: "YUV4MPEG2 F25:1", wantError: true},
+		{name: "bad interlace", line: "YUV4MPEG2 W4 H2 Ix", wantError: true},
+		{name: "high bit depth", line: "YUV4MPEG2 W4 H2 C420p10", wantError: true},
+		{name: "unknown parameter", line: "YUV4MPEG2 W4 H2 Z1", wantError: true},
+		{name: "zero frame rate denominator", line: "YUV4MPEG2 W4 H2 F25:0", wantError: true},
+		{name: "oversized frame", line: "YUV4MPEG2 W100000 H100000 F25:1", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseHeader(tt.line)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("parseHeader(%q) expected error", tt.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHeader(%q) unexpected error: %v", tt.line, err)
+			}
+			if !tt.check(h) {
+				t.Errorf("parseHeader(%q) = %+v", tt.line, h)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, colorspace := range []string{"420jpeg", "422", "444"} {
+		t.Run(colorspace, func(t *testing.T) {
+			header := Header{Width: 5, Height: 3, FrameRate: Rational{25, 1}, Interlace: Progressive, Colorspace: colorspace}
+			ratio, _ := header.SubsampleRatio()
+
+			frames := make([]*image.YCbCr, 3)
+			for i := range frames {
+				frames[i] = image.NewYCbCr(image.Rect(0, 0, 5, 3), ratio)
+				for j := range frames[i].Y {
+					frames[i].Y[j] = uint8(i*50 + j)
+				}
+				for j := range frames[i].Cb {
+					frames[i].Cb[j] = uint8(100 + i + j)
+					frames[i].Cr[j] = uint8(200 - i - j)
+				}
+			}
+
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, header)
+			if err != nil {
+				t.Fatalf("NewWriter() unexpected error: %v", err)
+			}
+			for _, frame := range frames {
+				if err := w.WriteFrame(frame); err != nil {
+					t.Fatalf("WriteFrame() unexpected error: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() unexpected error: %v", err)
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("NewReader() unexpected error: %v", err)
+			}
+			if r.Header.String() != header.String() {
+				t.Errorf("header = %q, want %q", r.Header, header)
+			}
+			for i, want := range frames {
+				got, err := r.ReadFrame()
+				if err != nil {
+					t.Fatalf("ReadFrame(%d) unexpected error: %v", i, err)
+				}
+				if !bytes.Equal(got.Y, want.Y) || !bytes.Equal(got.Cb, want.Cb) || !bytes.Equal(got.Cr, want.Cr) {
+					t.Errorf("frame %d differs after round trip", i)
+				}
+			}
+			if _, err := r.ReadFrame(); err != io.EOF {
+				t.Errorf("ReadFrame() after last frame = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadMono(t *testing.T) {
+	stream := "YUV4MPEG2 W2 H2 F25:1 Cmono\nFRAME\n\x10\x20\x30\x40"
+	r, err := NewReader(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewReader() unexpected error: %v", err)
+	}
+	frame, err := r.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame() unexpected error: %v", err)
+	}
+	if frame.Y[3] != 0x40 || frame.Cb[0] != 128 || frame.Cr[0] != 128 {
+		t.Errorf("mono frame decoded as Y=%v Cb=%v Cr=%v", frame.Y, frame.Cb, frame.Cr)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	stream := "YUV4MPEG2 W4 H4 F25:1\nFRAME\n\x00\x01"
+	r, err := NewReader(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewReader() unexpected error: %v", err)
+	}
+	if _, err := r.ReadFrame(); err == nil || err == io.EOF {
+		t.Errorf("ReadFrame() on truncated frame = %v, want unexpected EOF", err)
+	}
+}
+
+func TestWriteFrameMismatch(t *testing.T) {
+	w, err := NewWriter(io.Discard, Header{Width: 4, Height: 4, Colorspace: "420jpeg"})
+	if err != nil {
+		t.Fatalf("NewWriter() unexpected error: %v", err)
+	}
+	if err := w.WriteFrame(image.NewYCbCr(image.Rect(0, 0, 2, 2), image.YCbCrSubsampleRatio420)); err == nil {
+		t.Error("WriteFrame() expected size mismatch error")
+	}
+	if err := w.WriteFrame(image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio444)); err == nil {
+		t.Error("WriteFrame() expected subsampling mismatch error")
+	}
+}
+
+func TestParseRational(t *testing.T) {
+	for input, want := range map[string]Rational{
+		"30000/1001": {30000, 1001},
+		"25:1":       {25, 1},
+		"24":         {24, 1},
+	} {
+		got, err := ParseRational(input)
+		if err != nil || got != want {
+			t.Errorf("ParseRational(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "a/b", "1/-1", "x", "25/0", "0:0"} {
+		if _, err := ParseRational(input); err == nil {
+			t.Errorf("ParseRational(%q) expected error", input)
+		}
+	}
+}
+
+func TestChromaSiting(t *testing.T) {
+	for colorspace, want := range map[string]string{
+		"":         "center",
+		"420jpeg":  "center",
+		"420mpeg2": "left",
+		"420paldv": "topleft",
+		"422":      "left",
+		"444":      "center",
+	} {
+		if got := (Header{Colorspace: colorspace}).ChromaSiting(); got != want {
+			t.Errorf("ChromaSiting(%q) = %q, want %q", colorspace, got, want)
+		}
+	}
+}
+
+func TestColorRange(t *testing.T) {
+	h, err := parseHeader("YUV4MPEG2 W2 H2 F25:1 XYSCSS=420JPEG XCOLORRANGE=LIMITED")
+	if err != nil {
+		t.Fatalf("parseHeader() unexpected error: %v", err)
+	}
+	if got := h.ColorRange(); got != "LIMITED" {
+		t.Errorf("ColorRange() = %q, want LIMITED", got)
+	}
+
+	out := h
+	out.SetColorRange("FULL")
+	if got := out.ColorRange(); got != "FULL" {
+		t.Errorf("ColorRange() after SetColorRange = %q, want FULL", got)
+	}
+	if len(out.Extra) != 2 || h.ColorRange() != "LIMITED" {
+		t.Errorf("SetColorRange() left Extra = %v and changed the original to %q", out.Extra, h.ColorRange())
+	}
+}